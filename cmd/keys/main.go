@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github/alexnoodl/raiko-auth/internal/config"
+	"github/alexnoodl/raiko-auth/pkg/database"
+	"github/alexnoodl/raiko-auth/pkg/jwt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: keys <command> [flags]
+
+Manages the JWT signing key ring selected by JWT_KEY_SOURCE (dir or mongo).
+
+Commands:
+  list                          show all keys and their validity windows
+  generate [-alg EdDSA] [-not-before RFC3339]
+                                create a new verification-only key
+  promote [-grace 15m] <kid>    make <kid> the active signing key
+  retire [-at RFC3339] [-force] <kid>
+                                stop accepting tokens signed with <kid>
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+
+	store, err := newKeyStore(cfg)
+	if err != nil {
+		cfg.Logger.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "list":
+		err = listKeys(ctx, store)
+	case "generate":
+		err = generateKey(ctx, store, args)
+	case "promote":
+		err = promoteKey(ctx, store, args, cfg.AccessTokenTTL)
+	case "retire":
+		err = retireKey(ctx, store, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		cfg.Logger.Fatal(command, ": ", err)
+	}
+}
+
+func newKeyStore(cfg *config.Config) (jwt.KeyStore, error) {
+	switch cfg.JWTKeySource {
+	case "dir":
+		return jwt.NewDirKeyStore(cfg.JWTKeysDir), nil
+	case "mongo":
+		db, err := database.InitMongoDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.NewMongoKeyStore(db), nil
+	default:
+		return nil, fmt.Errorf("key management requires JWT_KEY_SOURCE=dir or mongo, got %q", cfg.JWTKeySource)
+	}
+}
+
+func listKeys(ctx context.Context, store jwt.KeyStore) error {
+	records, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATUS\tNOT BEFORE\tRETIRE AFTER")
+	for _, record := range records {
+		alg := "?"
+		if key, err := jwt.ParsePEMKey(record.PEM, record.ID); err == nil {
+			alg = key.Method.Alg()
+		}
+
+		status := string(record.Status)
+		if record.Retired(now) {
+			status = "retired"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", record.ID, alg, status,
+			formatTime(record.NotBefore), formatTime(record.RetireAfter))
+	}
+	return w.Flush()
+}
+
+func generateKey(ctx context.Context, store jwt.KeyStore, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	alg := flags.String("alg", "EdDSA", "signing algorithm: RS256, ES256, ES384 or EdDSA")
+	notBefore := flags.String("not-before", "", "earliest time the key may sign (RFC3339), defaults to now")
+	flags.Parse(args)
+
+	start := time.Now()
+	if *notBefore != "" {
+		parsed, err := time.Parse(time.RFC3339, *notBefore)
+		if err != nil {
+			return err
+		}
+		start = parsed
+	}
+
+	record, err := jwt.GenerateKey(ctx, store, *alg, start)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(record.ID)
+	return nil
+}
+
+func promoteKey(ctx context.Context, store jwt.KeyStore, args []string, defaultGrace time.Duration) error {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	grace := flags.Duration("grace", defaultGrace, "how long the previous signing key keeps verifying after the switch")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one kid")
+	}
+	return jwt.PromoteKey(ctx, store, flags.Arg(0), *grace)
+}
+
+func retireKey(ctx context.Context, store jwt.KeyStore, args []string) error {
+	flags := flag.NewFlagSet("retire", flag.ExitOnError)
+	at := flags.String("at", "", "time the key stops verifying (RFC3339), defaults to now")
+	force := flags.Bool("force", false, "allow retiring the current signing key")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one kid")
+	}
+
+	keyRing := jwt.NewKeyRing(store)
+	if err := keyRing.Load(ctx); err != nil {
+		return err
+	}
+	if current, err := keyRing.SigningKey(); err == nil && current.ID == flags.Arg(0) && !*force {
+		return fmt.Errorf("%s is the current signing key, promote another key first or pass -force", current.ID)
+	}
+
+	retireAt := time.Now()
+	if *at != "" {
+		parsed, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return err
+		}
+		retireAt = parsed
+	}
+	return jwt.RetireKey(ctx, store, flags.Arg(0), retireAt)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -1,19 +1,41 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	_ "github/alexnoodl/raiko-auth/docs"
 	"github/alexnoodl/raiko-auth/internal/config"
 	"github/alexnoodl/raiko-auth/internal/handler"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
 	"github/alexnoodl/raiko-auth/internal/services"
 	"github/alexnoodl/raiko-auth/pkg/database"
+	"github/alexnoodl/raiko-auth/pkg/jwt"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	"github/alexnoodl/raiko-auth/pkg/password"
+	"github/alexnoodl/raiko-auth/pkg/ratelimit"
+	"github/alexnoodl/raiko-auth/pkg/secretbox"
 	pb "github/alexnoodl/raiko-auth/proto"
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"log"
+	"math"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
 // @title API Авторизации
@@ -21,6 +43,9 @@ import (
 // @description Сервер авторизации.
 // @host localhost:8080
 // @BasePath /api/v1
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -31,28 +56,172 @@ func main() {
 		log.Fatal("Logger is nil after config initialization")
 	}
 
-	db, err := database.InitMongoDB(cfg)
+	store, db, err := newStore(cfg)
 	if err != nil {
-		cfg.Logger.Fatal("Failed to connect to MongoDB: ", err)
+		cfg.Logger.Fatal("Failed to set up storage: ", err)
 	}
-	cfg.Logger.Info("Connected to MongoDB successfully")
 
 	router := gin.Default()
 
 	router.Use(gin.Recovery())
 
-	authService := services.NewAuthService(db, cfg.Logger, []byte(cfg.JWTKey))
+	// Failed logins are counted per c.ClientIP(), which only honours
+	// X-Forwarded-For from these proxies.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		cfg.Logger.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
+	keyRing, err := newKeyRing(cfg, db)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to load JWT signing keys: ", err)
+	}
+	stopKeyReload := keyRing.Watch(cfg.JWTKeysReload, func(err error) {
+		cfg.Logger.WithError(err).Error("Failed to reload JWT signing keys")
+	})
+	defer stopKeyReload()
+
+	jwtManager := jwt.NewJWTManager(keyRing, cfg.JWTIssuer, cfg.JWTAudience, cfg.AccessTokenTTL)
+	mail, err := newMailer(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to configure mailer: ", err)
+	}
+
+	secrets, err := newSecretBox(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to set up MFA secret encryption: ", err)
+	}
+
+	relyingParty, err := newRelyingParty(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to configure WebAuthn: ", err)
+	}
+
+	passwords, err := newPasswordManager(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to configure password hashing: ", err)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to configure the password policy: ", err)
+	}
+
+	authService := services.NewAuthService(store, cfg.Logger, jwtManager, mail, secrets, relyingParty, passwords, passwordPolicy, cfg)
+	if err := authService.EnsureDefaultRoles(); err != nil {
+		cfg.Logger.Fatal("Failed to set up roles: ", err)
+	}
+	oauthClients, err := loadOAuthClients(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to read OAUTH_CLIENTS_FILE: ", err)
+	}
+	if err := authService.EnsureOAuthClients(oauthClients); err != nil {
+		cfg.Logger.Fatal("Failed to set up OAuth clients: ", err)
+	}
+	// Registered clients get the authorization code grant unless they ask
+	// for other grants.
+	if cfg.OAuthLoginURL == "" && (usesGrant(oauthClients, models.GrantAuthorizationCode) || cfg.OAuthRegistrationToken != "") {
+		cfg.Logger.Fatal("OAUTH_LOGIN_URL is required for clients with the authorization code grant and for OAUTH_REGISTRATION_TOKEN")
+	}
+	if cfg.OAuthDevicePageURL == "" && usesGrant(oauthClients, models.GrantDeviceCode) {
+		cfg.Logger.Fatal("OAUTH_DEVICE_PAGE_URL is required for clients with the device code grant")
+	}
+	if (len(oauthClients) > 0 || cfg.OAuthRegistrationToken != "") && len(jwtManager.SigningAlgorithms()) == 0 {
+		cfg.Logger.Warn("Tokens are signed with an HMAC key, OAuth clients will not be able to verify ID tokens")
+	}
+	limiter, err := newRateLimiter(cfg)
+	if err != nil {
+		cfg.Logger.Fatal("Failed to set up rate limiting: ", err)
+	}
+	limit := func(policies ...string) gin.HandlerFunc {
+		return middleware.RateLimit(limiter, cfg.Logger, policies...)
+	}
+
 	authHandler := handler.NewAuthHandler(authService, cfg.Logger)
+	adminHandler := handler.NewAdminHandler(authService, cfg.Logger)
+	jwksHandler := handler.NewJWKSHandler(jwtManager, cfg.Logger)
+	oauthHandler := handler.NewOAuthHandler(authService, cfg.Logger)
 
 	{
 		v1 := router.Group("/api/v1")
-		v1.POST("/register", authHandler.Register)
-		v1.POST("/login", authHandler.Login)
+		v1.POST("/register", limit("register"), authHandler.Register)
+		v1.POST("/login", limit("login", "login_account"), authHandler.Login)
+		v1.POST("/login/mfa", limit("mfa"), authHandler.LoginMFA)
+		v1.POST("/login/webauthn/begin", limit("mfa"), authHandler.BeginWebAuthnLogin)
+		v1.POST("/login/webauthn/finish", limit("mfa"), authHandler.FinishWebAuthnLogin)
+		v1.POST("/token/refresh", limit("refresh"), authHandler.Refresh)
+		v1.POST("/logout", limit("refresh"), authHandler.Logout)
+		v1.POST("/logout-all", limit("refresh"), authHandler.LogoutAll)
+		v1.POST("/introspect", limit("introspect"), oauthHandler.Introspect)
+		v1.GET("/verify-email", authHandler.VerifyEmail)
+		v1.POST("/verify-email", authHandler.VerifyEmail)
+		v1.POST("/verify-email/resend", limit("email"), authHandler.ResendVerification)
+		v1.POST("/password/forgot", limit("email"), authHandler.ForgotPassword)
+		v1.POST("/password/reset", limit("email"), authHandler.ResetPassword)
+		v1.GET("/me/email/confirm", authHandler.ConfirmEmailChange)
+		v1.POST("/me/email/confirm", authHandler.ConfirmEmailChange)
+
+		me := v1.Group("/me", middleware.Auth(authService.ValidateAccessToken, cfg.Logger), limit("account"))
+		me.GET("", middleware.RequirePermission(models.PermissionProfileRead), authHandler.GetProfile)
+		me.PATCH("", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.UpdateProfile)
+		me.POST("/password", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.ChangePassword)
+		me.POST("/email", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.ChangeEmail)
+		me.POST("/mfa/totp", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.BeginTOTPEnrollment)
+		me.POST("/mfa/totp/confirm", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.ConfirmTOTPEnrollment)
+		me.POST("/mfa/totp/disable", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.DisableTOTP)
+		me.POST("/mfa/recovery-codes", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.RegenerateRecoveryCodes)
+		me.GET("/webauthn/credentials", middleware.RequirePermission(models.PermissionProfileRead), authHandler.ListWebAuthnCredentials)
+		me.DELETE("/webauthn/credentials/:id", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.DeleteWebAuthnCredential)
+		me.POST("/webauthn/register/begin", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.BeginWebAuthnRegistration)
+		me.POST("/webauthn/register/finish", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.FinishWebAuthnRegistration)
+
+		admin := v1.Group("/admin", middleware.Auth(authService.ValidateAccessToken, cfg.Logger), limit("account"))
+		canRead := middleware.RequirePermission(models.PermissionUsersRead)
+		canWrite := middleware.RequirePermission(models.PermissionUsersWrite)
+		admin.GET("/users", canRead, adminHandler.ListUsers)
+		admin.GET("/users/:id", canRead, adminHandler.GetUser)
+		admin.PUT("/users/:id/role", canWrite, adminHandler.SetRole)
+		admin.POST("/users/:id/activate", canWrite, adminHandler.Activate)
+		admin.POST("/users/:id/deactivate", canWrite, adminHandler.Deactivate)
+		admin.POST("/users/:id/password-reset", canWrite, adminHandler.ForcePasswordReset)
+		admin.POST("/users/:id/revoke-sessions", canWrite, adminHandler.RevokeSessions)
+		admin.POST("/users/:id/unlock", canWrite, adminHandler.UnlockUser)
+		admin.DELETE("/users/:id", canWrite, adminHandler.DeleteUser)
+		admin.GET("/users/:id/audit", canRead, adminHandler.ListAuditEvents)
+		canReadClients := middleware.RequirePermission(models.PermissionClientsRead)
+		canWriteClients := middleware.RequirePermission(models.PermissionClientsWrite)
+		admin.GET("/clients", canReadClients, adminHandler.ListClients)
+		admin.POST("/clients", canWriteClients, adminHandler.CreateClient)
+		admin.GET("/clients/:id", canReadClients, adminHandler.GetClient)
+		admin.PUT("/clients/:id", canWriteClients, adminHandler.UpdateClient)
+		admin.POST("/clients/:id/secret", canWriteClients, adminHandler.RotateClientSecret)
+		admin.DELETE("/clients/:id", canWriteClients, adminHandler.DeleteClient)
+
+		oauth := v1.Group("/oauth", middleware.Auth(authService.ValidateAccessToken, cfg.Logger), limit("account"))
+		oauth.GET("/requests/:id", middleware.RequirePermission(models.PermissionProfileRead), oauthHandler.GetAuthorizationRequest)
+		oauth.POST("/requests/:id/consent", middleware.RequirePermission(models.PermissionProfileWrite), oauthHandler.AnswerAuthorizationRequest)
+		oauth.GET("/device/:user_code", middleware.RequirePermission(models.PermissionProfileRead), oauthHandler.GetDeviceAuthorization)
+		oauth.POST("/device/:user_code/consent", middleware.RequirePermission(models.PermissionProfileWrite), oauthHandler.AnswerDeviceAuthorization)
+
 		v1.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
 	}
 
+	router.GET("/.well-known/jwks.json", jwksHandler.JWKS)
+	router.GET("/.well-known/openid-configuration", oauthHandler.Discovery)
+	router.GET("/oauth/authorize", limit("oauth"), oauthHandler.Authorize)
+	router.POST("/oauth/token", limit("oauth"), oauthHandler.Token)
+	router.POST("/oauth/device_authorization", limit("oauth"), oauthHandler.DeviceAuthorization)
+	router.GET("/oauth/device", oauthHandler.DeviceVerification)
+	router.POST("/oauth/register", limit("oauth"), oauthHandler.RegisterClient)
+	router.GET("/oauth/register/:client_id", limit("oauth"), oauthHandler.GetRegisteredClient)
+	router.PUT("/oauth/register/:client_id", limit("oauth"), oauthHandler.UpdateRegisteredClient)
+	router.DELETE("/oauth/register/:client_id", limit("oauth"), oauthHandler.DeleteRegisteredClient)
+	router.GET("/oauth/logout", limit("oauth"), oauthHandler.EndSession)
+	router.POST("/oauth/logout", limit("oauth"), oauthHandler.EndSession)
+	userinfo := router.Group("/oauth/userinfo", middleware.Auth(authService.ValidateAccessToken, cfg.Logger), limit("account"))
+	userinfo.GET("", oauthHandler.UserInfo)
+	userinfo.POST("", oauthHandler.UserInfo)
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	go func() {
@@ -67,8 +236,69 @@ func main() {
 		cfg.Logger.Fatal("Failed to listen for gRPC: ", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcPermissions := middleware.MethodPermissions{
+		pb.AuthService_GetProfile_FullMethodName:     models.PermissionProfileRead,
+		pb.AuthService_UpdateProfile_FullMethodName:  models.PermissionProfileWrite,
+		pb.AuthService_ChangePassword_FullMethodName: models.PermissionProfileWrite,
+		pb.AuthService_ChangeEmail_FullMethodName:    models.PermissionProfileWrite,
+
+		pb.AuthService_BeginTOTPEnrollment_FullMethodName:     models.PermissionProfileWrite,
+		pb.AuthService_ConfirmTOTPEnrollment_FullMethodName:   models.PermissionProfileWrite,
+		pb.AuthService_DisableTOTP_FullMethodName:             models.PermissionProfileWrite,
+		pb.AuthService_RegenerateRecoveryCodes_FullMethodName: models.PermissionProfileWrite,
+
+		pb.AuthService_BeginWebAuthnRegistration_FullMethodName:  models.PermissionProfileWrite,
+		pb.AuthService_FinishWebAuthnRegistration_FullMethodName: models.PermissionProfileWrite,
+		pb.AuthService_ListWebAuthnCredentials_FullMethodName:    models.PermissionProfileRead,
+		pb.AuthService_DeleteWebAuthnCredential_FullMethodName:   models.PermissionProfileWrite,
+
+		pb.AdminService_ListUsers_FullMethodName:          models.PermissionUsersRead,
+		pb.AdminService_GetUser_FullMethodName:            models.PermissionUsersRead,
+		pb.AdminService_SetUserRole_FullMethodName:        models.PermissionUsersWrite,
+		pb.AdminService_SetUserActive_FullMethodName:      models.PermissionUsersWrite,
+		pb.AdminService_ForcePasswordReset_FullMethodName: models.PermissionUsersWrite,
+		pb.AdminService_RevokeUserSessions_FullMethodName: models.PermissionUsersWrite,
+		pb.AdminService_UnlockUser_FullMethodName:         models.PermissionUsersWrite,
+		pb.AdminService_DeleteUser_FullMethodName:         models.PermissionUsersWrite,
+		pb.AdminService_ListAuditEvents_FullMethodName:    models.PermissionUsersRead,
+
+		pb.AdminService_ListClients_FullMethodName:        models.PermissionClientsRead,
+		pb.AdminService_GetClient_FullMethodName:          models.PermissionClientsRead,
+		pb.AdminService_CreateClient_FullMethodName:       models.PermissionClientsWrite,
+		pb.AdminService_UpdateClient_FullMethodName:       models.PermissionClientsWrite,
+		pb.AdminService_RotateClientSecret_FullMethodName: models.PermissionClientsWrite,
+		pb.AdminService_DeleteClient_FullMethodName:       models.PermissionClientsWrite,
+	}
+
+	grpcRateLimits := middleware.RateLimitRules{
+		pb.AuthService_Register_FullMethodName:                []string{"register"},
+		pb.AuthService_Login_FullMethodName:                   []string{"login", "login_account"},
+		pb.AuthService_LoginMFA_FullMethodName:                []string{"mfa"},
+		pb.AuthService_BeginWebAuthnLogin_FullMethodName:      []string{"mfa"},
+		pb.AuthService_FinishWebAuthnLogin_FullMethodName:     []string{"mfa"},
+		pb.AuthService_Refresh_FullMethodName:                 []string{"refresh"},
+		pb.AuthService_Logout_FullMethodName:                  []string{"refresh"},
+		pb.AuthService_LogoutAll_FullMethodName:               []string{"refresh"},
+		pb.AuthService_ValidateToken_FullMethodName:           []string{"introspect"},
+		pb.AuthService_IssueServiceToken_FullMethodName:       []string{"oauth"},
+		pb.AuthService_ResendVerificationEmail_FullMethodName: []string{"email"},
+		pb.AuthService_ForgotPassword_FullMethodName:          []string{"email"},
+		pb.AuthService_ResetPassword_FullMethodName:           []string{"email"},
+	}
+	// Every method that requires an access token is limited per subject.
+	for method := range grpcPermissions {
+		grpcRateLimits[method] = []string{"account"}
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			middleware.UnaryServerInterceptor(authService.ValidateAccessToken, grpcPermissions, cfg.Logger),
+			middleware.UnaryRateLimitInterceptor(limiter, grpcRateLimits, cfg.Logger),
+		),
+		grpc.ChainStreamInterceptor(middleware.StreamServerInterceptor(authService.ValidateAccessToken, grpcPermissions, cfg.Logger)),
+	)
 	pb.RegisterAuthServiceServer(grpcServer, services.NewAuthGrpcServer(authService, cfg.Logger))
+	pb.RegisterAdminServiceServer(grpcServer, services.NewAdminGrpcServer(authService, cfg.Logger))
 	reflection.Register(grpcServer)
 
 	cfg.Logger.Info("Starting gRPC server on port 50051")
@@ -76,3 +306,328 @@ func main() {
 		cfg.Logger.Fatal("gRPC server failed: ", err)
 	}
 }
+
+// newStore connects to the backend selected by DB_DRIVER and, unless
+// DB_AUTO_MIGRATE is off, brings its schema up to date. The MongoDB database
+// is returned as well because the signing key store may live in it.
+func newStore(cfg *config.Config) (*repository.Store, *mongo.Database, error) {
+	switch cfg.DBDriver {
+	case "mongo":
+		db, err := database.InitMongoDB(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Logger.Info("Connected to MongoDB successfully")
+
+		if cfg.DBAutoMigrate {
+			applied, err := database.MigrateMongo(db)
+			if err != nil {
+				return nil, nil, fmt.Errorf("migrate MongoDB: %w", err)
+			}
+			logAppliedMigrations(cfg, applied)
+		}
+		return repository.NewMongoStore(db), db, nil
+	case "postgres":
+		pool, err := database.InitPostgres(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Logger.Info("Connected to PostgreSQL successfully")
+
+		if cfg.DBAutoMigrate {
+			applied, err := database.MigratePostgres(pool)
+			if err != nil {
+				return nil, nil, fmt.Errorf("migrate PostgreSQL: %w", err)
+			}
+			logAppliedMigrations(cfg, applied)
+		}
+		return repository.NewPostgresStore(pool), nil, nil
+	case "memory":
+		cfg.Logger.Warn("Using in-memory storage, all data is lost on restart")
+		return repository.NewMemoryStore(), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown DB_DRIVER %q", cfg.DBDriver)
+	}
+}
+
+func logAppliedMigrations(cfg *config.Config, applied []string) {
+	for _, version := range applied {
+		cfg.Logger.WithField("version", version).Info("Applied database migration")
+	}
+}
+
+// minHMACKeyLength is the shortest JWT_KEY accepted for HS256 (RFC 7518
+// asks for a key as long as the hash).
+const minHMACKeyLength = 32
+
+// newKeyRing loads the signing keys of JWT_KEY_SOURCE. Tokens are signed with
+// an asymmetric key unless HS256 is chosen with JWT_KEY_SOURCE=hmac.
+func newKeyRing(cfg *config.Config, db *mongo.Database) (*jwt.KeyRing, error) {
+	var store jwt.KeyStore
+	switch cfg.JWTKeySource {
+	case "dir":
+		store = jwt.NewDirKeyStore(cfg.JWTKeysDir)
+	case "mongo":
+		if db == nil {
+			return nil, fmt.Errorf("JWT_KEY_SOURCE=mongo requires DB_DRIVER=mongo")
+		}
+		store = jwt.NewMongoKeyStore(db)
+	case "static":
+		if cfg.JWTKeyFile == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required with JWT_KEY_SOURCE=static")
+		}
+
+		key, err := jwt.LoadPEMKey(cfg.JWTKeyFile, cfg.JWTKeyID)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Logger.WithFields(logrus.Fields{
+			"kid": key.ID,
+			"alg": key.Method.Alg(),
+		}).Info("Loaded JWT signing key")
+		return jwt.NewStaticKeyRing(key), nil
+	case "hmac":
+		// A shared secret is only meant for development: the JWKS stays
+		// empty, so no one else can verify the tokens.
+		if len(cfg.JWTKey) < minHMACKeyLength {
+			return nil, fmt.Errorf("JWT_KEY_SOURCE=hmac requires a JWT_KEY of at least %d characters", minHMACKeyLength)
+		}
+		cfg.Logger.Warn("Tokens are signed with HS256 and JWT_KEY, the JWKS is empty")
+		return jwt.NewStaticKeyRing(jwt.NewHMACKey([]byte(cfg.JWTKey), cfg.JWTKeyID)), nil
+	default:
+		return nil, fmt.Errorf("unknown JWT_KEY_SOURCE %q", cfg.JWTKeySource)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keyRing := jwt.NewKeyRing(store)
+	if err := keyRing.Load(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := keyRing.SigningKey(); err != nil {
+		return nil, err
+	}
+	cfg.Logger.WithField("source", cfg.JWTKeySource).Info("Loaded JWT key ring")
+	return keyRing, nil
+}
+
+// newSecretBox returns the cipher for TOTP secrets. MFA_ENCRYPTION_KEY must
+// hold 32 random bytes in base64. The key is never derived from another
+// secret, so rotating JWT_KEY cannot make TOTP secrets unreadable.
+func newSecretBox(cfg *config.Config) (*secretbox.Box, error) {
+	if cfg.MFAEncryptionKey == "" {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY is required")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.MFAEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY is not valid base64: %w", err)
+	}
+	if len(key) != secretbox.KeySize {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be %d bytes, got %d", secretbox.KeySize, len(key))
+	}
+	return secretbox.New(key)
+}
+
+// newRelyingParty configures WebAuthn for WEBAUTHN_RP_ID. Browsers only hand
+// out passkeys to the origins listed in WEBAUTHN_RP_ORIGINS.
+func newRelyingParty(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.WebAuthnTimeout, TimeoutUVD: cfg.WebAuthnTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnRPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// newPasswordManager sets up hashing with PASSWORD_HASH_ALGORITHM. The
+// pepper, PASSWORD_PEPPER, holds random bytes in base64; losing it makes
+// every password hashed with it unusable.
+func newPasswordManager(cfg *config.Config) (*password.Manager, error) {
+	var hasher password.Hasher
+	switch cfg.PasswordHashAlgorithm {
+	case "argon2id":
+		for name, value := range map[string]int{
+			"ARGON2_MEMORY":      cfg.Argon2Memory,
+			"ARGON2_ITERATIONS":  cfg.Argon2Iterations,
+			"ARGON2_SALT_LENGTH": cfg.Argon2SaltLength,
+			"ARGON2_KEY_LENGTH":  cfg.Argon2KeyLength,
+		} {
+			if value <= 0 || value > math.MaxUint32 {
+				return nil, fmt.Errorf("%s is out of range", name)
+			}
+		}
+		if cfg.Argon2Parallelism <= 0 || cfg.Argon2Parallelism > math.MaxUint8 {
+			return nil, fmt.Errorf("ARGON2_PARALLELISM is out of range")
+		}
+		argon := &password.Argon2id{
+			Memory:      uint32(cfg.Argon2Memory),
+			Iterations:  uint32(cfg.Argon2Iterations),
+			Parallelism: uint8(cfg.Argon2Parallelism),
+			SaltLength:  uint32(cfg.Argon2SaltLength),
+			KeyLength:   uint32(cfg.Argon2KeyLength),
+		}
+		if err := argon.Validate(); err != nil {
+			return nil, err
+		}
+		hasher = argon
+	case "bcrypt":
+		bcrypt := &password.Bcrypt{Cost: cfg.BcryptCost}
+		if err := bcrypt.Validate(); err != nil {
+			return nil, err
+		}
+		hasher = bcrypt
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASH_ALGORITHM %q", cfg.PasswordHashAlgorithm)
+	}
+
+	pepper := password.Pepper{ID: cfg.PasswordPepperID}
+	if cfg.PasswordPepper != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.PasswordPepper)
+		if err != nil {
+			return nil, fmt.Errorf("PASSWORD_PEPPER is not valid base64: %w", err)
+		}
+		if len(key) < 16 {
+			return nil, fmt.Errorf("PASSWORD_PEPPER must be at least 16 bytes")
+		}
+		pepper.Key = key
+	}
+
+	oldPeppers := make([]password.Pepper, 0, len(cfg.PasswordOldPeppers))
+	for _, entry := range cfg.PasswordOldPeppers {
+		id, encoded, ok := strings.Cut(entry, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("PASSWORD_OLD_PEPPERS entries must be <id>:<base64 key>")
+		}
+		oldPeppers = append(oldPeppers, password.Pepper{ID: id, Key: key})
+	}
+	return password.NewManager(hasher, pepper, oldPeppers...)
+}
+
+// newPasswordPolicy builds the policy for new passwords. PASSWORD_BANNED_FILE
+// adds its passwords, one per line, to the built-in list of common ones.
+func newPasswordPolicy(cfg *config.Config) (*password.Policy, error) {
+	if cfg.PasswordMinLength < 1 {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if cfg.PasswordMaxLength != 0 && cfg.PasswordMaxLength < cfg.PasswordMinLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH must not be below PASSWORD_MIN_LENGTH")
+	}
+	for _, class := range cfg.PasswordRequiredClasses {
+		if err := password.ValidateClass(class); err != nil {
+			return nil, fmt.Errorf("PASSWORD_REQUIRED_CLASSES: %w", err)
+		}
+	}
+
+	policy := password.NewPolicy()
+	policy.MinLength = cfg.PasswordMinLength
+	policy.MaxLength = cfg.PasswordMaxLength
+	policy.RequiredClasses = cfg.PasswordRequiredClasses
+	policy.MinEntropy = cfg.PasswordMinEntropy
+	policy.CheckUserInfo = cfg.PasswordCheckUserInfo
+
+	if cfg.PasswordBannedFile != "" {
+		file, err := os.Open(cfg.PasswordBannedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PASSWORD_BANNED_FILE: %w", err)
+		}
+		defer file.Close()
+		if err := policy.AddBanned(file); err != nil {
+			return nil, fmt.Errorf("failed to read PASSWORD_BANNED_FILE: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// newRateLimiter parses the policies and connects to the store selected by
+// RATE_LIMIT_STORE. With RATE_LIMIT_ENABLED=false it has no policies and
+// allows everything.
+func newRateLimiter(cfg *config.Config) (*ratelimit.Limiter, error) {
+	if !cfg.RateLimitEnabled {
+		cfg.Logger.Warn("Rate limiting is disabled")
+		return ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil), nil
+	}
+
+	policies := make([]ratelimit.Policy, 0, len(cfg.RateLimits))
+	for name, spec := range cfg.RateLimits {
+		policy, err := ratelimit.ParsePolicy(name, spec)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	switch cfg.RateLimitStore {
+	case "memory":
+		return ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policies), nil
+	case "redis":
+		options, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		client := redis.NewClient(options)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		return ratelimit.NewLimiter(ratelimit.NewRedisStore(client), policies), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", cfg.RateLimitStore)
+	}
+}
+
+// loadOAuthClients reads the clients to register from OAUTH_CLIENTS_FILE, a
+// JSON array. Without the file no clients are configured.
+func loadOAuthClients(cfg *config.Config) ([]models.OAuthClientConfig, error) {
+	if cfg.OAuthClientsFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(cfg.OAuthClientsFile)
+	if err != nil {
+		return nil, err
+	}
+	var clients []models.OAuthClientConfig
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// usesGrant reports whether one of the configured clients may use
+// grantType. Clients without grant_types get the authorization code grant.
+func usesGrant(clients []models.OAuthClientConfig, grantType string) bool {
+	for _, client := range clients {
+		grantTypes := client.GrantTypes
+		if len(grantTypes) == 0 {
+			grantTypes = []string{models.GrantAuthorizationCode}
+		}
+		if slices.Contains(grantTypes, grantType) {
+			return true
+		}
+	}
+	return false
+}
+
+func newMailer(cfg *config.Config) (mailer.Mailer, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		cfg.Logger.WithField("dir", cfg.MailDir).Warn("Emails are written to files instead of being sent")
+		return mailer.NewFileMailer(cfg.MailDir), nil
+	case "log":
+		cfg.Logger.Warn("Emails are written to the log instead of being sent")
+		return mailer.NewLogMailer(cfg.Logger), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", cfg.Mailer)
+	}
+}
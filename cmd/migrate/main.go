@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github/alexnoodl/raiko-auth/internal/config"
+	"github/alexnoodl/raiko-auth/pkg/database"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: migrate <command>
+
+Manages the schema of the database selected by DB_DRIVER (mongo or postgres).
+The server applies pending migrations itself unless DB_AUTO_MIGRATE=false.
+
+Commands:
+  up        apply pending migrations and create missing indexes
+  status    show every known migration and when it was applied
+`
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+
+	command := os.Args[1]
+	switch command {
+	case "up":
+		err = migrateUp(cfg)
+	case "status":
+		err = showStatus(cfg)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		cfg.Logger.Fatal(command, ": ", err)
+	}
+}
+
+func migrateUp(cfg *config.Config) error {
+	var (
+		applied []string
+		err     error
+	)
+	switch cfg.DBDriver {
+	case "mongo":
+		db, connErr := database.InitMongoDB(cfg)
+		if connErr != nil {
+			return connErr
+		}
+		applied, err = database.MigrateMongo(db)
+	case "postgres":
+		pool, connErr := database.InitPostgres(cfg)
+		if connErr != nil {
+			return connErr
+		}
+		defer pool.Close()
+		applied, err = database.MigratePostgres(pool)
+	default:
+		return fmt.Errorf("migrations require DB_DRIVER=mongo or postgres, got %q", cfg.DBDriver)
+	}
+
+	for _, version := range applied {
+		fmt.Println("applied", version)
+	}
+	if err == nil && len(applied) == 0 {
+		fmt.Println("schema is up to date")
+	}
+	return err
+}
+
+func showStatus(cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var (
+		statuses []database.MigrationStatus
+		err      error
+	)
+	switch cfg.DBDriver {
+	case "mongo":
+		db, connErr := database.InitMongoDB(cfg)
+		if connErr != nil {
+			return connErr
+		}
+		statuses, err = database.MongoMigrationStatus(ctx, db)
+	case "postgres":
+		pool, connErr := database.InitPostgres(cfg)
+		if connErr != nil {
+			return connErr
+		}
+		defer pool.Close()
+		statuses, err = database.PostgresMigrationStatus(ctx, pool)
+	default:
+		return fmt.Errorf("migrations require DB_DRIVER=mongo or postgres, got %q", cfg.DBDriver)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", status.Version, appliedAt)
+	}
+	return w.Flush()
+}
@@ -4,15 +4,120 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"github/alexnoodl/raiko-auth/pkg/logger"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Port     string
+	DBDriver string
 	MongoURI string
-	JWTKey   string
 	DBName   string
 	Logger   *logrus.Logger
+
+	PostgresDSN   string
+	DBAutoMigrate bool
+
+	JWTKey          string
+	JWTKeyFile      string
+	JWTKeyID        string
+	JWTKeySource    string
+	JWTKeysDir      string
+	JWTKeysReload   time.Duration
+	JWTIssuer       string
+	JWTAudience     string
+	DefaultScopes   []string
+	DefaultRole     string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	MFAEncryptionKey string
+	MFAIssuer        string
+	MFAChallengeTTL  time.Duration
+
+	WebAuthnRPID      string
+	WebAuthnRPName    string
+	WebAuthnRPOrigins []string
+	WebAuthnTimeout   time.Duration
+
+	LoginFailureWindow    time.Duration
+	LoginDelayAfter       int
+	LoginIPDelayAfter     int
+	LoginDelayBase        time.Duration
+	LoginDelayMax         time.Duration
+	LoginLockoutThreshold int
+	LoginLockoutDuration  time.Duration
+	TrustedProxies        []string
+
+	PasswordHashAlgorithm string
+	Argon2Memory          int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	Argon2SaltLength      int
+	Argon2KeyLength       int
+	BcryptCost            int
+	PasswordPepper        string
+	PasswordPepperID      string
+	// PasswordOldPeppers holds retired peppers as "<id>:<base64 key>", so
+	// hashes made with them still verify until they are upgraded on login.
+	PasswordOldPeppers []string
+
+	PasswordMinLength       int
+	PasswordMaxLength       int
+	PasswordRequiredClasses []string
+	PasswordMinEntropy      float64
+	PasswordBannedFile      string
+	PasswordCheckUserInfo   bool
+	// PasswordHistory is how many of the latest passwords, the current one
+	// included, cannot be chosen again. 0 turns the check off.
+	PasswordHistory int
+
+	RateLimitEnabled bool
+	RateLimitStore   string
+	RedisURL         string
+	// RateLimits holds the policy of every rate limit by name, in the form
+	// ratelimit.ParsePolicy reads.
+	RateLimits map[string]string
+
+	// OAuthClientsFile is a JSON array of models.OAuthClientConfig, saved on
+	// every start.
+	OAuthClientsFile string
+	// OAuthLoginURL is the page that logs the user in and asks them to approve
+	// an authorization request, given as ?request_id=. The service does not
+	// serve it, so it has no default.
+	OAuthLoginURL   string
+	OAuthRequestTTL time.Duration
+	OAuthCodeTTL    time.Duration
+	// OAuthDevicePageURL is the page where the user enters the user code of
+	// the device authorization grant, given as ?user_code= when known. Like
+	// OAuthLoginURL it is not served by the service and has no default.
+	OAuthDevicePageURL      string
+	OAuthDeviceCodeTTL      time.Duration
+	OAuthDevicePollInterval time.Duration
+	// OAuthRegistrationToken is the initial access token required for
+	// dynamic client registration, which is turned off when it is empty.
+	// Registered clients may only ask for OAuthRegistrationScopes.
+	OAuthRegistrationToken  string
+	OAuthRegistrationScopes []string
+	// OIDCIssuer is the public URL of this server: the iss of ID tokens and
+	// the base of the endpoints in the discovery document.
+	OIDCIssuer string
+
+	AppBaseURL           string
+	EmailVerificationTTL time.Duration
+	PasswordResetURL     string
+	PasswordResetTTL     time.Duration
+
+	Mailer       string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
 func LoadConfig() (*Config, error) {
@@ -29,18 +134,190 @@ func LoadConfig() (*Config, error) {
 
 	cfg := &Config{
 		Port:     getEnv("PORT", "8080"),
+		DBDriver: getEnv("DB_DRIVER", "mongo"),
 		MongoURI: getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		JWTKey:   getEnv("JWT_KEY", "secret"),
 		DBName:   getEnv("DB_NAME", "auth"),
 		Logger:   logger,
+
+		PostgresDSN:   getEnv("POSTGRES_DSN", "postgres://localhost:5432/auth?sslmode=disable"),
+		DBAutoMigrate: getEnvBool(logger, "DB_AUTO_MIGRATE", true),
+
+		JWTKey:          getEnv("JWT_KEY", ""),
+		JWTKeyFile:      getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTKeyID:        getEnv("JWT_KEY_ID", ""),
+		JWTKeySource:    getEnv("JWT_KEY_SOURCE", "static"),
+		JWTKeysDir:      getEnv("JWT_KEYS_DIR", "keys"),
+		JWTKeysReload:   getEnvDuration(logger, "JWT_KEYS_RELOAD_INTERVAL", time.Minute),
+		JWTIssuer:       getEnv("JWT_ISSUER", "raiko-auth"),
+		JWTAudience:     getEnv("JWT_AUDIENCE", "raiko-auth"),
+		DefaultScopes:   strings.Fields(getEnv("DEFAULT_SCOPES", "openid profile email")),
+		DefaultRole:     getEnv("DEFAULT_ROLE", "user"),
+		AccessTokenTTL:  getEnvDuration(logger, "ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration(logger, "REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		MFAEncryptionKey: getEnv("MFA_ENCRYPTION_KEY", ""),
+		MFAIssuer:        getEnv("MFA_ISSUER", "Raiko Auth"),
+		MFAChallengeTTL:  getEnvDuration(logger, "MFA_CHALLENGE_TTL", 5*time.Minute),
+
+		WebAuthnTimeout: getEnvDuration(logger, "WEBAUTHN_TIMEOUT", 5*time.Minute),
+
+		LoginFailureWindow:    getEnvDuration(logger, "LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginDelayAfter:       getEnvInt(logger, "LOGIN_DELAY_AFTER", 3),
+		LoginIPDelayAfter:     getEnvInt(logger, "LOGIN_IP_DELAY_AFTER", 20),
+		LoginDelayBase:        getEnvDuration(logger, "LOGIN_DELAY_BASE", time.Second),
+		LoginDelayMax:         getEnvDuration(logger, "LOGIN_DELAY_MAX", 30*time.Second),
+		LoginLockoutThreshold: getEnvInt(logger, "LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginLockoutDuration:  getEnvDuration(logger, "LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		// Without trusted proxies X-Forwarded-For is ignored, so clients cannot
+		// pick the IP their failed logins are counted against.
+		TrustedProxies: strings.Fields(getEnv("TRUSTED_PROXIES", "")),
+
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		Argon2Memory:          getEnvInt(logger, "ARGON2_MEMORY", 64*1024),
+		Argon2Iterations:      getEnvInt(logger, "ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     getEnvInt(logger, "ARGON2_PARALLELISM", 2),
+		Argon2SaltLength:      getEnvInt(logger, "ARGON2_SALT_LENGTH", 16),
+		Argon2KeyLength:       getEnvInt(logger, "ARGON2_KEY_LENGTH", 32),
+		BcryptCost:            getEnvInt(logger, "BCRYPT_COST", 12),
+		PasswordPepper:        getEnv("PASSWORD_PEPPER", ""),
+		PasswordPepperID:      getEnv("PASSWORD_PEPPER_ID", "1"),
+		PasswordOldPeppers:    strings.Fields(getEnv("PASSWORD_OLD_PEPPERS", "")),
+
+		PasswordMinLength:       getEnvInt(logger, "PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:       getEnvInt(logger, "PASSWORD_MAX_LENGTH", 128),
+		PasswordRequiredClasses: strings.Fields(getEnv("PASSWORD_REQUIRED_CLASSES", "")),
+		PasswordMinEntropy:      getEnvFloat(logger, "PASSWORD_MIN_ENTROPY", 35),
+		PasswordBannedFile:      getEnv("PASSWORD_BANNED_FILE", ""),
+		PasswordCheckUserInfo:   getEnvBool(logger, "PASSWORD_CHECK_USER_INFO", true),
+		PasswordHistory:         getEnvInt(logger, "PASSWORD_HISTORY", 5),
+
+		RateLimitEnabled: getEnvBool(logger, "RATE_LIMIT_ENABLED", true),
+		RateLimitStore:   getEnv("RATE_LIMIT_STORE", "memory"),
+		RedisURL:         getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		RateLimits:       map[string]string{},
+
+		OAuthClientsFile: getEnv("OAUTH_CLIENTS_FILE", ""),
+		OAuthLoginURL:    getEnv("OAUTH_LOGIN_URL", ""),
+		OAuthRequestTTL:  getEnvDuration(logger, "OAUTH_REQUEST_TTL", 10*time.Minute),
+		OAuthCodeTTL:     getEnvDuration(logger, "OAUTH_CODE_TTL", time.Minute),
+		OIDCIssuer:       strings.TrimRight(getEnv("OIDC_ISSUER", "http://localhost:8080"), "/"),
+
+		OAuthDevicePageURL:      getEnv("OAUTH_DEVICE_PAGE_URL", ""),
+		OAuthDeviceCodeTTL:      getEnvDuration(logger, "OAUTH_DEVICE_CODE_TTL", 10*time.Minute),
+		OAuthDevicePollInterval: getEnvDuration(logger, "OAUTH_DEVICE_POLL_INTERVAL", 5*time.Second),
+
+		OAuthRegistrationToken:  getEnv("OAUTH_REGISTRATION_TOKEN", ""),
+		OAuthRegistrationScopes: strings.Fields(getEnv("OAUTH_REGISTRATION_SCOPES", "openid email profile")),
+
+		AppBaseURL:           strings.TrimRight(getEnv("APP_BASE_URL", "http://localhost:8080"), "/"),
+		EmailVerificationTTL: getEnvDuration(logger, "EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		PasswordResetTTL:     getEnvDuration(logger, "PASSWORD_RESET_TTL", 30*time.Minute),
+
+		Mailer:       getEnv("MAILER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@raiko.local"),
+		MailDir:      getEnv("MAIL_DIR", "mail"),
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+	}
+
+	cfg.PasswordResetURL = getEnv("PASSWORD_RESET_URL", cfg.AppBaseURL+"/reset-password")
+
+	// Passkeys are bound to the relying party ID, so changing it later makes
+	// every registered credential unusable.
+	cfg.WebAuthnRPID = getEnv("WEBAUTHN_RP_ID", hostname(cfg.AppBaseURL))
+	cfg.WebAuthnRPName = getEnv("WEBAUTHN_RP_NAME", cfg.MFAIssuer)
+	cfg.WebAuthnRPOrigins = strings.Fields(getEnv("WEBAUTHN_RP_ORIGINS", cfg.AppBaseURL))
+
+	for name, spec := range defaultRateLimits {
+		cfg.RateLimits[name] = getEnv("RATE_LIMIT_"+strings.ToUpper(name), spec)
 	}
 
 	return cfg, nil
 }
 
+// defaultRateLimits lists the rate limit policies. Each can be changed with
+// RATE_LIMIT_<NAME>, or turned off with the value "off".
+var defaultRateLimits = map[string]string{
+	"register":      "sliding_window 10/1h key=ip",
+	"login":         "token_bucket 20/1m key=ip",
+	"login_account": "sliding_window 30/15m key=login",
+	"mfa":           "token_bucket 10/1m key=ip",
+	"email":         "sliding_window 10/1h key=ip",
+	"refresh":       "token_bucket 30/1m key=ip",
+	"oauth":         "token_bucket 30/1m key=ip",
+	"introspect":    "token_bucket 600/1m key=ip",
+	"account":       "token_bucket 60/1m key=subject",
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return defaultValue
 }
+
+func hostname(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return "localhost"
+	}
+	return parsed.Hostname()
+}
+
+func getEnvDuration(log *logrus.Logger, key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithField("key", key).Warn("Invalid duration in environment, using default")
+		return defaultValue
+	}
+	return duration
+}
+
+func getEnvInt(log *logrus.Logger, key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.WithField("key", key).Warn("Invalid integer in environment, using default")
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvFloat(log *logrus.Logger, key string, defaultValue float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.WithField("key", key).Warn("Invalid number in environment, using default")
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvBool(log *logrus.Logger, key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.WithField("key", key).Warn("Invalid boolean in environment, using default")
+		return defaultValue
+	}
+	return parsed
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"net/http"
+)
+
+// ListClients
+// @Summary Список OAuth-клиентов
+// @Description Возвращает всех клиентов, старые первыми. Секреты клиентов не возвращаются
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.ClientInformation "Клиенты"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients [get]
+func (h *AdminHandler) ListClients(c *gin.Context) {
+	h.logger.Info("Received admin list clients request")
+
+	clients, err := h.authService.ListClients()
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list clients")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+// GetClient
+// @Summary OAuth-клиент по ID
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID клиента"
+// @Success 200 {object} models.ClientInformation "Клиент"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Клиент не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients/{id} [get]
+func (h *AdminHandler) GetClient(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("id")).Info("Received admin get client request")
+
+	client, err := h.authService.GetClient(c.Param("id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get client")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
+
+// CreateClient
+// @Summary Создание OAuth-клиента
+// @Description Создает клиента с метаданными RFC 7591. Если client_id не задан, он генерируется. Секрет клиента генерируется сервером и возвращается только в этом ответе. В отличие от динамической регистрации, разрешены любые scopes и skip_consent
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.ClientMetadata true "Метаданные клиента"
+// @Success 201 {object} models.ClientInformation "Созданный клиент"
+// @Failure 400 {object} models.ErrorResponse "Неверные метаданные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Клиент с таким ID уже есть"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients [post]
+func (h *AdminHandler) CreateClient(c *gin.Context) {
+	h.logger.Info("Received admin create client request")
+
+	var metadata models.ClientMetadata
+
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		h.logger.WithError(err).Error("Failed to parse create client request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	client, err := h.authService.CreateClient(metadata)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to create client")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, client)
+}
+
+// UpdateClient
+// @Summary Изменение OAuth-клиента
+// @Description Заменяет метаданные клиента целиком: пропущенные поля получают значения по умолчанию. Секрет клиента сохраняется; если клиент переходит на аутентификацию секретом, новый секрет возвращается в ответе
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID клиента"
+// @Param body body models.ClientMetadata true "Метаданные клиента"
+// @Success 200 {object} models.ClientInformation "Обновленный клиент"
+// @Failure 400 {object} models.ErrorResponse "Неверные метаданные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Клиент не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients/{id} [put]
+func (h *AdminHandler) UpdateClient(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("id")).Info("Received admin update client request")
+
+	var metadata models.ClientMetadata
+
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		h.logger.WithError(err).Error("Failed to parse update client request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	client, err := h.authService.UpdateClient(c.Param("id"), metadata)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to update client")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, client)
+}
+
+// RotateClientSecret
+// @Summary Смена секрета OAuth-клиента
+// @Description Выдает клиенту новый секрет, старый сразу перестает действовать. Новый секрет возвращается только в этом ответе
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID клиента"
+// @Success 200 {object} models.ClientInformation "Клиент с новым секретом"
+// @Failure 400 {object} models.ErrorResponse "Клиент не использует секрет"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Клиент не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients/{id}/secret [post]
+func (h *AdminHandler) RotateClientSecret(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("id")).Info("Received admin rotate client secret request")
+
+	client, err := h.authService.RotateClientSecret(c.Param("id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to rotate client secret")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, client)
+}
+
+// DeleteClient
+// @Summary Удаление OAuth-клиента
+// @Description Удаляет клиента вместе с незавершенными запросами авторизации. Клиент больше не может получать и обновлять токены
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID клиента"
+// @Success 200 {object} models.SuccessResponse "Клиент удален"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Клиент не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/clients/{id} [delete]
+func (h *AdminHandler) DeleteClient(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("id")).Info("Received admin delete client request")
+
+	if err := h.authService.DeleteClient(c.Param("id")); err != nil {
+		h.logger.WithError(err).Error("Failed to delete client")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Client deleted"})
+}
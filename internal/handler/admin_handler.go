@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+	"strconv"
+)
+
+type AdminHandler struct {
+	authService *services.AuthService
+	logger      *logrus.Logger
+}
+
+func NewAdminHandler(authService *services.AuthService, logger *logrus.Logger) *AdminHandler {
+	return &AdminHandler{
+		authService: authService,
+		logger:      logger,
+	}
+}
+
+// ListUsers
+// @Summary Список пользователей
+// @Description Возвращает страницу пользователей с фильтрами по роли, активности, дате создания и префиксу email или username. Для следующей страницы передайте next_cursor в параметре cursor
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param role query string false "Роль"
+// @Param active query bool false "Активность аккаунта"
+// @Param created_after query string false "Создан не раньше (RFC3339)"
+// @Param created_before query string false "Создан раньше (RFC3339)"
+// @Param q query string false "Префикс email или username"
+// @Param sort query string false "Поле сортировки: created_at, email, username" default(created_at)
+// @Param order query string false "Порядок сортировки: asc, desc"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Param limit query int false "Размер страницы (не больше 200)" default(50)
+// @Success 200 {object} models.UserList "Страница пользователей"
+// @Failure 400 {object} models.ErrorResponse "Неверные параметры"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users [get]
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	h.logger.Info("Received admin list users request")
+
+	var filter models.UserFilter
+
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		h.logger.WithError(err).Error("Failed to parse list users query")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters"})
+		return
+	}
+
+	users, err := h.authService.ListUsers(filter)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list users")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// GetUser
+// @Summary Пользователь по ID
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.UserProfile "Пользователь"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id} [get]
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin get user request")
+
+	user, err := h.authService.GetUser(c.Param("id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// SetRole
+// @Summary Смена роли пользователя
+// @Description Назначает пользователю роль из коллекции roles и завершает его сессии, чтобы новые права применились сразу
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Param body body models.SetRoleRequest true "Новая роль"
+// @Success 200 {object} models.UserProfile "Обновленный пользователь"
+// @Failure 400 {object} models.ErrorResponse "Неизвестная роль"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/role [put]
+func (h *AdminHandler) SetRole(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin set role request")
+
+	var req models.SetRoleRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse set role request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	user, err := h.authService.SetUserRole(c.Param("id"), req.Role)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to set user role")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// Activate
+// @Summary Активация пользователя
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.UserProfile "Обновленный пользователь"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/activate [post]
+func (h *AdminHandler) Activate(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+// Deactivate
+// @Summary Деактивация пользователя
+// @Description Блокирует вход пользователя и завершает все его сессии
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.UserProfile "Обновленный пользователь"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/deactivate [post]
+func (h *AdminHandler) Deactivate(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+// ForcePasswordReset
+// @Summary Принудительный сброс пароля
+// @Description Делает текущий пароль недействительным, завершает все сессии и отправляет пользователю письмо для сброса пароля
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.SuccessResponse "Пароль сброшен, письмо отправлено"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/password-reset [post]
+func (h *AdminHandler) ForcePasswordReset(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin force password reset request")
+
+	if err := h.authService.ForcePasswordReset(c.Param("id")); err != nil {
+		h.logger.WithError(err).Error("Failed to force password reset")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset email sent"})
+}
+
+// RevokeSessions
+// @Summary Завершение сессий пользователя
+// @Description Отзывает все access- и refresh-токены пользователя
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.SuccessResponse "Сессии завершены"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/revoke-sessions [post]
+func (h *AdminHandler) RevokeSessions(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin revoke sessions request")
+
+	if err := h.authService.RevokeUserSessions(c.Param("id")); err != nil {
+		h.logger.WithError(err).Error("Failed to revoke user sessions")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked"})
+}
+
+// UnlockUser
+// @Summary Разблокировка пользователя
+// @Description Снимает временную блокировку после неудачных попыток входа и обнуляет их счетчик. Счетчик попыток по IP не сбрасывается
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.UserProfile "Обновленный пользователь"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/unlock [post]
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin unlock user request")
+
+	user, err := h.authService.UnlockUser(c.Param("id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to unlock user")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// DeleteUser
+// @Summary Удаление пользователя
+// @Description Удаляет пользователя вместе с его refresh-токенами и токенами подтверждения
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Success 200 {object} models.SuccessResponse "Пользователь удален"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id} [delete]
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin delete user request")
+
+	if err := h.authService.DeleteUser(c.Param("id")); err != nil {
+		h.logger.WithError(err).Error("Failed to delete user")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// ListAuditEvents
+// @Summary Журнал аудита пользователя
+// @Description Возвращает последние события безопасности пользователя, новые первыми
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID пользователя"
+// @Param limit query int false "Количество событий (не больше 100)" default(100)
+// @Success 200 {array} models.AuditEvent "События"
+// @Failure 400 {object} models.ErrorResponse "Неверные параметры"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/admin/users/{id}/audit [get]
+func (h *AdminHandler) ListAuditEvents(c *gin.Context) {
+	h.logger.WithField("user_id", c.Param("id")).Info("Received admin audit log request")
+
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := h.authService.ListAuditEvents(c.Param("id"), limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list audit events")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (h *AdminHandler) setActive(c *gin.Context, active bool) {
+	h.logger.WithFields(logrus.Fields{
+		"user_id": c.Param("id"),
+		"active":  active,
+	}).Info("Received admin set active request")
+
+	user, err := h.authService.SetUserActive(c.Param("id"), active)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to change user activity")
+		h.respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+func (h *AdminHandler) respondAdminError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	switch {
+	case errors.Is(err, services.ErrUnknownRole),
+		errors.Is(err, services.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidFilter),
+		errors.Is(err, services.ErrNoClientSecret),
+		errors.As(err, &oauthErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound),
+		errors.Is(err, services.ErrClientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrClientExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/middleware"
 	"github/alexnoodl/raiko-auth/internal/models"
 	"github/alexnoodl/raiko-auth/internal/services"
 	"net/http"
+	"strconv"
 )
 
 type AuthHandler struct {
@@ -22,14 +25,15 @@ func NewAuthHandler(authService *services.AuthService, logger *logrus.Logger) *A
 
 // Register
 // @Summary Регистрация нового пользователя
-// @Description Создает нового пользователя с указанными email, username и паролем
+// @Description Создает нового неактивного пользователя с указанными email, username и паролем и отправляет письмо для подтверждения email
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param body body models.RegisterRequest true "Данные для регистрации"
 // @Success 201 {object} models.SuccessResponse "Пользователь успешно создан"
-// @Failure 400 {object} models.ErrorResponse "Неверные данные или пароль не соответствует требованиям"
+// @Failure 400 {object} models.PasswordPolicyErrorResponse "Неверные данные или пароль не соответствует требованиям"
 // @Failure 409 {object} models.ErrorResponse "Email или username уже занят"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
 // @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
 // @Router /api/v1/register [post]
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -60,23 +64,25 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			"email": user.Email,
 			"error": err,
 		}).Error("Registration failed")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.respondProfileError(c, err)
 		return
 	}
 
 	h.logger.WithField("email", user.Email).Info("Registration request completed successfully")
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully, check your email to activate the account"})
 }
 
 // Login
 // @Summary Аутентификация пользователя
-// @Description Аутентифицирует пользователя и возвращает JWT-токен
+// @Description Аутентифицирует пользователя и возвращает короткоживущий JWT-токен и refresh-токен. Если у пользователя включена двухфакторная аутентификация, вместо токенов возвращаются mfa_required, mfa_token и mfa_methods, а вход завершается через /api/v1/login/mfa. Неудачные попытки, в том числе неверные коды второго фактора, считаются для аккаунта и для IP клиента: после нескольких ошибок с одного IP следующую попытку нужно подождать (429 с заголовком Retry-After), а после LOGIN_LOCKOUT_THRESHOLD ошибок аккаунт временно блокируется. Аккаунт, которому нужно подождать, и заблокированный аккаунт получают тот же ответ 401, что и неверный пароль
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param body body models.LoginRequest true "Данные для входа"
-// @Success 200 {object} models.LoginResponse "Успешный вход"
+// @Success 200 {object} models.LoginResponse "Успешный вход или требуется второй фактор"
 // @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Неверный логин или пароль; при верном пароле — аккаунт не активен или email не подтвержден"
+// @Failure 429 {object} models.ErrorResponse "Слишком много неудачных попыток с этого IP, нужно подождать"
 // @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
 // @Router /api/v1/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -95,16 +101,299 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	h.logger.WithField("identifier", credentials.Login).Debug("Processing login request")
 
-	token, err := h.authService.Login(credentials.Login, credentials.Password)
+	result, err := h.authService.Login(credentials.Login, credentials.Password, c.ClientIP())
 	if err != nil {
 		h.logger.WithFields(logrus.Fields{
 			"identifier": credentials.Login,
 			"error":      err,
 		}).Error("Login failed")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		h.respondLoginError(c, err)
+		return
+	}
+
+	if result.Tokens == nil {
+		h.logger.WithField("login", credentials.Login).Info("Login request requires a second factor")
+		c.JSON(http.StatusOK, models.LoginResponse{
+			MFARequired: true,
+			MFAToken:    result.MFAToken,
+			MFAMethods:  result.MFAMethods,
+		})
 		return
 	}
 
 	h.logger.WithField("login", credentials.Login).Info("Login request completed successfully")
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{
+		"token":         result.Tokens.AccessToken,
+		"refresh_token": result.Tokens.RefreshToken,
+		"expires_in":    result.Tokens.ExpiresIn,
+	})
+}
+
+// VerifyEmail
+// @Summary Подтверждение email
+// @Description Подтверждает email по одноразовому токену из письма и активирует аккаунт. Токен можно передать в query (ссылка из письма) или в теле запроса
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Токен из письма"
+// @Param body body models.VerifyEmailRequest false "Токен из письма"
+// @Success 200 {object} models.SuccessResponse "Email подтвержден"
+// @Failure 400 {object} models.ErrorResponse "Токен недействителен, использован или истек"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/verify-email [get]
+// @Router /api/v1/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	h.logger.Info("Received email verification request")
+
+	var req models.VerifyEmailRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse email verification request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.VerifyEmail(req.Token); err != nil {
+		h.logger.WithError(err).Error("Email verification failed")
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	h.logger.Info("Email verification request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification
+// @Summary Повторная отправка письма подтверждения
+// @Description Отправляет новое письмо для подтверждения email. Ответ одинаков независимо от того, существует ли аккаунт
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.ResendVerificationRequest true "Email аккаунта"
+// @Success 202 {object} models.SuccessResponse "Запрос принят"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	h.logger.Info("Received verification resend request")
+
+	var req models.ResendVerificationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse verification resend request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.ResendVerificationEmail(req.Email); err != nil {
+		h.logger.WithError(err).Error("Verification resend failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is not verified yet, a new email has been sent"})
+}
+
+// ForgotPassword
+// @Summary Запрос на сброс пароля
+// @Description Отправляет на email одноразовую ссылку для сброса пароля. Ответ одинаков независимо от того, существует ли аккаунт
+// @Tags password
+// @Accept json
+// @Produce json
+// @Param body body models.ForgotPasswordRequest true "Email аккаунта"
+// @Success 202 {object} models.SuccessResponse "Запрос принят"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	h.logger.Info("Received forgot password request")
+
+	var req models.ForgotPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse forgot password request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.ForgotPassword(req.Email); err != nil {
+		h.logger.WithError(err).Error("Forgot password request failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+// ResetPassword
+// @Summary Сброс пароля
+// @Description Устанавливает новый пароль по одноразовому токену из письма и завершает все сессии пользователя
+// @Tags password
+// @Accept json
+// @Produce json
+// @Param body body models.ResetPasswordRequest true "Токен и новый пароль"
+// @Success 200 {object} models.SuccessResponse "Пароль изменен"
+// @Failure 400 {object} models.PasswordPolicyErrorResponse "Токен недействителен или пароль не соответствует требованиям"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	h.logger.Info("Received password reset request")
+
+	var req models.ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse password reset request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		h.logger.WithError(err).Error("Password reset failed")
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidVerificationToken) || errors.Is(err, services.ErrInvalidPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	h.logger.Info("Password reset request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// Refresh
+// @Summary Обновление токенов
+// @Description Обменивает refresh-токен на новую пару токенов. Использованный refresh-токен становится недействительным, а его повторное использование отзывает всё семейство токенов
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.RefreshRequest true "Refresh-токен"
+// @Success 200 {object} models.LoginResponse "Новая пара токенов"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Refresh-токен недействителен или отозван"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/token/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	h.logger.Info("Received token refresh request")
+
+	var req models.RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse refresh request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		h.logger.WithError(err).Error("Token refresh failed")
+		if errors.Is(err, services.ErrInvalidRefreshToken) ||
+			errors.Is(err, services.ErrRefreshTokenReused) ||
+			errors.Is(err, services.ErrAccountNotActive) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	h.logger.Info("Token refresh request completed successfully")
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// Logout
+// @Summary Выход из текущей сессии
+// @Description Отзывает переданный access-токен и, если указан, всё семейство refresh-токена
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.LogoutRequest false "Refresh-токен текущей сессии"
+// @Success 200 {object} models.SuccessResponse "Сессия завершена"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	h.logger.Info("Received logout request")
+
+	var req models.LogoutRequest
+
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.WithError(err).Error("Failed to parse logout request body")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	if err := h.authService.Logout(middleware.BearerToken(c), req.RefreshToken); err != nil {
+		h.logger.WithError(err).Error("Logout failed")
+		h.respondTokenError(c, err)
+		return
+	}
+
+	h.logger.Info("Logout request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll
+// @Summary Выход из всех сессий
+// @Description Отзывает все access- и refresh-токены пользователя
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.SuccessResponse "Все сессии завершены"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	h.logger.Info("Received logout-all request")
+
+	if err := h.authService.LogoutAll(middleware.BearerToken(c)); err != nil {
+		h.logger.WithError(err).Error("Logout from all sessions failed")
+		h.respondTokenError(c, err)
+		return
+	}
+
+	h.logger.Info("Logout-all request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all sessions"})
+}
+
+func (h *AuthHandler) respondLoginError(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.FormatInt(throttled.RetryAfterSeconds(), 10))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCredentials),
+		errors.Is(err, services.ErrEmailNotVerified),
+		errors.Is(err, services.ErrAccountNotActive):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+func (h *AuthHandler) respondTokenError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenRevoked) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 }
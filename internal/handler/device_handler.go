@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+)
+
+// DeviceAuthorization
+// @Summary Запрос авторизации устройства (RFC 8628)
+// @Description Начинает поток device authorization для устройств и CLI без браузера. Возвращает device_code для опроса /oauth/token и user_code, который пользователь вводит на странице verification_uri. Клиент аутентифицируется так же, как в /oauth/token, и должен быть зарегистрирован с grant urn:ietf:params:oauth:grant-type:device_code
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param client_id formData string false "ID клиента"
+// @Param client_secret formData string false "Секрет клиента (client_secret_post)"
+// @Param scope formData string false "Запрашиваемые scopes через пробел, по умолчанию все scopes клиента"
+// @Success 200 {object} models.DeviceAuthorizationResponse "Коды устройства и пользователя"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверный запрос или scope"
+// @Failure 401 {object} models.OAuthErrorResponse "Клиент не прошел аутентификацию"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/device_authorization [post]
+func (h *OAuthHandler) DeviceAuthorization(c *gin.Context) {
+	h.logger.Info("Received device authorization request")
+
+	c.Header("Cache-Control", "no-store")
+
+	var req models.TokenRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse device authorization request")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidRequest})
+		return
+	}
+
+	authMethod, err := clientCredentials(c, &req)
+	if err != nil {
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	response, err := h.authService.DeviceAuthorization(req, authMethod)
+	if err != nil {
+		h.logger.WithError(err).Warn("Device authorization request failed")
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeviceVerification
+// @Summary Адрес проверки устройства
+// @Description verification_uri потока device authorization. Перенаправляет браузер на страницу OAUTH_DEVICE_PAGE_URL, передавая user_code, если он есть в адресе
+// @Tags oauth
+// @Param user_code query string false "Код с экрана устройства"
+// @Success 302 "Редирект на страницу ввода кода"
+// @Failure 500 {object} models.OAuthErrorResponse "Страница ввода кода не настроена"
+// @Router /oauth/device [get]
+func (h *OAuthHandler) DeviceVerification(c *gin.Context) {
+	location, err := h.authService.DevicePage(c.Query("user_code"))
+	if err != nil {
+		h.logger.WithError(err).Error("Device verification page is not configured")
+		h.respondOAuthError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, location)
+}
+
+// GetDeviceAuthorization
+// @Summary Ожидающая авторизация устройства
+// @Description Возвращает клиента и scopes авторизации устройства по user_code, чтобы страница проверки могла показать их пользователю перед подтверждением. Регистр, пробелы и дефисы в коде не важны
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Param user_code path string true "Код с экрана устройства"
+// @Success 200 {object} models.DeviceAuthorizationInfo "Авторизация устройства"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Токен выдан OAuth-клиенту"
+// @Failure 404 {object} models.ErrorResponse "Код не найден, истек или уже использован"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/oauth/device/{user_code} [get]
+func (h *OAuthHandler) GetDeviceAuthorization(c *gin.Context) {
+	if !h.requireFirstParty(c) {
+		return
+	}
+
+	info, err := h.authService.GetDeviceAuthorization(c.Param("user_code"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get device authorization")
+		h.respondConsentError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// AnswerDeviceAuthorization
+// @Summary Подтверждение устройства
+// @Description Разрешает или отклоняет авторизацию устройства от имени текущего пользователя. Устройство получит токены или access_denied при следующем опросе /oauth/token. На каждую авторизацию можно ответить один раз
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user_code path string true "Код с экрана устройства"
+// @Param body body models.ConsentRequest true "Решение пользователя"
+// @Success 200 {object} models.SuccessResponse "Решение сохранено"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Токен выдан OAuth-клиенту"
+// @Failure 404 {object} models.ErrorResponse "Код не найден, истек или уже использован"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/oauth/device/{user_code}/consent [post]
+func (h *OAuthHandler) AnswerDeviceAuthorization(c *gin.Context) {
+	h.logger.Info("Received device authorization consent request")
+
+	if !h.requireFirstParty(c) {
+		return
+	}
+
+	var req models.ConsentRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse device consent request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	err := h.authService.AnswerDeviceAuthorization(middleware.Claims(c), c.Param("user_code"), req.Approve)
+	if err != nil {
+		h.logger.WithError(err).Error("Device authorization consent failed")
+		h.respondConsentError(c, err)
+		return
+	}
+
+	h.logger.Info("Device authorization consent request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Device authorization answered"})
+}
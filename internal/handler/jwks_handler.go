@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/pkg/jwt"
+	"net/http"
+)
+
+type JWKSHandler struct {
+	jwtManager *jwt.JWTManager
+	logger     *logrus.Logger
+}
+
+func NewJWKSHandler(jwtManager *jwt.JWTManager, logger *logrus.Logger) *JWKSHandler {
+	return &JWKSHandler{
+		jwtManager: jwtManager,
+		logger:     logger,
+	}
+}
+
+// JWKS
+// @Summary Публичные ключи подписи
+// @Description Возвращает JWK Set с публичными ключами, которыми можно проверять выданные токены
+// @Tags keys
+// @Produce json
+// @Success 200 {object} jwt.JWKS "Набор публичных ключей"
+// @Router /.well-known/jwks.json [get]
+func (h *JWKSHandler) JWKS(c *gin.Context) {
+	h.logger.Debug("Received JWKS request")
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.jwtManager.JWKS())
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+)
+
+// LoginMFA
+// @Summary Второй шаг входа
+// @Description Обменивает mfa_token, полученный при входе, и код из приложения-аутентификатора или код восстановления на пару токенов. Токен одноразовый: после неверного кода вход нужно начать заново, а сам код считается неудачной попыткой входа для аккаунта и IP клиента
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.LoginMFARequest true "MFA-токен и код"
+// @Success 200 {object} models.LoginResponse "Успешный вход"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен или код недействителен"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/login/mfa [post]
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	h.logger.Info("Received MFA login request")
+
+	var req models.LoginMFARequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse MFA login request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.LoginMFA(req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		h.logger.WithError(err).Error("MFA login failed")
+		switch {
+		case errors.Is(err, services.ErrInvalidMFAToken),
+			errors.Is(err, services.ErrInvalidMFACode),
+			errors.Is(err, services.ErrMFANotEnabled),
+			errors.Is(err, services.ErrAccountNotActive):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	h.logger.Info("MFA login request completed successfully")
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+// BeginTOTPEnrollment
+// @Summary Подключение приложения-аутентификатора
+// @Description Создает секрет TOTP и otpauth:// URI для QR-кода. Двухфакторная аутентификация включается только после подтверждения первым кодом
+// @Tags mfa
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.TOTPSetupResponse "Секрет и URI"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Двухфакторная аутентификация уже включена"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/mfa/totp [post]
+func (h *AuthHandler) BeginTOTPEnrollment(c *gin.Context) {
+	h.logger.Info("Received TOTP enrollment request")
+
+	setup, err := h.authService.BeginTOTPEnrollment(middleware.Claims(c).Subject)
+	if err != nil {
+		h.logger.WithError(err).Error("TOTP enrollment failed")
+		h.respondMFAError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, setup)
+}
+
+// ConfirmTOTPEnrollment
+// @Summary Подтверждение приложения-аутентификатора
+// @Description Включает двухфакторную аутентификацию после проверки кода из приложения и возвращает десять одноразовых кодов восстановления. Коды показываются только один раз
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.ConfirmTOTPRequest true "Код из приложения"
+// @Success 200 {object} models.RecoveryCodesResponse "Коды восстановления"
+// @Failure 400 {object} models.ErrorResponse "Неверный код"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Нет незавершенного подключения или оно уже подтверждено"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/mfa/totp/confirm [post]
+func (h *AuthHandler) ConfirmTOTPEnrollment(c *gin.Context) {
+	h.logger.Info("Received TOTP confirmation request")
+
+	var req models.ConfirmTOTPRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse TOTP confirmation request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	codes, err := h.authService.ConfirmTOTPEnrollment(middleware.Claims(c).Subject, req.Code)
+	if err != nil {
+		h.logger.WithError(err).Error("TOTP confirmation failed")
+		h.respondMFAError(c, err)
+		return
+	}
+
+	h.logger.Info("TOTP confirmation request completed successfully")
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP
+// @Summary Отключение двухфакторной аутентификации
+// @Description Удаляет приложение-аутентификатор и коды восстановления после проверки пароля
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.PasswordConfirmationRequest true "Текущий пароль"
+// @Success 200 {object} models.SuccessResponse "Двухфакторная аутентификация отключена"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Пароль неверен или недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Двухфакторная аутентификация не включена"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/mfa/totp/disable [post]
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	h.logger.Info("Received TOTP disable request")
+
+	var req models.PasswordConfirmationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse TOTP disable request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.DisableTOTP(middleware.Claims(c).Subject, req.Password); err != nil {
+		h.logger.WithError(err).Error("TOTP disable failed")
+		h.respondMFAError(c, err)
+		return
+	}
+
+	h.logger.Info("TOTP disable request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes
+// @Summary Новые коды восстановления
+// @Description Заменяет все коды восстановления новыми после проверки пароля. Старые коды перестают действовать
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.PasswordConfirmationRequest true "Текущий пароль"
+// @Success 200 {object} models.RecoveryCodesResponse "Коды восстановления"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Пароль неверен или недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Двухфакторная аутентификация не включена"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	h.logger.Info("Received recovery codes request")
+
+	var req models.PasswordConfirmationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse recovery codes request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(middleware.Claims(c).Subject, req.Password)
+	if err != nil {
+		h.logger.WithError(err).Error("Recovery codes regeneration failed")
+		h.respondMFAError(c, err)
+		return
+	}
+
+	h.logger.Info("Recovery codes request completed successfully")
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *AuthHandler) respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIncorrectPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
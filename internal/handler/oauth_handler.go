@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type OAuthHandler struct {
+	authService *services.AuthService
+	logger      *logrus.Logger
+}
+
+func NewOAuthHandler(authService *services.AuthService, logger *logrus.Logger) *OAuthHandler {
+	return &OAuthHandler{
+		authService: authService,
+		logger:      logger,
+	}
+}
+
+// Authorize
+// @Summary Запрос авторизации OAuth 2.1
+// @Description Начинает поток authorization code. Проверяет клиента, redirect_uri, scope и PKCE (обязателен S256), сохраняет запрос и перенаправляет браузер на страницу входа OAUTH_LOGIN_URL с параметром request_id. Ошибки после проверки redirect_uri возвращаются клиенту редиректом с error и state, а при неизвестном клиенте или redirect_uri ответ 400
+// @Tags oauth
+// @Produce json
+// @Param response_type query string true "Только code"
+// @Param client_id query string true "ID клиента"
+// @Param redirect_uri query string true "Зарегистрированный redirect URI"
+// @Param scope query string false "Запрашиваемые scopes через пробел, по умолчанию все scopes клиента"
+// @Param state query string false "Значение, которое вернется клиенту"
+// @Param nonce query string false "Значение для claim nonce в ID-токене (OpenID Connect)"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Только S256"
+// @Success 302 "Редирект на страницу входа или обратно клиенту с ошибкой"
+// @Failure 400 {object} models.OAuthErrorResponse "Неизвестный клиент или redirect_uri"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/authorize [get]
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	h.logger.Info("Received authorization request")
+
+	var req models.AuthorizeRequest
+
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse authorization request")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidRequest})
+		return
+	}
+
+	location, err := h.authService.Authorize(req)
+	if err != nil {
+		h.logger.WithError(err).Warn("Authorization request failed")
+	}
+	if location != "" {
+		c.Redirect(http.StatusFound, location)
+		return
+	}
+	h.respondOAuthError(c, err)
+}
+
+// Token
+// @Summary Выдача токенов OAuth 2.1
+// @Description Обменивает authorization code с code_verifier (PKCE) или refresh-токен на токены, по device_code опрашивает авторизацию устройства (ошибки authorization_pending, slow_down, access_denied и expired_token по RFC 8628), а при grant_type=client_credentials выдает сервисному аккаунту access-токен на него самого (sub и client_id равны ID клиента, без email и refresh-токена). Если выдан scope openid, в ответе есть id_token. Клиент аутентифицируется способом, с которым зарегистрирован: client_secret_basic (заголовок Authorization), client_secret_post (client_id и client_secret в форме), private_key_jwt (client_assertion, подписанный ключом из JWKS клиента) или none (только client_id). Повторное использование кода отзывает выданные по нему refresh-токены
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token, client_credentials или urn:ietf:params:oauth:grant-type:device_code"
+// @Param code formData string false "Authorization code"
+// @Param device_code formData string false "device_code из /oauth/device_authorization"
+// @Param redirect_uri formData string false "redirect_uri из запроса авторизации"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh-токен"
+// @Param scope formData string false "Сужение scopes при обновлении или scopes сервисного токена, по умолчанию все scopes клиента кроме openid, email и profile"
+// @Param client_id formData string false "ID клиента"
+// @Param client_secret formData string false "Секрет клиента (client_secret_post)"
+// @Param client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer (private_key_jwt)"
+// @Param client_assertion formData string false "JWT клиента с iss и sub равными client_id, aud равным OIDC_ISSUER или адресу /oauth/token, jti и exp не дальше 10 минут (private_key_jwt)"
+// @Success 200 {object} models.OAuthTokenResponse "Токены"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверный запрос, код или refresh-токен"
+// @Failure 401 {object} models.OAuthErrorResponse "Клиент не прошел аутентификацию"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/token [post]
+func (h *OAuthHandler) Token(c *gin.Context) {
+	h.logger.Info("Received OAuth token request")
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var req models.TokenRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse OAuth token request")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidRequest})
+		return
+	}
+
+	authMethod, err := clientCredentials(c, &req)
+	if err != nil {
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	tokens, err := h.authService.Token(req, authMethod)
+	if err != nil {
+		h.logger.WithError(err).Warn("OAuth token request failed")
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	h.logger.WithField("client_id", req.ClientID).Info("OAuth token request completed successfully")
+	c.JSON(http.StatusOK, models.OAuthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		Scope:        strings.Join(tokens.Scopes, " "),
+	})
+}
+
+// Introspect
+// @Summary Интроспекция токена
+// @Description Проверяет access-токен по RFC 7662 и возвращает его состояние: активен ли он, субъект, роль, scopes, срок действия и признак отзыва. Вызывать эндпоинт может только конфиденциальный OAuth-клиент, который аутентифицируется так же, как на /oauth/token: client_secret_basic, client_secret_post или private_key_jwt
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Param body body models.IntrospectRequest true "Проверяемый токен и данные клиента"
+// @Success 200 {object} models.TokenIntrospection "Состояние токена"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверные данные"
+// @Failure 401 {object} models.OAuthErrorResponse "Клиент не прошел аутентификацию"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /api/v1/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	h.logger.Info("Received token introspection request")
+
+	c.Header("Cache-Control", "no-store")
+
+	var req models.IntrospectRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse introspection request")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidRequest})
+		return
+	}
+
+	credentials := models.TokenRequest{
+		ClientID:            req.ClientID,
+		ClientSecret:        req.ClientSecret,
+		ClientAssertionType: req.ClientAssertionType,
+		ClientAssertion:     req.ClientAssertion,
+	}
+	authMethod, err := clientCredentials(c, &credentials)
+	if err != nil {
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	result, err := h.authService.Introspect(credentials, req.Token, authMethod)
+	if err != nil {
+		h.logger.WithError(err).Warn("Token introspection failed")
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetAuthorizationRequest
+// @Summary Ожидающий запрос авторизации
+// @Description Возвращает клиента и scopes запроса авторизации, чтобы страница входа могла показать их пользователю. consent_required=false у доверенных клиентов, для них согласие можно отправить сразу
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "request_id из редиректа на страницу входа"
+// @Success 200 {object} models.AuthorizationRequestInfo "Запрос авторизации"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Токен выдан OAuth-клиенту"
+// @Failure 404 {object} models.ErrorResponse "Запрос не найден или истек"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/oauth/requests/{id} [get]
+func (h *OAuthHandler) GetAuthorizationRequest(c *gin.Context) {
+	if !h.requireFirstParty(c) {
+		return
+	}
+
+	info, err := h.authService.GetAuthorizationRequest(c.Param("id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get authorization request")
+		h.respondConsentError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// AnswerAuthorizationRequest
+// @Summary Согласие на запрос авторизации
+// @Description Разрешает или отклоняет запрос авторизации от имени текущего пользователя. Возвращает адрес, на который страница входа должна перенаправить браузер: redirect_uri клиента с code и state или с error=access_denied. На каждый запрос можно ответить один раз
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "request_id из редиректа на страницу входа"
+// @Param body body models.ConsentRequest true "Решение пользователя"
+// @Success 200 {object} models.ConsentResponse "Адрес для редиректа"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Токен выдан OAuth-клиенту"
+// @Failure 404 {object} models.ErrorResponse "Запрос не найден или истек"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/oauth/requests/{id}/consent [post]
+func (h *OAuthHandler) AnswerAuthorizationRequest(c *gin.Context) {
+	h.logger.Info("Received authorization consent request")
+
+	if !h.requireFirstParty(c) {
+		return
+	}
+
+	var req models.ConsentRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse consent request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	location, err := h.authService.AnswerAuthorizationRequest(middleware.Claims(c), c.Param("id"), req.Approve)
+	if err != nil {
+		h.logger.WithError(err).Error("Authorization consent failed")
+		h.respondConsentError(c, err)
+		return
+	}
+
+	h.logger.Info("Authorization consent request completed successfully")
+	c.JSON(http.StatusOK, models.ConsentResponse{RedirectTo: location})
+}
+
+// requireFirstParty rejects access tokens of OAuth clients, so that a client
+// cannot approve authorization requests on its own.
+func (h *OAuthHandler) requireFirstParty(c *gin.Context) bool {
+	if claims := middleware.Claims(c); claims == nil || claims.ClientID != "" {
+		h.logger.Warn("Authorization request accessed with a client token")
+		c.JSON(http.StatusForbidden, gin.H{"error": "a token of the first-party login is required"})
+		return false
+	}
+	return true
+}
+
+// clientCredentials moves the credentials of HTTP Basic authentication into
+// req and tells which authentication method the client used. Using more than
+// one is not allowed.
+func clientCredentials(c *gin.Context, req *models.TokenRequest) (string, error) {
+	id, secret, ok := c.Request.BasicAuth()
+	if req.ClientAssertionType != "" || req.ClientAssertion != "" {
+		if ok || req.ClientSecret != "" {
+			return "", &services.OAuthError{Code: services.OAuthInvalidRequest, Description: "use only one client authentication method"}
+		}
+		return models.ClientAuthPrivateKeyJWT, nil
+	}
+	if !ok {
+		if req.ClientSecret != "" {
+			return models.ClientAuthClientSecretPost, nil
+		}
+		return models.ClientAuthNone, nil
+	}
+
+	if req.ClientSecret != "" {
+		return "", &services.OAuthError{Code: services.OAuthInvalidRequest, Description: "use only one client authentication method"}
+	}
+	// RFC 6749 has the credentials form-encoded before they are put in the header.
+	var errID, errSecret error
+	id, errID = url.QueryUnescape(id)
+	secret, errSecret = url.QueryUnescape(secret)
+	if errID != nil || errSecret != nil || (req.ClientID != "" && req.ClientID != id) {
+		return "", &services.OAuthError{Code: services.OAuthInvalidClient, Description: "malformed client credentials"}
+	}
+	req.ClientID, req.ClientSecret = id, secret
+	return models.ClientAuthClientSecretBasic, nil
+}
+
+func (h *OAuthHandler) respondOAuthError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, models.OAuthErrorResponse{Error: services.OAuthServerError})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == services.OAuthInvalidClient {
+		status = http.StatusUnauthorized
+		if c.GetHeader("Authorization") != "" {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	}
+	c.JSON(status, models.OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
+
+func (h *OAuthHandler) respondConsentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrAuthorizationRequestNotFound),
+		errors.Is(err, services.ErrDeviceAuthorizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
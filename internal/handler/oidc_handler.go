@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+)
+
+// Discovery
+// @Summary Документ OpenID Connect Discovery
+// @Description Возвращает адреса эндпоинтов, поддерживаемые scopes, алгоритмы подписи ID-токенов и другие параметры провайдера. Адреса строятся от OIDC_ISSUER
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} models.OpenIDConfiguration "Конфигурация провайдера"
+// @Router /.well-known/openid-configuration [get]
+func (h *OAuthHandler) Discovery(c *gin.Context) {
+	h.logger.Debug("Received OpenID configuration request")
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.authService.OpenIDConfiguration())
+}
+
+// UserInfo
+// @Summary Claims о пользователе
+// @Description Возвращает claims владельца access-токена. Токену нужен scope openid; email и email_verified отдаются со scope email, preferred_username со scope profile
+// @Tags oidc
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.UserInfo "Claims пользователя"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.OAuthErrorResponse "У токена нет scope openid"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /oauth/userinfo [get]
+// @Router /oauth/userinfo [post]
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	h.logger.Info("Received userinfo request")
+
+	info, err := h.authService.UserInfo(middleware.Claims(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInsufficientScope):
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.JSON(http.StatusForbidden, models.OAuthErrorResponse{Error: "insufficient_scope", ErrorDescription: err.Error()})
+		case errors.Is(err, services.ErrUserNotFound):
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			h.logger.WithError(err).Error("Failed to get userinfo")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// EndSession
+// @Summary Выход, инициированный клиентом (RP-initiated logout)
+// @Description Отзывает refresh-токены сессии, на которую указывает id_token_hint (claim sid), и перенаправляет браузер на post_logout_redirect_uri с state. Адрес должен быть зарегистрирован у клиента в post_logout_redirect_uris. Без post_logout_redirect_uri возвращается 200. Уже выданные access-токены действуют до истечения срока
+// @Tags oidc
+// @Produce json
+// @Param id_token_hint query string true "ID-токен, выданный клиенту; истекший тоже подходит"
+// @Param client_id query string false "ID клиента, должен совпадать с aud ID-токена"
+// @Param post_logout_redirect_uri query string false "Куда вернуть браузер после выхода"
+// @Param state query string false "Значение, которое вернется клиенту"
+// @Success 200 {object} models.SuccessResponse "Сессия завершена"
+// @Success 302 "Редирект на post_logout_redirect_uri"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверный id_token_hint, клиент или post_logout_redirect_uri"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/logout [get]
+// @Router /oauth/logout [post]
+func (h *OAuthHandler) EndSession(c *gin.Context) {
+	h.logger.Info("Received RP-initiated logout request")
+
+	var req models.EndSessionRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse logout request")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidRequest})
+		return
+	}
+
+	location, err := h.authService.EndSession(req)
+	if err != nil {
+		h.logger.WithError(err).Warn("RP-initiated logout failed")
+		h.respondOAuthError(c, err)
+		return
+	}
+
+	if location != "" {
+		c.Redirect(http.StatusFound, location)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
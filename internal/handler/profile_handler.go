@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+)
+
+// GetProfile
+// @Summary Профиль текущего пользователя
+// @Description Возвращает профиль пользователя, которому выдан access-токен
+// @Tags profile
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.UserProfile "Профиль пользователя"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Пользователь не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me [get]
+func (h *AuthHandler) GetProfile(c *gin.Context) {
+	h.logger.Info("Received profile request")
+
+	user, err := h.authService.GetProfile(middleware.Claims(c).Subject)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to load profile")
+		h.respondProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// UpdateProfile
+// @Summary Изменение профиля
+// @Description Изменяет username текущего пользователя
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.UpdateProfileRequest true "Новые данные профиля"
+// @Success 200 {object} models.UserProfile "Обновленный профиль"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Username уже занят"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me [patch]
+func (h *AuthHandler) UpdateProfile(c *gin.Context) {
+	h.logger.Info("Received profile update request")
+
+	var req models.UpdateProfileRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse profile update request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	user, err := h.authService.UpdateProfile(middleware.Claims(c).Subject, req.Username)
+	if err != nil {
+		h.logger.WithError(err).Error("Profile update failed")
+		h.respondProfileError(c, err)
+		return
+	}
+
+	h.logger.Info("Profile update request completed successfully")
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// ChangePassword
+// @Summary Смена пароля
+// @Description Меняет пароль после проверки текущего, завершает все сессии пользователя и выдает новую пару токенов
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.ChangePasswordRequest true "Текущий и новый пароль"
+// @Success 200 {object} models.LoginResponse "Пароль изменен, новая пара токенов"
+// @Failure 400 {object} models.PasswordPolicyErrorResponse "Неверные данные или пароль не соответствует требованиям"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Текущий пароль неверен или недостаточно прав"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/password [post]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	h.logger.Info("Received password change request")
+
+	var req models.ChangePasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse password change request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.ChangePassword(middleware.Claims(c).Subject, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		h.logger.WithError(err).Error("Password change failed")
+		h.respondProfileError(c, err)
+		return
+	}
+
+	h.logger.Info("Password change request completed successfully")
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+// ChangeEmail
+// @Summary Смена email
+// @Description Отправляет ссылку подтверждения на новый адрес. Email меняется только после перехода по ссылке
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.ChangeEmailRequest true "Новый email и текущий пароль"
+// @Success 202 {object} models.SuccessResponse "Письмо с подтверждением отправлено"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Пароль неверен или недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Email уже занят"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/email [post]
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	h.logger.Info("Received email change request")
+
+	var req models.ChangeEmailRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse email change request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.RequestEmailChange(middleware.Claims(c).Subject, req.NewEmail, req.Password); err != nil {
+		h.logger.WithError(err).Error("Email change request failed")
+		h.respondProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Check the new address to confirm the change"})
+}
+
+// ConfirmEmailChange
+// @Summary Подтверждение смены email
+// @Description Подтверждает новый email по одноразовому токену из письма. Токен передается в query-параметре (ссылка из письма) или в теле запроса
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param token query string false "Токен из письма"
+// @Param body body models.VerifyEmailRequest false "Токен из письма"
+// @Success 200 {object} models.SuccessResponse "Email изменен"
+// @Failure 400 {object} models.ErrorResponse "Токен недействителен или истек"
+// @Failure 409 {object} models.ErrorResponse "Email уже занят"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/email/confirm [get]
+// @Router /api/v1/me/email/confirm [post]
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	h.logger.Info("Received email change confirmation request")
+
+	var req models.VerifyEmailRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse email change confirmation request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.authService.ConfirmEmailChange(req.Token); err != nil {
+		h.logger.WithError(err).Error("Email change confirmation failed")
+		h.respondProfileError(c, err)
+		return
+	}
+
+	h.logger.Info("Email change confirmation completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully"})
+}
+
+func (h *AuthHandler) respondProfileError(c *gin.Context, err error) {
+	if respondPasswordPolicyError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidPassword),
+		errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrInvalidUsername),
+		errors.Is(err, services.ErrInvalidVerificationToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIncorrectPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUsernameTaken),
+		errors.Is(err, services.ErrEmailTaken),
+		errors.Is(err, services.ErrUserExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// respondPasswordPolicyError answers a password rejected by the policy with
+// 400 and the list of violations. It reports whether err was such an error.
+func respondPasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, models.PasswordPolicyErrorResponse{
+		Error:      services.ErrInvalidPassword.Error(),
+		Violations: policyErr.Violations,
+	})
+	return true
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"net/http"
+)
+
+// RegisterClient
+// @Summary Динамическая регистрация клиента (RFC 7591)
+// @Description Регистрирует OAuth-клиента. Запрос авторизуется начальным токеном доступа OAUTH_REGISTRATION_TOKEN в заголовке Authorization: Bearer. Сервер выдает client_id, client_secret (для client_secret_basic и client_secret_post) и registration_access_token для управления регистрацией. Доступны только scopes из OAUTH_REGISTRATION_SCOPES, по умолчанию клиент получает их все. skip_consent и grant client_credentials недоступны
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.ClientMetadata true "Метаданные клиента"
+// @Success 201 {object} models.ClientInformation "Зарегистрированный клиент"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверные метаданные или redirect URI"
+// @Failure 401 {object} models.OAuthErrorResponse "Начальный токен доступа недействителен"
+// @Failure 403 {object} models.OAuthErrorResponse "Динамическая регистрация отключена"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/register [post]
+func (h *OAuthHandler) RegisterClient(c *gin.Context) {
+	h.logger.Info("Received client registration request")
+
+	c.Header("Cache-Control", "no-store")
+
+	var metadata models.ClientMetadata
+
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		h.logger.WithError(err).Error("Failed to parse client registration request body")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidClientMetadata})
+		return
+	}
+
+	info, err := h.authService.RegisterClient(middleware.BearerToken(c), metadata)
+	if err != nil {
+		h.logger.WithError(err).Warn("Client registration failed")
+		h.respondRegistrationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, info)
+}
+
+// GetRegisteredClient
+// @Summary Чтение регистрации клиента (RFC 7592)
+// @Description Возвращает метаданные клиента. Запрос авторизуется registration_access_token, полученным при регистрации. Секрет клиента не возвращается
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Param client_id path string true "ID клиента"
+// @Success 200 {object} models.ClientInformation "Клиент"
+// @Failure 401 {object} models.OAuthErrorResponse "Токен регистрации недействителен или клиент не найден"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/register/{client_id} [get]
+func (h *OAuthHandler) GetRegisteredClient(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	info, err := h.authService.GetRegisteredClient(c.Param("client_id"), middleware.BearerToken(c))
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed to read client registration")
+		h.respondRegistrationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// UpdateRegisteredClient
+// @Summary Изменение регистрации клиента (RFC 7592)
+// @Description Заменяет метаданные клиента целиком: пропущенные поля получают значения по умолчанию. client_id в теле обязателен и должен совпадать с адресом. Секрет клиента и токен регистрации не меняются
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param client_id path string true "ID клиента"
+// @Param body body models.ClientMetadata true "Метаданные клиента"
+// @Success 200 {object} models.ClientInformation "Обновленный клиент"
+// @Failure 400 {object} models.OAuthErrorResponse "Неверные метаданные или redirect URI"
+// @Failure 401 {object} models.OAuthErrorResponse "Токен регистрации недействителен или клиент не найден"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/register/{client_id} [put]
+func (h *OAuthHandler) UpdateRegisteredClient(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("client_id")).Info("Received client registration update request")
+
+	c.Header("Cache-Control", "no-store")
+
+	var metadata models.ClientMetadata
+
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		h.logger.WithError(err).Error("Failed to parse client registration update body")
+		c.JSON(http.StatusBadRequest, models.OAuthErrorResponse{Error: services.OAuthInvalidClientMetadata})
+		return
+	}
+
+	info, err := h.authService.UpdateRegisteredClient(c.Param("client_id"), middleware.BearerToken(c), metadata)
+	if err != nil {
+		h.logger.WithError(err).Warn("Client registration update failed")
+		h.respondRegistrationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// DeleteRegisteredClient
+// @Summary Удаление регистрации клиента (RFC 7592)
+// @Description Удаляет клиента. После этого он не может получать и обновлять токены
+// @Tags oauth
+// @Security BearerAuth
+// @Param client_id path string true "ID клиента"
+// @Success 204 "Клиент удален"
+// @Failure 401 {object} models.OAuthErrorResponse "Токен регистрации недействителен или клиент не найден"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.OAuthErrorResponse "Ошибка сервера"
+// @Router /oauth/register/{client_id} [delete]
+func (h *OAuthHandler) DeleteRegisteredClient(c *gin.Context) {
+	h.logger.WithField("client_id", c.Param("client_id")).Info("Received client registration delete request")
+
+	if err := h.authService.DeleteRegisteredClient(c.Param("client_id"), middleware.BearerToken(c)); err != nil {
+		h.logger.WithError(err).Warn("Client registration delete failed")
+		h.respondRegistrationError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondRegistrationError answers with the errors of RFC 7591. Bad tokens
+// are reported like on resources protected by bearer tokens (RFC 6750).
+func (h *OAuthHandler) respondRegistrationError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, models.OAuthErrorResponse{Error: services.OAuthServerError})
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch oauthErr.Code {
+	case services.OAuthInvalidToken:
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	case services.OAuthAccessDenied:
+		status = http.StatusForbidden
+	}
+	c.JSON(status, models.OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"io"
+	"net/http"
+)
+
+// BeginWebAuthnRegistration
+// @Summary Начало регистрации passkey
+// @Description Возвращает параметры для navigator.credentials.create() и session_id, с которым нужно завершить регистрацию
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.WebAuthnOptionsResponse "Параметры регистрации"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/webauthn/register/begin [post]
+func (h *AuthHandler) BeginWebAuthnRegistration(c *gin.Context) {
+	h.logger.Info("Received WebAuthn registration request")
+
+	options, err := h.authService.BeginWebAuthnRegistration(middleware.Claims(c).Subject)
+	if err != nil {
+		h.logger.WithError(err).Error("WebAuthn registration failed")
+		h.respondWebAuthnError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishWebAuthnRegistration
+// @Summary Завершение регистрации passkey
+// @Description Проверяет ответ navigator.credentials.create() и сохраняет ключ. Сессия одноразовая: после ошибки регистрацию нужно начать заново
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.FinishWebAuthnRegistrationRequest true "Сессия, название и ответ аутентификатора"
+// @Success 201 {object} models.WebAuthnCredentialInfo "Зарегистрированный ключ"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные, сессия истекла или ответ не прошел проверку"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 409 {object} models.ErrorResponse "Ключ уже зарегистрирован"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/webauthn/register/finish [post]
+func (h *AuthHandler) FinishWebAuthnRegistration(c *gin.Context) {
+	h.logger.Info("Received WebAuthn registration finish request")
+
+	var req models.FinishWebAuthnRegistrationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse WebAuthn registration request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	credential, err := h.authService.FinishWebAuthnRegistration(middleware.Claims(c).Subject, req.SessionID, req.Name, req.Credential)
+	if err != nil {
+		h.logger.WithError(err).Error("WebAuthn registration finish failed")
+		h.respondWebAuthnError(c, err)
+		return
+	}
+
+	h.logger.Info("WebAuthn registration request completed successfully")
+	c.JSON(http.StatusCreated, credential.Info())
+}
+
+// ListWebAuthnCredentials
+// @Summary Список passkey
+// @Description Возвращает зарегистрированные ключи текущего пользователя
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.WebAuthnCredentialsResponse "Ключи"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Недостаточно прав"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/webauthn/credentials [get]
+func (h *AuthHandler) ListWebAuthnCredentials(c *gin.Context) {
+	credentials, err := h.authService.ListWebAuthnCredentials(middleware.Claims(c).Subject)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list WebAuthn credentials")
+		h.respondWebAuthnError(c, err)
+		return
+	}
+
+	response := models.WebAuthnCredentialsResponse{Credentials: make([]models.WebAuthnCredentialInfo, 0, len(credentials))}
+	for i := range credentials {
+		response.Credentials = append(response.Credentials, credentials[i].Info())
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteWebAuthnCredential
+// @Summary Удаление passkey
+// @Description Удаляет ключ текущего пользователя после проверки пароля
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID ключа"
+// @Param body body models.PasswordConfirmationRequest true "Текущий пароль"
+// @Success 200 {object} models.SuccessResponse "Ключ удален"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Токен недействителен или отозван"
+// @Failure 403 {object} models.ErrorResponse "Пароль неверен или недостаточно прав"
+// @Failure 404 {object} models.ErrorResponse "Ключ не найден"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/me/webauthn/credentials/{id} [delete]
+func (h *AuthHandler) DeleteWebAuthnCredential(c *gin.Context) {
+	h.logger.Info("Received WebAuthn credential delete request")
+
+	var req models.PasswordConfirmationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse WebAuthn credential delete request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.authService.DeleteWebAuthnCredential(middleware.Claims(c).Subject, c.Param("id"), req.Password); err != nil {
+		h.logger.WithError(err).Error("WebAuthn credential delete failed")
+		h.respondWebAuthnError(c, err)
+		return
+	}
+
+	h.logger.Info("WebAuthn credential delete request completed successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "Credential deleted"})
+}
+
+// BeginWebAuthnLogin
+// @Summary Начало входа по passkey
+// @Description Возвращает параметры для navigator.credentials.get(). С mfa_token, полученным при входе по паролю, passkey проверяется как второй фактор, а mfa_token расходуется. Без тела запроса начинается вход без пароля
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.BeginWebAuthnLoginRequest false "MFA-токен для второго фактора"
+// @Success 200 {object} models.WebAuthnOptionsResponse "Параметры входа"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "MFA-токен недействителен или у аккаунта нет passkey"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/login/webauthn/begin [post]
+func (h *AuthHandler) BeginWebAuthnLogin(c *gin.Context) {
+	h.logger.Info("Received WebAuthn login request")
+
+	var req models.BeginWebAuthnLoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithError(err).Error("Failed to parse WebAuthn login request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	options, err := h.authService.BeginWebAuthnLogin(req.MFAToken)
+	if err != nil {
+		h.logger.WithError(err).Error("WebAuthn login failed")
+		h.respondWebAuthnLoginError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishWebAuthnLogin
+// @Summary Завершение входа по passkey
+// @Description Проверяет ответ navigator.credentials.get() и выдает пару токенов. Сессия одноразовая: после ошибки вход нужно начать заново
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.FinishWebAuthnLoginRequest true "Сессия и ответ аутентификатора"
+// @Success 200 {object} models.LoginResponse "Успешный вход"
+// @Failure 400 {object} models.ErrorResponse "Неверные данные"
+// @Failure 401 {object} models.ErrorResponse "Сессия истекла или ответ не прошел проверку"
+// @Failure 429 {object} models.ErrorResponse "Превышен лимит запросов"
+// @Failure 500 {object} models.ErrorResponse "Ошибка сервера"
+// @Router /api/v1/login/webauthn/finish [post]
+func (h *AuthHandler) FinishWebAuthnLogin(c *gin.Context) {
+	h.logger.Info("Received WebAuthn login finish request")
+
+	var req models.FinishWebAuthnLoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to parse WebAuthn login request body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.FinishWebAuthnLogin(req.SessionID, req.Credential)
+	if err != nil {
+		h.logger.WithError(err).Error("WebAuthn login finish failed")
+		h.respondWebAuthnLoginError(c, err)
+		return
+	}
+
+	h.logger.Info("WebAuthn login request completed successfully")
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+func (h *AuthHandler) respondWebAuthnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebAuthnSession),
+		errors.Is(err, services.ErrInvalidWebAuthnResponse),
+		errors.Is(err, services.ErrInvalidCredentialName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIncorrectPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound),
+		errors.Is(err, services.ErrWebAuthnCredentialNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWebAuthnCredentialExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// respondWebAuthnLoginError answers every failed check during login with 401,
+// like a wrong password.
+func (h *AuthHandler) respondWebAuthnLoginError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebAuthnSession),
+		errors.Is(err, services.ErrInvalidWebAuthnResponse),
+		errors.Is(err, services.ErrInvalidMFAToken),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrAccountNotActive):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
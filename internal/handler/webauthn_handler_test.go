@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/services"
+	"github/alexnoodl/raiko-auth/internal/servicetest"
+	"github/alexnoodl/raiko-auth/internal/webauthntest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestRouter serves the WebAuthn routes of an AuthService on the memory
+// store.
+func newTestRouter(t *testing.T) (*gin.Engine, *services.AuthService, *servicetest.Mailer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	d := servicetest.New(t)
+	authService := services.NewAuthService(d.Store, d.Logger, d.JWT, d.Mailer, d.Secrets, d.RelyingParty, d.Passwords, d.Policy, d.Config)
+	if err := authService.EnsureDefaultRoles(); err != nil {
+		t.Fatalf("default roles: %v", err)
+	}
+
+	authHandler := NewAuthHandler(authService, d.Logger)
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.POST("/login/webauthn/begin", authHandler.BeginWebAuthnLogin)
+	v1.POST("/login/webauthn/finish", authHandler.FinishWebAuthnLogin)
+	me := v1.Group("/me", middleware.Auth(authService.ValidateAccessToken, d.Logger))
+	me.GET("/webauthn/credentials", middleware.RequirePermission(models.PermissionProfileRead), authHandler.ListWebAuthnCredentials)
+	me.POST("/webauthn/register/begin", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.BeginWebAuthnRegistration)
+	me.POST("/webauthn/register/finish", middleware.RequirePermission(models.PermissionProfileWrite), authHandler.FinishWebAuthnRegistration)
+	return router, authService, d.Mailer
+}
+
+// loginAs registers a verified user and returns their access token.
+func loginAs(t *testing.T, authService *services.AuthService, mail *servicetest.Mailer, email, username string) string {
+	t.Helper()
+
+	if err := authService.Register(&models.User{Email: email, Username: username, Password: servicetest.Password}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := authService.VerifyEmail(mail.LastToken(t, email)); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+
+	result, err := authService.Login(username, servicetest.Password, "")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return result.Tokens.AccessToken
+}
+
+// do sends body as JSON and decodes the JSON response into out, if given.
+func do(t *testing.T, router *gin.Engine, method, path, accessToken string, body any, out any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if out != nil && rec.Code < http.StatusBadRequest {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func registerPasskey(t *testing.T, router *gin.Engine, accessToken string, authenticator *webauthntest.Authenticator, opts ...webauthntest.Option) (*webauthntest.Credential, int) {
+	t.Helper()
+
+	var options models.WebAuthnOptionsResponse
+	if code := do(t, router, http.MethodPost, "/api/v1/me/webauthn/register/begin", accessToken, nil, &options); code != http.StatusOK {
+		t.Fatalf("begin registration: status %d", code)
+	}
+	credential, response, err := authenticator.Register(options.Options, opts...)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+
+	code := do(t, router, http.MethodPost, "/api/v1/me/webauthn/register/finish", accessToken, models.FinishWebAuthnRegistrationRequest{
+		SessionID:  options.SessionID,
+		Name:       "Laptop",
+		Credential: response,
+	}, nil)
+	return credential, code
+}
+
+func TestWebAuthnHandlerRegistration(t *testing.T) {
+	router, authService, mail := newTestRouter(t)
+	accessToken := loginAs(t, authService, mail, "alice@example.com", "alice")
+	authenticator := &webauthntest.Authenticator{RPID: servicetest.RPID, Origin: servicetest.Origin}
+
+	if code := do(t, router, http.MethodPost, "/api/v1/me/webauthn/register/begin", "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("begin without token: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if _, code := registerPasskey(t, router, accessToken, authenticator, webauthntest.WithChallenge([]byte("another challenge of 32 bytes..."))); code != http.StatusBadRequest {
+		t.Errorf("bad challenge: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if _, code := registerPasskey(t, router, accessToken, authenticator, webauthntest.WithOrigin("https://evil.example.com")); code != http.StatusBadRequest {
+		t.Errorf("wrong origin: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if _, code := registerPasskey(t, router, accessToken, authenticator); code != http.StatusCreated {
+		t.Fatalf("registration: status %d, want %d", code, http.StatusCreated)
+	}
+
+	var list models.WebAuthnCredentialsResponse
+	if code := do(t, router, http.MethodGet, "/api/v1/me/webauthn/credentials", accessToken, nil, &list); code != http.StatusOK {
+		t.Fatalf("list credentials: status %d", code)
+	}
+	if len(list.Credentials) != 1 || list.Credentials[0].Name != "Laptop" {
+		t.Errorf("credentials = %+v, want the one registered", list.Credentials)
+	}
+
+	code := do(t, router, http.MethodPost, "/api/v1/me/webauthn/register/finish", accessToken, models.FinishWebAuthnRegistrationRequest{
+		SessionID:  "unknown",
+		Credential: json.RawMessage(`{}`),
+	}, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("unknown session: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestWebAuthnHandlerLogin(t *testing.T) {
+	router, authService, mail := newTestRouter(t)
+	accessToken := loginAs(t, authService, mail, "alice@example.com", "alice")
+	authenticator := &webauthntest.Authenticator{RPID: servicetest.RPID, Origin: servicetest.Origin}
+	credential, code := registerPasskey(t, router, accessToken, authenticator)
+	if code != http.StatusCreated {
+		t.Fatalf("registration: status %d", code)
+	}
+
+	login := func(credential *webauthntest.Credential, opts ...webauthntest.Option) (int, models.LoginResponse) {
+		t.Helper()
+
+		var options models.WebAuthnOptionsResponse
+		if code := do(t, router, http.MethodPost, "/api/v1/login/webauthn/begin", "", nil, &options); code != http.StatusOK {
+			t.Fatalf("begin login: status %d", code)
+		}
+		response, err := authenticator.Login(options.Options, credential, opts...)
+		if err != nil {
+			t.Fatalf("authenticator: %v", err)
+		}
+
+		var tokens models.LoginResponse
+		code := do(t, router, http.MethodPost, "/api/v1/login/webauthn/finish", "", models.FinishWebAuthnLoginRequest{
+			SessionID:  options.SessionID,
+			Credential: response,
+		}, &tokens)
+		return code, tokens
+	}
+
+	code, tokens := login(credential)
+	if code != http.StatusOK {
+		t.Fatalf("login: status %d, want %d", code, http.StatusOK)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Errorf("login response = %+v, want a token pair", tokens)
+	}
+
+	if code, _ := login(credential, webauthntest.WithChallenge([]byte("another challenge of 32 bytes..."))); code != http.StatusUnauthorized {
+		t.Errorf("bad challenge: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := login(credential, webauthntest.WithOrigin("https://evil.example.com")); code != http.StatusUnauthorized {
+		t.Errorf("wrong origin: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// The failed attempts never reached the stored counter, which is still 1.
+	credential.SignCount = 0
+	if code, _ := login(credential); code != http.StatusUnauthorized {
+		t.Errorf("replayed sign count: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	unknown, err := webauthntest.NewCredential(credential.UserHandle)
+	if err != nil {
+		t.Fatalf("new credential: %v", err)
+	}
+	if code, _ := login(unknown); code != http.StatusUnauthorized {
+		t.Errorf("unknown credential: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code := do(t, router, http.MethodPost, "/api/v1/login/webauthn/finish", "", map[string]string{"session_id": "x"}, nil); code != http.StatusBadRequest {
+		t.Errorf("missing credential: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
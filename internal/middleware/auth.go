@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/pkg/jwt"
+	"net/http"
+	"strings"
+)
+
+const claimsKey = "auth.claims"
+
+// TokenVerifier checks an access token and returns its claims.
+// jwt.JWTManager.VerifyToken only checks the signature and standard claims,
+// services.AuthService.ValidateAccessToken also rejects revoked tokens.
+type TokenVerifier func(tokenString string) (*jwt.Claims, error)
+
+// Auth rejects requests without a valid bearer access token and stores the
+// token claims in the context for the handlers that follow.
+func Auth(verify TokenVerifier, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := BearerToken(c)
+		if token == "" {
+			logger.WithField("path", c.FullPath()).Warn("Request without bearer token")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+
+		claims, err := verify(token)
+		if err != nil {
+			logger.WithError(err).WithField("path", c.FullPath()).Warn("Request with invalid bearer token")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+
+		c.Set(claimsKey, claims)
+		c.Next()
+	}
+}
+
+// Claims returns the claims stored by Auth, or nil when the route is not protected.
+func Claims(c *gin.Context) *jwt.Claims {
+	value, ok := c.Get(claimsKey)
+	if !ok {
+		return nil
+	}
+	claims, _ := value.(*jwt.Claims)
+	return claims
+}
+
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// RequirePermission allows the request only when the access token grants
+// permission. It must run after Auth.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := Claims(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+
+		if !claims.HasPermission(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+
+		c.Next()
+	}
+}
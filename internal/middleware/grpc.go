@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/pkg/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+)
+
+// MethodPermissions maps full gRPC method names to the permission they
+// require. An empty permission only requires a valid access token. Methods
+// that are not listed are public.
+type MethodPermissions map[string]string
+
+type claimsContextKey struct{}
+
+func ContextWithClaims(ctx context.Context, claims *jwt.Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by the interceptors, or nil when
+// the method is public.
+func ClaimsFromContext(ctx context.Context) *jwt.Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*jwt.Claims)
+	return claims
+}
+
+func UnaryServerInterceptor(verify TokenVerifier, rules MethodPermissions, logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, info.FullMethod, verify, rules, logger)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(verify TokenVerifier, rules MethodPermissions, logger *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), info.FullMethod, verify, rules, logger)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authorize(ctx context.Context, method string, verify TokenVerifier, rules MethodPermissions, logger *logrus.Logger) (context.Context, error) {
+	permission, protected := rules[method]
+	if !protected {
+		return ctx, nil
+	}
+
+	token := BearerTokenFromContext(ctx)
+	if token == "" {
+		logger.WithField("method", method).Warn("gRPC request without bearer token")
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	claims, err := verify(token)
+	if err != nil {
+		logger.WithError(err).WithField("method", method).Warn("gRPC request with invalid bearer token")
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if permission != "" && !claims.HasPermission(permission) {
+		logger.WithFields(logrus.Fields{
+			"method":     method,
+			"subject":    claims.Subject,
+			"permission": permission,
+		}).Warn("gRPC request without required permission")
+		return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
+	}
+
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// BearerTokenFromContext reads the access token from the "authorization"
+// metadata of an incoming gRPC request.
+func BearerTokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+			return strings.TrimSpace(value[7:])
+		}
+	}
+	return ""
+}
+
+// PeerIP returns the IP address of the gRPC client, or "" when the
+// connection has none, as with Unix sockets.
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"github/alexnoodl/raiko-auth/pkg/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitRules maps full gRPC method names to the rate limit policies that
+// apply to them. Methods that are not listed are not limited.
+type RateLimitRules map[string][]string
+
+// RateLimit refuses requests over any of the named policies with 429 and a
+// Retry-After header. Policies keyed by subject must run after Auth. When the
+// store fails the request is let through, so an outage of Redis does not take
+// logins down with it.
+func RateLimit(limiter *ratelimit.Limiter, logger *logrus.Logger, policies ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, policy, err := takeRateLimits(c.Request.Context(), limiter, policies, func(kind ratelimit.KeyKind) string {
+			switch kind {
+			case ratelimit.KeySubject:
+				if claims := Claims(c); claims != nil {
+					return claims.Subject
+				}
+			case ratelimit.KeyLogin:
+				return loginFromBody(c)
+			}
+			return ""
+		}, c.ClientIP())
+		if err != nil {
+			logger.WithError(err).WithField("policy", policy).Error("Rate limit check failed")
+		}
+		if result == nil {
+			c.Next()
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"policy": policy,
+			"path":   c.FullPath(),
+			"ip":     c.ClientIP(),
+		}).Warn("Request rate limited")
+		c.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(result.RetryAfter), 10))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+	}
+}
+
+// UnaryRateLimitInterceptor applies the policies of rules to unary calls. A
+// refused call fails with ResourceExhausted, carrying a RetryInfo detail and
+// the seconds to wait in the "retry-after" header. It must run after the
+// authentication interceptor for policies keyed by subject.
+func UnaryRateLimitInterceptor(limiter *ratelimit.Limiter, rules RateLimitRules, logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		policies := rules[info.FullMethod]
+		if len(policies) == 0 {
+			return handler(ctx, req)
+		}
+
+		result, policy, err := takeRateLimits(ctx, limiter, policies, func(kind ratelimit.KeyKind) string {
+			switch kind {
+			case ratelimit.KeySubject:
+				if claims := ClaimsFromContext(ctx); claims != nil {
+					return claims.Subject
+				}
+			case ratelimit.KeyLogin:
+				return loginFromMessage(req)
+			}
+			return ""
+		}, PeerIP(ctx))
+		if err != nil {
+			logger.WithError(err).WithField("policy", policy).Error("Rate limit check failed")
+		}
+		if result == nil {
+			return handler(ctx, req)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"policy": policy,
+			"method": info.FullMethod,
+		}).Warn("gRPC call rate limited")
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(retryAfterSeconds(result.RetryAfter), 10)))
+
+		st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(result.RetryAfter)}); err == nil {
+			st = detailed
+		}
+		return nil, st.Err()
+	}
+}
+
+// takeRateLimits counts the request under each policy in turn and returns the
+// result of the first one that refuses it, or nil when all allow it. keyFor
+// returns the key of a policy, an empty key falls back to the client IP.
+func takeRateLimits(ctx context.Context, limiter *ratelimit.Limiter, policies []string, keyFor func(ratelimit.KeyKind) string, ip string) (*ratelimit.Result, string, error) {
+	for _, name := range policies {
+		policy := limiter.Policy(name)
+		if !policy.Enabled() {
+			continue
+		}
+
+		value := ""
+		if policy.Key != ratelimit.KeyIP {
+			value = keyFor(policy.Key)
+		}
+		key := string(policy.Key) + ":" + value
+		if value == "" {
+			key = "ip:" + ip
+		}
+
+		result, err := limiter.Allow(ctx, name, key)
+		if err != nil {
+			return nil, name, err
+		}
+		if !result.Allowed {
+			return &result, name, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// maxLoginBodySize is how much of a request body loginFromBody reads. Login
+// and password requests are far smaller.
+const maxLoginBodySize = 4 << 10
+
+// loginFromBody reads the login or email field of a JSON body and puts the
+// body back for the handler. A body over maxLoginBodySize is not parsed, the
+// request is then limited by the client IP.
+func loginFromBody(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	body := c.Request.Body
+	data, err := io.ReadAll(io.LimitReader(body, maxLoginBodySize+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil || len(data) > maxLoginBodySize {
+		return ""
+	}
+
+	var fields struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+	if fields.Login != "" {
+		return utils.NormalizeIdentifier(fields.Login)
+	}
+	return utils.NormalizeIdentifier(fields.Email)
+}
+
+func loginFromMessage(req interface{}) string {
+	switch msg := req.(type) {
+	case interface{ GetLogin() string }:
+		return utils.NormalizeIdentifier(msg.GetLogin())
+	case interface{ GetEmail() string }:
+		return utils.NormalizeIdentifier(msg.GetEmail())
+	}
+	return ""
+}
+
+// retryAfterSeconds rounds up, as Retry-After takes whole seconds.
+func retryAfterSeconds(wait time.Duration) int64 {
+	return int64((wait + time.Second - 1) / time.Second)
+}
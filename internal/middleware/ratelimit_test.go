@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoginFromBody(t *testing.T) {
+	padding := strings.Repeat(" ", maxLoginBodySize)
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "login", body: `{"login": "Alice@Example.com", "password": "secret"}`, want: "alice@example.com"},
+		{name: "email", body: `{"email": "alice@example.com"}`, want: "alice@example.com"},
+		{name: "not JSON", body: `login=alice`, want: ""},
+		{name: "too large", body: `{"login": "alice", "password": "secret"}` + padding, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(tt.body))
+
+			if got := loginFromBody(c); got != tt.want {
+				t.Errorf("login = %q, want %q", got, tt.want)
+			}
+
+			// The handler still reads the whole body.
+			rest, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if string(rest) != tt.body {
+				t.Errorf("body left for the handler has %d bytes, want %d", len(rest), len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// UserFilter selects users for the admin list. Query matches a prefix of the
+// email or the username. Results are ordered by Sort (created_at, email or
+// username) and paged with the opaque cursor returned by the previous page.
+type UserFilter struct {
+	Role          string    `form:"role"`
+	Active        *bool     `form:"active"`
+	CreatedAfter  time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Query         string    `form:"q"`
+	Sort          string    `form:"sort"`
+	Order         string    `form:"order"`
+	Cursor        string    `form:"cursor"`
+	Limit         int       `form:"limit"`
+}
+
+type UserList struct {
+	Users      []*UserProfile `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
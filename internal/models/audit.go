@@ -0,0 +1,45 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	AuditUserRegistered      = "user.registered"
+	AuditLoginSucceeded      = "login.succeeded"
+	AuditLoginFailed         = "login.failed"
+	AuditAccountLocked       = "login.account_locked"
+	AuditEmailVerified       = "email.verified"
+	AuditEmailChanged        = "email.changed"
+	AuditPasswordChanged     = "password.changed"
+	AuditPasswordReset       = "password.reset"
+	AuditSessionsRevoked     = "sessions.revoked"
+	AuditRefreshTokenReused  = "refresh_token.reused"
+	AuditMFAEnabled          = "mfa.enabled"
+	AuditMFADisabled         = "mfa.disabled"
+	AuditMFAFailed           = "mfa.failed"
+	AuditRecoveryCodeUsed    = "mfa.recovery_code_used"
+	AuditRecoveryCodesReset  = "mfa.recovery_codes_regenerated"
+	AuditWebAuthnAdded       = "webauthn.credential_added"
+	AuditWebAuthnRemoved     = "webauthn.credential_removed"
+	AuditOAuthConsentGranted = "oauth.consent_granted"
+	AuditOAuthConsentDenied  = "oauth.consent_denied"
+	AuditOAuthCodeReused     = "oauth.code_reused"
+	AuditOAuthLogout         = "oauth.logout"
+	AuditRoleChanged         = "admin.role_changed"
+	AuditUserActivated       = "admin.user_activated"
+	AuditUserDeactivated     = "admin.user_deactivated"
+	AuditUserUnlocked        = "admin.user_unlocked"
+	AuditPasswordResetForced = "admin.password_reset_forced"
+	AuditUserDeleted         = "admin.user_deleted"
+)
+
+// AuditEvent records a security relevant change to an account.
+type AuditEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Action    string             `json:"action" bson:"action"`
+	Details   map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
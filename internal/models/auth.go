@@ -1,5 +1,7 @@
 package models
 
+import "github/alexnoodl/raiko-auth/pkg/password"
+
 type RegisterRequest struct {
 	Username string `json:"username" bson:"username" validate:"required,min=3,max=20"`
 	Email    string `json:"email" bson:"email" validate:"required,email"`
@@ -11,14 +13,78 @@ type LoginRequest struct {
 	Password string `json:"password" bson:"password" validate:"required,password"`
 }
 
+// LoginResponse carries the tokens, or only the MFA fields when the account
+// has a second factor and the login has to be finished at /login/mfa.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string   `json:"token,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	ExpiresIn    int64    `json:"expires_in,omitempty"`
+	MFARequired  bool     `json:"mfa_required,omitempty"`
+	MFAToken     string   `json:"mfa_token,omitempty"`
+	MFAMethods   []string `json:"mfa_methods,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type IntrospectRequest struct {
+	Token         string `json:"token" form:"token" binding:"required"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"`
+	// The caller authenticates like at the token endpoint, or with HTTP
+	// Basic authentication.
+	ClientID            string `json:"client_id" form:"client_id"`
+	ClientSecret        string `json:"client_secret" form:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type" form:"client_assertion_type"`
+	ClientAssertion     string `json:"client_assertion" form:"client_assertion"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type UpdateProfileRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// PasswordPolicyErrorResponse is returned when a new password is rejected.
+// Violations tell why, by code, so clients can show their own messages.
+type PasswordPolicyErrorResponse struct {
+	Error      string               `json:"error"`
+	Violations []password.Violation `json:"violations,omitempty"`
+}
+
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
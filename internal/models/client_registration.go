@@ -0,0 +1,32 @@
+package models
+
+import "encoding/json"
+
+// ClientMetadata is the client metadata of RFC 7591, sent to register a
+// client or to replace its registration. Scope lists the scopes separated by
+// spaces.
+type ClientMetadata struct {
+	ClientID                string          `json:"client_id,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	RedirectURIs            []string        `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectURIs  []string        `json:"post_logout_redirect_uris,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string          `json:"scope,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty" swaggertype:"object"`
+	// SkipConsent is only accepted from administrators.
+	SkipConsent bool `json:"skip_consent,omitempty"`
+}
+
+// ClientInformation is the registration of a client as returned by the
+// registration and admin endpoints. The client secret and the registration
+// access token are only returned when they are issued.
+type ClientInformation struct {
+	ClientMetadata
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// States of a device authorization. It waits for the user as pending until
+// they approve or deny it on another device.
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization is a device authorization request of RFC 8628. The
+// device polls with the device code while the user enters the user code on
+// the verification page; only hashes of both are stored. Interval is the
+// polling interval in seconds, raised whenever the device polls too fast.
+type DeviceAuthorization struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	DeviceCodeHash string             `bson:"device_code_hash"`
+	UserCodeHash   string             `bson:"user_code_hash"`
+	ClientID       string             `bson:"client_id"`
+	Scopes         []string           `bson:"scopes"`
+	Status         string             `bson:"status"`
+	// UserID, AuthTime and AMR are set once the user approved.
+	UserID       primitive.ObjectID `bson:"user_id,omitempty"`
+	AuthTime     time.Time          `bson:"auth_time,omitempty"`
+	AMR          []string           `bson:"amr,omitempty"`
+	Interval     int                `bson:"interval"`
+	LastPolledAt *time.Time         `bson:"last_polled_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at"`
+}
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceAuthorizationInfo is what the verification page shows the user
+// before they approve a device.
+type DeviceAuthorizationInfo struct {
+	UserCode   string    `json:"user_code"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
+// TOTPEnrollment is the authenticator app of a user. The secret is encrypted
+// and only the hashes of the recovery codes are stored.
+type TOTPEnrollment struct {
+	UserID        primitive.ObjectID `bson:"_id"`
+	Secret        string             `bson:"secret"`
+	Confirmed     bool               `bson:"confirmed"`
+	LastUsedStep  int64              `bson:"last_used_step"`
+	RecoveryCodes []RecoveryCode     `bson:"recovery_codes"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	ConfirmedAt   *time.Time         `bson:"confirmed_at,omitempty"`
+}
+
+type RecoveryCode struct {
+	Hash   string     `bson:"hash"`
+	UsedAt *time.Time `bson:"used_at,omitempty"`
+}
+
+// LoginResult holds either the tokens of a completed login or, when the
+// account has a second factor, the challenge to answer at /login/mfa.
+type LoginResult struct {
+	Tokens     *TokenPair
+	MFAToken   string
+	MFAMethods []string
+}
+
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type PasswordConfirmationRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
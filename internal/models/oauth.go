@@ -0,0 +1,206 @@
+package models
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	// GrantClientCredentials lets a client get tokens for itself, as a
+	// service account without a user.
+	GrantClientCredentials = "client_credentials"
+	// GrantDeviceCode is the device authorization grant of RFC 8628, for
+	// devices without a browser that let the user approve on another one.
+	GrantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Token endpoint authentication methods of RFC 7591. Clients with "none" are
+// public clients, such as single-page and native apps, that cannot keep a
+// secret and rely on PKCE alone. private_key_jwt clients sign an assertion
+// with a key registered in their JWKS instead of sending a secret.
+const (
+	ClientAuthNone              = "none"
+	ClientAuthClientSecretBasic = "client_secret_basic"
+	ClientAuthClientSecretPost  = "client_secret_post"
+	ClientAuthPrivateKeyJWT     = "private_key_jwt"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type of RFC 7523.
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const PKCEMethodS256 = "S256"
+
+// OAuthClient is an application allowed to obtain tokens for users. ID is the
+// client_id. Only the hash of the client secret is stored.
+type OAuthClient struct {
+	ID           string   `bson:"_id"`
+	Name         string   `bson:"name"`
+	SecretHash   string   `bson:"secret_hash,omitempty"`
+	RedirectURIs []string `bson:"redirect_uris"`
+	// PostLogoutRedirectURIs are where RP-initiated logout may send the
+	// browser afterwards.
+	PostLogoutRedirectURIs  []string `bson:"post_logout_redirect_uris,omitempty"`
+	Scopes                  []string `bson:"scopes"`
+	GrantTypes              []string `bson:"grant_types"`
+	TokenEndpointAuthMethod string   `bson:"token_endpoint_auth_method"`
+	// JWKS holds the public keys of a private_key_jwt client as a JWK Set.
+	JWKS string `bson:"jwks,omitempty"`
+	// SkipConsent marks first-party clients, whose users are not asked to
+	// approve them.
+	SkipConsent bool `bson:"skip_consent"`
+	// RegistrationTokenHash is the hash of the registration access token of
+	// a dynamically registered client, with which it manages its own
+	// registration (RFC 7592).
+	RegistrationTokenHash string    `bson:"registration_token_hash,omitempty"`
+	CreatedAt             time.Time `bson:"created_at"`
+	UpdatedAt             time.Time `bson:"updated_at"`
+}
+
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	for _, allowed := range c.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *OAuthClient) AllowsPostLogoutRedirectURI(uri string) bool {
+	for _, registered := range c.PostLogoutRedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthClientConfig is one entry of OAUTH_CLIENTS_FILE.
+type OAuthClientConfig struct {
+	ClientID                string          `json:"client_id"`
+	Name                    string          `json:"name"`
+	ClientSecret            string          `json:"client_secret"`
+	RedirectURIs            []string        `json:"redirect_uris"`
+	PostLogoutRedirectURIs  []string        `json:"post_logout_redirect_uris"`
+	Scopes                  []string        `json:"scopes"`
+	GrantTypes              []string        `json:"grant_types"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method"`
+	JWKS                    json.RawMessage `json:"jwks"`
+	SkipConsent             bool            `json:"skip_consent"`
+}
+
+// AuthorizationRequest is an authorization request that passed validation and
+// waits for the user to log in and approve it. It is known to the login page
+// by an opaque request ID, of which only the hash is stored.
+type AuthorizationRequest struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash           string             `bson:"token_hash"`
+	ClientID            string             `bson:"client_id"`
+	RedirectURI         string             `bson:"redirect_uri"`
+	Scopes              []string           `bson:"scopes"`
+	State               string             `bson:"state,omitempty"`
+	Nonce               string             `bson:"nonce,omitempty"`
+	CodeChallenge       string             `bson:"code_challenge"`
+	CodeChallengeMethod string             `bson:"code_challenge_method"`
+	CreatedAt           time.Time          `bson:"created_at"`
+	ExpiresAt           time.Time          `bson:"expires_at"`
+}
+
+// AuthorizationCode is issued once the user approved a request. It can be
+// exchanged once; FamilyID is the refresh token family of that exchange, so a
+// second attempt can revoke what the first one got. Nonce, AuthTime and AMR
+// are copied into the ID token.
+type AuthorizationCode struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty"`
+	CodeHash            string             `bson:"code_hash"`
+	ClientID            string             `bson:"client_id"`
+	UserID              primitive.ObjectID `bson:"user_id"`
+	RedirectURI         string             `bson:"redirect_uri"`
+	Scopes              []string           `bson:"scopes"`
+	CodeChallenge       string             `bson:"code_challenge"`
+	CodeChallengeMethod string             `bson:"code_challenge_method"`
+	FamilyID            string             `bson:"family_id"`
+	Nonce               string             `bson:"nonce,omitempty"`
+	AuthTime            time.Time          `bson:"auth_time,omitempty"`
+	AMR                 []string           `bson:"amr,omitempty"`
+	CreatedAt           time.Time          `bson:"created_at"`
+	ExpiresAt           time.Time          `bson:"expires_at"`
+	UsedAt              *time.Time         `bson:"used_at,omitempty"`
+}
+
+// AuthorizeRequest holds the query parameters of /oauth/authorize.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// TokenRequest holds the form parameters of /oauth/token. The client
+// credentials may also come in the Authorization header.
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	DeviceCode   string `form:"device_code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	// ClientAssertionType and ClientAssertion carry a private_key_jwt
+	// authentication.
+	ClientAssertionType string `form:"client_assertion_type"`
+	ClientAssertion     string `form:"client_assertion"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthErrorResponse is the error format of RFC 6749.
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// AuthorizationRequestInfo is what the login page shows the user before they
+// approve a request.
+type AuthorizationRequestInfo struct {
+	RequestID       string    `json:"request_id"`
+	ClientID        string    `json:"client_id"`
+	ClientName      string    `json:"client_name"`
+	Scopes          []string  `json:"scopes"`
+	ConsentRequired bool      `json:"consent_required"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+type ConsentRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// ConsentResponse tells the login page where to send the browser: back to
+// the client with a code, or with an error if the user declined.
+type ConsentResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
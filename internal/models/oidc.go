@@ -0,0 +1,65 @@
+package models
+
+// Scopes defined by OpenID Connect. openid asks for an ID token, email and
+// profile decide which claims /oauth/userinfo returns.
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// Authentication method references of RFC 8176, as put in the amr claim.
+const (
+	AMRPassword        = "pwd"
+	AMROneTimePassword = "otp"
+	AMRHardwareKey     = "hwk"
+	AMRMultiFactor     = "mfa"
+)
+
+// Authentication context classes put in the acr claim. A login is
+// multi-factor when its amr contains "mfa".
+const (
+	ACRSingleFactor = "urn:raiko-auth:acr:sfa"
+	ACRMultiFactor  = "urn:raiko-auth:acr:mfa"
+)
+
+// OpenIDConfiguration is the discovery document of OpenID Connect Discovery
+// 1.0.
+type OpenIDConfiguration struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint"`
+	JWKSURI                                    string   `json:"jwks_uri"`
+	EndSessionEndpoint                         string   `json:"end_session_endpoint"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	ResponseModesSupported                     []string `json:"response_modes_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                            []string `json:"claims_supported"`
+	ACRValuesSupported                         []string `json:"acr_values_supported"`
+}
+
+// UserInfo holds the claims of /oauth/userinfo. Only those allowed by the
+// scopes of the access token are set.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// EndSessionRequest holds the parameters of RP-initiated logout.
+type EndSessionRequest struct {
+	IDTokenHint           string `form:"id_token_hint"`
+	ClientID              string `form:"client_id"`
+	PostLogoutRedirectURI string `form:"post_logout_redirect_uri"`
+	State                 string `form:"state"`
+}
@@ -0,0 +1,38 @@
+package models
+
+const (
+	PermissionProfileRead  = "profile:read"
+	PermissionProfileWrite = "profile:write"
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionClientsRead  = "clients:read"
+	PermissionClientsWrite = "clients:write"
+)
+
+// RoleDefinition maps a role to the permissions it grants. Definitions live in
+// the roles collection and are copied into access tokens when they are issued.
+type RoleDefinition struct {
+	Name        Role     `json:"name" bson:"_id"`
+	Description string   `json:"description" bson:"description,omitempty"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+// DefaultRoles are created on startup when they do not exist yet. Existing
+// definitions are left alone, so permissions edited in the database survive
+// restarts.
+var DefaultRoles = []RoleDefinition{
+	{
+		Name:        UserRole,
+		Description: "Regular user managing their own account",
+		Permissions: []string{PermissionProfileRead, PermissionProfileWrite},
+	},
+	{
+		Name:        AdminRole,
+		Description: "Administrator with access to all users",
+		Permissions: []string{
+			PermissionProfileRead, PermissionProfileWrite,
+			PermissionUsersRead, PermissionUsersWrite,
+			PermissionClientsRead, PermissionClientsWrite,
+		},
+	},
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	FamilyID  string             `bson:"family_id"`
+	TokenHash string             `bson:"token_hash"`
+	// ClientID and Scopes are set for tokens issued to OAuth clients, which
+	// can only be refreshed by that client and for those scopes.
+	ClientID string   `bson:"client_id,omitempty"`
+	Scopes   []string `bson:"scopes,omitempty"`
+	// AuthTime and AMR tell when and how the user logged in, and are kept
+	// across refreshes.
+	AuthTime  time.Time  `bson:"auth_time,omitempty"`
+	AMR       []string   `bson:"amr,omitempty"`
+	CreatedAt time.Time  `bson:"created_at"`
+	ExpiresAt time.Time  `bson:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty"`
+	Revoked   bool       `bson:"revoked"`
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+	Scopes       []string
+	// IDToken is only issued to OAuth clients granted the openid scope.
+	IDToken string
+}
+
+type RevokedToken struct {
+	JTI       string             `bson:"jti"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	RevokedAt time.Time          `bson:"revoked_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+type TokenIntrospection struct {
+	Active      bool     `json:"active"`
+	Subject     string   `json:"sub,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	JTI         string   `json:"jti,omitempty"`
+	TokenType   string   `json:"token_type,omitempty"`
+	Revoked     bool     `json:"revoked"`
+}
+
+type TokenPurpose string
+
+const (
+	PurposeEmailVerification TokenPurpose = "email_verification"
+	PurposePasswordReset     TokenPurpose = "password_reset"
+	PurposeEmailChange       TokenPurpose = "email_change"
+	PurposeMFAChallenge      TokenPurpose = "mfa_challenge"
+)
+
+// VerificationToken is a single-use token sent by email. Only its hash is stored.
+type VerificationToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   TokenPurpose       `bson:"purpose"`
+	TokenHash string             `bson:"token_hash"`
+	Email     string             `bson:"email"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+}
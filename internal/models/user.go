@@ -1,6 +1,9 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
 type Role string
 
@@ -12,8 +15,65 @@ const (
 type User struct {
 	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Email    string             `json:"email" bson:"email" validate:"required,email"`
-	Password string             `json:"password" bson:"password" validate:"required,min=8,max=20"`
+	Password string             `json:"password" bson:"password" validate:"required,min=8,max=128"`
 	Username string             `json:"username" bson:"username" validate:"required,min=3,max=20"`
 	IsActive bool               `json:"is_active" bson:"is_active"`
 	Role     Role               `json:"role" bson:"role"`
+
+	EmailVerified bool `json:"email_verified" bson:"email_verified"`
+
+	TokensValidAfter time.Time `json:"-" bson:"tokens_valid_after,omitempty"`
+
+	// FailedLogins counts the recent wrong passwords, the latest of them at
+	// LastFailedLoginAt. LockedUntil is zero unless the account was locked.
+	FailedLogins      int       `json:"-" bson:"failed_logins,omitempty"`
+	LastFailedLoginAt time.Time `json:"-" bson:"last_failed_login_at,omitempty"`
+	LockedUntil       time.Time `json:"-" bson:"locked_until,omitempty"`
+
+	// PasswordHistory holds the hashes of earlier passwords, newest first, so
+	// that they are not chosen again.
+	PasswordHistory []string `json:"-" bson:"password_history,omitempty"`
+}
+
+// UserProfile is the part of User that is shown to the account owner.
+type UserProfile struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	Username      string     `json:"username"`
+	Role          Role       `json:"role"`
+	IsActive      bool       `json:"is_active"`
+	EmailVerified bool       `json:"email_verified"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (u *User) Profile() *UserProfile {
+	profile := &UserProfile{
+		ID:            u.ID.Hex(),
+		Email:         u.Email,
+		Username:      u.Username,
+		Role:          u.Role,
+		IsActive:      u.IsActive,
+		EmailVerified: u.EmailVerified,
+		CreatedAt:     u.ID.Timestamp(),
+	}
+	if u.IsLocked(time.Now()) {
+		lockedUntil := u.LockedUntil
+		profile.LockedUntil = &lockedUntil
+	}
+	return profile
+}
+
+// IsLocked reports whether a temporary lockout is in effect at now. Locks
+// expire on their own, so an elapsed LockedUntil needs no cleanup.
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil.After(now)
+}
+
+// LoginFailures tracks the failed logins from one client IP.
+type LoginFailures struct {
+	IP           string    `bson:"_id"`
+	Count        int       `bson:"count"`
+	LastFailedAt time.Time `bson:"last_failed_at"`
+	ExpiresAt    time.Time `bson:"expires_at"`
 }
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const MFAMethodWebAuthn = "webauthn"
+
+// WebAuthnCredential is a passkey or security key registered by a user. ID is
+// the base64url encoded credential ID chosen by the authenticator.
+type WebAuthnCredential struct {
+	ID              string             `bson:"_id"`
+	UserID          primitive.ObjectID `bson:"user_id"`
+	Name            string             `bson:"name"`
+	PublicKey       []byte             `bson:"public_key"`
+	AttestationType string             `bson:"attestation_type"`
+	AAGUID          []byte             `bson:"aaguid"`
+	SignCount       uint32             `bson:"sign_count"`
+	Transports      []string           `bson:"transports"`
+	BackupEligible  bool               `bson:"backup_eligible"`
+	BackupState     bool               `bson:"backup_state"`
+	CreatedAt       time.Time          `bson:"created_at"`
+	LastUsedAt      *time.Time         `bson:"last_used_at,omitempty"`
+}
+
+type WebAuthnCeremony string
+
+const (
+	WebAuthnRegistration WebAuthnCeremony = "registration"
+	// WebAuthnLogin is a passwordless login with a discoverable credential.
+	WebAuthnLogin WebAuthnCeremony = "login"
+	// WebAuthnMFA answers the MFA challenge of a password login.
+	WebAuthnMFA WebAuthnCeremony = "mfa"
+)
+
+// WebAuthnSession keeps the challenge of a ceremony between its begin and
+// finish requests. Data is the session state of the WebAuthn library as JSON.
+// UserID is empty for a passwordless login, where the user is only known once
+// the authenticator answers.
+type WebAuthnSession struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash string             `bson:"token_hash"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Ceremony  WebAuthnCeremony   `bson:"ceremony"`
+	Data      []byte             `bson:"data"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// WebAuthnOptionsResponse carries the options to pass to
+// navigator.credentials.create() or .get() and the session to finish with.
+type WebAuthnOptionsResponse struct {
+	SessionID string          `json:"session_id"`
+	Options   json.RawMessage `json:"options" swaggertype:"object"`
+}
+
+type FinishWebAuthnRegistrationRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+type BeginWebAuthnLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type FinishWebAuthnLoginRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// WebAuthnCredentialInfo is the part of a credential shown to its owner.
+type WebAuthnCredentialInfo struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	AAGUID         string     `json:"aaguid"`
+	Transports     []string   `json:"transports"`
+	BackupEligible bool       `json:"backup_eligible"`
+	BackupState    bool       `json:"backup_state"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+type WebAuthnCredentialsResponse struct {
+	Credentials []WebAuthnCredentialInfo `json:"credentials"`
+}
+
+func (c *WebAuthnCredential) Info() WebAuthnCredentialInfo {
+	transports := c.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	return WebAuthnCredentialInfo{
+		ID:             c.ID,
+		Name:           c.Name,
+		AAGUID:         formatAAGUID(c.AAGUID),
+		Transports:     transports,
+		BackupEligible: c.BackupEligible,
+		BackupState:    c.BackupState,
+		CreatedAt:      c.CreatedAt,
+		LastUsedAt:     c.LastUsedAt,
+	}
+}
+
+// formatAAGUID writes the authenticator model ID in the usual UUID form, which
+// is what authenticator metadata lists use.
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", aaguid[0:4], aaguid[4:6], aaguid[6:8], aaguid[8:10], aaguid[10:])
+}
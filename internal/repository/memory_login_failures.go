@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"sync"
+	"time"
+)
+
+type MemoryLoginFailureRepository struct {
+	mu       sync.Mutex
+	failures map[string]models.LoginFailures
+}
+
+func NewMemoryLoginFailureRepository() *MemoryLoginFailureRepository {
+	return &MemoryLoginFailureRepository{failures: map[string]models.LoginFailures{}}
+}
+
+func (r *MemoryLoginFailureRepository) Record(ctx context.Context, ip string, at, expiresAt time.Time) (*models.LoginFailures, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Expired records are dropped here, as there is no TTL index to do it.
+	for key, record := range r.failures {
+		if !record.ExpiresAt.After(at) {
+			delete(r.failures, key)
+		}
+	}
+
+	record := r.failures[ip]
+	record.IP = ip
+	record.Count++
+	record.LastFailedAt = at
+	record.ExpiresAt = expiresAt
+
+	r.failures[ip] = record
+	return &record, nil
+}
+
+func (r *MemoryLoginFailureRepository) Find(ctx context.Context, ip string, now time.Time) (*models.LoginFailures, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.failures[ip]
+	if !ok || !record.ExpiresAt.After(now) {
+		return nil, ErrNotFound
+	}
+	return &record, nil
+}
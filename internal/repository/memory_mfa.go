@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+type MemoryMFARepository struct {
+	mu          sync.Mutex
+	enrollments map[primitive.ObjectID]models.TOTPEnrollment
+}
+
+func NewMemoryMFARepository() *MemoryMFARepository {
+	return &MemoryMFARepository{enrollments: map[primitive.ObjectID]models.TOTPEnrollment{}}
+}
+
+func (r *MemoryMFARepository) SaveTOTP(ctx context.Context, enrollment *models.TOTPEnrollment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *enrollment
+	stored.RecoveryCodes = append([]models.RecoveryCode(nil), enrollment.RecoveryCodes...)
+	r.enrollments[enrollment.UserID] = stored
+	return nil
+}
+
+func (r *MemoryMFARepository) FindTOTP(ctx context.Context, userID primitive.ObjectID) (*models.TOTPEnrollment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enrollment, ok := r.enrollments[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	enrollment.RecoveryCodes = append([]models.RecoveryCode(nil), enrollment.RecoveryCodes...)
+	return &enrollment, nil
+}
+
+func (r *MemoryMFARepository) ConfirmTOTP(ctx context.Context, userID primitive.ObjectID, step int64, at time.Time) error {
+	return r.update(userID, func(e *models.TOTPEnrollment) bool {
+		if e.Confirmed {
+			return false
+		}
+		e.Confirmed = true
+		e.ConfirmedAt = &at
+		e.LastUsedStep = step
+		return true
+	})
+}
+
+func (r *MemoryMFARepository) UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error {
+	return r.update(userID, func(e *models.TOTPEnrollment) bool {
+		if !e.Confirmed || e.LastUsedStep >= step {
+			return false
+		}
+		e.LastUsedStep = step
+		return true
+	})
+}
+
+func (r *MemoryMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string) error {
+	return r.update(userID, func(e *models.TOTPEnrollment) bool {
+		e.RecoveryCodes = make([]models.RecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			e.RecoveryCodes = append(e.RecoveryCodes, models.RecoveryCode{Hash: hash})
+		}
+		return true
+	})
+}
+
+func (r *MemoryMFARepository) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string, at time.Time) error {
+	return r.update(userID, func(e *models.TOTPEnrollment) bool {
+		if !e.Confirmed {
+			return false
+		}
+		for i := range e.RecoveryCodes {
+			if e.RecoveryCodes[i].Hash == hash && e.RecoveryCodes[i].UsedAt == nil {
+				e.RecoveryCodes[i].UsedAt = &at
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *MemoryMFARepository) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.enrollments, userID)
+	return nil
+}
+
+// update applies change to the enrollment of the user and stores the result
+// if change reports that it applied.
+func (r *MemoryMFARepository) update(userID primitive.ObjectID, change func(*models.TOTPEnrollment) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enrollment, ok := r.enrollments[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	enrollment.RecoveryCodes = append([]models.RecoveryCode(nil), enrollment.RecoveryCodes...)
+	if !change(&enrollment) {
+		return ErrNotFound
+	}
+	r.enrollments[userID] = enrollment
+	return nil
+}
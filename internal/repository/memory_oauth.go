@@ -0,0 +1,348 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryOAuthRepository drops expired requests, codes and device
+// authorizations whenever new ones are stored.
+type MemoryOAuthRepository struct {
+	mu       sync.Mutex
+	clients  map[string]models.OAuthClient
+	requests map[string]models.AuthorizationRequest
+	codes    map[string]models.AuthorizationCode
+	// devices maps the device code hash to the device authorization.
+	devices map[string]models.DeviceAuthorization
+	// assertions maps client ID and jti of used client assertions to their
+	// expiry.
+	assertions map[[2]string]time.Time
+}
+
+func NewMemoryOAuthRepository() *MemoryOAuthRepository {
+	return &MemoryOAuthRepository{
+		clients:  map[string]models.OAuthClient{},
+		requests: map[string]models.AuthorizationRequest{},
+		codes:    map[string]models.AuthorizationCode{},
+
+		devices:    map[string]models.DeviceAuthorization{},
+		assertions: map[[2]string]time.Time{},
+	}
+}
+
+func (r *MemoryOAuthRepository) SaveClient(ctx context.Context, client *models.OAuthClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := copyClient(*client)
+	if existing, ok := r.clients[client.ID]; ok {
+		saved.CreatedAt = existing.CreatedAt
+	}
+	r.clients[client.ID] = saved
+	return nil
+}
+
+func (r *MemoryOAuthRepository) FindClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	client = copyClient(client)
+	return &client, nil
+}
+
+func (r *MemoryOAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[client.ID]; ok {
+		return ErrDuplicate
+	}
+	r.clients[client.ID] = copyClient(*client)
+	return nil
+}
+
+func (r *MemoryOAuthRepository) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := make([]models.OAuthClient, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, copyClient(client))
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].CreatedAt.Equal(clients[j].CreatedAt) {
+			return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, nil
+}
+
+func (r *MemoryOAuthRepository) DeleteClient(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.clients, id)
+
+	for hash, request := range r.requests {
+		if request.ClientID == id {
+			delete(r.requests, hash)
+		}
+	}
+	for hash, code := range r.codes {
+		if code.ClientID == id {
+			delete(r.codes, hash)
+		}
+	}
+	for hash, authorization := range r.devices {
+		if authorization.ClientID == id {
+			delete(r.devices, hash)
+		}
+	}
+	for key := range r.assertions {
+		if key[0] == id {
+			delete(r.assertions, key)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryOAuthRepository) SaveAuthorizationRequest(ctx context.Context, request *models.AuthorizationRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneExpired(request.CreatedAt)
+	if request.ID.IsZero() {
+		request.ID = primitive.NewObjectID()
+	}
+	r.requests[request.TokenHash] = *request
+	return nil
+}
+
+func (r *MemoryOAuthRepository) FindAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[hash]
+	if !ok || !now.Before(request.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &request, nil
+}
+
+func (r *MemoryOAuthRepository) ConsumeAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(r.requests, hash)
+	if !now.Before(request.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &request, nil
+}
+
+func (r *MemoryOAuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneExpired(code.CreatedAt)
+	if _, ok := r.codes[code.CodeHash]; ok {
+		return ErrDuplicate
+	}
+	if code.ID.IsZero() {
+		code.ID = primitive.NewObjectID()
+	}
+	r.codes[code.CodeHash] = *code
+	return nil
+}
+
+func (r *MemoryOAuthRepository) FindAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &code, nil
+}
+
+func (r *MemoryOAuthRepository) MarkAuthorizationCodeUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.ID != id {
+			continue
+		}
+		if code.UsedAt != nil {
+			return ErrNotFound
+		}
+		code.UsedAt = &at
+		r.codes[hash] = code
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryOAuthRepository) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, expiry := range r.assertions {
+		if !now.Before(expiry) {
+			delete(r.assertions, key)
+		}
+	}
+
+	key := [2]string{clientID, jti}
+	if _, ok := r.assertions[key]; ok {
+		return ErrDuplicate
+	}
+	r.assertions[key] = expiresAt
+	return nil
+}
+
+func (r *MemoryOAuthRepository) CreateDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneExpired(authorization.CreatedAt)
+	for hash, existing := range r.devices {
+		if hash == authorization.DeviceCodeHash || existing.UserCodeHash == authorization.UserCodeHash {
+			return ErrDuplicate
+		}
+	}
+	if authorization.ID.IsZero() {
+		authorization.ID = primitive.NewObjectID()
+	}
+	r.devices[authorization.DeviceCodeHash] = copyDeviceAuthorization(*authorization)
+	return nil
+}
+
+func (r *MemoryOAuthRepository) FindDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*models.DeviceAuthorization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	authorization, ok := r.devices[deviceCodeHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	authorization = copyDeviceAuthorization(authorization)
+	return &authorization, nil
+}
+
+func (r *MemoryOAuthRepository) FindPendingDeviceAuthorization(ctx context.Context, userCodeHash string, now time.Time) (*models.DeviceAuthorization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, authorization := range r.devices {
+		if authorization.UserCodeHash == userCodeHash &&
+			authorization.Status == models.DeviceAuthorizationPending && now.Before(authorization.ExpiresAt) {
+			authorization = copyDeviceAuthorization(authorization)
+			return &authorization, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryOAuthRepository) AnswerDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, stored := range r.devices {
+		if stored.ID != authorization.ID {
+			continue
+		}
+		if stored.Status != models.DeviceAuthorizationPending || !now.Before(stored.ExpiresAt) {
+			return ErrNotFound
+		}
+		stored.Status = authorization.Status
+		stored.UserID = authorization.UserID
+		stored.AuthTime = authorization.AuthTime
+		stored.AMR = append([]string(nil), authorization.AMR...)
+		r.devices[hash] = stored
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryOAuthRepository) RecordDeviceAuthorizationPoll(ctx context.Context, id primitive.ObjectID, at time.Time, interval int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, stored := range r.devices {
+		if stored.ID == id {
+			stored.LastPolledAt = &at
+			stored.Interval = interval
+			r.devices[hash] = stored
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryOAuthRepository) ConsumeDeviceAuthorization(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, stored := range r.devices {
+		if stored.ID == id && stored.Status == models.DeviceAuthorizationApproved {
+			delete(r.devices, hash)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryOAuthRepository) pruneExpired(now time.Time) {
+	for hash, request := range r.requests {
+		if !now.Before(request.ExpiresAt) {
+			delete(r.requests, hash)
+		}
+	}
+	for hash, code := range r.codes {
+		if !now.Before(code.ExpiresAt) {
+			delete(r.codes, hash)
+		}
+	}
+	for hash, authorization := range r.devices {
+		if !now.Before(authorization.ExpiresAt) {
+			delete(r.devices, hash)
+		}
+	}
+}
+
+func copyClient(client models.OAuthClient) models.OAuthClient {
+	client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	client.PostLogoutRedirectURIs = append([]string(nil), client.PostLogoutRedirectURIs...)
+	client.Scopes = append([]string(nil), client.Scopes...)
+	client.GrantTypes = append([]string(nil), client.GrantTypes...)
+	return client
+}
+
+func copyDeviceAuthorization(authorization models.DeviceAuthorization) models.DeviceAuthorization {
+	authorization.Scopes = append([]string(nil), authorization.Scopes...)
+	authorization.AMR = append([]string(nil), authorization.AMR...)
+	if authorization.LastPolledAt != nil {
+		polledAt := *authorization.LastPolledAt
+		authorization.LastPolledAt = &polledAt
+	}
+	return authorization
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// NewMemoryStore returns repositories that keep everything in process memory.
+// Data does not survive a restart, so it is only suitable for tests and local
+// development.
+func NewMemoryStore() *Store {
+	users := NewMemoryUserRepository()
+	tokens := NewMemoryTokenRepository()
+	verificationTokens := NewMemoryVerificationTokenRepository()
+	roles := NewMemoryRoleRepository()
+	audit := NewMemoryAuditRepository()
+	mfa := NewMemoryMFARepository()
+	webAuthn := NewMemoryWebAuthnRepository()
+	loginFailures := NewMemoryLoginFailureRepository()
+	oauth := NewMemoryOAuthRepository()
+
+	store := &Store{
+		Users:              users,
+		Tokens:             tokens,
+		VerificationTokens: verificationTokens,
+		Roles:              roles,
+		Audit:              audit,
+		MFA:                mfa,
+		WebAuthn:           webAuthn,
+		LoginFailures:      loginFailures,
+		OAuth:              oauth,
+	}
+
+	// Transactions run one at a time and put every repository back the way it
+	// was when fn fails. They are not isolated: writes made outside of a
+	// transaction while it runs are lost on rollback as well.
+	var mu sync.Mutex
+	snapshotters := []memorySnapshotter{users, tokens, verificationTokens, roles, audit, mfa, webAuthn, loginFailures, oauth}
+	store.transact = func(ctx context.Context, fn func(*Store) error) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		restores := make([]func(), 0, len(snapshotters))
+		for _, repository := range snapshotters {
+			restores = append(restores, repository.snapshot())
+		}
+		if err := fn(store); err != nil {
+			for _, restore := range restores {
+				restore()
+			}
+			return err
+		}
+		return nil
+	}
+	return store
+}
+
+// memorySnapshotter is implemented by the memory repositories. snapshot
+// copies the stored entries and returns a function that puts them back.
+type memorySnapshotter interface {
+	snapshot() (restore func())
+}
+
+func (r *MemoryUserRepository) snapshot() func() {
+	r.mu.RLock()
+	users := maps.Clone(r.users)
+	r.mu.RUnlock()
+
+	return func() {
+		r.mu.Lock()
+		r.users = users
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryTokenRepository) snapshot() func() {
+	r.mu.Lock()
+	refreshTokens, revokedTokens := maps.Clone(r.refreshTokens), maps.Clone(r.revokedTokens)
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.refreshTokens, r.revokedTokens = refreshTokens, revokedTokens
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryVerificationTokenRepository) snapshot() func() {
+	r.mu.Lock()
+	tokens := maps.Clone(r.tokens)
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.tokens = tokens
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryRoleRepository) snapshot() func() {
+	r.mu.RLock()
+	roles := maps.Clone(r.roles)
+	r.mu.RUnlock()
+
+	return func() {
+		r.mu.Lock()
+		r.roles = roles
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryAuditRepository) snapshot() func() {
+	r.mu.RLock()
+	events := slices.Clone(r.events)
+	r.mu.RUnlock()
+
+	return func() {
+		r.mu.Lock()
+		r.events = events
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryMFARepository) snapshot() func() {
+	r.mu.Lock()
+	enrollments := make(map[primitive.ObjectID]models.TOTPEnrollment, len(r.enrollments))
+	for id, enrollment := range r.enrollments {
+		// Recovery codes are marked as used in place.
+		enrollment.RecoveryCodes = slices.Clone(enrollment.RecoveryCodes)
+		enrollments[id] = enrollment
+	}
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.enrollments = enrollments
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryWebAuthnRepository) snapshot() func() {
+	r.mu.Lock()
+	credentials, sessions := maps.Clone(r.credentials), maps.Clone(r.sessions)
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.credentials, r.sessions = credentials, sessions
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryLoginFailureRepository) snapshot() func() {
+	r.mu.Lock()
+	failures := maps.Clone(r.failures)
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.failures = failures
+		r.mu.Unlock()
+	}
+}
+
+func (r *MemoryOAuthRepository) snapshot() func() {
+	r.mu.Lock()
+	clients, requests, codes := maps.Clone(r.clients), maps.Clone(r.requests), maps.Clone(r.codes)
+	devices, assertions := maps.Clone(r.devices), maps.Clone(r.assertions)
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		r.clients, r.requests, r.codes = clients, requests, codes
+		r.devices, r.assertions = devices, assertions
+		r.mu.Unlock()
+	}
+}
+
+type MemoryRoleRepository struct {
+	mu    sync.RWMutex
+	roles map[models.Role]models.RoleDefinition
+}
+
+func NewMemoryRoleRepository() *MemoryRoleRepository {
+	return &MemoryRoleRepository{roles: map[models.Role]models.RoleDefinition{}}
+}
+
+func (r *MemoryRoleRepository) CreateIfMissing(ctx context.Context, role *models.RoleDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[role.Name]; !ok {
+		definition := *role
+		definition.Permissions = append([]string(nil), role.Permissions...)
+		r.roles[role.Name] = definition
+	}
+	return nil
+}
+
+func (r *MemoryRoleRepository) FindByName(ctx context.Context, name models.Role) (*models.RoleDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role, ok := r.roles[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &role, nil
+}
+
+type MemoryAuditRepository struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+func NewMemoryAuditRepository() *MemoryAuditRepository {
+	return &MemoryAuditRepository{}
+}
+
+func (r *MemoryAuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *MemoryAuditRepository) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.AuditEvent, error) {
+	r.mu.RLock()
+	events := []models.AuditEvent{}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].UserID == userID {
+			events = append(events, r.events[i])
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.After(events[j].CreatedAt) })
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
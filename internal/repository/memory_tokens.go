@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// MemoryTokenRepository keeps refresh tokens and revoked access tokens in
+// process memory. Expired entries are dropped whenever a token is stored.
+type MemoryTokenRepository struct {
+	mu            sync.Mutex
+	refreshTokens map[primitive.ObjectID]models.RefreshToken
+	revokedTokens map[string]models.RevokedToken
+}
+
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{
+		refreshTokens: map[primitive.ObjectID]models.RefreshToken{},
+		revokedTokens: map[string]models.RevokedToken{},
+	}
+}
+
+func (r *MemoryTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneExpired(time.Now())
+	for _, existing := range r.refreshTokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	r.refreshTokens[token.ID] = *token
+	return nil
+}
+
+func (r *MemoryTokenRepository) FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.refreshTokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[id]
+	if !ok || token.UsedAt != nil || token.Revoked {
+		return ErrNotFound
+	}
+	token.UsedAt = &at
+	r.refreshTokens[id] = token
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	r.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.FamilyID == familyID })
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	r.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (r *MemoryTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.refreshTokens {
+		if token.UserID == userID {
+			delete(r.refreshTokens, id)
+		}
+	}
+	for jti, token := range r.revokedTokens {
+		if token.UserID == userID {
+			delete(r.revokedTokens, jti)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) DeleteClientRefreshTokens(ctx context.Context, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.refreshTokens {
+		if token.ClientID == clientID {
+			delete(r.refreshTokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneExpired(time.Now())
+	if _, ok := r.revokedTokens[token.JTI]; !ok {
+		r.revokedTokens[token.JTI] = *token
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.revokedTokens[jti]
+	return ok, nil
+}
+
+func (r *MemoryTokenRepository) revokeRefreshTokens(match func(*models.RefreshToken) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.refreshTokens {
+		if match(&token) {
+			token.Revoked = true
+			r.refreshTokens[id] = token
+		}
+	}
+}
+
+// pruneExpired plays the part of the TTL indexes of the MongoDB collections.
+func (r *MemoryTokenRepository) pruneExpired(now time.Time) {
+	for id, token := range r.refreshTokens {
+		if now.After(token.ExpiresAt) {
+			delete(r.refreshTokens, id)
+		}
+	}
+	for jti, token := range r.revokedTokens {
+		if now.After(token.ExpiresAt) {
+			delete(r.revokedTokens, jti)
+		}
+	}
+}
+
+type MemoryVerificationTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[primitive.ObjectID]models.VerificationToken
+}
+
+func NewMemoryVerificationTokenRepository() *MemoryVerificationTokenRepository {
+	return &MemoryVerificationTokenRepository{tokens: map[primitive.ObjectID]models.VerificationToken{}}
+}
+
+func (r *MemoryVerificationTokenRepository) Replace(ctx context.Context, token *models.VerificationToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range r.tokens {
+		unusedDuplicate := existing.UserID == token.UserID && existing.Purpose == token.Purpose && existing.UsedAt == nil
+		if unusedDuplicate || now.After(existing.ExpiresAt) {
+			delete(r.tokens, id)
+		}
+	}
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	r.tokens[token.ID] = *token
+	return nil
+}
+
+func (r *MemoryVerificationTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.TokenHash != hash || token.Purpose != purpose || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+			continue
+		}
+		token.UsedAt = &now
+		r.tokens[id] = token
+		return &token, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryVerificationTokenRepository) Find(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.TokenHash == hash && token.Purpose == purpose && token.UsedAt == nil && now.Before(token.ExpiresAt) {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryVerificationTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
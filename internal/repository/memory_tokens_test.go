@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenRepositoryRefreshTokenIsUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewMemoryTokenRepository()
+	now := time.Now()
+
+	create := func(hash, family string) *models.RefreshToken {
+		t.Helper()
+
+		token := &models.RefreshToken{FamilyID: family, TokenHash: hash, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := tokens.CreateRefreshToken(ctx, token); err != nil {
+			t.Fatalf("create refresh token: %v", err)
+		}
+		return token
+	}
+	used := create("used", "a")
+	revoked := create("revoked", "b")
+	fresh := create("fresh", "c")
+
+	if err := tokens.CreateRefreshToken(ctx, &models.RefreshToken{TokenHash: "fresh", ExpiresAt: now.Add(time.Hour)}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("same hash: err = %v, want %v", err, ErrDuplicate)
+	}
+	if err := tokens.MarkRefreshTokenUsed(ctx, used.ID, now); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := tokens.RevokeRefreshFamily(ctx, "b"); err != nil {
+		t.Fatalf("revoke family: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   primitive.ObjectID
+		err  error
+	}{
+		{"already used", used.ID, ErrNotFound},
+		{"revoked family", revoked.ID, ErrNotFound},
+		{"unknown", primitive.NewObjectID(), ErrNotFound},
+		{"unused", fresh.ID, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tokens.MarkRefreshTokenUsed(ctx, tt.id, now); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMemoryVerificationTokenRepository(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewMemoryVerificationTokenRepository()
+	userID := primitive.NewObjectID()
+	now := time.Now()
+
+	replace := func(hash string, purpose models.TokenPurpose) {
+		t.Helper()
+
+		token := &models.VerificationToken{UserID: userID, Purpose: purpose, TokenHash: hash, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := tokens.Replace(ctx, token); err != nil {
+			t.Fatalf("replace: %v", err)
+		}
+	}
+	replace("first", models.PurposePasswordReset)
+	replace("second", models.PurposePasswordReset)
+	replace("verify", models.PurposeEmailVerification)
+
+	tests := []struct {
+		name    string
+		hash    string
+		purpose models.TokenPurpose
+		at      time.Time
+		err     error
+	}{
+		{"replaced token", "first", models.PurposePasswordReset, now, ErrNotFound},
+		{"other purpose", "second", models.PurposeEmailVerification, now, ErrNotFound},
+		{"expired", "second", models.PurposePasswordReset, now.Add(time.Hour), ErrNotFound},
+		{"latest token", "second", models.PurposePasswordReset, now, nil},
+		{"consumed token", "second", models.PurposePasswordReset, now, ErrNotFound},
+		{"token of another purpose is kept", "verify", models.PurposeEmailVerification, now, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tokens.Consume(ctx, tt.hash, tt.purpose, tt.at)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("consume: err = %v, want %v", err, tt.err)
+			}
+			if err == nil && (token.UsedAt == nil || token.UserID != userID) {
+				t.Errorf("consumed token = %+v", token)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRollsBackFailedTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	failure := errors.New("failure")
+
+	err := store.InTransaction(ctx, func(tx *Store) error {
+		if err := tx.Users.Create(ctx, &models.User{Email: "alice@example.com", Username: "alice"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("transaction: err = %v, want %v", err, failure)
+	}
+	if _, err := store.Users.FindByLogin(ctx, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("user of the failed transaction: err = %v, want %v", err, ErrNotFound)
+	}
+
+	err = store.InTransaction(ctx, func(tx *Store) error {
+		return tx.Users.Create(ctx, &models.User{Email: "alice@example.com", Username: "alice"})
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if _, err := store.Users.FindByLogin(ctx, "alice"); err != nil {
+		t.Errorf("user of the committed transaction: %v", err)
+	}
+}
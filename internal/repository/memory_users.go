@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository keeps users in process memory. It is meant for tests
+// and local development and enforces the same uniqueness as the databases:
+// emails and usernames are compared in their normalized form.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]models.User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[primitive.ObjectID]models.User{}}
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if sameIdentifier(existing.Email, user.Email) || sameIdentifier(existing.Username, user.Username) {
+			return ErrDuplicate
+		}
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return sameIdentifier(u.Email, email) })
+}
+
+func (r *MemoryUserRepository) FindByLogin(ctx context.Context, login string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return sameIdentifier(u.Email, login) || sameIdentifier(u.Username, login) })
+}
+
+func (r *MemoryUserRepository) EmailTaken(ctx context.Context, email string, exclude primitive.ObjectID) (bool, error) {
+	_, err := r.find(func(u *models.User) bool { return sameIdentifier(u.Email, email) && u.ID != exclude })
+	return err == nil, nil
+}
+
+func (r *MemoryUserRepository) UsernameTaken(ctx context.Context, username string, exclude primitive.ObjectID) (bool, error) {
+	_, err := r.find(func(u *models.User) bool { return sameIdentifier(u.Username, username) && u.ID != exclude })
+	return err == nil, nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	for otherID, other := range r.users {
+		if otherID == id {
+			continue
+		}
+		if (update.Email != nil && sameIdentifier(other.Email, *update.Email)) ||
+			(update.Username != nil && sameIdentifier(other.Username, *update.Username)) {
+			return nil, ErrDuplicate
+		}
+	}
+
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.Username != nil {
+		user.Username = *update.Username
+	}
+	if update.Password != nil {
+		user.Password = *update.Password
+	}
+	if update.Role != nil {
+		user.Role = *update.Role
+	}
+	if update.IsActive != nil {
+		user.IsActive = *update.IsActive
+	}
+	if update.EmailVerified != nil {
+		user.EmailVerified = *update.EmailVerified
+	}
+	if update.TokensValidAfter != nil {
+		user.TokensValidAfter = *update.TokensValidAfter
+	}
+	if update.PasswordHistory != nil {
+		user.PasswordHistory = append([]string(nil), *update.PasswordHistory...)
+	}
+
+	r.users[id] = user
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+func (r *MemoryUserRepository) RecordLoginFailure(ctx context.Context, id primitive.ObjectID, at, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	if user.LastFailedLoginAt.After(since) {
+		user.FailedLogins++
+	} else {
+		user.FailedLogins = 1
+	}
+	user.LastFailedLoginAt = at
+
+	r.users[id] = user
+	return user.FailedLogins, nil
+}
+
+func (r *MemoryUserRepository) Lock(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	return r.setLoginState(id, until)
+}
+
+func (r *MemoryUserRepository) ResetLoginFailures(ctx context.Context, id primitive.ObjectID) error {
+	return r.setLoginState(id, time.Time{})
+}
+
+func (r *MemoryUserRepository) setLoginState(id primitive.ObjectID, lockedUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	user.FailedLogins = 0
+	user.LastFailedLoginAt = time.Time{}
+	user.LockedUntil = lockedUntil
+
+	r.users[id] = user
+	return nil
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	r.mu.RLock()
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if matchesUserQuery(&user, &query) {
+			users = append(users, user)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		c := compareUserPosition(&users[i], query.SortBy, userSortValue(&users[j], query.SortBy), users[j].ID)
+		if query.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if query.Limit > 0 && len(users) > query.Limit {
+		users = users[:query.Limit]
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func sameIdentifier(a, b string) bool {
+	return utils.NormalizeIdentifier(a) == utils.NormalizeIdentifier(b)
+}
+
+func matchesUserQuery(user *models.User, query *UserQuery) bool {
+	if query.Role != "" && string(user.Role) != query.Role {
+		return false
+	}
+	if query.Active != nil && user.IsActive != *query.Active {
+		return false
+	}
+
+	created := user.ID.Timestamp().Unix()
+	if !query.CreatedAfter.IsZero() && created < query.CreatedAfter.Unix() {
+		return false
+	}
+	if !query.CreatedBefore.IsZero() && created >= query.CreatedBefore.Unix() {
+		return false
+	}
+
+	if query.Prefix != "" {
+		prefix := strings.ToLower(query.Prefix)
+		if !strings.HasPrefix(strings.ToLower(user.Email), prefix) &&
+			!strings.HasPrefix(strings.ToLower(user.Username), prefix) {
+			return false
+		}
+	}
+
+	if query.After != nil {
+		c := compareUserPosition(user, query.SortBy, query.After.Value, query.After.ID)
+		if (query.Descending && c >= 0) || (!query.Descending && c <= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareUserPosition orders user against the sort position (value, id) the
+// same way the MongoDB query does: by the sort field, then by ID.
+func compareUserPosition(user *models.User, sortBy, value string, id primitive.ObjectID) int {
+	if c := strings.Compare(userSortValue(user, sortBy), value); c != 0 {
+		return c
+	}
+	return bytes.Compare(user.ID[:], id[:])
+}
+
+func userSortValue(user *models.User, sortBy string) string {
+	switch sortBy {
+	case "email":
+		return user.Email
+	case "username":
+		return user.Username
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func createUser(t *testing.T, users UserRepository, email, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, Username: username}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatalf("create %s: %v", username, err)
+	}
+	return user
+}
+
+func TestMemoryUserRepositoryUniqueness(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemoryUserRepository()
+	alice := createUser(t, users, "alice@example.com", "alice")
+	bob := createUser(t, users, "bob@example.com", "bob")
+
+	tests := []struct {
+		name     string
+		email    string
+		username string
+		err      error
+	}{
+		{"same email", "alice@example.com", "carol", ErrDuplicate},
+		{"email in another case", "Alice@Example.COM", "carol", ErrDuplicate},
+		{"username in another case", "carol@example.com", "ALICE", ErrDuplicate},
+		{"new email and username", "carol@example.com", "carol", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := users.Create(ctx, &models.User{Email: tt.email, Username: tt.username})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("create: err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	email := "ALICE@example.com"
+	if _, err := users.Update(ctx, bob.ID, UserUpdate{Email: &email}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("update to a taken email: err = %v, want %v", err, ErrDuplicate)
+	}
+	if _, err := users.Update(ctx, alice.ID, UserUpdate{Email: &email}); err != nil {
+		t.Errorf("update to the user's own email in another case: %v", err)
+	}
+}
+
+func TestMemoryUserRepositoryFindByLogin(t *testing.T) {
+	users := NewMemoryUserRepository()
+	alice := createUser(t, users, "alice@example.com", "alice")
+
+	tests := []struct {
+		login string
+		err   error
+	}{
+		{"alice", nil},
+		{"ALICE", nil},
+		{"Alice@Example.com", nil},
+		{"bob", ErrNotFound},
+		{"", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			user, err := users.FindByLogin(context.Background(), tt.login)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && user.ID != alice.ID {
+				t.Errorf("found %s, want %s", user.ID.Hex(), alice.ID.Hex())
+			}
+		})
+	}
+}
+
+func TestMemoryUserRepositoryLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemoryUserRepository()
+	user := createUser(t, users, "alice@example.com", "alice")
+	now := time.Now()
+
+	record := func(at time.Time, window time.Duration) int {
+		t.Helper()
+
+		count, err := users.RecordLoginFailure(ctx, user.ID, at, at.Add(-window))
+		if err != nil {
+			t.Fatalf("record login failure: %v", err)
+		}
+		return count
+	}
+
+	if got := record(now, time.Minute); got != 1 {
+		t.Errorf("first failure: count = %d, want 1", got)
+	}
+	if got := record(now.Add(30*time.Second), time.Minute); got != 2 {
+		t.Errorf("failure within the window: count = %d, want 2", got)
+	}
+	if got := record(now.Add(5*time.Minute), time.Minute); got != 1 {
+		t.Errorf("failure after the window: count = %d, want 1", got)
+	}
+
+	until := now.Add(time.Hour)
+	if err := users.Lock(ctx, user.ID, until); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	locked, err := users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if !locked.LockedUntil.Equal(until) || locked.FailedLogins != 0 {
+		t.Errorf("locked user = until %v with %d failures, want until %v with none", locked.LockedUntil, locked.FailedLogins, until)
+	}
+
+	record(now, time.Minute)
+	if err := users.ResetLoginFailures(ctx, user.ID); err != nil {
+		t.Fatalf("reset login failures: %v", err)
+	}
+	reset, err := users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if !reset.LockedUntil.IsZero() || reset.FailedLogins != 0 || !reset.LastFailedLoginAt.IsZero() {
+		t.Errorf("reset user = %+v, want no lock and no failures", reset)
+	}
+
+	if _, err := users.RecordLoginFailure(ctx, primitive.NewObjectID(), now, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown user: err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryLoginFailureRepositoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	failures := NewMemoryLoginFailureRepository()
+	now := time.Now()
+
+	for i := 1; i <= 2; i++ {
+		record, err := failures.Record(ctx, "192.0.2.1", now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("record: %v", err)
+		}
+		if record.Count != i {
+			t.Errorf("failure %d: count = %d", i, record.Count)
+		}
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		at   time.Time
+		err  error
+	}{
+		{"within the window", "192.0.2.1", now.Add(30 * time.Second), nil},
+		{"at expiry", "192.0.2.1", now.Add(time.Minute), ErrNotFound},
+		{"other address", "192.0.2.2", now, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := failures.Find(ctx, tt.ip, tt.at); !errors.Is(err, tt.err) {
+				t.Errorf("find: err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	later := now.Add(2 * time.Minute)
+	record, err := failures.Record(ctx, "192.0.2.1", later, later.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if record.Count != 1 {
+		t.Errorf("failure after expiry: count = %d, want 1", record.Count)
+	}
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryWebAuthnRepository struct {
+	mu          sync.Mutex
+	credentials map[string]models.WebAuthnCredential
+	sessions    map[string]models.WebAuthnSession
+}
+
+func NewMemoryWebAuthnRepository() *MemoryWebAuthnRepository {
+	return &MemoryWebAuthnRepository{
+		credentials: map[string]models.WebAuthnCredential{},
+		sessions:    map[string]models.WebAuthnSession{},
+	}
+}
+
+func (r *MemoryWebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.credentials[credential.ID]; ok {
+		return ErrDuplicate
+	}
+	r.credentials[credential.ID] = copyCredential(*credential)
+	return nil
+}
+
+func (r *MemoryWebAuthnRepository) FindCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	credential = copyCredential(credential)
+	return &credential, nil
+}
+
+func (r *MemoryWebAuthnRepository) ListCredentials(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var credentials []models.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, copyCredential(credential))
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+	})
+	return credentials, nil
+}
+
+func (r *MemoryWebAuthnRepository) UpdateCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+	credential.SignCount = signCount
+	credential.BackupState = backupState
+	credential.LastUsedAt = &at
+	r.credentials[id] = credential
+	return nil
+}
+
+func (r *MemoryWebAuthnRepository) DeleteCredential(ctx context.Context, userID primitive.ObjectID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok || credential.UserID != userID {
+		return ErrNotFound
+	}
+	delete(r.credentials, id)
+	return nil
+}
+
+func (r *MemoryWebAuthnRepository) DeleteUserCredentials(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, credential := range r.credentials {
+		if credential.UserID == userID {
+			delete(r.credentials, id)
+		}
+	}
+	for hash, session := range r.sessions {
+		if session.UserID == userID {
+			delete(r.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryWebAuthnRepository) SaveSession(ctx context.Context, session *models.WebAuthnSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for hash, existing := range r.sessions {
+		if now.After(existing.ExpiresAt) {
+			delete(r.sessions, hash)
+		}
+	}
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	stored := *session
+	stored.Data = append([]byte(nil), session.Data...)
+	r.sessions[session.TokenHash] = stored
+	return nil
+}
+
+func (r *MemoryWebAuthnRepository) ConsumeSession(ctx context.Context, hash string, now time.Time) (*models.WebAuthnSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(r.sessions, hash)
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+func copyCredential(credential models.WebAuthnCredential) models.WebAuthnCredential {
+	credential.PublicKey = append([]byte(nil), credential.PublicKey...)
+	credential.AAGUID = append([]byte(nil), credential.AAGUID...)
+	credential.Transports = append([]string(nil), credential.Transports...)
+	return credential
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoLoginFailureRepository keys records by IP. A TTL index on expires_at
+// removes them once they expire.
+type MongoLoginFailureRepository struct {
+	failures *mongo.Collection
+}
+
+func NewMongoLoginFailureRepository(db *mongo.Database) *MongoLoginFailureRepository {
+	return &MongoLoginFailureRepository{failures: db.Collection("login_failures")}
+}
+
+func (r *MongoLoginFailureRepository) Record(ctx context.Context, ip string, at, expiresAt time.Time) (*models.LoginFailures, error) {
+	// The TTL monitor runs about once a minute, so a record may still exist
+	// after it expired. The count starts over in that case.
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"count": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$expires_at", at}},
+			bson.M{"$add": bson.A{"$count", 1}},
+			1,
+		}},
+		"last_failed_at": at,
+		"expires_at":     expiresAt,
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var record models.LoginFailures
+	err := r.failures.FindOneAndUpdate(ctx, bson.M{"_id": ip}, update, opts).Decode(&record)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent upsert inserted the record first, this time it matches.
+		err = r.failures.FindOneAndUpdate(ctx, bson.M{"_id": ip}, update, opts).Decode(&record)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *MongoLoginFailureRepository) Find(ctx context.Context, ip string, now time.Time) (*models.LoginFailures, error) {
+	var record models.LoginFailures
+	err := r.failures.FindOne(ctx, bson.M{"_id": ip, "expires_at": bson.M{"$gt": now}}).Decode(&record)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoMFARepository keeps one document per user, keyed by the user ID, with
+// the recovery codes embedded.
+type MongoMFARepository struct {
+	enrollments *mongo.Collection
+}
+
+func NewMongoMFARepository(db *mongo.Database) *MongoMFARepository {
+	return &MongoMFARepository{enrollments: db.Collection("totp_enrollments")}
+}
+
+func (r *MongoMFARepository) SaveTOTP(ctx context.Context, enrollment *models.TOTPEnrollment) error {
+	_, err := r.enrollments.ReplaceOne(ctx,
+		bson.M{"_id": enrollment.UserID},
+		enrollment,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *MongoMFARepository) FindTOTP(ctx context.Context, userID primitive.ObjectID) (*models.TOTPEnrollment, error) {
+	var enrollment models.TOTPEnrollment
+	if err := r.enrollments.FindOne(ctx, bson.M{"_id": userID}).Decode(&enrollment); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+func (r *MongoMFARepository) ConfirmTOTP(ctx context.Context, userID primitive.ObjectID, step int64, at time.Time) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": userID, "confirmed": false},
+		bson.M{"$set": bson.M{"confirmed": true, "confirmed_at": at, "last_used_step": step}},
+	)
+}
+
+func (r *MongoMFARepository) UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": userID, "confirmed": true, "last_used_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"last_used_step": step}},
+	)
+}
+
+func (r *MongoMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string) error {
+	codes := make([]models.RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, models.RecoveryCode{Hash: hash})
+	}
+	return r.updateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"recovery_codes": codes}},
+	)
+}
+
+func (r *MongoMFARepository) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string, at time.Time) error {
+	return r.updateOne(ctx,
+		bson.M{
+			"_id":       userID,
+			"confirmed": true,
+			"recovery_codes": bson.M{"$elemMatch": bson.M{
+				"hash":    hash,
+				"used_at": bson.M{"$exists": false},
+			}},
+		},
+		bson.M{"$set": bson.M{"recovery_codes.$.used_at": at}},
+	)
+}
+
+func (r *MongoMFARepository) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.enrollments.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+func (r *MongoMFARepository) updateOne(ctx context.Context, filter, update bson.M) error {
+	result, err := r.enrollments.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
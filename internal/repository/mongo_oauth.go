@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoOAuthRepository relies on TTL indexes on expires_at to remove old
+// authorization requests, codes, device authorizations and client assertions.
+type MongoOAuthRepository struct {
+	clients    *mongo.Collection
+	requests   *mongo.Collection
+	codes      *mongo.Collection
+	devices    *mongo.Collection
+	assertions *mongo.Collection
+}
+
+func NewMongoOAuthRepository(db *mongo.Database) *MongoOAuthRepository {
+	return &MongoOAuthRepository{
+		clients:  db.Collection("oauth_clients"),
+		requests: db.Collection("oauth_authorization_requests"),
+		codes:    db.Collection("oauth_authorization_codes"),
+
+		devices:    db.Collection("oauth_device_authorizations"),
+		assertions: db.Collection("oauth_client_assertions"),
+	}
+}
+
+func (r *MongoOAuthRepository) SaveClient(ctx context.Context, client *models.OAuthClient) error {
+	_, err := r.clients.UpdateOne(ctx,
+		bson.M{"_id": client.ID},
+		bson.M{
+			"$set": bson.M{
+				"name":                       client.Name,
+				"secret_hash":                client.SecretHash,
+				"redirect_uris":              client.RedirectURIs,
+				"post_logout_redirect_uris":  client.PostLogoutRedirectURIs,
+				"scopes":                     client.Scopes,
+				"grant_types":                client.GrantTypes,
+				"token_endpoint_auth_method": client.TokenEndpointAuthMethod,
+				"jwks":                       client.JWKS,
+				"skip_consent":               client.SkipConsent,
+				"registration_token_hash":    client.RegistrationTokenHash,
+				"updated_at":                 client.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"created_at": client.CreatedAt},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *MongoOAuthRepository) FindClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := r.clients.FindOne(ctx, bson.M{"_id": id}).Decode(&client); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *MongoOAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	_, err := r.clients.InsertOne(ctx, client)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *MongoOAuthRepository) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	cursor, err := r.clients.Find(ctx,
+		bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	clients := []models.OAuthClient{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteClient removes the client first, so that nothing new is stored for
+// it while the rest is deleted.
+func (r *MongoOAuthRepository) DeleteClient(ctx context.Context, id string) error {
+	result, err := r.clients.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	for _, collection := range []*mongo.Collection{r.requests, r.codes, r.devices, r.assertions} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"client_id": id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MongoOAuthRepository) SaveAuthorizationRequest(ctx context.Context, request *models.AuthorizationRequest) error {
+	result, err := r.requests.InsertOne(ctx, request)
+	if err != nil {
+		return err
+	}
+	request.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoOAuthRepository) FindAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	var request models.AuthorizationRequest
+	err := r.requests.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *MongoOAuthRepository) ConsumeAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	var request models.AuthorizationRequest
+	err := r.requests.FindOneAndDelete(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *MongoOAuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	result, err := r.codes.InsertOne(ctx, code)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	code.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoOAuthRepository) FindAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	if err := r.codes.FindOne(ctx, bson.M{"code_hash": hash}).Decode(&code); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *MongoOAuthRepository) MarkAuthorizationCodeUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := r.codes.UpdateOne(ctx,
+		bson.M{"_id": id, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoOAuthRepository) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	_, err := r.assertions.InsertOne(ctx, bson.M{
+		"client_id":  clientID,
+		"jti":        jti,
+		"expires_at": expiresAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *MongoOAuthRepository) CreateDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization) error {
+	result, err := r.devices.InsertOne(ctx, authorization)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	authorization.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoOAuthRepository) FindDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*models.DeviceAuthorization, error) {
+	return r.findDeviceAuthorization(ctx, bson.M{"device_code_hash": deviceCodeHash})
+}
+
+func (r *MongoOAuthRepository) FindPendingDeviceAuthorization(ctx context.Context, userCodeHash string, now time.Time) (*models.DeviceAuthorization, error) {
+	return r.findDeviceAuthorization(ctx, bson.M{
+		"user_code_hash": userCodeHash,
+		"status":         models.DeviceAuthorizationPending,
+		"expires_at":     bson.M{"$gt": now},
+	})
+}
+
+func (r *MongoOAuthRepository) findDeviceAuthorization(ctx context.Context, filter bson.M) (*models.DeviceAuthorization, error) {
+	var authorization models.DeviceAuthorization
+	if err := r.devices.FindOne(ctx, filter).Decode(&authorization); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+func (r *MongoOAuthRepository) AnswerDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization, now time.Time) error {
+	set := bson.M{"status": authorization.Status}
+	if !authorization.UserID.IsZero() {
+		set["user_id"] = authorization.UserID
+	}
+	if !authorization.AuthTime.IsZero() {
+		set["auth_time"] = authorization.AuthTime
+	}
+	if len(authorization.AMR) > 0 {
+		set["amr"] = authorization.AMR
+	}
+
+	result, err := r.devices.UpdateOne(ctx,
+		bson.M{
+			"_id":        authorization.ID,
+			"status":     models.DeviceAuthorizationPending,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoOAuthRepository) RecordDeviceAuthorizationPoll(ctx context.Context, id primitive.ObjectID, at time.Time, interval int) error {
+	result, err := r.devices.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"last_polled_at": at, "interval": interval}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoOAuthRepository) ConsumeDeviceAuthorization(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.devices.DeleteOne(ctx, bson.M{"_id": id, "status": models.DeviceAuthorizationApproved})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func NewMongoStore(db *mongo.Database) *Store {
+	return &Store{
+		Users:              NewMongoUserRepository(db),
+		Tokens:             NewMongoTokenRepository(db),
+		VerificationTokens: NewMongoVerificationTokenRepository(db),
+		Roles:              NewMongoRoleRepository(db),
+		Audit:              NewMongoAuditRepository(db),
+		MFA:                NewMongoMFARepository(db),
+		WebAuthn:           NewMongoWebAuthnRepository(db),
+		LoginFailures:      NewMongoLoginFailureRepository(db),
+		OAuth:              NewMongoOAuthRepository(db),
+	}
+}
+
+type MongoRoleRepository struct {
+	roles *mongo.Collection
+}
+
+func NewMongoRoleRepository(db *mongo.Database) *MongoRoleRepository {
+	return &MongoRoleRepository{roles: db.Collection("roles")}
+}
+
+func (r *MongoRoleRepository) CreateIfMissing(ctx context.Context, role *models.RoleDefinition) error {
+	_, err := r.roles.UpdateOne(ctx,
+		bson.M{"_id": role.Name},
+		bson.M{"$setOnInsert": role},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *MongoRoleRepository) FindByName(ctx context.Context, name models.Role) (*models.RoleDefinition, error) {
+	var role models.RoleDefinition
+	if err := r.roles.FindOne(ctx, bson.M{"_id": name}).Decode(&role); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+type MongoAuditRepository struct {
+	events *mongo.Collection
+}
+
+func NewMongoAuditRepository(db *mongo.Database) *MongoAuditRepository {
+	return &MongoAuditRepository{events: db.Collection("audit_events")}
+}
+
+func (r *MongoAuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	result, err := r.events.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+	event.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoAuditRepository) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.AuditEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := r.events.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
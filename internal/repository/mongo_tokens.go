@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type MongoTokenRepository struct {
+	refreshTokens *mongo.Collection
+	revokedTokens *mongo.Collection
+}
+
+func NewMongoTokenRepository(db *mongo.Database) *MongoTokenRepository {
+	return &MongoTokenRepository{
+		refreshTokens: db.Collection("refresh_tokens"),
+		revokedTokens: db.Collection("revoked_tokens"),
+	}
+}
+
+func (r *MongoTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	result, err := r.refreshTokens.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+	token.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoTokenRepository) FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.refreshTokens.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *MongoTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	err := r.refreshTokens.FindOneAndUpdate(ctx, bson.M{
+		"_id":     id,
+		"used_at": bson.M{"$exists": false},
+		"revoked": false,
+	}, bson.M{"$set": bson.M{"used_at": at}}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *MongoTokenRepository) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := r.refreshTokens.UpdateMany(ctx,
+		bson.M{"family_id": familyID},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	return err
+}
+
+func (r *MongoTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.refreshTokens.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	return err
+}
+
+func (r *MongoTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := r.refreshTokens.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := r.revokedTokens.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *MongoTokenRepository) DeleteClientRefreshTokens(ctx context.Context, clientID string) error {
+	_, err := r.refreshTokens.DeleteMany(ctx, bson.M{"client_id": clientID})
+	return err
+}
+
+func (r *MongoTokenRepository) RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error {
+	_, err := r.revokedTokens.InsertOne(ctx, token)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *MongoTokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := r.revokedTokens.CountDocuments(ctx, bson.M{"jti": jti}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+type MongoVerificationTokenRepository struct {
+	tokens *mongo.Collection
+}
+
+func NewMongoVerificationTokenRepository(db *mongo.Database) *MongoVerificationTokenRepository {
+	return &MongoVerificationTokenRepository{tokens: db.Collection("verification_tokens")}
+}
+
+func (r *MongoVerificationTokenRepository) Replace(ctx context.Context, token *models.VerificationToken) error {
+	_, err := r.tokens.DeleteMany(ctx, bson.M{
+		"user_id": token.UserID,
+		"purpose": token.Purpose,
+		"used_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+
+	result, err := r.tokens.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+	token.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoVerificationTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	var token models.VerificationToken
+	err := r.tokens.FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hash,
+			"purpose":    purpose,
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *MongoVerificationTokenRepository) Find(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	var token models.VerificationToken
+	err := r.tokens.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"purpose":    purpose,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *MongoVerificationTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.tokens.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+)
+
+// userSortFields maps the public sort names to document fields. Creation time
+// is taken from the ObjectID, so sorting by it is sorting by _id.
+var userSortFields = map[string]string{
+	"created_at": "_id",
+	"email":      "email",
+	"username":   "username",
+}
+
+// mongoUser is the stored form of a user. The normalized fields carry the
+// unique indexes and are what lookups by email and username match on.
+type mongoUser struct {
+	models.User        `bson:",inline"`
+	EmailNormalized    string `bson:"email_normalized"`
+	UsernameNormalized string `bson:"username_normalized"`
+}
+
+type MongoUserRepository struct {
+	users *mongo.Collection
+}
+
+func NewMongoUserRepository(db *mongo.Database) *MongoUserRepository {
+	return &MongoUserRepository{users: db.Collection("users")}
+}
+
+func (r *MongoUserRepository) Create(ctx context.Context, user *models.User) error {
+	result, err := r.users.InsertOne(ctx, mongoUser{
+		User:               *user,
+		EmailNormalized:    utils.NormalizeIdentifier(user.Email),
+		UsernameNormalized: utils.NormalizeIdentifier(user.Username),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicate
+		}
+		return err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *MongoUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email_normalized": utils.NormalizeIdentifier(email)})
+}
+
+func (r *MongoUserRepository) FindByLogin(ctx context.Context, login string) (*models.User, error) {
+	login = utils.NormalizeIdentifier(login)
+	return r.findOne(ctx, bson.M{"$or": []bson.M{
+		{"email_normalized": login},
+		{"username_normalized": login},
+	}})
+}
+
+func (r *MongoUserRepository) EmailTaken(ctx context.Context, email string, exclude primitive.ObjectID) (bool, error) {
+	return r.taken(ctx, "email_normalized", utils.NormalizeIdentifier(email), exclude)
+}
+
+func (r *MongoUserRepository) UsernameTaken(ctx context.Context, username string, exclude primitive.ObjectID) (bool, error) {
+	return r.taken(ctx, "username_normalized", utils.NormalizeIdentifier(username), exclude)
+}
+
+func (r *MongoUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*models.User, error) {
+	set := bson.M{}
+	if update.Email != nil {
+		set["email"] = *update.Email
+		set["email_normalized"] = utils.NormalizeIdentifier(*update.Email)
+	}
+	if update.Username != nil {
+		set["username"] = *update.Username
+		set["username_normalized"] = utils.NormalizeIdentifier(*update.Username)
+	}
+	if update.Password != nil {
+		set["password"] = *update.Password
+	}
+	if update.Role != nil {
+		set["role"] = *update.Role
+	}
+	if update.IsActive != nil {
+		set["is_active"] = *update.IsActive
+	}
+	if update.EmailVerified != nil {
+		set["email_verified"] = *update.EmailVerified
+	}
+	if update.TokensValidAfter != nil {
+		set["tokens_valid_after"] = *update.TokensValidAfter
+	}
+	if update.PasswordHistory != nil {
+		set["password_history"] = *update.PasswordHistory
+	}
+	if len(set) == 0 {
+		return r.FindByID(ctx, id)
+	}
+
+	var user models.User
+	err := r.users.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicate
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.users.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoUserRepository) RecordLoginFailure(ctx context.Context, id primitive.ObjectID, at, since time.Time) (int, error) {
+	// The pipeline reads the previous failure in the same atomic update, so
+	// concurrent wrong passwords are all counted.
+	var user models.User
+	err := r.users.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"failed_logins": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$last_failed_login_at", since}},
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failed_logins", 0}}, 1}},
+				1,
+			}},
+			"last_failed_login_at": at,
+		}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return user.FailedLogins, nil
+}
+
+func (r *MongoUserRepository) Lock(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	return r.updateLoginState(ctx, id, bson.M{
+		"$set":   bson.M{"locked_until": until},
+		"$unset": bson.M{"failed_logins": "", "last_failed_login_at": ""},
+	})
+}
+
+func (r *MongoUserRepository) ResetLoginFailures(ctx context.Context, id primitive.ObjectID) error {
+	return r.updateLoginState(ctx, id, bson.M{
+		"$unset": bson.M{"failed_logins": "", "last_failed_login_at": "", "locked_until": ""},
+	})
+}
+
+func (r *MongoUserRepository) updateLoginState(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := r.users.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoUserRepository) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	field, ok := userSortFields[query.SortBy]
+	if !ok {
+		field = "_id"
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	conditions := []bson.M{}
+	if query.Role != "" {
+		conditions = append(conditions, bson.M{"role": query.Role})
+	}
+	if query.Active != nil {
+		conditions = append(conditions, bson.M{"is_active": *query.Active})
+	}
+	if !query.CreatedAfter.IsZero() {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(query.CreatedAfter)}})
+	}
+	if !query.CreatedBefore.IsZero() {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(query.CreatedBefore)}})
+	}
+	if query.Prefix != "" {
+		// An anchored, case-sensitive regex on the normalized fields can use their indexes.
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(utils.NormalizeIdentifier(query.Prefix))}
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"email_normalized": prefix},
+			{"username_normalized": prefix},
+		}})
+	}
+	if query.After != nil {
+		// Documents after the cursor in sort order, _id breaks ties between equal values.
+		op := "$gt"
+		if query.Descending {
+			op = "$lt"
+		}
+		if field == "_id" {
+			conditions = append(conditions, bson.M{"_id": bson.M{op: query.After.ID}})
+		} else {
+			conditions = append(conditions, bson.M{"$or": []bson.M{
+				{field: bson.M{op: query.After.Value}},
+				{field: query.After.Value, "_id": bson.M{op: query.After.ID}},
+			}})
+		}
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter = bson.M{"$and": conditions}
+	}
+
+	sort := bson.D{{Key: field, Value: direction}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+
+	opts := options.Find().SetSort(sort)
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := r.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *MongoUserRepository) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	if err := r.users.FindOne(ctx, filter).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) taken(ctx context.Context, field, value string, exclude primitive.ObjectID) (bool, error) {
+	filter := bson.M{field: value}
+	if !exclude.IsZero() {
+		filter["_id"] = bson.M{"$ne": exclude}
+	}
+
+	count, err := r.users.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoWebAuthnRepository keys credentials by their credential ID, which keeps
+// one credential from being registered twice.
+type MongoWebAuthnRepository struct {
+	credentials *mongo.Collection
+	sessions    *mongo.Collection
+}
+
+func NewMongoWebAuthnRepository(db *mongo.Database) *MongoWebAuthnRepository {
+	return &MongoWebAuthnRepository{
+		credentials: db.Collection("webauthn_credentials"),
+		sessions:    db.Collection("webauthn_sessions"),
+	}
+}
+
+func (r *MongoWebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	_, err := r.credentials.InsertOne(ctx, credential)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *MongoWebAuthnRepository) FindCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := r.credentials.FindOne(ctx, bson.M{"_id": id}).Decode(&credential); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *MongoWebAuthnRepository) ListCredentials(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	cursor, err := r.credentials.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *MongoWebAuthnRepository) UpdateCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, at time.Time) error {
+	result, err := r.credentials.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"sign_count": signCount, "backup_state": backupState, "last_used_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoWebAuthnRepository) DeleteCredential(ctx context.Context, userID primitive.ObjectID, id string) error {
+	result, err := r.credentials.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoWebAuthnRepository) DeleteUserCredentials(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := r.credentials.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := r.sessions.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *MongoWebAuthnRepository) SaveSession(ctx context.Context, session *models.WebAuthnSession) error {
+	result, err := r.sessions.InsertOne(ctx, session)
+	if err != nil {
+		return err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoWebAuthnRepository) ConsumeSession(ctx context.Context, hash string, now time.Time) (*models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	err := r.sessions.FindOneAndDelete(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
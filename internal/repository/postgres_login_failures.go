@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"time"
+)
+
+type PostgresLoginFailureRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresLoginFailureRepository) Record(ctx context.Context, ip string, at, expiresAt time.Time) (*models.LoginFailures, error) {
+	if _, err := r.db.Exec(ctx, "DELETE FROM login_failures WHERE expires_at <= $1 AND ip <> $2", at, ip); err != nil {
+		return nil, err
+	}
+
+	record := models.LoginFailures{IP: ip}
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO login_failures (ip, count, last_failed_at, expires_at) VALUES ($1, 1, $2, $3)
+		 ON CONFLICT (ip) DO UPDATE SET
+		     count = CASE WHEN login_failures.expires_at > $2 THEN login_failures.count + 1 ELSE 1 END,
+		     last_failed_at = $2,
+		     expires_at = $3
+		 RETURNING count, last_failed_at, expires_at`,
+		ip, at, expiresAt).Scan(&record.Count, &record.LastFailedAt, &record.ExpiresAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &record, nil
+}
+
+func (r *PostgresLoginFailureRepository) Find(ctx context.Context, ip string, now time.Time) (*models.LoginFailures, error) {
+	record := models.LoginFailures{IP: ip}
+	err := r.db.QueryRow(ctx,
+		"SELECT count, last_failed_at, expires_at FROM login_failures WHERE ip = $1 AND expires_at > $2",
+		ip, now).Scan(&record.Count, &record.LastFailedAt, &record.ExpiresAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// pgForeignKeyViolation is the SQLSTATE of a foreign key violation.
+const pgForeignKeyViolation = "23503"
+
+type PostgresMFARepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresMFARepository) SaveTOTP(ctx context.Context, enrollment *models.TOTPEnrollment) error {
+	// Deleting the previous enrollment drops its recovery codes as well.
+	if _, err := r.db.Exec(ctx, "DELETE FROM totp_enrollments WHERE user_id = $1", enrollment.UserID.Hex()); err != nil {
+		return err
+	}
+
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO totp_enrollments (user_id, secret, confirmed, last_used_step, created_at, confirmed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		enrollment.UserID.Hex(), enrollment.Secret, enrollment.Confirmed,
+		enrollment.LastUsedStep, enrollment.CreatedAt, enrollment.ConfirmedAt)
+	if err != nil {
+		return pgError(err)
+	}
+
+	hashes := make([]string, 0, len(enrollment.RecoveryCodes))
+	for _, code := range enrollment.RecoveryCodes {
+		hashes = append(hashes, code.Hash)
+	}
+	return r.ReplaceRecoveryCodes(ctx, enrollment.UserID, hashes)
+}
+
+func (r *PostgresMFARepository) FindTOTP(ctx context.Context, userID primitive.ObjectID) (*models.TOTPEnrollment, error) {
+	enrollment := models.TOTPEnrollment{UserID: userID}
+	err := r.db.QueryRow(ctx,
+		`SELECT secret, confirmed, last_used_step, created_at, confirmed_at
+		 FROM totp_enrollments WHERE user_id = $1`, userID.Hex()).
+		Scan(&enrollment.Secret, &enrollment.Confirmed, &enrollment.LastUsedStep,
+			&enrollment.CreatedAt, &enrollment.ConfirmedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	rows, err := r.db.Query(ctx, "SELECT code_hash, used_at FROM recovery_codes WHERE user_id = $1", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.Hash, &code.UsedAt); err != nil {
+			return nil, err
+		}
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, code)
+	}
+	return &enrollment, rows.Err()
+}
+
+func (r *PostgresMFARepository) ConfirmTOTP(ctx context.Context, userID primitive.ObjectID, step int64, at time.Time) error {
+	return r.updateOne(ctx,
+		"UPDATE totp_enrollments SET confirmed = TRUE, confirmed_at = $3, last_used_step = $2 WHERE user_id = $1 AND NOT confirmed",
+		userID.Hex(), step, at)
+}
+
+func (r *PostgresMFARepository) UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error {
+	return r.updateOne(ctx,
+		"UPDATE totp_enrollments SET last_used_step = $2 WHERE user_id = $1 AND confirmed AND last_used_step < $2",
+		userID.Hex(), step)
+}
+
+func (r *PostgresMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID.Hex()); err != nil {
+		return err
+	}
+
+	_, err := r.db.Exec(ctx,
+		"INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])",
+		userID.Hex(), hashes)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *PostgresMFARepository) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string, at time.Time) error {
+	return r.updateOne(ctx,
+		`UPDATE recovery_codes SET used_at = $3
+		 FROM totp_enrollments
+		 WHERE recovery_codes.user_id = $1 AND recovery_codes.code_hash = $2 AND recovery_codes.used_at IS NULL
+		   AND totp_enrollments.user_id = recovery_codes.user_id AND totp_enrollments.confirmed`,
+		userID.Hex(), hash, at)
+}
+
+func (r *PostgresMFARepository) DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM totp_enrollments WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func (r *PostgresMFARepository) updateOne(ctx context.Context, sql string, args ...any) error {
+	tag, err := r.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
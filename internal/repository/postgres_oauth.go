@@ -0,0 +1,357 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// PostgresOAuthRepository deletes expired authorization requests, codes,
+// device authorizations and client assertions whenever new ones are stored.
+type PostgresOAuthRepository struct {
+	db pgQuerier
+}
+
+const clientColumns = `id, name, secret_hash, redirect_uris, post_logout_redirect_uris, scopes, grant_types,
+     token_endpoint_auth_method, jwks, skip_consent, registration_token_hash, created_at, updated_at`
+
+func (r *PostgresOAuthRepository) SaveClient(ctx context.Context, client *models.OAuthClient) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_clients (`+clientColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		 ON CONFLICT (id) DO UPDATE SET
+		     name = EXCLUDED.name,
+		     secret_hash = EXCLUDED.secret_hash,
+		     redirect_uris = EXCLUDED.redirect_uris,
+		     post_logout_redirect_uris = EXCLUDED.post_logout_redirect_uris,
+		     scopes = EXCLUDED.scopes,
+		     grant_types = EXCLUDED.grant_types,
+		     token_endpoint_auth_method = EXCLUDED.token_endpoint_auth_method,
+		     jwks = EXCLUDED.jwks,
+		     skip_consent = EXCLUDED.skip_consent,
+		     registration_token_hash = EXCLUDED.registration_token_hash,
+		     updated_at = EXCLUDED.updated_at`,
+		clientArgs(client)...)
+	return pgError(err)
+}
+
+func (r *PostgresOAuthRepository) FindClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	return scanClient(r.db.QueryRow(ctx, `SELECT `+clientColumns+` FROM oauth_clients WHERE id = $1`, id))
+}
+
+func (r *PostgresOAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_clients (`+clientColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		clientArgs(client)...)
+	return pgError(err)
+}
+
+func (r *PostgresOAuthRepository) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+clientColumns+` FROM oauth_clients ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+// DeleteClient relies on the foreign keys to delete what belongs to the
+// client.
+func (r *PostgresOAuthRepository) DeleteClient(ctx context.Context, id string) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM oauth_clients WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func clientArgs(client *models.OAuthClient) []any {
+	return []any{
+		client.ID, client.Name, client.SecretHash,
+		append([]string{}, client.RedirectURIs...),
+		append([]string{}, client.PostLogoutRedirectURIs...),
+		append([]string{}, client.Scopes...),
+		append([]string{}, client.GrantTypes...),
+		client.TokenEndpointAuthMethod, client.JWKS, client.SkipConsent, client.RegistrationTokenHash,
+		client.CreatedAt, client.UpdatedAt,
+	}
+}
+
+func scanClient(row pgx.Row) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := row.Scan(&client.ID, &client.Name, &client.SecretHash, &client.RedirectURIs, &client.PostLogoutRedirectURIs,
+		&client.Scopes, &client.GrantTypes, &client.TokenEndpointAuthMethod, &client.JWKS, &client.SkipConsent,
+		&client.RegistrationTokenHash, &client.CreatedAt, &client.UpdatedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &client, nil
+}
+
+func (r *PostgresOAuthRepository) SaveAuthorizationRequest(ctx context.Context, request *models.AuthorizationRequest) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM oauth_authorization_requests WHERE expires_at < $1", request.CreatedAt); err != nil {
+		return err
+	}
+
+	id := newID(request.ID)
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_authorization_requests (id, token_hash, client_id, redirect_uri, scopes, state, nonce,
+		     code_challenge, code_challenge_method, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		id.Hex(), request.TokenHash, request.ClientID, request.RedirectURI,
+		append([]string{}, request.Scopes...), request.State, request.Nonce,
+		request.CodeChallenge, request.CodeChallengeMethod, request.CreatedAt, request.ExpiresAt)
+	if err != nil {
+		return pgError(err)
+	}
+	request.ID = id
+	return nil
+}
+
+func (r *PostgresOAuthRepository) FindAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	return r.scanAuthorizationRequest(ctx,
+		`SELECT id, token_hash, client_id, redirect_uri, scopes, state, nonce,
+		     code_challenge, code_challenge_method, created_at, expires_at
+		 FROM oauth_authorization_requests WHERE token_hash = $1 AND expires_at > $2`,
+		hash, now)
+}
+
+func (r *PostgresOAuthRepository) ConsumeAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error) {
+	return r.scanAuthorizationRequest(ctx,
+		`DELETE FROM oauth_authorization_requests WHERE token_hash = $1 AND expires_at > $2
+		 RETURNING id, token_hash, client_id, redirect_uri, scopes, state, nonce,
+		     code_challenge, code_challenge_method, created_at, expires_at`,
+		hash, now)
+}
+
+func (r *PostgresOAuthRepository) scanAuthorizationRequest(ctx context.Context, sql string, args ...any) (*models.AuthorizationRequest, error) {
+	var (
+		request models.AuthorizationRequest
+		id      string
+	)
+	err := r.db.QueryRow(ctx, sql, args...).
+		Scan(&id, &request.TokenHash, &request.ClientID, &request.RedirectURI, &request.Scopes, &request.State,
+			&request.Nonce, &request.CodeChallenge, &request.CodeChallengeMethod, &request.CreatedAt, &request.ExpiresAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if request.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *PostgresOAuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM oauth_authorization_codes WHERE expires_at < $1", code.CreatedAt); err != nil {
+		return err
+	}
+
+	id := newID(code.ID)
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_authorization_codes (id, code_hash, client_id, user_id, redirect_uri, scopes,
+		     code_challenge, code_challenge_method, family_id, nonce, auth_time, amr, created_at, expires_at, used_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		id.Hex(), code.CodeHash, code.ClientID, code.UserID.Hex(), code.RedirectURI,
+		append([]string{}, code.Scopes...), code.CodeChallenge, code.CodeChallengeMethod,
+		code.FamilyID, code.Nonce, nullTime(code.AuthTime), append([]string{}, code.AMR...),
+		code.CreatedAt, code.ExpiresAt, code.UsedAt)
+	if err != nil {
+		return pgError(err)
+	}
+	code.ID = id
+	return nil
+}
+
+func (r *PostgresOAuthRepository) FindAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	var (
+		code        models.AuthorizationCode
+		id, ownerID string
+		authTime    *time.Time
+	)
+	err := r.db.QueryRow(ctx,
+		`SELECT id, code_hash, client_id, user_id, redirect_uri, scopes,
+		     code_challenge, code_challenge_method, family_id, nonce, auth_time, amr, created_at, expires_at, used_at
+		 FROM oauth_authorization_codes WHERE code_hash = $1`, hash).
+		Scan(&id, &code.CodeHash, &code.ClientID, &ownerID, &code.RedirectURI, &code.Scopes,
+			&code.CodeChallenge, &code.CodeChallengeMethod, &code.FamilyID, &code.Nonce, &authTime, &code.AMR,
+			&code.CreatedAt, &code.ExpiresAt, &code.UsedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if code.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if code.UserID, err = primitive.ObjectIDFromHex(ownerID); err != nil {
+		return nil, err
+	}
+	if authTime != nil {
+		code.AuthTime = *authTime
+	}
+	return &code, nil
+}
+
+func (r *PostgresOAuthRepository) MarkAuthorizationCodeUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE oauth_authorization_codes SET used_at = $2 WHERE id = $1 AND used_at IS NULL",
+		id.Hex(), at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresOAuthRepository) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM oauth_client_assertions WHERE expires_at < $1", time.Now()); err != nil {
+		return err
+	}
+
+	tag, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_client_assertions (client_id, jti, expires_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (client_id, jti) DO NOTHING`,
+		clientID, jti, expiresAt)
+	if err != nil {
+		return pgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+const deviceAuthorizationColumns = `id, device_code_hash, user_code_hash, client_id, scopes, status,
+     user_id, auth_time, amr, interval_seconds, last_polled_at, created_at, expires_at`
+
+func (r *PostgresOAuthRepository) CreateDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM oauth_device_authorizations WHERE expires_at < $1", authorization.CreatedAt); err != nil {
+		return err
+	}
+
+	var userID *string
+	if !authorization.UserID.IsZero() {
+		hex := authorization.UserID.Hex()
+		userID = &hex
+	}
+
+	id := newID(authorization.ID)
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO oauth_device_authorizations (`+deviceAuthorizationColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		id.Hex(), authorization.DeviceCodeHash, authorization.UserCodeHash, authorization.ClientID,
+		append([]string{}, authorization.Scopes...), authorization.Status, userID,
+		nullTime(authorization.AuthTime), append([]string{}, authorization.AMR...), authorization.Interval,
+		authorization.LastPolledAt, authorization.CreatedAt, authorization.ExpiresAt)
+	if err != nil {
+		return pgError(err)
+	}
+	authorization.ID = id
+	return nil
+}
+
+func (r *PostgresOAuthRepository) FindDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*models.DeviceAuthorization, error) {
+	return r.scanDeviceAuthorization(ctx,
+		`SELECT `+deviceAuthorizationColumns+` FROM oauth_device_authorizations WHERE device_code_hash = $1`,
+		deviceCodeHash)
+}
+
+func (r *PostgresOAuthRepository) FindPendingDeviceAuthorization(ctx context.Context, userCodeHash string, now time.Time) (*models.DeviceAuthorization, error) {
+	return r.scanDeviceAuthorization(ctx,
+		`SELECT `+deviceAuthorizationColumns+` FROM oauth_device_authorizations
+		 WHERE user_code_hash = $1 AND status = $2 AND expires_at > $3`,
+		userCodeHash, models.DeviceAuthorizationPending, now)
+}
+
+func (r *PostgresOAuthRepository) scanDeviceAuthorization(ctx context.Context, sql string, args ...any) (*models.DeviceAuthorization, error) {
+	var (
+		authorization models.DeviceAuthorization
+		id            string
+		userID        *string
+		authTime      *time.Time
+	)
+	err := r.db.QueryRow(ctx, sql, args...).
+		Scan(&id, &authorization.DeviceCodeHash, &authorization.UserCodeHash, &authorization.ClientID,
+			&authorization.Scopes, &authorization.Status, &userID, &authTime, &authorization.AMR,
+			&authorization.Interval, &authorization.LastPolledAt, &authorization.CreatedAt, &authorization.ExpiresAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if authorization.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if userID != nil {
+		if authorization.UserID, err = primitive.ObjectIDFromHex(*userID); err != nil {
+			return nil, err
+		}
+	}
+	if authTime != nil {
+		authorization.AuthTime = *authTime
+	}
+	return &authorization, nil
+}
+
+func (r *PostgresOAuthRepository) AnswerDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization, now time.Time) error {
+	var userID *string
+	if !authorization.UserID.IsZero() {
+		hex := authorization.UserID.Hex()
+		userID = &hex
+	}
+
+	tag, err := r.db.Exec(ctx,
+		`UPDATE oauth_device_authorizations SET status = $2, user_id = $3, auth_time = $4, amr = $5
+		 WHERE id = $1 AND status = $6 AND expires_at > $7`,
+		authorization.ID.Hex(), authorization.Status, userID, nullTime(authorization.AuthTime),
+		append([]string{}, authorization.AMR...), models.DeviceAuthorizationPending, now)
+	if err != nil {
+		return pgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresOAuthRepository) RecordDeviceAuthorizationPoll(ctx context.Context, id primitive.ObjectID, at time.Time, interval int) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE oauth_device_authorizations SET last_polled_at = $2, interval_seconds = $3 WHERE id = $1",
+		id.Hex(), at, interval)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresOAuthRepository) ConsumeDeviceAuthorization(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := r.db.Exec(ctx,
+		"DELETE FROM oauth_device_authorizations WHERE id = $1 AND status = $2",
+		id.Hex(), models.DeviceAuthorizationApproved)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
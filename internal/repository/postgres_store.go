@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// pgUniqueViolation is the SQLSTATE of a unique constraint violation.
+const pgUniqueViolation = "23505"
+
+// pgQuerier is implemented by both the pool and a transaction, so the same
+// repositories work inside and outside of InTransaction.
+type pgQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// NewPostgresStore returns repositories backed by PostgreSQL. The schema is
+// created by database.MigratePostgres.
+func NewPostgresStore(pool *pgxpool.Pool) *Store {
+	store := newPostgresStore(pool)
+	store.transact = func(ctx context.Context, fn func(*Store) error) error {
+		return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+			return fn(newPostgresStore(tx))
+		})
+	}
+	return store
+}
+
+func newPostgresStore(db pgQuerier) *Store {
+	return &Store{
+		Users:              &PostgresUserRepository{db: db},
+		Tokens:             &PostgresTokenRepository{db: db},
+		VerificationTokens: &PostgresVerificationTokenRepository{db: db},
+		Roles:              &PostgresRoleRepository{db: db},
+		Audit:              &PostgresAuditRepository{db: db},
+		MFA:                &PostgresMFARepository{db: db},
+		WebAuthn:           &PostgresWebAuthnRepository{db: db},
+		LoginFailures:      &PostgresLoginFailureRepository{db: db},
+		OAuth:              &PostgresOAuthRepository{db: db},
+	}
+}
+
+type PostgresRoleRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresRoleRepository) CreateIfMissing(ctx context.Context, role *models.RoleDefinition) error {
+	permissions := role.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3)
+		 ON CONFLICT (name) DO NOTHING`,
+		string(role.Name), role.Description, permissions)
+	return err
+}
+
+func (r *PostgresRoleRepository) FindByName(ctx context.Context, name models.Role) (*models.RoleDefinition, error) {
+	role := models.RoleDefinition{Name: name}
+	err := r.db.QueryRow(ctx, "SELECT description, permissions FROM roles WHERE name = $1", string(name)).
+		Scan(&role.Description, &role.Permissions)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &role, nil
+}
+
+type PostgresAuditRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresAuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	var details []byte
+	if event.Details != nil {
+		encoded, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		details = encoded
+	}
+
+	id := newID(event.ID)
+	_, err := r.db.Exec(ctx,
+		"INSERT INTO audit_events (id, user_id, action, details, created_at) VALUES ($1, $2, $3, $4, $5)",
+		id.Hex(), event.UserID.Hex(), event.Action, details, event.CreatedAt)
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	return nil
+}
+
+func (r *PostgresAuditRepository) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.AuditEvent, error) {
+	sql := "SELECT id, user_id, action, details, created_at FROM audit_events WHERE user_id = $1 ORDER BY created_at DESC, id DESC"
+	args := []any{userID.Hex()}
+	if limit > 0 {
+		sql += " LIMIT $2"
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var (
+			event       models.AuditEvent
+			id, ownerID string
+			details     []byte
+		)
+		if err := rows.Scan(&id, &ownerID, &event.Action, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if event.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if event.UserID, err = primitive.ObjectIDFromHex(ownerID); err != nil {
+			return nil, err
+		}
+		if details != nil {
+			if err := json.Unmarshal(details, &event.Details); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// pgError maps driver errors onto the repository errors.
+func pgError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// newID keeps an ID set by the caller and generates one otherwise, like the
+// MongoDB driver does on insert.
+func newID(id primitive.ObjectID) primitive.ObjectID {
+	if id.IsZero() {
+		return primitive.NewObjectID()
+	}
+	return id
+}
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// PostgresTokenRepository has no TTL index to rely on, so expired rows are
+// deleted whenever new ones of the same kind are written.
+type PostgresTokenRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < $2",
+		token.UserID.Hex(), token.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id := newID(token.ID)
+	_, err = r.db.Exec(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, client_id, scopes, auth_time, amr,
+		     created_at, expires_at, used_at, revoked)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		id.Hex(), token.UserID.Hex(), token.FamilyID, token.TokenHash, token.ClientID,
+		append([]string{}, token.Scopes...), nullTime(token.AuthTime), append([]string{}, token.AMR...),
+		token.CreatedAt, token.ExpiresAt, token.UsedAt, token.Revoked)
+	if err != nil {
+		return pgError(err)
+	}
+	token.ID = id
+	return nil
+}
+
+func (r *PostgresTokenRepository) FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var (
+		token       models.RefreshToken
+		id, ownerID string
+		authTime    *time.Time
+	)
+	err := r.db.QueryRow(ctx,
+		`SELECT id, user_id, family_id, token_hash, client_id, scopes, auth_time, amr,
+		     created_at, expires_at, used_at, revoked
+		 FROM refresh_tokens WHERE token_hash = $1`, hash).
+		Scan(&id, &ownerID, &token.FamilyID, &token.TokenHash, &token.ClientID, &token.Scopes, &authTime, &token.AMR,
+			&token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &token.Revoked)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if token.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = primitive.ObjectIDFromHex(ownerID); err != nil {
+		return nil, err
+	}
+	if authTime != nil {
+		token.AuthTime = *authTime
+	}
+	return &token, nil
+}
+
+func (r *PostgresTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL AND NOT revoked",
+		id.Hex(), at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresTokenRepository) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.Exec(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1", familyID)
+	return err
+}
+
+func (r *PostgresTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.db.Exec(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = $1 AND NOT revoked", userID.Hex())
+	return err
+}
+
+func (r *PostgresTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE user_id = $1", userID.Hex()); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func (r *PostgresTokenRepository) DeleteClientRefreshTokens(ctx context.Context, clientID string) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE client_id = $1", clientID)
+	return err
+}
+
+func (r *PostgresTokenRepository) RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < $1", token.RevokedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx,
+		`INSERT INTO revoked_tokens (jti, user_id, revoked_at, expires_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (jti) DO NOTHING`,
+		token.JTI, token.UserID.Hex(), token.RevokedAt, token.ExpiresAt)
+	return err
+}
+
+func (r *PostgresTokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+type PostgresVerificationTokenRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresVerificationTokenRepository) Replace(ctx context.Context, token *models.VerificationToken) error {
+	_, err := r.db.Exec(ctx,
+		`DELETE FROM verification_tokens
+		 WHERE user_id = $1 AND ((purpose = $2 AND used_at IS NULL) OR expires_at < $3)`,
+		token.UserID.Hex(), string(token.Purpose), token.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id := newID(token.ID)
+	_, err = r.db.Exec(ctx,
+		`INSERT INTO verification_tokens (id, user_id, purpose, token_hash, email, created_at, expires_at, used_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id.Hex(), token.UserID.Hex(), string(token.Purpose), token.TokenHash,
+		token.Email, token.CreatedAt, token.ExpiresAt, token.UsedAt)
+	if err != nil {
+		return pgError(err)
+	}
+	token.ID = id
+	return nil
+}
+
+func (r *PostgresVerificationTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	row := r.db.QueryRow(ctx,
+		`UPDATE verification_tokens SET used_at = $3
+		 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		 RETURNING id, user_id, purpose, token_hash, email, created_at, expires_at, used_at`,
+		hash, string(purpose), now)
+	return scanVerificationToken(row)
+}
+
+func (r *PostgresVerificationTokenRepository) Find(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error) {
+	row := r.db.QueryRow(ctx,
+		`SELECT id, user_id, purpose, token_hash, email, created_at, expires_at, used_at
+		 FROM verification_tokens
+		 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3`,
+		hash, string(purpose), now)
+	return scanVerificationToken(row)
+}
+
+func (r *PostgresVerificationTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM verification_tokens WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func scanVerificationToken(row pgx.Row) (*models.VerificationToken, error) {
+	var (
+		token                models.VerificationToken
+		id, ownerID, purpose string
+	)
+	err := row.Scan(&id, &ownerID, &purpose, &token.TokenHash,
+		&token.Email, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if token.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = primitive.ObjectIDFromHex(ownerID); err != nil {
+		return nil, err
+	}
+	token.Purpose = models.TokenPurpose(purpose)
+	return &token, nil
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const userColumns = `id, email, username, password, role, is_active, email_verified, tokens_valid_after,
+	failed_logins, last_failed_login_at, locked_until, password_history`
+
+// postgresUserSortColumns maps the public sort names to columns. IDs are
+// ObjectIDs, so sorting by creation time is sorting by id.
+var postgresUserSortColumns = map[string]string{
+	"created_at": "id",
+	"email":      "email",
+	"username":   "username",
+}
+
+// PostgresUserRepository compares email and username case-insensitively,
+// matching the unique indexes on lower(email) and lower(username).
+type PostgresUserRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User) error {
+	id := newID(user.ID)
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO users (id, email, username, password, role, is_active, email_verified, tokens_valid_after, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		id.Hex(), user.Email, user.Username, user.Password, string(user.Role),
+		user.IsActive, user.EmailVerified, nullTime(user.TokensValidAfter), id.Timestamp())
+	if err != nil {
+		return pgError(err)
+	}
+	user.ID = id
+	return nil
+}
+
+func (r *PostgresUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.findOne(ctx, "id = $1", id.Hex())
+}
+
+func (r *PostgresUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, "lower(email) = lower($1)", email)
+}
+
+func (r *PostgresUserRepository) FindByLogin(ctx context.Context, login string) (*models.User, error) {
+	// An email match wins should the login also be someone else's username.
+	return r.findOne(ctx, "lower(email) = lower($1) OR lower(username) = lower($1) ORDER BY lower(email) = lower($1) DESC LIMIT 1", login)
+}
+
+func (r *PostgresUserRepository) EmailTaken(ctx context.Context, email string, exclude primitive.ObjectID) (bool, error) {
+	return r.taken(ctx, "email", email, exclude)
+}
+
+func (r *PostgresUserRepository) UsernameTaken(ctx context.Context, username string, exclude primitive.ObjectID) (bool, error) {
+	return r.taken(ctx, "username", username, exclude)
+}
+
+func (r *PostgresUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*models.User, error) {
+	set := []string{}
+	args := []any{id.Hex()}
+	add := func(column string, value any) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if update.Email != nil {
+		add("email", *update.Email)
+	}
+	if update.Username != nil {
+		add("username", *update.Username)
+	}
+	if update.Password != nil {
+		add("password", *update.Password)
+	}
+	if update.Role != nil {
+		add("role", string(*update.Role))
+	}
+	if update.IsActive != nil {
+		add("is_active", *update.IsActive)
+	}
+	if update.EmailVerified != nil {
+		add("email_verified", *update.EmailVerified)
+	}
+	if update.TokensValidAfter != nil {
+		add("tokens_valid_after", nullTime(*update.TokensValidAfter))
+	}
+	if update.PasswordHistory != nil {
+		add("password_history", append([]string{}, *update.PasswordHistory...))
+	}
+	if len(set) == 0 {
+		return r.FindByID(ctx, id)
+	}
+
+	row := r.db.QueryRow(ctx,
+		"UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = $1 RETURNING "+userColumns,
+		args...)
+	return scanUser(row)
+}
+
+func (r *PostgresUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM users WHERE id = $1", id.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) RecordLoginFailure(ctx context.Context, id primitive.ObjectID, at, since time.Time) (int, error) {
+	var failures int
+	err := r.db.QueryRow(ctx,
+		`UPDATE users SET
+		     failed_logins = CASE WHEN last_failed_login_at > $3 THEN failed_logins + 1 ELSE 1 END,
+		     last_failed_login_at = $2
+		 WHERE id = $1 RETURNING failed_logins`,
+		id.Hex(), at, since).Scan(&failures)
+	if err != nil {
+		return 0, pgError(err)
+	}
+	return failures, nil
+}
+
+func (r *PostgresUserRepository) Lock(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	return r.setLoginState(ctx, id, &until)
+}
+
+func (r *PostgresUserRepository) ResetLoginFailures(ctx context.Context, id primitive.ObjectID) error {
+	return r.setLoginState(ctx, id, nil)
+}
+
+func (r *PostgresUserRepository) setLoginState(ctx context.Context, id primitive.ObjectID, lockedUntil *time.Time) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE users SET failed_logins = 0, last_failed_login_at = NULL, locked_until = $2 WHERE id = $1",
+		id.Hex(), lockedUntil)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	column, ok := postgresUserSortColumns[query.SortBy]
+	if !ok {
+		column = "id"
+	}
+
+	direction := "ASC"
+	if query.Descending {
+		direction = "DESC"
+	}
+
+	conditions := []string{}
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.Role != "" {
+		conditions = append(conditions, "role = "+arg(query.Role))
+	}
+	if query.Active != nil {
+		conditions = append(conditions, "is_active = "+arg(*query.Active))
+	}
+	if !query.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(query.CreatedAfter))
+	}
+	if !query.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(query.CreatedBefore))
+	}
+	if query.Prefix != "" {
+		prefix := arg(escapeLike(strings.ToLower(query.Prefix)) + "%")
+		conditions = append(conditions, fmt.Sprintf("(lower(email) LIKE %s OR lower(username) LIKE %s)", prefix, prefix))
+	}
+	if query.After != nil {
+		// Rows after the cursor in sort order, id breaks ties between equal values.
+		op := ">"
+		if query.Descending {
+			op = "<"
+		}
+		if column == "id" {
+			conditions = append(conditions, fmt.Sprintf("id %s %s", op, arg(query.After.ID.Hex())))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, arg(query.After.Value), arg(query.After.ID.Hex())))
+		}
+	}
+
+	sql := "SELECT " + userColumns + " FROM users"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sql += fmt.Sprintf(" ORDER BY %s %s", column, direction)
+	if column != "id" {
+		sql += ", id " + direction
+	}
+	if query.Limit > 0 {
+		sql += " LIMIT " + arg(query.Limit)
+	}
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *PostgresUserRepository) findOne(ctx context.Context, where string, args ...any) (*models.User, error) {
+	return scanUser(r.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+where, args...))
+}
+
+func (r *PostgresUserRepository) taken(ctx context.Context, column, value string, exclude primitive.ObjectID) (bool, error) {
+	var taken bool
+	err := r.db.QueryRow(ctx,
+		fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM users WHERE lower(%s) = lower($1) AND id <> $2)", column),
+		value, exclude.Hex()).Scan(&taken)
+	return taken, err
+}
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var (
+		user                                        models.User
+		id, role                                    string
+		tokensValidAfter, lastFailedAt, lockedUntil *time.Time
+	)
+	err := row.Scan(&id, &user.Email, &user.Username, &user.Password, &role,
+		&user.IsActive, &user.EmailVerified, &tokensValidAfter,
+		&user.FailedLogins, &lastFailedAt, &lockedUntil, &user.PasswordHistory)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	user.Role = models.Role(role)
+	if tokensValidAfter != nil {
+		user.TokensValidAfter = *tokensValidAfter
+	}
+	if lastFailedAt != nil {
+		user.LastFailedLoginAt = *lastFailedAt
+	}
+	if lockedUntil != nil {
+		user.LockedUntil = *lockedUntil
+	}
+	return &user, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, using the default backslash escape.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
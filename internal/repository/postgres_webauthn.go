@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const credentialColumns = `id, user_id, name, public_key, attestation_type, aaguid, sign_count,
+	transports, backup_eligible, backup_state, created_at, last_used_at`
+
+type PostgresWebAuthnRepository struct {
+	db pgQuerier
+}
+
+func (r *PostgresWebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	transports := credential.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO webauthn_credentials (`+credentialColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		credential.ID, credential.UserID.Hex(), credential.Name, credential.PublicKey,
+		credential.AttestationType, credential.AAGUID, int64(credential.SignCount), transports,
+		credential.BackupEligible, credential.BackupState, credential.CreatedAt, credential.LastUsedAt)
+	return pgError(err)
+}
+
+func (r *PostgresWebAuthnRepository) FindCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	row := r.db.QueryRow(ctx, "SELECT "+credentialColumns+" FROM webauthn_credentials WHERE id = $1", id)
+	return scanCredential(row)
+}
+
+func (r *PostgresWebAuthnRepository) ListCredentials(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	rows, err := r.db.Query(ctx,
+		"SELECT "+credentialColumns+" FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at",
+		userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (r *PostgresWebAuthnRepository) UpdateCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, at time.Time) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = $4 WHERE id = $1",
+		id, int64(signCount), backupState, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresWebAuthnRepository) DeleteCredential(ctx context.Context, userID primitive.ObjectID, id string) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresWebAuthnRepository) DeleteUserCredentials(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM webauthn_credentials WHERE user_id = $1", userID.Hex()); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, "DELETE FROM webauthn_sessions WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func (r *PostgresWebAuthnRepository) SaveSession(ctx context.Context, session *models.WebAuthnSession) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM webauthn_sessions WHERE expires_at < $1", session.CreatedAt); err != nil {
+		return err
+	}
+
+	var userID *string
+	if !session.UserID.IsZero() {
+		hex := session.UserID.Hex()
+		userID = &hex
+	}
+
+	id := newID(session.ID)
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO webauthn_sessions (id, token_hash, user_id, ceremony, data, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id.Hex(), session.TokenHash, userID, string(session.Ceremony),
+		session.Data, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return pgError(err)
+	}
+	session.ID = id
+	return nil
+}
+
+func (r *PostgresWebAuthnRepository) ConsumeSession(ctx context.Context, hash string, now time.Time) (*models.WebAuthnSession, error) {
+	var (
+		session      models.WebAuthnSession
+		id, ceremony string
+		userID       *string
+	)
+	err := r.db.QueryRow(ctx,
+		`DELETE FROM webauthn_sessions WHERE token_hash = $1 AND expires_at > $2
+		 RETURNING id, token_hash, user_id, ceremony, data, created_at, expires_at`,
+		hash, now).
+		Scan(&id, &session.TokenHash, &userID, &ceremony, &session.Data, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if session.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if userID != nil {
+		if session.UserID, err = primitive.ObjectIDFromHex(*userID); err != nil {
+			return nil, err
+		}
+	}
+	session.Ceremony = models.WebAuthnCeremony(ceremony)
+	return &session, nil
+}
+
+func scanCredential(row pgx.Row) (*models.WebAuthnCredential, error) {
+	var (
+		credential models.WebAuthnCredential
+		ownerID    string
+		signCount  int64
+	)
+	err := row.Scan(&credential.ID, &ownerID, &credential.Name, &credential.PublicKey,
+		&credential.AttestationType, &credential.AAGUID, &signCount, &credential.Transports,
+		&credential.BackupEligible, &credential.BackupState, &credential.CreatedAt, &credential.LastUsedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if credential.UserID, err = primitive.ObjectIDFromHex(ownerID); err != nil {
+		return nil, err
+	}
+	credential.SignCount = uint32(signCount)
+	return &credential, nil
+}
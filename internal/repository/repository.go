@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// Store bundles the repositories of one storage backend.
+type Store struct {
+	Users              UserRepository
+	Tokens             TokenRepository
+	VerificationTokens VerificationTokenRepository
+	Roles              RoleRepository
+	Audit              AuditRepository
+	MFA                MFARepository
+	WebAuthn           WebAuthnRepository
+	LoginFailures      LoginFailureRepository
+	OAuth              OAuthRepository
+
+	// transact runs fn with repositories bound to one transaction. It is nil
+	// for backends without transactions.
+	transact func(ctx context.Context, fn func(*Store) error) error
+}
+
+// InTransaction runs fn with repositories whose writes are committed together,
+// or not at all if fn returns an error. Backends without transactions pass the
+// store itself, so fn must not depend on isolation for correctness; unique
+// constraints still reject conflicting writes.
+func (s *Store) InTransaction(ctx context.Context, fn func(tx *Store) error) error {
+	if s.transact == nil {
+		return fn(s)
+	}
+	return s.transact(ctx, fn)
+}
+
+type UserRepository interface {
+	// Create stores a new user and sets its ID.
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// FindByLogin looks the user up by email or username.
+	FindByLogin(ctx context.Context, login string) (*models.User, error)
+	// EmailTaken and UsernameTaken report whether a user other than exclude
+	// already has the value. Pass primitive.NilObjectID to check all users.
+	EmailTaken(ctx context.Context, email string, exclude primitive.ObjectID) (bool, error)
+	UsernameTaken(ctx context.Context, username string, exclude primitive.ObjectID) (bool, error)
+	// Update applies the non-nil fields of update and returns the updated user.
+	Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*models.User, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	List(ctx context.Context, query UserQuery) ([]models.User, error)
+	// RecordLoginFailure counts a wrong password at at and returns the number
+	// of failures so far. The count starts over when the previous failure is
+	// older than since.
+	RecordLoginFailure(ctx context.Context, id primitive.ObjectID, at, since time.Time) (int, error)
+	// Lock sets LockedUntil and resets the failure count.
+	Lock(ctx context.Context, id primitive.ObjectID, until time.Time) error
+	// ResetLoginFailures clears the failure count and any lock.
+	ResetLoginFailures(ctx context.Context, id primitive.ObjectID) error
+}
+
+type UserUpdate struct {
+	Email            *string
+	Username         *string
+	Password         *string
+	Role             *models.Role
+	IsActive         *bool
+	EmailVerified    *bool
+	TokensValidAfter *time.Time
+	PasswordHistory  *[]string
+}
+
+// UserQuery selects a page of users. SortBy is created_at, email or username,
+// ties are broken by ID. After continues a previous page.
+type UserQuery struct {
+	Role          string
+	Active        *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Prefix        string
+	SortBy        string
+	Descending    bool
+	After         *UserCursor
+	Limit         int
+}
+
+// UserCursor is the position of the last user of a page. Value holds the sort
+// field of that user and is empty when sorting by created_at.
+type UserCursor struct {
+	Value string             `json:"v,omitempty"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+// TokenRepository stores refresh tokens and the denylist of revoked access
+// tokens.
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// MarkRefreshTokenUsed returns ErrNotFound when the token has already been
+	// used or revoked, so only one of two concurrent refreshes succeeds.
+	MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error
+	// DeleteClientRefreshTokens deletes the refresh tokens issued to an OAuth
+	// client, so they are not accepted if a client with the same ID returns.
+	DeleteClientRefreshTokens(ctx context.Context, clientID string) error
+	// RevokeAccessToken is idempotent.
+	RevokeAccessToken(ctx context.Context, token *models.RevokedToken) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// LoginFailureRepository counts failed logins per client IP. Records expire
+// once no failure has happened for the failure window.
+type LoginFailureRepository interface {
+	// Record counts a failure at at and returns the updated record. An expired
+	// record starts over, the new one expires at expiresAt.
+	Record(ctx context.Context, ip string, at, expiresAt time.Time) (*models.LoginFailures, error)
+	// Find returns ErrNotFound when ip has no unexpired record.
+	Find(ctx context.Context, ip string, now time.Time) (*models.LoginFailures, error)
+}
+
+type VerificationTokenRepository interface {
+	// Replace drops the unused tokens of the same user and purpose before
+	// storing token, so only the most recent one works.
+	Replace(ctx context.Context, token *models.VerificationToken) error
+	// Consume marks an unused, unexpired token as used and returns it. It
+	// returns ErrNotFound for anything else.
+	Consume(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error)
+	// Find returns a token that Consume would accept, without using it up.
+	Find(ctx context.Context, hash string, purpose models.TokenPurpose, now time.Time) (*models.VerificationToken, error)
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type RoleRepository interface {
+	// CreateIfMissing stores role unless a role with that name already exists.
+	CreateIfMissing(ctx context.Context, role *models.RoleDefinition) error
+	FindByName(ctx context.Context, name models.Role) (*models.RoleDefinition, error)
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+	// ListByUser returns the newest events of the user first.
+	ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.AuditEvent, error)
+}
+
+// MFARepository stores the TOTP enrollment of each user.
+type MFARepository interface {
+	// SaveTOTP stores a new unconfirmed enrollment, replacing any previous one
+	// of the user together with its recovery codes.
+	SaveTOTP(ctx context.Context, enrollment *models.TOTPEnrollment) error
+	FindTOTP(ctx context.Context, userID primitive.ObjectID) (*models.TOTPEnrollment, error)
+	// ConfirmTOTP marks an unconfirmed enrollment as confirmed and records step
+	// as used. It returns ErrNotFound if there is no such enrollment.
+	ConfirmTOTP(ctx context.Context, userID primitive.ObjectID, step int64, at time.Time) error
+	// UseTOTPStep records step as used. It returns ErrNotFound unless step is
+	// later than the last used one, so every code works only once.
+	UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string) error
+	// UseRecoveryCode marks an unused code as used and returns ErrNotFound for
+	// anything else.
+	UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string, at time.Time) error
+	DeleteTOTP(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// WebAuthnRepository stores the passkeys of each user and the state of
+// ceremonies that are in progress.
+type WebAuthnRepository interface {
+	// CreateCredential returns ErrDuplicate if the credential ID is already
+	// registered, to this or another user.
+	CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	FindCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error)
+	ListCredentials(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error)
+	// UpdateCredentialUsage records a successful assertion with the new
+	// signature counter and backup state reported by the authenticator.
+	UpdateCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, at time.Time) error
+	// DeleteCredential returns ErrNotFound unless the credential belongs to userID.
+	DeleteCredential(ctx context.Context, userID primitive.ObjectID, id string) error
+	DeleteUserCredentials(ctx context.Context, userID primitive.ObjectID) error
+	SaveSession(ctx context.Context, session *models.WebAuthnSession) error
+	// ConsumeSession deletes an unexpired session and returns it, so every
+	// challenge is answered at most once. It returns ErrNotFound otherwise.
+	ConsumeSession(ctx context.Context, hash string, now time.Time) (*models.WebAuthnSession, error)
+}
+
+// OAuthRepository stores OAuth clients and the state of authorization code
+// flows in progress.
+type OAuthRepository interface {
+	// SaveClient creates the client or replaces the one with the same ID,
+	// keeping its CreatedAt.
+	SaveClient(ctx context.Context, client *models.OAuthClient) error
+	FindClient(ctx context.Context, id string) (*models.OAuthClient, error)
+	// CreateClient stores a new client. It returns ErrDuplicate when the ID
+	// is taken.
+	CreateClient(ctx context.Context, client *models.OAuthClient) error
+	// ListClients returns all clients, oldest first.
+	ListClients(ctx context.Context) ([]models.OAuthClient, error)
+	// DeleteClient deletes the client together with its authorization
+	// requests, codes, device authorizations and used client assertions. It
+	// returns ErrNotFound when there is no such client.
+	DeleteClient(ctx context.Context, id string) error
+	SaveAuthorizationRequest(ctx context.Context, request *models.AuthorizationRequest) error
+	// FindAuthorizationRequest returns an unexpired request without using it up.
+	FindAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error)
+	// ConsumeAuthorizationRequest deletes an unexpired request and returns it,
+	// so every request is answered at most once. It returns ErrNotFound otherwise.
+	ConsumeAuthorizationRequest(ctx context.Context, hash string, now time.Time) (*models.AuthorizationRequest, error)
+	CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	// FindAuthorizationCode returns the code whether or not it has been used,
+	// so that a second exchange can be told apart from an unknown code.
+	// Expired codes may already be gone.
+	FindAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error)
+	// MarkAuthorizationCodeUsed returns ErrNotFound when the code has already
+	// been used, so only one of two concurrent exchanges succeeds.
+	MarkAuthorizationCodeUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// UseClientAssertion records the jti of a private_key_jwt assertion until
+	// it expires. It returns ErrDuplicate when the jti was used before, so an
+	// assertion cannot be replayed.
+	UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error
+	// CreateDeviceAuthorization returns ErrDuplicate when the device or user
+	// code is taken.
+	CreateDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization) error
+	// FindDeviceAuthorization looks a device authorization up by the hash of
+	// its device code. Expired ones may already be gone.
+	FindDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*models.DeviceAuthorization, error)
+	// FindPendingDeviceAuthorization returns an unexpired device authorization
+	// that waits for the user, by the hash of its user code.
+	FindPendingDeviceAuthorization(ctx context.Context, userCodeHash string, now time.Time) (*models.DeviceAuthorization, error)
+	// AnswerDeviceAuthorization stores the status, user, auth time and AMR of
+	// authorization if it is still pending and unexpired, so that it is
+	// answered once. It returns ErrNotFound otherwise.
+	AnswerDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization, now time.Time) error
+	RecordDeviceAuthorizationPoll(ctx context.Context, id primitive.ObjectID, at time.Time, interval int) error
+	// ConsumeDeviceAuthorization deletes an approved device authorization
+	// once its tokens are issued. It returns ErrNotFound when it is already
+	// gone, so only one of two concurrent polls gets tokens.
+	ConsumeDeviceAuthorization(ctx context.Context, id primitive.ObjectID) error
+}
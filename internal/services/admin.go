@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"time"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+var (
+	ErrUnknownRole   = errors.New("unknown role")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+func (s *AuthService) ListUsers(filter models.UserFilter) (*models.UserList, error) {
+	s.logger.WithFields(logrus.Fields{
+		"role":  filter.Role,
+		"query": filter.Query,
+		"sort":  filter.Sort,
+	}).Info("Listing users")
+
+	query := repository.UserQuery{
+		Role:          filter.Role,
+		Active:        filter.Active,
+		CreatedAfter:  filter.CreatedAfter,
+		CreatedBefore: filter.CreatedBefore,
+		Prefix:        filter.Query,
+		SortBy:        filter.Sort,
+		Limit:         filter.Limit,
+	}
+
+	switch query.SortBy {
+	case "":
+		query.SortBy = "created_at"
+	case "created_at", "email", "username":
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	// Newest users come first by default, names are listed alphabetically.
+	switch filter.Order {
+	case "":
+		query.Descending = query.SortBy == "created_at"
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultUserListLimit
+	}
+	if query.Limit > maxUserListLimit {
+		query.Limit = maxUserListLimit
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeUserCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query.After = cursor
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// One extra user tells whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+	users, err := s.users.List(ctx, query)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list users")
+		return nil, err
+	}
+
+	result := &models.UserList{Users: []*models.UserProfile{}}
+	if len(users) > limit {
+		users = users[:limit]
+		result.NextCursor = encodeUserCursor(&users[limit-1], query.SortBy)
+	}
+	for i := range users {
+		result.Users = append(result.Users, users[i].Profile())
+	}
+	return result, nil
+}
+
+func (s *AuthService) GetUser(userID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.findUserByID(ctx, userID)
+}
+
+// SetUserRole assigns a role defined in the roles collection. The user's
+// sessions are revoked so that tokens carrying the old permissions stop
+// working right away.
+func (s *AuthService) SetUserRole(userID, role string) (*models.User, error) {
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"role":    role,
+	}).Info("Changing user role")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	definition, err := s.roles.FindByName(ctx, models.Role(role))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUnknownRole
+		}
+		s.logger.WithError(err).Error("Failed to look up role")
+		return nil, err
+	}
+
+	user, err := s.updateUser(ctx, userID, repository.UserUpdate{Role: &definition.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditRoleChanged, map[string]string{"role": role})
+	return user, nil
+}
+
+// SetUserActive activates or deactivates an account. Deactivation also ends
+// every session of the user.
+func (s *AuthService) SetUserActive(userID string, active bool) (*models.User, error) {
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"active":  active,
+	}).Info("Changing user activity")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.updateUser(ctx, userID, repository.UserUpdate{IsActive: &active})
+	if err != nil {
+		return nil, err
+	}
+
+	if !active {
+		if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		s.recordEvent(ctx, user.ID, models.AuditUserDeactivated, nil)
+	} else {
+		s.recordEvent(ctx, user.ID, models.AuditUserActivated, nil)
+	}
+	return user, nil
+}
+
+// ForcePasswordReset clears the password so it can no longer be used to log
+// in, ends every session and emails the user a reset link.
+func (s *AuthService) ForcePasswordReset(userID string) error {
+	s.logger.WithField("user_id", userID).Info("Forcing password reset")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// The replaced password goes to the history, so the reset cannot set it
+	// again. A password cleared before is not recorded.
+	password := ""
+	history := s.recentPasswordHashes(user)
+	user, err = s.updateUser(ctx, userID, repository.UserUpdate{Password: &password, PasswordHistory: &history})
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditPasswordResetForced, nil)
+
+	return s.sendPasswordReset(ctx, user)
+}
+
+func (s *AuthService) RevokeUserSessions(userID string) error {
+	s.logger.WithField("user_id", userID).Info("Revoking user sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditSessionsRevoked, nil)
+	return nil
+}
+
+// UnlockUser lifts a lockout before it expires and clears the failed login
+// count of the account.
+func (s *AuthService) UnlockUser(userID string) (*models.User, error) {
+	s.logger.WithField("user_id", userID).Info("Unlocking user")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.ResetLoginFailures(ctx, user.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.logger.WithError(err).Error("Failed to unlock user")
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditUserUnlocked, nil)
+
+	user.FailedLogins = 0
+	user.LastFailedLoginAt = time.Time{}
+	user.LockedUntil = time.Time{}
+	return user, nil
+}
+
+// DeleteUser removes the user together with their refresh and verification
+// tokens and second factors. Access tokens stop validating because their subject is gone.
+func (s *AuthService) DeleteUser(userID string) error {
+	s.logger.WithField("user_id", userID).Info("Deleting user")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.users.Delete(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete user")
+		return err
+	}
+
+	if err := s.tokens.DeleteUserTokens(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete user tokens")
+		return err
+	}
+	if err := s.verificationTokens.DeleteUserTokens(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete user verification tokens")
+		return err
+	}
+	if err := s.mfa.DeleteTOTP(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete user TOTP enrollment")
+		return err
+	}
+	if err := s.webAuthnCredentials.DeleteUserCredentials(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete user WebAuthn credentials")
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditUserDeleted, map[string]string{"email": user.Email})
+
+	s.logger.WithField("email", user.Email).Info("User deleted successfully")
+	return nil
+}
+
+func (s *AuthService) updateUser(ctx context.Context, userID string, update repository.UserUpdate) (*models.User, error) {
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = s.users.Update(ctx, user.ID, update)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.logger.WithError(err).Error("Failed to update user")
+		return nil, err
+	}
+	return user, nil
+}
+
+func encodeUserCursor(user *models.User, sortBy string) string {
+	cursor := repository.UserCursor{ID: user.ID}
+	switch sortBy {
+	case "email":
+		cursor.Value = user.Email
+	case "username":
+		cursor.Value = user.Username
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(encoded string) (*repository.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor repository.UserCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
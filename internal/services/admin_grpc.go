@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	pb "github/alexnoodl/raiko-auth/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+type AdminGrpcServer struct {
+	pb.UnimplementedAdminServiceServer
+	AuthService *AuthService
+	logger      *logrus.Logger
+}
+
+func NewAdminGrpcServer(authService *AuthService, logger *logrus.Logger) *AdminGrpcServer {
+	return &AdminGrpcServer{
+		AuthService: authService,
+		logger:      logger,
+	}
+}
+
+func (s *AdminGrpcServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	s.logger.Info("gRPC ListUsers request received")
+
+	filter := models.UserFilter{
+		Role:   req.Role,
+		Active: req.Active,
+		Query:  req.Query,
+		Sort:   req.Sort,
+		Order:  req.Order,
+		Cursor: req.Cursor,
+		Limit:  int(req.Limit),
+	}
+	if req.CreatedAfter != 0 {
+		filter.CreatedAfter = time.Unix(req.CreatedAfter, 0)
+	}
+	if req.CreatedBefore != 0 {
+		filter.CreatedBefore = time.Unix(req.CreatedBefore, 0)
+	}
+
+	list, err := s.AuthService.ListUsers(filter)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ListUsers failed")
+		return &pb.ListUsersResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	resp := &pb.ListUsersResponse{NextCursor: list.NextCursor}
+	for _, profile := range list.Users {
+		resp.Users = append(resp.Users, userProfileToProto(profile))
+	}
+	return resp, nil
+}
+
+func (s *AdminGrpcServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC GetUser request received")
+
+	user, err := s.AuthService.GetUser(req.UserId)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC GetUser failed")
+		return &pb.UserResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.UserResponse{User: profileToProto(user)}, nil
+}
+
+func (s *AdminGrpcServer) SetUserRole(ctx context.Context, req *pb.SetUserRoleRequest) (*pb.UserResponse, error) {
+	s.logger.WithFields(logrus.Fields{
+		"user_id": req.UserId,
+		"role":    req.Role,
+	}).Info("gRPC SetUserRole request received")
+
+	user, err := s.AuthService.SetUserRole(req.UserId, req.Role)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC SetUserRole failed")
+		return &pb.UserResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.UserResponse{User: profileToProto(user)}, nil
+}
+
+func (s *AdminGrpcServer) SetUserActive(ctx context.Context, req *pb.SetUserActiveRequest) (*pb.UserResponse, error) {
+	s.logger.WithFields(logrus.Fields{
+		"user_id": req.UserId,
+		"active":  req.Active,
+	}).Info("gRPC SetUserActive request received")
+
+	user, err := s.AuthService.SetUserActive(req.UserId, req.Active)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC SetUserActive failed")
+		return &pb.UserResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.UserResponse{User: profileToProto(user)}, nil
+}
+
+func (s *AdminGrpcServer) ForcePasswordReset(ctx context.Context, req *pb.ForcePasswordResetRequest) (*pb.AdminActionResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC ForcePasswordReset request received")
+
+	if err := s.AuthService.ForcePasswordReset(req.UserId); err != nil {
+		s.logger.WithError(err).Error("gRPC ForcePasswordReset failed")
+		return &pb.AdminActionResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.AdminActionResponse{Message: "Password reset email sent"}, nil
+}
+
+func (s *AdminGrpcServer) RevokeUserSessions(ctx context.Context, req *pb.RevokeUserSessionsRequest) (*pb.AdminActionResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC RevokeUserSessions request received")
+
+	if err := s.AuthService.RevokeUserSessions(req.UserId); err != nil {
+		s.logger.WithError(err).Error("gRPC RevokeUserSessions failed")
+		return &pb.AdminActionResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.AdminActionResponse{Message: "All sessions revoked"}, nil
+}
+
+func (s *AdminGrpcServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.AdminActionResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC DeleteUser request received")
+
+	if err := s.AuthService.DeleteUser(req.UserId); err != nil {
+		s.logger.WithError(err).Error("gRPC DeleteUser failed")
+		return &pb.AdminActionResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.AdminActionResponse{Message: "User deleted"}, nil
+}
+
+func (s *AdminGrpcServer) UnlockUser(ctx context.Context, req *pb.UnlockUserRequest) (*pb.UserResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC UnlockUser request received")
+
+	user, err := s.AuthService.UnlockUser(req.UserId)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC UnlockUser failed")
+		return &pb.UserResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.UserResponse{User: profileToProto(user)}, nil
+}
+
+func (s *AdminGrpcServer) ListAuditEvents(ctx context.Context, req *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	s.logger.WithField("user_id", req.UserId).Info("gRPC ListAuditEvents request received")
+
+	events, err := s.AuthService.ListAuditEvents(req.UserId, int(req.Limit))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ListAuditEvents failed")
+		return &pb.ListAuditEventsResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	resp := &pb.ListAuditEventsResponse{}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &pb.AuditEvent{
+			Id:        event.ID.Hex(),
+			UserId:    event.UserID.Hex(),
+			Action:    event.Action,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+func (s *AdminGrpcServer) ListClients(ctx context.Context, req *pb.ListClientsRequest) (*pb.ListClientsResponse, error) {
+	s.logger.Info("gRPC ListClients request received")
+
+	clients, err := s.AuthService.ListClients()
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ListClients failed")
+		return &pb.ListClientsResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	resp := &pb.ListClientsResponse{}
+	for i := range clients {
+		resp.Clients = append(resp.Clients, clientToProto(&clients[i]))
+	}
+	return resp, nil
+}
+
+func (s *AdminGrpcServer) GetClient(ctx context.Context, req *pb.GetClientRequest) (*pb.ClientResponse, error) {
+	s.logger.WithField("client_id", req.ClientId).Info("gRPC GetClient request received")
+
+	client, err := s.AuthService.GetClient(req.ClientId)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC GetClient failed")
+		return &pb.ClientResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.ClientResponse{Client: clientToProto(client)}, nil
+}
+
+func (s *AdminGrpcServer) CreateClient(ctx context.Context, req *pb.CreateClientRequest) (*pb.ClientResponse, error) {
+	s.logger.WithField("client_id", req.GetMetadata().GetClientId()).Info("gRPC CreateClient request received")
+
+	client, err := s.AuthService.CreateClient(clientMetadataFromProto(req.Metadata))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC CreateClient failed")
+		return &pb.ClientResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.ClientResponse{Client: clientToProto(client), ClientSecret: client.ClientSecret}, nil
+}
+
+func (s *AdminGrpcServer) UpdateClient(ctx context.Context, req *pb.UpdateClientRequest) (*pb.ClientResponse, error) {
+	s.logger.WithField("client_id", req.ClientId).Info("gRPC UpdateClient request received")
+
+	client, err := s.AuthService.UpdateClient(req.ClientId, clientMetadataFromProto(req.Metadata))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC UpdateClient failed")
+		return &pb.ClientResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.ClientResponse{Client: clientToProto(client), ClientSecret: client.ClientSecret}, nil
+}
+
+func (s *AdminGrpcServer) RotateClientSecret(ctx context.Context, req *pb.RotateClientSecretRequest) (*pb.ClientResponse, error) {
+	s.logger.WithField("client_id", req.ClientId).Info("gRPC RotateClientSecret request received")
+
+	client, err := s.AuthService.RotateClientSecret(req.ClientId)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC RotateClientSecret failed")
+		return &pb.ClientResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.ClientResponse{Client: clientToProto(client), ClientSecret: client.ClientSecret}, nil
+}
+
+func (s *AdminGrpcServer) DeleteClient(ctx context.Context, req *pb.DeleteClientRequest) (*pb.AdminActionResponse, error) {
+	s.logger.WithField("client_id", req.ClientId).Info("gRPC DeleteClient request received")
+
+	if err := s.AuthService.DeleteClient(req.ClientId); err != nil {
+		s.logger.WithError(err).Error("gRPC DeleteClient failed")
+		return &pb.AdminActionResponse{Error: err.Error()}, adminErrorStatus(err)
+	}
+
+	return &pb.AdminActionResponse{Message: "Client deleted"}, nil
+}
+
+func clientMetadataFromProto(metadata *pb.ClientMetadata) models.ClientMetadata {
+	if metadata == nil {
+		return models.ClientMetadata{}
+	}
+	result := models.ClientMetadata{
+		ClientID:                metadata.ClientId,
+		ClientName:              metadata.ClientName,
+		RedirectURIs:            metadata.RedirectUris,
+		PostLogoutRedirectURIs:  metadata.PostLogoutRedirectUris,
+		GrantTypes:              metadata.GrantTypes,
+		TokenEndpointAuthMethod: metadata.TokenEndpointAuthMethod,
+		Scope:                   metadata.Scope,
+		SkipConsent:             metadata.SkipConsent,
+	}
+	if metadata.Jwks != "" {
+		result.JWKS = json.RawMessage(metadata.Jwks)
+	}
+	return result
+}
+
+func clientToProto(client *models.ClientInformation) *pb.OAuthClient {
+	return &pb.OAuthClient{
+		Metadata: &pb.ClientMetadata{
+			ClientId:                client.ClientID,
+			ClientName:              client.ClientName,
+			RedirectUris:            client.RedirectURIs,
+			PostLogoutRedirectUris:  client.PostLogoutRedirectURIs,
+			GrantTypes:              client.GrantTypes,
+			TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+			Scope:                   client.Scope,
+			Jwks:                    string(client.JWKS),
+			SkipConsent:             client.SkipConsent,
+		},
+		ClientIdIssuedAt:      client.ClientIDIssuedAt,
+		RegistrationClientUri: client.RegistrationClientURI,
+	}
+}
+
+func adminErrorStatus(err error) error {
+	var oauthErr *OAuthError
+	switch {
+	case errors.Is(err, ErrUnknownRole),
+		errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, ErrInvalidFilter),
+		errors.Is(err, ErrNoClientSecret),
+		errors.As(err, &oauthErr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUserNotFound),
+		errors.Is(err, ErrClientNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrClientExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const defaultAuditLimit = 100
+
+func (s *AuthService) ListAuditEvents(userID string, limit int) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > defaultAuditLimit {
+		limit = defaultAuditLimit
+	}
+
+	events, err := s.audit.ListByUser(ctx, user.ID, limit)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list audit events")
+		return nil, err
+	}
+	return events, nil
+}
+
+// recordEvent stores an audit event. A failure is only logged: the action
+// being audited has already happened and must not be reported as failed.
+func (s *AuthService) recordEvent(ctx context.Context, userID primitive.ObjectID, action string, details map[string]string) {
+	err := s.audit.Record(ctx, &models.AuditEvent{
+		UserID:    userID,
+		Action:    action,
+		Details:   details,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"user_id": userID.Hex(),
+			"action":  action,
+			"error":   err,
+		}).Error("Failed to record audit event")
+	}
+}
@@ -2,11 +2,17 @@ package services
 
 import (
 	"context"
+	"errors"
 	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/middleware"
 	"github/alexnoodl/raiko-auth/internal/models"
 	pb "github/alexnoodl/raiko-auth/proto"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
 )
 
 type AuthGrpcServer struct {
@@ -25,13 +31,46 @@ func NewAuthGrpcServer(authService *AuthService, logger *logrus.Logger) *AuthGrp
 func (s *AuthGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
 	s.logger.WithField("login", req.Login).Info("gRPC Login request received")
 
-	token, err := s.AuthService.Login(req.Login, req.Password)
+	result, err := s.AuthService.Login(req.Login, req.Password, middleware.PeerIP(ctx))
 	if err != nil {
 		s.logger.WithError(err).Error("gRPC Login failed")
-		return &pb.LoginResponse{Error: err.Error()}, status.Error(codes.Unauthenticated, err.Error())
+		return &pb.LoginResponse{Error: err.Error()}, loginErrorStatus(ctx, err)
 	}
 
-	return &pb.LoginResponse{Token: token}, nil
+	if result.Tokens == nil {
+		return &pb.LoginResponse{
+			MfaRequired: true,
+			MfaToken:    result.MFAToken,
+			MfaMethods:  result.MFAMethods,
+		}, nil
+	}
+
+	return &pb.LoginResponse{
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+		ExpiresIn:    result.Tokens.ExpiresIn,
+	}, nil
+}
+
+func (s *AuthGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.RefreshResponse, error) {
+	s.logger.Info("gRPC Refresh request received")
+
+	tokens, err := s.AuthService.Refresh(req.RefreshToken)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC Refresh failed")
+		if errors.Is(err, ErrInvalidRefreshToken) ||
+			errors.Is(err, ErrRefreshTokenReused) ||
+			errors.Is(err, ErrAccountNotActive) {
+			return &pb.RefreshResponse{Error: err.Error()}, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return &pb.RefreshResponse{Error: err.Error()}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.RefreshResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
 }
 
 func (s *AuthGrpcServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
@@ -49,8 +88,159 @@ func (s *AuthGrpcServer) Register(ctx context.Context, req *pb.RegisterRequest)
 	err := s.AuthService.Register(user)
 	if err != nil {
 		s.logger.WithError(err).Error("gRPC Register failed")
-		return &pb.RegisterResponse{Error: err.Error()}, status.Error(codes.InvalidArgument, err.Error())
+		return &pb.RegisterResponse{Error: err.Error()}, passwordErrorStatus(err, "password")
+	}
+
+	return &pb.RegisterResponse{Message: "Successfully registered user, check your email to activate the account"}, nil
+}
+
+func (s *AuthGrpcServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	s.logger.Info("gRPC Logout request received")
+
+	if err := s.AuthService.Logout(middleware.BearerTokenFromContext(ctx), req.RefreshToken); err != nil {
+		s.logger.WithError(err).Error("gRPC Logout failed")
+		return &pb.LogoutResponse{Error: err.Error()}, tokenErrorStatus(err)
+	}
+
+	return &pb.LogoutResponse{Message: "Logged out successfully"}, nil
+}
+
+func (s *AuthGrpcServer) LogoutAll(ctx context.Context, req *pb.LogoutAllRequest) (*pb.LogoutAllResponse, error) {
+	s.logger.Info("gRPC LogoutAll request received")
+
+	if err := s.AuthService.LogoutAll(middleware.BearerTokenFromContext(ctx)); err != nil {
+		s.logger.WithError(err).Error("gRPC LogoutAll failed")
+		return &pb.LogoutAllResponse{Error: err.Error()}, tokenErrorStatus(err)
+	}
+
+	return &pb.LogoutAllResponse{Message: "Logged out from all sessions"}, nil
+}
+
+func (s *AuthGrpcServer) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	s.logger.Debug("gRPC ValidateToken request received")
+
+	credentials, methods := grpcClientCredentials(req.ClientId, req.ClientSecret, req.ClientAssertion)
+	result, err := s.AuthService.Introspect(credentials, req.Token, methods...)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ValidateToken failed")
+		return &pb.ValidateTokenResponse{Error: err.Error()}, oauthErrorStatus(err)
+	}
+
+	return &pb.ValidateTokenResponse{
+		Active:      result.Active,
+		Subject:     result.Subject,
+		Role:        result.Role,
+		Scopes:      strings.Fields(result.Scope),
+		ExpiresAt:   result.ExpiresAt,
+		Revoked:     result.Revoked,
+		Permissions: result.Permissions,
+		ClientId:    result.ClientID,
+	}, nil
+}
+
+func (s *AuthGrpcServer) IssueServiceToken(ctx context.Context, req *pb.IssueServiceTokenRequest) (*pb.IssueServiceTokenResponse, error) {
+	s.logger.WithField("client_id", req.ClientId).Info("gRPC IssueServiceToken request received")
+
+	tokens, err := s.AuthService.IssueServiceToken(req.ClientId, req.ClientSecret, req.ClientAssertion, req.Scope)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC IssueServiceToken failed")
+		return &pb.IssueServiceTokenResponse{Error: err.Error()}, oauthErrorStatus(err)
 	}
 
-	return &pb.RegisterResponse{Message: "Successfully registered user"}, nil
+	return &pb.IssueServiceTokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   tokens.ExpiresIn,
+		Scope:       strings.Join(tokens.Scopes, " "),
+	}, nil
+}
+
+func (s *AuthGrpcServer) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	s.logger.Info("gRPC VerifyEmail request received")
+
+	if err := s.AuthService.VerifyEmail(req.Token); err != nil {
+		s.logger.WithError(err).Error("gRPC VerifyEmail failed")
+		if errors.Is(err, ErrInvalidVerificationToken) {
+			return &pb.VerifyEmailResponse{Error: err.Error()}, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return &pb.VerifyEmailResponse{Error: err.Error()}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.VerifyEmailResponse{Message: "Email verified successfully"}, nil
+}
+
+func (s *AuthGrpcServer) ResendVerificationEmail(ctx context.Context, req *pb.ResendVerificationEmailRequest) (*pb.ResendVerificationEmailResponse, error) {
+	s.logger.WithField("email", req.Email).Info("gRPC ResendVerificationEmail request received")
+
+	if err := s.AuthService.ResendVerificationEmail(req.Email); err != nil {
+		s.logger.WithError(err).Error("gRPC ResendVerificationEmail failed")
+		return &pb.ResendVerificationEmailResponse{Error: err.Error()}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.ResendVerificationEmailResponse{
+		Message: "If the account exists and is not verified yet, a new email has been sent",
+	}, nil
+}
+
+func (s *AuthGrpcServer) ForgotPassword(ctx context.Context, req *pb.ForgotPasswordRequest) (*pb.ForgotPasswordResponse, error) {
+	s.logger.WithField("email", req.Email).Info("gRPC ForgotPassword request received")
+
+	if err := s.AuthService.ForgotPassword(req.Email); err != nil {
+		s.logger.WithError(err).Error("gRPC ForgotPassword failed")
+		return &pb.ForgotPasswordResponse{Error: err.Error()}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.ForgotPasswordResponse{Message: "If the account exists, a password reset email has been sent"}, nil
+}
+
+func (s *AuthGrpcServer) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	s.logger.Info("gRPC ResetPassword request received")
+
+	if err := s.AuthService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		s.logger.WithError(err).Error("gRPC ResetPassword failed")
+		return &pb.ResetPasswordResponse{Error: err.Error()}, passwordErrorStatus(err, "new_password")
+	}
+
+	return &pb.ResetPasswordResponse{Message: "Password has been reset"}, nil
+}
+
+func tokenErrorStatus(err error) error {
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenRevoked) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// oauthErrorStatus maps the OAuth errors of client authentication and token
+// requests to gRPC codes.
+func oauthErrorStatus(err error) error {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch oauthErr.Code {
+	case OAuthInvalidClient:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case OAuthUnauthorizedClient:
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// loginErrorStatus maps Login errors like the REST handler does. Throttled
+// logins carry the seconds to wait in the "retry-after" header.
+func loginErrorStatus(ctx context.Context, err error) error {
+	var throttled *LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(throttled.RetryAfterSeconds(), 10)))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrInvalidCredentials),
+		errors.Is(err, ErrEmailNotVerified),
+		errors.Is(err, ErrAccountNotActive):
+		return status.Error(codes.Unauthenticated, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
 }
@@ -3,85 +3,169 @@ package services
 import (
 	"context"
 	"errors"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/config"
 	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
 	"github/alexnoodl/raiko-auth/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	passwordhash "github/alexnoodl/raiko-auth/pkg/password"
+	"github/alexnoodl/raiko-auth/pkg/secretbox"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
 	"time"
 )
 
 type AuthService struct {
-	db     *mongo.Database
-	logger *logrus.Logger
-	jwtKey []byte
+	store               *repository.Store
+	users               repository.UserRepository
+	tokens              repository.TokenRepository
+	verificationTokens  repository.VerificationTokenRepository
+	roles               repository.RoleRepository
+	audit               repository.AuditRepository
+	mfa                 repository.MFARepository
+	webAuthnCredentials repository.WebAuthnRepository
+	loginFailures       repository.LoginFailureRepository
+	oauth               repository.OAuthRepository
+	secrets             *secretbox.Box
+	relyingParty        *webauthn.WebAuthn
+	passwords           *passwordhash.Manager
+	passwordPolicy      *passwordhash.Policy
+	logger              *logrus.Logger
+	jwtManager          *jwtmanager.JWTManager
+	mailer              mailer.Mailer
+	cfg                 *config.Config
 }
 
-func NewAuthService(db *mongo.Database, logger *logrus.Logger, jwtKey []byte) *AuthService {
-	return &AuthService{
-		db:     db,
-		logger: logger,
-		jwtKey: jwtKey,
+func NewAuthService(store *repository.Store, logger *logrus.Logger, jwtManager *jwtmanager.JWTManager, mailer mailer.Mailer, secrets *secretbox.Box, relyingParty *webauthn.WebAuthn, passwords *passwordhash.Manager, passwordPolicy *passwordhash.Policy, cfg *config.Config) *AuthService {
+	s := &AuthService{
+		secrets:        secrets,
+		relyingParty:   relyingParty,
+		passwords:      passwords,
+		passwordPolicy: passwordPolicy,
+		logger:         logger,
+		jwtManager:     jwtManager,
+		mailer:         mailer,
+		cfg:            cfg,
 	}
+	return s.withStore(store)
 }
 
-type Claims struct {
-	Email string `json:"email"`
-	jwt.StandardClaims
+// withStore returns a copy of the service that works on the repositories of
+// store, such as the ones handed out by Store.InTransaction.
+func (s *AuthService) withStore(store *repository.Store) *AuthService {
+	bound := *s
+	bound.store = store
+	bound.users = store.Users
+	bound.tokens = store.Tokens
+	bound.verificationTokens = store.VerificationTokens
+	bound.roles = store.Roles
+	bound.audit = store.Audit
+	bound.mfa = store.MFA
+	bound.webAuthnCredentials = store.WebAuthn
+	bound.loginFailures = store.LoginFailures
+	bound.oauth = store.OAuth
+	return &bound
 }
 
+var (
+	ErrAccountNotActive = errors.New("account is not active")
+	ErrEmailNotVerified = errors.New("email is not verified")
+	ErrInvalidPassword  = errors.New("incorrect password type")
+	ErrUserExists       = errors.New("email or username already exists")
+)
+
 func (s *AuthService) Register(user *models.User) error {
 	s.logger.WithFields(logrus.Fields{
 		"email":    user.Email,
 		"username": user.Username,
 	}).Info("Starting user registration")
 
-	if !utils.IsValidPassword(user.Password) {
-		s.logger.WithField("email", user.Email).Warn("Password validation failed")
-		return errors.New("incorrect password type")
+	if !utils.IsValidEmail(user.Email) {
+		s.logger.WithField("email", user.Email).Warn("Email validation failed")
+		return ErrInvalidEmail
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	count, err := s.db.Collection("users").CountDocuments(ctx, bson.M{
-		"$or": []bson.M{
-			{"email": user.Email},
-			{"username": user.Username},
-		}})
-
-	if err != nil {
-		s.logger.WithError(err).Error("Failed to check existing users")
+	// A new user has no earlier passwords, and user.Password is not a hash yet,
+	// so only the policy applies.
+	if err := s.checkNewPassword(&models.User{Username: user.Username, Email: user.Email}, user.Password); err != nil {
 		return err
 	}
 
-	if count > 0 {
-		s.logger.WithFields(logrus.Fields{
-			"email":    user.Email,
-			"username": user.Username,
-		}).Warn("Email or username already exists")
-		return errors.New("email or username already exists")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	s.logger.WithField("email", user.Email).Debug("Hashing password")
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.Hash(user.Password)
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to hash password")
 		return err
 	}
-	// Todo нужно будет в дальнейшем isActive делать false до подтверждения регистрации по email
-	user.Password = string(hashedPassword)
-	user.IsActive = true
+	// The account stays inactive until the email address is confirmed.
+	// The role is never taken from the request.
+	user.ID = primitive.NilObjectID
+	user.Password = hashedPassword
+	user.Role = models.Role(s.cfg.DefaultRole)
+	user.IsActive = false
+	user.EmailVerified = false
+
+	// The user, the verification token and the audit event are written together,
+	// so a failed registration leaves nothing behind that blocks a retry.
+	var token string
+	err = s.store.InTransaction(ctx, func(tx *repository.Store) error {
+		txs := s.withStore(tx)
 
-	s.logger.WithField("email", user.Email).Debug("Inserting user into database")
-	_, err = s.db.Collection("users").InsertOne(ctx, user)
+		emailTaken, err := txs.users.EmailTaken(ctx, user.Email, primitive.NilObjectID)
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to check existing users")
+			return err
+		}
+		usernameTaken, err := txs.users.UsernameTaken(ctx, user.Username, primitive.NilObjectID)
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to check existing users")
+			return err
+		}
+
+		if emailTaken || usernameTaken {
+			s.logger.WithFields(logrus.Fields{
+				"email":    user.Email,
+				"username": user.Username,
+			}).Warn("Email or username already exists")
+			return ErrUserExists
+		}
+
+		s.logger.WithField("email", user.Email).Debug("Inserting user into database")
+		if err := txs.users.Create(ctx, user); err != nil {
+			if errors.Is(err, repository.ErrDuplicate) {
+				return ErrUserExists
+			}
+			s.logger.WithError(err).Error("Failed to insert user")
+			return err
+		}
+
+		token, err = txs.issueVerificationToken(ctx, user, models.PurposeEmailVerification, user.Email, s.cfg.EmailVerificationTTL)
+		if err != nil {
+			return err
+		}
+		txs.recordEvent(ctx, user.ID, models.AuditUserRegistered, nil)
+		return nil
+	})
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to insert user")
+		user.ID = primitive.NilObjectID
 		return err
 	}
 
+	// The email can be requested again, so a delivery failure does not undo the registration.
+	if err := s.mailEmailVerification(ctx, user, token); err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"email": user.Email,
+			"error": err,
+		}).Error("Failed to send verification email")
+	}
+
 	s.logger.WithFields(logrus.Fields{
 		"email":    user.Email,
 		"username": user.Username,
@@ -89,58 +173,159 @@ func (s *AuthService) Register(user *models.User) error {
 	return nil
 }
 
-func (s *AuthService) Login(login, password string) (string, error) {
-	s.logger.WithField("login", login).Info("Starting login attempt")
+// Login checks the password. Accounts without a second factor get their tokens
+// right away, the others an MFA challenge to be answered with LoginMFA.
+// Failures are counted per account and per client IP; both have to wait
+// progressively longer between attempts, and the account is locked for a
+// while once it reaches the lockout threshold. Only the IP wait is reported
+// as such, a locked or waiting account gets the same answer as a wrong
+// password. Unknown logins take as long as wrong passwords,
+// and only a caller who knows the password learns that the account is
+// inactive or unverified. A password hashed with an older algorithm or weaker
+// parameters is rehashed on the way.
+func (s *AuthService) Login(login, password, clientIP string) (*models.LoginResult, error) {
+	s.logger.WithFields(logrus.Fields{
+		"login": login,
+		"ip":    clientIP,
+	}).Info("Starting login attempt")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var user models.User
-
-	err := s.db.Collection("users").FindOne(ctx, bson.M{"$or": []bson.M{
-		{"email": login},
-		{"username": login},
-	}}).Decode(&user)
+	now := time.Now()
+	if err := s.checkIPThrottle(ctx, clientIP, now); err != nil {
+		return nil, err
+	}
 
+	user, err := s.users.FindByLogin(ctx, login)
 	if err != nil {
 		s.logger.WithFields(logrus.Fields{
 			"login": login,
 			"error": err,
 		}).Warn("User not found")
-		return "", errors.New("invalid credentials")
+		s.passwords.VerifyDummy(password)
+		s.recordIPFailure(ctx, clientIP, now)
+		return nil, ErrInvalidCredentials
 	}
 
-	if !user.IsActive {
-		s.logger.WithField("email", user.Email).Warn("Login attempt on inactive account")
-		return "", errors.New("account is not active")
+	if err := s.checkUserThrottle(ctx, user, clientIP, now); err != nil {
+		s.passwords.VerifyDummy(password)
+		return nil, err
 	}
 
 	s.logger.WithField("email", user.Email).Debug("Verifying password")
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	rehash, err := s.passwords.Verify(password, user.Password)
+	if err != nil && !errors.Is(err, passwordhash.ErrMismatch) {
+		// A hash that cannot be checked, for example because its pepper is not
+		// configured, is not the client's fault and is not counted against it.
+		s.logger.WithFields(logrus.Fields{
+			"email": user.Email,
+			"error": err,
+		}).Error("Failed to verify password hash")
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		s.logger.WithFields(logrus.Fields{
 			"email": user.Email,
 			"error": err,
 		}).Warn("Password verification failed")
-		return "", errors.New("invalid credentials")
+		s.recordEvent(ctx, user.ID, models.AuditLoginFailed, map[string]string{"ip": clientIP})
+		s.recordIPFailure(ctx, clientIP, now)
+		s.recordUserFailure(ctx, user, clientIP, now)
+		return nil, ErrInvalidCredentials
+	}
+
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("Login attempt on inactive account")
+		if !user.EmailVerified {
+			return nil, ErrEmailNotVerified
+		}
+		return nil, ErrAccountNotActive
+	}
+
+	if rehash {
+		s.upgradePasswordHash(ctx, user, password)
+	}
+
+	methods, err := s.mfaMethods(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	// With a second factor the failures are only reset once it is answered,
+	// so a known password does not buy unlimited guesses of codes.
+	if len(methods) > 0 {
+		return s.issueMFAChallenge(ctx, user, methods)
 	}
+	s.resetLoginFailures(ctx, user)
 
+	tokens, err := s.issueTokenPair(ctx, user, models.AMRPassword)
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditLoginSucceeded, nil)
+
+	s.logger.WithField("email", user.Email).Info("Login successful")
+	return &models.LoginResult{Tokens: tokens}, nil
+}
+
+// upgradePasswordHash replaces the stored hash of a password that has just
+// been verified. The login goes ahead if that fails, the hash is upgraded on
+// a later one.
+func (s *AuthService) upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to hash password")
+		return
+	}
+	if _, err := s.users.Update(ctx, user.ID, repository.UserUpdate{Password: &hashedPassword}); err != nil {
+		s.logger.WithError(err).Error("Failed to upgrade password hash")
+		return
+	}
+	user.Password = hashedPassword
+	s.logger.WithField("email", user.Email).Info("Password hash upgraded")
+}
+
+// generateAccessToken signs an access token for user. Tokens of OAuth clients
+// carry the approved scopes and only those permissions of the role that were
+// also requested as scopes, so a client cannot act with more rights than the
+// user agreed to.
+func (s *AuthService) generateAccessToken(ctx context.Context, user *models.User, grant tokenGrant, scopes []string) (string, error) {
 	s.logger.WithField("email", user.Email).Debug("Generating JWT token")
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Email: user.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	role, permissions, err := s.permissionsForRole(ctx, user.Role)
+	if err != nil {
+		return "", err
+	}
+
+	if grant.ClientID == "" {
+		scopes = s.cfg.DefaultScopes
+	} else {
+		permissions = intersect(permissions, scopes)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(s.jwtKey)
+	claims, err := s.jwtManager.NewClaims(user.ID.Hex())
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to generate JWT token")
 		return "", err
 	}
+	// A token issued in the second its user's sessions were revoked would be
+	// revoked with them, so it is dated the next second instead.
+	if validFrom := tokensValidFrom(user); claims.IssuedAt.Before(validFrom) {
+		claims.IssuedAt = jwt.NewNumericDate(validFrom)
+	}
+	claims.Email = user.Email
+	claims.Role = string(role)
+	claims.Scope = strings.Join(scopes, " ")
+	claims.Permissions = permissions
+	claims.ClientID = grant.ClientID
+	claims.AMR = grant.AMR
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(grant.AuthTime)
+	}
 
-	s.logger.WithField("email", user.Email).Info("Login successful")
+	tokenString, err := s.jwtManager.SignClaims(claims)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate JWT token")
+		return "", err
+	}
 	return tokenString, nil
 }
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/servicetest"
+	"testing"
+	"time"
+)
+
+const (
+	testOrigin   = servicetest.Origin
+	testPassword = servicetest.Password
+)
+
+// newTestAuthService returns a service on the memory store with the default
+// roles in place.
+func newTestAuthService(t *testing.T) (*AuthService, *repository.Store, *servicetest.Mailer) {
+	t.Helper()
+
+	d := servicetest.New(t)
+	s := NewAuthService(d.Store, d.Logger, d.JWT, d.Mailer, d.Secrets, d.RelyingParty, d.Passwords, d.Policy, d.Config)
+	if err := s.EnsureDefaultRoles(); err != nil {
+		t.Fatalf("default roles: %v", err)
+	}
+	return s, d.Store, d.Mailer
+}
+
+// registerVerifiedUser registers a user and confirms their email address.
+func registerVerifiedUser(t *testing.T, s *AuthService, mail *servicetest.Mailer, email, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, Username: username, Password: testPassword}
+	if err := s.Register(user); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := s.VerifyEmail(mail.LastToken(t, email)); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	return user
+}
+
+func TestRegister(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+
+	user := &models.User{Email: "alice@example.com", Username: "alice", Password: testPassword}
+	if err := s.Register(user); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	stored, err := store.Users.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if stored.Password == testPassword {
+		t.Error("password is stored in plain text")
+	}
+	if stored.IsActive || stored.EmailVerified {
+		t.Error("account is active before the email is confirmed")
+	}
+	if stored.Role != models.UserRole {
+		t.Errorf("role = %q, want %q", stored.Role, models.UserRole)
+	}
+
+	if _, err := s.Login("alice", testPassword, "192.0.2.1"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("login before verification: err = %v, want %v", err, ErrEmailNotVerified)
+	}
+	if err := s.VerifyEmail(mail.LastToken(t, "alice@example.com")); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	if _, err := s.Login("alice", testPassword, "192.0.2.1"); err != nil {
+		t.Errorf("login after verification: %v", err)
+	}
+}
+
+func TestRegisterRejectsDuplicates(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+
+	if err := s.Register(&models.User{Email: "alice@example.com", Username: "alice", Password: testPassword}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	for _, user := range []*models.User{
+		{Email: "Alice@Example.com", Username: "alice2", Password: testPassword},
+		{Email: "alice2@example.com", Username: "ALICE", Password: testPassword},
+	} {
+		if err := s.Register(user); !errors.Is(err, ErrUserExists) {
+			t.Errorf("register %s/%s: err = %v, want %v", user.Email, user.Username, err, ErrUserExists)
+		}
+	}
+}
+
+func TestRegisterRejectsWeakPassword(t *testing.T) {
+	s, store, _ := newTestAuthService(t)
+
+	err := s.Register(&models.User{Email: "alice@example.com", Username: "alice", Password: "password"})
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("err = %v, want a password policy error", err)
+	}
+	if _, err := store.Users.FindByEmail(context.Background(), "alice@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("user was stored: err = %v", err)
+	}
+}
+
+// failingVerificationTokens fails to store tokens, which is the last write of
+// the registration transaction.
+type failingVerificationTokens struct {
+	repository.VerificationTokenRepository
+}
+
+var errStorage = errors.New("storage unavailable")
+
+func (failingVerificationTokens) Replace(ctx context.Context, token *models.VerificationToken) error {
+	return errStorage
+}
+
+func TestRegisterRollsBack(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+
+	tokens := store.VerificationTokens
+	store.VerificationTokens = failingVerificationTokens{tokens}
+	user := &models.User{Email: "alice@example.com", Username: "alice", Password: testPassword}
+	if err := s.Register(user); !errors.Is(err, errStorage) {
+		t.Fatalf("register: err = %v, want %v", err, errStorage)
+	}
+	if !user.ID.IsZero() {
+		t.Error("user ID is kept after a failed registration")
+	}
+	if _, err := store.Users.FindByEmail(context.Background(), "alice@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("user is left behind: err = %v", err)
+	}
+
+	store.VerificationTokens = tokens
+	registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+}
+
+func TestInTransactionRollsBack(t *testing.T) {
+	store := repository.NewMemoryStore()
+	ctx := context.Background()
+
+	existing := &models.User{Email: "bob@example.com", Username: "bob"}
+	if err := store.Users.Create(ctx, existing); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	err := store.InTransaction(ctx, func(tx *repository.Store) error {
+		if err := tx.Users.Create(ctx, &models.User{Email: "alice@example.com", Username: "alice"}); err != nil {
+			return err
+		}
+		active := true
+		if _, err := tx.Users.Update(ctx, existing.ID, repository.UserUpdate{IsActive: &active}); err != nil {
+			return err
+		}
+		if err := tx.Audit.Record(ctx, &models.AuditEvent{UserID: existing.ID, Action: models.AuditUserActivated}); err != nil {
+			return err
+		}
+		return errStorage
+	})
+	if !errors.Is(err, errStorage) {
+		t.Fatalf("err = %v, want %v", err, errStorage)
+	}
+
+	if _, err := store.Users.FindByEmail(ctx, "alice@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("created user survived the rollback: err = %v", err)
+	}
+	bob, err := store.Users.FindByID(ctx, existing.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if bob.IsActive {
+		t.Error("update survived the rollback")
+	}
+	events, err := store.Audit.ListByUser(ctx, existing.ID, 0)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("audit events = %d, want 0", len(events))
+	}
+
+	err = store.InTransaction(ctx, func(tx *repository.Store) error {
+		return tx.Users.Create(ctx, &models.User{Email: "alice@example.com", Username: "alice"})
+	})
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, err := store.Users.FindByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("committed user is missing: %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	for _, login := range []string{"alice", "ALICE@example.com"} {
+		result, err := s.Login(login, testPassword, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("login as %s: %v", login, err)
+		}
+		if result.Tokens == nil || result.Tokens.AccessToken == "" || result.Tokens.RefreshToken == "" {
+			t.Fatalf("login as %s: no token pair", login)
+		}
+
+		claims, err := s.ValidateAccessToken(result.Tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("validate access token: %v", err)
+		}
+		if claims.Subject != user.ID.Hex() {
+			t.Errorf("subject = %s, want %s", claims.Subject, user.ID.Hex())
+		}
+		if !claims.HasPermission(models.PermissionProfileRead) || claims.HasPermission(models.PermissionUsersRead) {
+			t.Errorf("permissions = %v, want those of the user role", claims.Permissions)
+		}
+	}
+}
+
+func TestLoginRejectsWrongCredentials(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	if _, err := s.Login("alice", "Wrong-Harbor-Lantern-92", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := s.Login("nobody", testPassword, "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown login: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestLoginHidesInactiveAccount(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	if err := s.Register(&models.User{Email: "alice@example.com", Username: "alice", Password: testPassword}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if _, err := s.Login("alice", "Wrong-Harbor-Lantern-92", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unverified account, wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := s.Login("alice", testPassword, "192.0.2.1"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("unverified account: err = %v, want %v", err, ErrEmailNotVerified)
+	}
+
+	if err := s.VerifyEmail(mail.LastToken(t, "alice@example.com")); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	user, err := store.Users.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if _, err := s.SetUserActive(user.ID.Hex(), false); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+
+	if _, err := s.Login("alice", "Wrong-Harbor-Lantern-92", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("inactive account, wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := s.Login("alice", testPassword, "192.0.2.1"); !errors.Is(err, ErrAccountNotActive) {
+		t.Errorf("inactive account: err = %v, want %v", err, ErrAccountNotActive)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	first := result.Tokens
+	second, err := s.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	if _, err := s.ValidateAccessToken(second.AccessToken); err != nil {
+		t.Errorf("validate refreshed access token: %v", err)
+	}
+
+	third, err := s.Refresh(second.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh with the rotated token: %v", err)
+	}
+	if third.RefreshToken == second.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	if _, err := s.Refresh("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: err = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	other, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+
+	rotated, err := s.Refresh(result.Tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if _, err := s.Refresh(result.Tokens.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := s.Refresh(rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("token of the revoked family: err = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := s.Refresh(other.Tokens.RefreshToken); err != nil {
+		t.Errorf("token of another family: %v", err)
+	}
+
+	events, err := store.Audit.ListByUser(context.Background(), user.ID, 0)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	found := false
+	for _, event := range events {
+		found = found || event.Action == models.AuditRefreshTokenReused
+	}
+	if !found {
+		t.Error("reuse was not audited")
+	}
+}
+
+func TestLoginHidesLockedAccount(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	ctx := context.Background()
+	if err := store.Users.Lock(ctx, user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	_, lockedErr := s.Login("alice", testPassword, "192.0.2.1")
+	_, unknownErr := s.Login("nobody", testPassword, "192.0.2.2")
+	if !errors.Is(lockedErr, ErrInvalidCredentials) || lockedErr.Error() != unknownErr.Error() {
+		t.Fatalf("locked account: err = %v, want the answer to an unknown login (%v)", lockedErr, unknownErr)
+	}
+
+	events, err := store.Audit.ListByUser(ctx, user.ID, 0)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) == 0 || events[0].Action != models.AuditLoginFailed || events[0].Details["reason"] != "account_locked" {
+		t.Errorf("latest audit event = %+v, want the refused login on the locked account", events)
+	}
+
+	failures, err := store.LoginFailures.Find(ctx, "192.0.2.1", time.Now())
+	if err != nil || failures.Count != 1 {
+		t.Errorf("IP failures = %+v (%v), want 1", failures, err)
+	}
+}
+
+func TestForcePasswordResetKeepsHistory(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	ctx := context.Background()
+	// Forcing the reset twice finds the password cleared the second time.
+	for range 2 {
+		if err := s.ForcePasswordReset(user.ID.Hex()); err != nil {
+			t.Fatalf("force password reset: %v", err)
+		}
+	}
+	stored, err := store.Users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if stored.Password != "" || len(stored.PasswordHistory) != 1 || stored.PasswordHistory[0] == "" {
+		t.Fatalf("password = %q, history = %q, want a cleared password and the old hash", stored.Password, stored.PasswordHistory)
+	}
+
+	token := mail.LastToken(t, "alice@example.com")
+	if err := s.ResetPassword(token, testPassword); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("reset to the old password: err = %v, want %v", err, ErrInvalidPassword)
+	}
+	if err := s.ResetPassword(token, "Quiet-Meadow-Compass-57"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	stored, err = store.Users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if len(stored.PasswordHistory) != 1 {
+		t.Errorf("history = %q, want only the old hash", stored.PasswordHistory)
+	}
+}
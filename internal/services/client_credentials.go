@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"strings"
+	"time"
+)
+
+// maxClientAssertionLifetime bounds how long a client assertion may be valid,
+// and so how long its jti has to be remembered.
+const maxClientAssertionLifetime = 10 * time.Minute
+
+// IssueServiceToken runs the client credentials grant for a service account
+// that calls over gRPC. The client authenticates with its secret, or with a
+// signed assertion when it is registered for private_key_jwt.
+func (s *AuthService) IssueServiceToken(clientID, secret, assertion, scope string) (*models.TokenPair, error) {
+	s.logger.WithField("client_id", clientID).Info("Starting service token request")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, methods := grpcClientCredentials(clientID, secret, assertion)
+	req.GrantType = models.GrantClientCredentials
+	req.Scope = scope
+
+	client, err := s.authenticateClient(ctx, req, methods...)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueServiceToken(ctx, client, req.Scope)
+}
+
+// grpcClientCredentials turns the credentials of a gRPC request into a token
+// request and the authentication methods it may use. gRPC has no header for
+// client_secret_basic, so a secret is accepted for both secret methods.
+func grpcClientCredentials(clientID, secret, assertion string) (models.TokenRequest, []string) {
+	req := models.TokenRequest{
+		ClientID:     clientID,
+		ClientSecret: secret,
+	}
+	if assertion != "" {
+		req.ClientAssertionType = models.ClientAssertionTypeJWTBearer
+		req.ClientAssertion = assertion
+		return req, []string{models.ClientAuthPrivateKeyJWT}
+	}
+	return req, []string{models.ClientAuthClientSecretBasic, models.ClientAuthClientSecretPost}
+}
+
+// issueServiceToken signs an access token for the client itself. It has the
+// client ID as subject and carries the scopes instead of a user's email and
+// role. OpenID Connect scopes describe a user and are never granted. No
+// refresh token is issued: the client can simply authenticate again.
+func (s *AuthService) issueServiceToken(ctx context.Context, client *models.OAuthClient, scope string) (*models.TokenPair, error) {
+	if !client.AllowsGrant(models.GrantClientCredentials) {
+		return nil, oauthError(OAuthUnauthorizedClient, "the client may not use this grant type")
+	}
+
+	var serviceScopes []string
+	for _, scope := range client.Scopes {
+		if !isOpenIDScope(scope) {
+			serviceScopes = append(serviceScopes, scope)
+		}
+	}
+
+	scopes := serviceScopes
+	if scope != "" {
+		scopes = uniqueScopes(strings.Fields(scope))
+		if !containsAll(serviceScopes, scopes) {
+			s.logger.WithFields(logrus.Fields{
+				"client_id": client.ID,
+				"scope":     scope,
+			}).Warn("Service token requested with unregistered scope")
+			return nil, oauthError(OAuthInvalidScope, "the scope is not registered for the client")
+		}
+	}
+
+	claims, err := s.jwtManager.NewClaims(client.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate service token")
+		return nil, err
+	}
+	claims.ClientID = client.ID
+	claims.Scope = strings.Join(scopes, " ")
+
+	token, err := s.jwtManager.SignClaims(claims)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate service token")
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"client_id": client.ID,
+		"scope":     claims.Scope,
+	}).Info("Service token issued")
+	return &models.TokenPair{
+		AccessToken: token,
+		ExpiresIn:   int64(s.jwtManager.TokenExpires().Seconds()),
+		Scopes:      scopes,
+	}, nil
+}
+
+// isServiceToken tells apart tokens of service accounts, whose subject is
+// the client itself, from tokens clients got for a user.
+func isServiceToken(claims *jwtmanager.Claims) bool {
+	return claims.ClientID != "" && claims.Subject == claims.ClientID
+}
+
+// checkServiceToken is checkTokenRevocation for service tokens. A token
+// stays usable only while its client exists and may use the client
+// credentials grant, so removing a client or the grant disables its tokens.
+func (s *AuthService) checkServiceToken(ctx context.Context, claims *jwtmanager.Claims) error {
+	if claims.ID == "" || claims.IssuedAt == nil {
+		s.logger.Warn("Service token without jti or iat claim")
+		return ErrInvalidToken
+	}
+
+	revoked, err := s.tokens.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check revoked tokens")
+		return err
+	}
+	if revoked {
+		s.logger.WithField("jti", claims.ID).Warn("Revoked service token presented")
+		return ErrTokenRevoked
+	}
+
+	client, err := s.findOAuthClient(ctx, claims.ClientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	if !client.AllowsGrant(models.GrantClientCredentials) {
+		s.logger.WithField("client_id", client.ID).Warn("Service token of client without the client credentials grant")
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion (RFC 7523): it is
+// signed with a key of the client, issued by the client about itself for
+// this server, and used once.
+func (s *AuthService) verifyClientAssertion(ctx context.Context, client *models.OAuthClient, assertion string) error {
+	keys, err := jwtmanager.ParseJWKS([]byte(client.JWKS))
+	if err != nil {
+		s.logger.WithError(err).WithField("client_id", client.ID).Error("Stored JWKS of client is invalid")
+		return err
+	}
+
+	claims, err := jwtmanager.VerifyAssertion(assertion, keys)
+	if err != nil {
+		s.logger.WithError(err).WithField("client_id", client.ID).Warn("Client assertion failed verification")
+		return oauthError(OAuthInvalidClient, "client authentication failed")
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != client.ID || claims.Subject != client.ID:
+		s.logger.WithField("client_id", client.ID).Warn("Client assertion with wrong iss or sub")
+		return oauthError(OAuthInvalidClient, "client authentication failed")
+	case len(intersect(claims.Audience, []string{s.cfg.OIDCIssuer, s.cfg.OIDCIssuer + "/oauth/token"})) == 0:
+		s.logger.WithField("client_id", client.ID).Warn("Client assertion for another audience")
+		return oauthError(OAuthInvalidClient, "client authentication failed")
+	case claims.ID == "":
+		return oauthError(OAuthInvalidClient, "the client assertion needs a jti")
+	case claims.ExpiresAt.Sub(now) > maxClientAssertionLifetime:
+		return oauthError(OAuthInvalidClient, "the client assertion is valid for too long")
+	}
+
+	err = s.oauth.UseClientAssertion(ctx, client.ID, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			s.logger.WithField("client_id", client.ID).Warn("Client assertion replayed")
+			return oauthError(OAuthInvalidClient, "client authentication failed")
+		}
+		s.logger.WithError(err).Error("Failed to store client assertion")
+		return err
+	}
+	return nil
+}
+
+// assertionSubject reads the sub of a client assertion before it is
+// verified, to find the client when the request has no client_id.
+func assertionSubject(assertion string) string {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+func isOpenIDScope(scope string) bool {
+	return scope == models.ScopeOpenID || scope == models.ScopeEmail || scope == models.ScopeProfile
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github/alexnoodl/raiko-auth/internal/models"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testServiceClientID = "reports"
+
+// createServiceClient creates a client_credentials client authenticating
+// with a secret, and returns the secret.
+func createServiceClient(t *testing.T, s *AuthService) string {
+	t.Helper()
+
+	info, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                testServiceClientID,
+		GrantTypes:              []string{models.GrantClientCredentials},
+		TokenEndpointAuthMethod: models.ClientAuthClientSecretBasic,
+		Scope:                   "openid orders:read orders:write",
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	return info.ClientSecret
+}
+
+func TestValidateAccessTokenAcceptsServiceTokens(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	secret := createServiceClient(t, s)
+
+	tokens, err := s.IssueServiceToken(testServiceClientID, secret, "", "")
+	if err != nil {
+		t.Fatalf("issue service token: %v", err)
+	}
+
+	claims, err := s.ValidateAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("validate service token: %v", err)
+	}
+	if claims.Subject != testServiceClientID || len(claims.Permissions) != 0 {
+		t.Errorf("claims = %+v, want the client as subject and no permissions", claims)
+	}
+	if _, err := s.UserInfo(claims); !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("userinfo: err = %v, want %v", err, ErrInsufficientScope)
+	}
+
+	if err := s.DeleteClient(testServiceClientID); err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token of a deleted client: err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	secret := createServiceClient(t, s)
+	createPublicClient(t, s)
+
+	tests := []struct {
+		name   string
+		req    models.TokenRequest
+		method string
+		scopes []string
+		code   string
+	}{
+		{
+			name:   "registered scopes",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: secret},
+			method: models.ClientAuthClientSecretBasic,
+			scopes: []string{"orders:read", "orders:write"},
+		},
+		{
+			name:   "narrower scope",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: secret, Scope: "orders:read"},
+			method: models.ClientAuthClientSecretBasic,
+			scopes: []string{"orders:read"},
+		},
+		{
+			name:   "unregistered scope",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: secret, Scope: "orders:read users:delete"},
+			method: models.ClientAuthClientSecretBasic,
+			code:   OAuthInvalidScope,
+		},
+		{
+			name:   "openid scope",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: secret, Scope: "openid"},
+			method: models.ClientAuthClientSecretBasic,
+			code:   OAuthInvalidScope,
+		},
+		{
+			name:   "wrong secret",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: "wrong"},
+			method: models.ClientAuthClientSecretBasic,
+			code:   OAuthInvalidClient,
+		},
+		{
+			name:   "secret in the body of a client_secret_basic client",
+			req:    models.TokenRequest{ClientID: testServiceClientID, ClientSecret: secret},
+			method: models.ClientAuthClientSecretPost,
+			code:   OAuthInvalidClient,
+		},
+		{
+			name:   "public client",
+			req:    models.TokenRequest{ClientID: testClientID},
+			method: models.ClientAuthNone,
+			code:   OAuthUnauthorizedClient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.GrantType = models.GrantClientCredentials
+			pair, err := s.Token(tt.req, tt.method)
+			if tt.code != "" {
+				if !isOAuthError(err, tt.code) {
+					t.Errorf("err = %v, want %s", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("token: %v", err)
+			}
+			if pair.RefreshToken != "" || pair.IDToken != "" {
+				t.Errorf("token pair = %+v, want only an access token", pair)
+			}
+			claims, err := s.ValidateAccessToken(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("validate access token: %v", err)
+			}
+			if !slices.Equal(claims.Scopes(), tt.scopes) {
+				t.Errorf("scopes = %v, want %v", claims.Scopes(), tt.scopes)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyJWTAuthentication(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+
+	newKey := func(kid string) *jwtmanager.Key {
+		privateKey, err := jwtmanager.GeneratePrivateKey("ES256")
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		key, err := jwtmanager.NewKey(privateKey, kid)
+		if err != nil {
+			t.Fatalf("key: %v", err)
+		}
+		return key
+	}
+	key := newKey("reports-1")
+	jwk, _ := key.PublicJWK()
+	jwks, err := json.Marshal(jwtmanager.JWKS{Keys: []jwtmanager.JWK{jwk}})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+	if _, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                testServiceClientID,
+		GrantTypes:              []string{models.GrantClientCredentials},
+		TokenEndpointAuthMethod: models.ClientAuthPrivateKeyJWT,
+		JWKS:                    jwks,
+		Scope:                   "orders:read",
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	jti := 0
+	assertion := func(signer *jwtmanager.Key, edit func(*jwt.RegisteredClaims)) string {
+		jti++
+		claims := jwt.RegisteredClaims{
+			Issuer:    testServiceClientID,
+			Subject:   testServiceClientID,
+			Audience:  jwt.ClaimStrings{testOrigin + "/oauth/token"},
+			ID:        fmt.Sprintf("assertion-%d", jti),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}
+		if edit != nil {
+			edit(&claims)
+		}
+		token := jwt.NewWithClaims(signer.Method, claims)
+		token.Header["kid"] = signer.ID
+		signed, err := token.SignedString(signer.PrivateKey)
+		if err != nil {
+			t.Fatalf("sign assertion: %v", err)
+		}
+		return signed
+	}
+	replayed := assertion(key, nil)
+
+	tests := []struct {
+		name          string
+		assertion     string
+		assertionType string
+		code          string
+	}{
+		{name: "valid assertion", assertion: replayed},
+		{name: "replayed jti", assertion: replayed, code: OAuthInvalidClient},
+		{name: "issuer as audience", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{testOrigin} })},
+		{name: "expired", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second)) }), code: OAuthInvalidClient},
+		{name: "valid for too long", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour)) }), code: OAuthInvalidClient},
+		{name: "no jti", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.ID = "" }), code: OAuthInvalidClient},
+		{name: "other audience", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"https://other.example.com"} }), code: OAuthInvalidClient},
+		{name: "other issuer", assertion: assertion(key, func(c *jwt.RegisteredClaims) { c.Issuer = "orders" }), code: OAuthInvalidClient},
+		{name: "unregistered key", assertion: assertion(newKey("reports-1"), nil), code: OAuthInvalidClient},
+		{name: "other assertion type", assertion: assertion(key, nil), assertionType: "urn:example:saml", code: OAuthInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertionType := models.ClientAssertionTypeJWTBearer
+			if tt.assertionType != "" {
+				assertionType = tt.assertionType
+			}
+			// The client is found through the sub of the assertion.
+			_, err := s.Token(models.TokenRequest{
+				GrantType:           models.GrantClientCredentials,
+				ClientAssertionType: assertionType,
+				ClientAssertion:     tt.assertion,
+			}, models.ClientAuthPrivateKeyJWT)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("token: %v", err)
+				}
+				return
+			}
+			if !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+
+	if _, err := s.IssueServiceToken(testServiceClientID, "", assertion(key, nil), "orders:read"); err != nil {
+		t.Errorf("gRPC service token with an assertion: %v", err)
+	}
+	if _, err := s.IssueServiceToken(testServiceClientID, "secret", "", ""); !isOAuthError(err, OAuthInvalidClient) {
+		t.Errorf("gRPC service token with a secret: err = %v, want %s", err, OAuthInvalidClient)
+	}
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"strings"
+	"time"
+)
+
+const (
+	clientIDSize                = 16
+	clientSecretSize            = 32
+	registrationAccessTokenSize = 32
+)
+
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrClientExists   = errors.New("client already exists")
+	ErrNoClientSecret = errors.New("client does not authenticate with a client secret")
+)
+
+// RegisterClient is dynamic client registration (RFC 7591). The request has
+// to carry the initial access token of OAUTH_REGISTRATION_TOKEN. The server
+// picks the client ID and secret, and returns a registration access token
+// with which the client can read, update and delete its registration.
+// Registered clients may only use the scopes of OAUTH_REGISTRATION_SCOPES
+// and never skip consent.
+func (s *AuthService) RegisterClient(initialAccessToken string, metadata models.ClientMetadata) (*models.ClientInformation, error) {
+	s.logger.WithField("client_name", metadata.ClientName).Info("Starting client registration")
+
+	if s.cfg.OAuthRegistrationToken == "" {
+		return nil, oauthError(OAuthAccessDenied, "dynamic client registration is disabled")
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(initialAccessToken)), []byte(utils.HashToken(s.cfg.OAuthRegistrationToken))) != 1 {
+		s.logger.Warn("Client registration with an invalid initial access token")
+		return nil, oauthError(OAuthInvalidToken, "the initial access token is invalid")
+	}
+	if metadata.ClientID != "" {
+		return nil, oauthError(OAuthInvalidClientMetadata, "the client_id is assigned by the server")
+	}
+
+	metadata, err := s.registrationMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientID, err := utils.GenerateRandomToken(clientIDSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate client ID")
+		return nil, err
+	}
+	client, secret, err := clientFromMetadata(clientID, metadata, nil)
+	if err != nil {
+		s.logger.WithError(err).Warn("Client registration with invalid metadata")
+		return nil, err
+	}
+
+	registrationToken, err := utils.GenerateRandomToken(registrationAccessTokenSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate registration access token")
+		return nil, err
+	}
+	client.RegistrationTokenHash = utils.HashToken(registrationToken)
+
+	if err := s.oauth.CreateClient(ctx, client); err != nil {
+		s.logger.WithError(err).Error("Failed to save registered client")
+		return nil, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("Client registered")
+	info := s.clientInformation(client)
+	info.ClientSecret = secret
+	info.RegistrationAccessToken = registrationToken
+	return info, nil
+}
+
+// GetRegisteredClient returns the registration of a client to the holder of
+// its registration access token (RFC 7592).
+func (s *AuthService) GetRegisteredClient(clientID, registrationToken string) (*models.ClientInformation, error) {
+	s.logger.WithField("client_id", clientID).Info("Reading client registration")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.authenticateRegistration(ctx, clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.clientInformation(client), nil
+}
+
+// UpdateRegisteredClient replaces the metadata of a registered client. As in
+// RFC 7592, the request names the client and omitted fields are reset to
+// their defaults. The client secret and registration access token stay.
+func (s *AuthService) UpdateRegisteredClient(clientID, registrationToken string, metadata models.ClientMetadata) (*models.ClientInformation, error) {
+	s.logger.WithField("client_id", clientID).Info("Updating client registration")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.authenticateRegistration(ctx, clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ClientID != client.ID {
+		return nil, oauthError(OAuthInvalidClientMetadata, "the client_id does not match the registration")
+	}
+
+	metadata, err = s.registrationMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return s.replaceClient(ctx, client, metadata)
+}
+
+// DeleteRegisteredClient deletes a registered client, which can then no
+// longer get tokens.
+func (s *AuthService) DeleteRegisteredClient(clientID, registrationToken string) error {
+	s.logger.WithField("client_id", clientID).Info("Deleting client registration")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.authenticateRegistration(ctx, clientID, registrationToken)
+	if err != nil {
+		return err
+	}
+	return s.deleteClient(ctx, client.ID)
+}
+
+func (s *AuthService) ListClients() ([]models.ClientInformation, error) {
+	s.logger.Info("Listing OAuth clients")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clients, err := s.oauth.ListClients(ctx)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list OAuth clients")
+		return nil, err
+	}
+
+	infos := make([]models.ClientInformation, 0, len(clients))
+	for i := range clients {
+		infos = append(infos, *s.clientInformation(&clients[i]))
+	}
+	return infos, nil
+}
+
+func (s *AuthService) GetClient(clientID string) (*models.ClientInformation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return s.clientInformation(client), nil
+}
+
+// CreateClient registers a client for an administrator. Unlike dynamic
+// registration, the client ID may be chosen, any scope is allowed and
+// first-party clients may skip consent. The secret is only returned here.
+func (s *AuthService) CreateClient(metadata models.ClientMetadata) (*models.ClientInformation, error) {
+	s.logger.WithField("client_id", metadata.ClientID).Info("Creating OAuth client")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientID := metadata.ClientID
+	if clientID == "" {
+		generated, err := utils.GenerateRandomToken(clientIDSize)
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to generate client ID")
+			return nil, err
+		}
+		clientID = generated
+	}
+
+	client, secret, err := clientFromMetadata(clientID, metadata, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.oauth.CreateClient(ctx, client); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, ErrClientExists
+		}
+		s.logger.WithError(err).Error("Failed to save OAuth client")
+		return nil, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("OAuth client created")
+	info := s.clientInformation(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+// UpdateClient replaces the metadata of a client, keeping its secret. A
+// client that starts to authenticate with a secret gets a new one, which is
+// returned.
+func (s *AuthService) UpdateClient(clientID string, metadata models.ClientMetadata) (*models.ClientInformation, error) {
+	s.logger.WithField("client_id", clientID).Info("Updating OAuth client")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ClientID != "" && metadata.ClientID != client.ID {
+		return nil, oauthError(OAuthInvalidClientMetadata, "the client_id cannot be changed")
+	}
+	return s.replaceClient(ctx, client, metadata)
+}
+
+// RotateClientSecret gives the client a new secret. The old one stops
+// working at once.
+func (s *AuthService) RotateClientSecret(clientID string) (*models.ClientInformation, error) {
+	s.logger.WithField("client_id", clientID).Info("Rotating client secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.SecretHash == "" {
+		return nil, ErrNoClientSecret
+	}
+
+	secret, err := utils.GenerateRandomToken(clientSecretSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate client secret")
+		return nil, err
+	}
+	client.SecretHash = utils.HashToken(secret)
+	client.UpdatedAt = time.Now()
+
+	if err := s.oauth.SaveClient(ctx, client); err != nil {
+		s.logger.WithError(err).Error("Failed to save OAuth client")
+		return nil, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("Client secret rotated")
+	info := s.clientInformation(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+func (s *AuthService) DeleteClient(clientID string) error {
+	s.logger.WithField("client_id", clientID).Info("Deleting OAuth client")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.deleteClient(ctx, clientID)
+}
+
+func (s *AuthService) getClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	client, err := s.findOAuthClient(ctx, clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrClientNotFound
+	}
+	return client, err
+}
+
+// deleteClient deletes the client with its pending authorizations and its
+// refresh tokens, so none of them work for a client later created with the
+// same ID. Service tokens are checked against the client and stop working
+// too.
+func (s *AuthService) deleteClient(ctx context.Context, clientID string) error {
+	err := s.store.InTransaction(ctx, func(tx *repository.Store) error {
+		if err := tx.OAuth.DeleteClient(ctx, clientID); err != nil {
+			return err
+		}
+		return tx.Tokens.DeleteClientRefreshTokens(ctx, clientID)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrClientNotFound
+		}
+		s.logger.WithError(err).Error("Failed to delete OAuth client")
+		return err
+	}
+	s.logger.WithField("client_id", clientID).Info("OAuth client deleted")
+	return nil
+}
+
+func (s *AuthService) replaceClient(ctx context.Context, previous *models.OAuthClient, metadata models.ClientMetadata) (*models.ClientInformation, error) {
+	client, secret, err := clientFromMetadata(previous.ID, metadata, previous)
+	if err != nil {
+		s.logger.WithError(err).WithField("client_id", previous.ID).Warn("Client update with invalid metadata")
+		return nil, err
+	}
+
+	if err := s.oauth.SaveClient(ctx, client); err != nil {
+		s.logger.WithError(err).Error("Failed to save OAuth client")
+		return nil, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("OAuth client updated")
+	info := s.clientInformation(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+// authenticateRegistration checks the registration access token of a
+// client. Unknown clients get the same error as a wrong token, as RFC 7592
+// asks.
+func (s *AuthService) authenticateRegistration(ctx context.Context, clientID, registrationToken string) (*models.OAuthClient, error) {
+	client, err := s.findOAuthClient(ctx, clientID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if client == nil || client.RegistrationTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(utils.HashToken(registrationToken)), []byte(client.RegistrationTokenHash)) != 1 {
+		s.logger.WithField("client_id", clientID).Warn("Invalid registration access token")
+		return nil, oauthError(OAuthInvalidToken, "the registration access token is invalid")
+	}
+	return client, nil
+}
+
+// registrationMetadata applies the limits of dynamic registration: the
+// scopes default to, and must be among, OAUTH_REGISTRATION_SCOPES. Clients
+// get user tokens only with the consent of the user, so client_credentials,
+// which needs no user, is left to administrators.
+func (s *AuthService) registrationMetadata(metadata models.ClientMetadata) (models.ClientMetadata, error) {
+	if metadata.SkipConsent {
+		return metadata, oauthError(OAuthInvalidClientMetadata, "skip_consent cannot be registered")
+	}
+	if containsAll(metadata.GrantTypes, []string{models.GrantClientCredentials}) {
+		s.logger.Warn("Client registration with the client credentials grant")
+		return metadata, oauthError(OAuthInvalidClientMetadata, "the client_credentials grant cannot be registered")
+	}
+
+	scopes := strings.Fields(metadata.Scope)
+	if len(scopes) == 0 {
+		metadata.Scope = strings.Join(s.cfg.OAuthRegistrationScopes, " ")
+	} else if !containsAll(s.cfg.OAuthRegistrationScopes, scopes) {
+		s.logger.WithField("scope", metadata.Scope).Warn("Client registration with a scope that is not allowed")
+		return metadata, oauthError(OAuthInvalidClientMetadata, "the scope is not allowed for registered clients")
+	}
+	return metadata, nil
+}
+
+// clientFromMetadata validates metadata and turns it into a client with the
+// given ID. Clients that authenticate with a secret keep the one of previous,
+// or get a new one, which is returned.
+func clientFromMetadata(clientID string, metadata models.ClientMetadata, previous *models.OAuthClient) (*models.OAuthClient, string, error) {
+	for _, uri := range metadata.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, "", oauthError(OAuthInvalidRedirectURI, "redirect URI "+uri+": "+err.Error())
+		}
+	}
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != "code" {
+			return nil, "", oauthError(OAuthInvalidClientMetadata, "unsupported response type "+responseType)
+		}
+	}
+
+	config := models.OAuthClientConfig{
+		ClientID:                clientID,
+		Name:                    metadata.ClientName,
+		RedirectURIs:            metadata.RedirectURIs,
+		PostLogoutRedirectURIs:  metadata.PostLogoutRedirectURIs,
+		Scopes:                  strings.Fields(metadata.Scope),
+		GrantTypes:              metadata.GrantTypes,
+		TokenEndpointAuthMethod: metadata.TokenEndpointAuthMethod,
+		JWKS:                    metadata.JWKS,
+		SkipConsent:             metadata.SkipConsent,
+	}
+	if config.TokenEndpointAuthMethod == "" {
+		config.TokenEndpointAuthMethod = models.ClientAuthClientSecretBasic
+	}
+
+	var secret string
+	usesSecret := config.TokenEndpointAuthMethod != models.ClientAuthNone &&
+		config.TokenEndpointAuthMethod != models.ClientAuthPrivateKeyJWT
+	if usesSecret {
+		generated, err := utils.GenerateRandomToken(clientSecretSize)
+		if err != nil {
+			return nil, "", err
+		}
+		config.ClientSecret = generated
+		secret = generated
+	}
+
+	client, err := newOAuthClient(config)
+	if err != nil {
+		return nil, "", oauthError(OAuthInvalidClientMetadata, err.Error())
+	}
+	if len(metadata.ResponseTypes) > 0 && !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, "", oauthError(OAuthInvalidClientMetadata, "the code response type needs the authorization code grant")
+	}
+
+	if previous != nil {
+		client.CreatedAt = previous.CreatedAt
+		client.RegistrationTokenHash = previous.RegistrationTokenHash
+		if usesSecret && previous.SecretHash != "" {
+			client.SecretHash = previous.SecretHash
+			secret = ""
+		}
+	}
+	return client, secret, nil
+}
+
+// clientInformation describes a client without its secrets.
+func (s *AuthService) clientInformation(client *models.OAuthClient) *models.ClientInformation {
+	info := &models.ClientInformation{
+		ClientMetadata: models.ClientMetadata{
+			ClientID:                client.ID,
+			ClientName:              client.Name,
+			RedirectURIs:            client.RedirectURIs,
+			PostLogoutRedirectURIs:  client.PostLogoutRedirectURIs,
+			GrantTypes:              client.GrantTypes,
+			TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+			Scope:                   strings.Join(client.Scopes, " "),
+			SkipConsent:             client.SkipConsent,
+		},
+		ClientIDIssuedAt: client.CreatedAt.Unix(),
+	}
+	if client.AllowsGrant(models.GrantAuthorizationCode) {
+		info.ResponseTypes = []string{"code"}
+	}
+	if client.JWKS != "" {
+		info.JWKS = json.RawMessage(client.JWKS)
+	}
+	if client.RegistrationTokenHash != "" {
+		info.RegistrationClientURI = s.cfg.OIDCIssuer + "/oauth/register/" + client.ID
+	}
+	return info
+}
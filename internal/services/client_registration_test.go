@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"testing"
+)
+
+const testRegistrationToken = "initial-access-token"
+
+func newRegistrationService(t *testing.T) *AuthService {
+	t.Helper()
+
+	s, _, _ := newTestAuthService(t)
+	s.cfg.OAuthRegistrationToken = testRegistrationToken
+	s.cfg.OAuthRegistrationScopes = []string{"openid", "profile:read"}
+	return s
+}
+
+func TestRegisterClient(t *testing.T) {
+	s := newRegistrationService(t)
+
+	info, err := s.RegisterClient(testRegistrationToken, models.ClientMetadata{
+		ClientName:   "Notes",
+		RedirectURIs: []string{testRedirectURI},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	if info.ClientID == "" || info.ClientSecret == "" || info.RegistrationAccessToken == "" {
+		t.Fatalf("registration = %+v, want a client ID, secret and registration access token", info)
+	}
+	if info.Scope != "openid profile:read" {
+		t.Errorf("scope = %q, want the registration scopes", info.Scope)
+	}
+
+	if _, err := s.GetRegisteredClient(info.ClientID, info.RegistrationAccessToken); err != nil {
+		t.Errorf("read registration: %v", err)
+	}
+	if _, err := s.GetRegisteredClient(info.ClientID, "wrong"); !isOAuthError(err, OAuthInvalidToken) {
+		t.Errorf("read with a wrong registration token: err = %v, want %s", err, OAuthInvalidToken)
+	}
+
+	if err := s.DeleteRegisteredClient(info.ClientID, info.RegistrationAccessToken); err != nil {
+		t.Fatalf("delete registration: %v", err)
+	}
+	if _, err := s.GetClient(info.ClientID); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("deleted client: err = %v, want %v", err, ErrClientNotFound)
+	}
+}
+
+func TestRegisterClientRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		metadata models.ClientMetadata
+		code     string
+	}{
+		{
+			name:     "wrong initial access token",
+			token:    "wrong",
+			metadata: models.ClientMetadata{RedirectURIs: []string{testRedirectURI}},
+			code:     OAuthInvalidToken,
+		},
+		{
+			name:     "client ID",
+			metadata: models.ClientMetadata{ClientID: "mine", RedirectURIs: []string{testRedirectURI}},
+			code:     OAuthInvalidClientMetadata,
+		},
+		{
+			name:     "scope outside the registration scopes",
+			metadata: models.ClientMetadata{RedirectURIs: []string{testRedirectURI}, Scope: "openid users:write"},
+			code:     OAuthInvalidClientMetadata,
+		},
+		{
+			name:     "skip consent",
+			metadata: models.ClientMetadata{RedirectURIs: []string{testRedirectURI}, SkipConsent: true},
+			code:     OAuthInvalidClientMetadata,
+		},
+		{
+			name:     "client credentials",
+			metadata: models.ClientMetadata{GrantTypes: []string{models.GrantClientCredentials}},
+			code:     OAuthInvalidClientMetadata,
+		},
+		{
+			name: "client credentials with a key",
+			metadata: models.ClientMetadata{
+				GrantTypes:              []string{models.GrantAuthorizationCode, models.GrantClientCredentials},
+				RedirectURIs:            []string{testRedirectURI},
+				TokenEndpointAuthMethod: models.ClientAuthPrivateKeyJWT,
+			},
+			code: OAuthInvalidClientMetadata,
+		},
+		{
+			name:     "insecure redirect URI",
+			metadata: models.ClientMetadata{RedirectURIs: []string{"http://client.example.com/callback"}},
+			code:     OAuthInvalidRedirectURI,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRegistrationService(t)
+			token := tt.token
+			if token == "" {
+				token = testRegistrationToken
+			}
+			if _, err := s.RegisterClient(token, tt.metadata); !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func isOAuthError(err error, code string) bool {
+	var oauthErr *OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == code
+}
+
+// authenticatesWith reports whether secret is the current secret of the
+// client, by introspecting a token with it.
+func authenticatesWith(t *testing.T, s *AuthService, clientID, secret string) bool {
+	t.Helper()
+
+	credentials := models.TokenRequest{ClientID: clientID, ClientSecret: secret}
+	_, err := s.Introspect(credentials, "token", models.ClientAuthClientSecretBasic)
+	if err != nil && !isOAuthError(err, OAuthInvalidClient) {
+		t.Fatalf("introspect: %v", err)
+	}
+	return err == nil
+}
+
+func TestUpdateRegisteredClient(t *testing.T) {
+	s := newRegistrationService(t)
+
+	info, err := s.RegisterClient(testRegistrationToken, models.ClientMetadata{
+		ClientName:   "Notes",
+		RedirectURIs: []string{testRedirectURI},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	other, err := s.RegisterClient(testRegistrationToken, models.ClientMetadata{RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+
+	metadata := func(edit func(*models.ClientMetadata)) models.ClientMetadata {
+		m := models.ClientMetadata{
+			ClientID:     info.ClientID,
+			ClientName:   "Notes 2",
+			RedirectURIs: []string{testRedirectURI, "https://client.example.com/other"},
+			Scope:        "openid",
+		}
+		if edit != nil {
+			edit(&m)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		metadata models.ClientMetadata
+		code     string
+	}{
+		{"wrong registration token", "wrong", metadata(nil), OAuthInvalidToken},
+		{"registration token of another client", other.RegistrationAccessToken, metadata(nil), OAuthInvalidToken},
+		{"other client ID", info.RegistrationAccessToken, metadata(func(m *models.ClientMetadata) { m.ClientID = other.ClientID }), OAuthInvalidClientMetadata},
+		{"scope outside the registration scopes", info.RegistrationAccessToken, metadata(func(m *models.ClientMetadata) { m.Scope = "openid users:write" }), OAuthInvalidClientMetadata},
+		{"client credentials", info.RegistrationAccessToken, metadata(func(m *models.ClientMetadata) {
+			m.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantClientCredentials}
+		}), OAuthInvalidClientMetadata},
+		{"skip consent", info.RegistrationAccessToken, metadata(func(m *models.ClientMetadata) { m.SkipConsent = true }), OAuthInvalidClientMetadata},
+		{"insecure redirect URI", info.RegistrationAccessToken, metadata(func(m *models.ClientMetadata) {
+			m.RedirectURIs = []string{"http://client.example.com/callback"}
+		}), OAuthInvalidRedirectURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.UpdateRegisteredClient(info.ClientID, tt.token, tt.metadata); !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+
+	updated, err := s.UpdateRegisteredClient(info.ClientID, info.RegistrationAccessToken, metadata(nil))
+	if err != nil {
+		t.Fatalf("update registration: %v", err)
+	}
+	if updated.ClientName != "Notes 2" || len(updated.RedirectURIs) != 2 || updated.Scope != "openid" {
+		t.Errorf("registration = %+v, want the new metadata", updated)
+	}
+	if updated.ClientSecret != "" || !authenticatesWith(t, s, info.ClientID, info.ClientSecret) {
+		t.Error("the update replaced the client secret")
+	}
+	if _, err := s.GetRegisteredClient(info.ClientID, info.RegistrationAccessToken); err != nil {
+		t.Errorf("read with the registration token after the update: %v", err)
+	}
+}
+
+func TestRegisterClientDisabled(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+
+	_, err := s.RegisterClient("", models.ClientMetadata{RedirectURIs: []string{testRedirectURI}})
+	if !isOAuthError(err, OAuthAccessDenied) {
+		t.Errorf("err = %v, want %s", err, OAuthAccessDenied)
+	}
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	secret := createServiceClient(t, s)
+	createPublicClient(t, s)
+
+	rotated, err := s.RotateClientSecret(testServiceClientID)
+	if err != nil {
+		t.Fatalf("rotate secret: %v", err)
+	}
+	if rotated.ClientSecret == "" || rotated.ClientSecret == secret {
+		t.Fatalf("rotated secret = %q, want a new one", rotated.ClientSecret)
+	}
+	if authenticatesWith(t, s, testServiceClientID, secret) {
+		t.Error("the old secret still works")
+	}
+	if !authenticatesWith(t, s, testServiceClientID, rotated.ClientSecret) {
+		t.Error("the new secret does not work")
+	}
+
+	if _, err := s.RotateClientSecret(testClientID); !errors.Is(err, ErrNoClientSecret) {
+		t.Errorf("public client: err = %v, want %v", err, ErrNoClientSecret)
+	}
+	if _, err := s.RotateClientSecret("unknown"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("unknown client: err = %v, want %v", err, ErrClientNotFound)
+	}
+}
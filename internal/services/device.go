@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeSize = 32
+	// userCodeAlphabet has no vowels, so user codes cannot spell words, and
+	// no digits that are confused with letters (RFC 8628, section 6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// slowDownStep is added to the polling interval of a device that polls
+	// too fast.
+	slowDownStep = 5
+	// userCodeAttempts bounds the retries when a new user code is taken.
+	userCodeAttempts = 3
+)
+
+var (
+	ErrDeviceAuthorizationNotFound = errors.New("device authorization not found or expired")
+	ErrDevicePageNotConfigured     = errors.New("OAUTH_DEVICE_PAGE_URL is not set")
+)
+
+// DeviceAuthorization starts the device authorization grant for a client
+// that cannot open a browser. req carries the client credentials and scope,
+// which /oauth/device_authorization takes like /oauth/token. The device
+// shows the user code and verification URI and polls /oauth/token with the
+// device code until the user has answered.
+func (s *AuthService) DeviceAuthorization(req models.TokenRequest, authMethod string) (*models.DeviceAuthorizationResponse, error) {
+	s.logger.WithField("client_id", req.ClientID).Info("Starting device authorization")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.authenticateClient(ctx, req, authMethod)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(models.GrantDeviceCode) {
+		return nil, oauthError(OAuthUnauthorizedClient, "the client may not use the device authorization grant")
+	}
+	// Without the verification page the user has nowhere to enter the code.
+	if s.cfg.OAuthDevicePageURL == "" {
+		s.logger.Error("OAUTH_DEVICE_PAGE_URL is not set, device authorizations cannot be answered")
+		return nil, ErrDevicePageNotConfigured
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = uniqueScopes(strings.Fields(req.Scope))
+		if !containsAll(client.Scopes, scopes) {
+			return nil, oauthError(OAuthInvalidScope, "the client may not request these scopes")
+		}
+	}
+
+	deviceCode, err := utils.GenerateRandomToken(deviceCodeSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate device code")
+		return nil, err
+	}
+
+	now := time.Now()
+	interval := int(s.cfg.OAuthDevicePollInterval.Seconds())
+	authorization := &models.DeviceAuthorization{
+		DeviceCodeHash: utils.HashToken(deviceCode),
+		ClientID:       client.ID,
+		Scopes:         scopes,
+		Status:         models.DeviceAuthorizationPending,
+		Interval:       interval,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.cfg.OAuthDeviceCodeTTL),
+	}
+
+	// User codes are short enough to collide now and then.
+	var userCode string
+	for attempt := 1; ; attempt++ {
+		if userCode, err = generateUserCode(); err != nil {
+			s.logger.WithError(err).Error("Failed to generate user code")
+			return nil, err
+		}
+		authorization.UserCodeHash = utils.HashToken(normalizeUserCode(userCode))
+
+		err = s.oauth.CreateDeviceAuthorization(ctx, authorization)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrDuplicate) || attempt == userCodeAttempts {
+			s.logger.WithError(err).Error("Failed to store device authorization")
+			return nil, err
+		}
+	}
+
+	verificationURI := s.cfg.OIDCIssuer + "/oauth/device"
+	s.logger.WithField("client_id", client.ID).Info("Device authorization waiting for the user")
+	return &models.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: authorizationResponse(verificationURI, url.Values{"user_code": {userCode}}),
+		ExpiresIn:               int64(s.cfg.OAuthDeviceCodeTTL.Seconds()),
+		Interval:                interval,
+	}, nil
+}
+
+// DevicePage returns where the verification URI sends the browser: the
+// verification page, with the user code when the device passed it along.
+func (s *AuthService) DevicePage(userCode string) (string, error) {
+	if s.cfg.OAuthDevicePageURL == "" {
+		return "", ErrDevicePageNotConfigured
+	}
+	params := url.Values{}
+	if code := normalizeUserCode(userCode); len(code) == userCodeLength {
+		params.Set("user_code", formatUserCode(code))
+	}
+	return authorizationResponse(s.cfg.OAuthDevicePageURL, params), nil
+}
+
+// GetDeviceAuthorization describes a pending device authorization to the
+// user who entered its user code.
+func (s *AuthService) GetDeviceAuthorization(userCode string) (*models.DeviceAuthorizationInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authorization, client, err := s.findPendingDeviceAuthorization(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DeviceAuthorizationInfo{
+		UserCode:   formatUserCode(normalizeUserCode(userCode)),
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scopes:     authorization.Scopes,
+		ExpiresAt:  authorization.ExpiresAt,
+	}, nil
+}
+
+// AnswerDeviceAuthorization records the decision of the logged-in user on
+// the device authorization with userCode. The device gets its tokens, or
+// access_denied, with its next poll. Every device authorization is answered
+// once.
+func (s *AuthService) AnswerDeviceAuthorization(claims *jwtmanager.Claims, userCode string, approve bool) error {
+	userID := claims.Subject
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"approve": approve,
+	}).Info("Starting device authorization consent")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	authorization, _, err := s.findPendingDeviceAuthorization(ctx, userCode)
+	if err != nil {
+		return err
+	}
+
+	action := models.AuditOAuthConsentDenied
+	authorization.Status = models.DeviceAuthorizationDenied
+	if approve {
+		action = models.AuditOAuthConsentGranted
+		authorization.Status = models.DeviceAuthorizationApproved
+		authorization.UserID = user.ID
+		authorization.AuthTime = loginTime(claims)
+		authorization.AMR = claims.AMR
+	}
+
+	if err := s.oauth.AnswerDeviceAuthorization(ctx, authorization, time.Now()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrDeviceAuthorizationNotFound
+		}
+		s.logger.WithError(err).Error("Failed to store device authorization answer")
+		return err
+	}
+
+	s.recordEvent(ctx, user.ID, action, map[string]string{
+		"client_id":  authorization.ClientID,
+		"scope":      strings.Join(authorization.Scopes, " "),
+		"grant_type": models.GrantDeviceCode,
+	})
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"client_id": authorization.ClientID,
+		"status":    authorization.Status,
+	}).Info("Device authorization answered")
+	return nil
+}
+
+func (s *AuthService) findPendingDeviceAuthorization(ctx context.Context, userCode string) (*models.DeviceAuthorization, *models.OAuthClient, error) {
+	code := normalizeUserCode(userCode)
+	if len(code) != userCodeLength {
+		return nil, nil, ErrDeviceAuthorizationNotFound
+	}
+
+	authorization, err := s.oauth.FindPendingDeviceAuthorization(ctx, utils.HashToken(code), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.Warn("Unknown or expired user code entered")
+			return nil, nil, ErrDeviceAuthorizationNotFound
+		}
+		s.logger.WithError(err).Error("Failed to look up device authorization")
+		return nil, nil, err
+	}
+
+	client, err := s.findOAuthClient(ctx, authorization.ClientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, ErrDeviceAuthorizationNotFound
+		}
+		return nil, nil, err
+	}
+	return authorization, client, nil
+}
+
+// exchangeDeviceCode answers a poll of the device: authorization_pending
+// until the user answered, slow_down when it polls faster than its interval,
+// which then grows, and expired_token once the device code expired.
+func (s *AuthService) exchangeDeviceCode(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenPair, error) {
+	if req.DeviceCode == "" {
+		return nil, oauthError(OAuthInvalidRequest, "device_code is required")
+	}
+
+	authorization, err := s.oauth.FindDeviceAuthorization(ctx, utils.HashToken(req.DeviceCode))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("client_id", client.ID).Warn("Device code not found")
+			return nil, oauthError(OAuthInvalidGrant, "invalid device code")
+		}
+		s.logger.WithError(err).Error("Failed to look up device code")
+		return nil, err
+	}
+	if authorization.ClientID != client.ID {
+		s.logger.WithField("client_id", client.ID).Warn("Device code presented by another client")
+		return nil, oauthError(OAuthInvalidGrant, "invalid device code")
+	}
+
+	now := time.Now()
+	if !now.Before(authorization.ExpiresAt) {
+		return nil, oauthError(OAuthExpiredToken, "the device code has expired")
+	}
+
+	interval := authorization.Interval
+	tooFast := authorization.LastPolledAt != nil &&
+		now.Sub(*authorization.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooFast {
+		interval += slowDownStep
+	}
+	if err := s.oauth.RecordDeviceAuthorizationPoll(ctx, authorization.ID, now, interval); err != nil &&
+		!errors.Is(err, repository.ErrNotFound) {
+		s.logger.WithError(err).Error("Failed to record device poll")
+		return nil, err
+	}
+	if tooFast {
+		s.logger.WithFields(logrus.Fields{
+			"client_id": client.ID,
+			"interval":  interval,
+		}).Warn("Device polling too fast")
+		return nil, oauthError(OAuthSlowDown, "poll less often")
+	}
+
+	switch authorization.Status {
+	case models.DeviceAuthorizationPending:
+		return nil, oauthError(OAuthAuthorizationPending, "")
+	case models.DeviceAuthorizationDenied:
+		return nil, oauthError(OAuthAccessDenied, "the user denied the request")
+	}
+
+	// Of two concurrent polls only one consumes the approval.
+	if err := s.oauth.ConsumeDeviceAuthorization(ctx, authorization.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, oauthError(OAuthInvalidGrant, "invalid device code")
+		}
+		s.logger.WithError(err).Error("Failed to consume device authorization")
+		return nil, err
+	}
+
+	user, err := s.users.FindByID(ctx, authorization.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, oauthError(OAuthInvalidGrant, "invalid device code")
+		}
+		s.logger.WithError(err).Error("Failed to load user for device code")
+		return nil, err
+	}
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("Device code of inactive account")
+		return nil, oauthError(OAuthInvalidGrant, ErrAccountNotActive.Error())
+	}
+
+	pair, err := s.issueTokens(ctx, user, tokenGrant{
+		ClientID:       client.ID,
+		Scopes:         authorization.Scopes,
+		NoRefreshToken: !client.AllowsGrant(models.GrantRefreshToken),
+		AuthTime:       authorization.AuthTime,
+		AMR:            authorization.AMR,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"email":     user.Email,
+		"client_id": client.ID,
+	}).Info("Device code exchanged")
+	return pair, nil
+}
+
+// generateUserCode returns a user code formatted as XXXX-XXXX.
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return formatUserCode(string(code)), nil
+}
+
+// normalizeUserCode drops the dashes and spaces people type along with a
+// user code and ignores case.
+func normalizeUserCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+func formatUserCode(code string) string {
+	half := len(code) / 2
+	return code[:half] + "-" + code[half:]
+}
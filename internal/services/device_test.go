@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDeviceClientID = "tv"
+
+// createDeviceClient creates a public client that uses the device
+// authorization grant and refresh tokens.
+func createDeviceClient(t *testing.T, s *AuthService) {
+	t.Helper()
+
+	_, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                testDeviceClientID,
+		ClientName:              "TV",
+		GrantTypes:              []string{models.GrantDeviceCode, models.GrantRefreshToken},
+		TokenEndpointAuthMethod: models.ClientAuthNone,
+		Scope:                   "openid profile:read",
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+}
+
+func startDeviceAuthorization(t *testing.T, s *AuthService) *models.DeviceAuthorizationResponse {
+	t.Helper()
+
+	response, err := s.DeviceAuthorization(models.TokenRequest{ClientID: testDeviceClientID}, models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("device authorization: %v", err)
+	}
+	return response
+}
+
+func pollDevice(s *AuthService, clientID, deviceCode string) (*models.TokenPair, error) {
+	return s.Token(models.TokenRequest{
+		GrantType:  models.GrantDeviceCode,
+		ClientID:   clientID,
+		DeviceCode: deviceCode,
+	}, models.ClientAuthNone)
+}
+
+// waitPollInterval moves the last poll of the device back, as if the device
+// had waited for its polling interval.
+func waitPollInterval(t *testing.T, store *repository.Store, deviceCode string) {
+	t.Helper()
+
+	ctx := context.Background()
+	authorization, err := store.OAuth.FindDeviceAuthorization(ctx, utils.HashToken(deviceCode))
+	if err != nil {
+		t.Fatalf("find device authorization: %v", err)
+	}
+	polled := time.Now().Add(-time.Duration(authorization.Interval) * time.Second)
+	if err := store.OAuth.RecordDeviceAuthorizationPoll(ctx, authorization.ID, polled, authorization.Interval); err != nil {
+		t.Fatalf("record poll: %v", err)
+	}
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createDeviceClient(t, s)
+	if _, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                "radio",
+		GrantTypes:              []string{models.GrantDeviceCode},
+		TokenEndpointAuthMethod: models.ClientAuthNone,
+		Scope:                   "openid profile:read",
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	response := startDeviceAuthorization(t, s)
+	if response.Interval != 5 || response.VerificationURIComplete != testOrigin+"/oauth/device?user_code="+response.UserCode {
+		t.Errorf("response = %+v", response)
+	}
+
+	if _, err := pollDevice(s, testDeviceClientID, response.DeviceCode); !isOAuthError(err, OAuthAuthorizationPending) {
+		t.Fatalf("first poll: err = %v, want %s", err, OAuthAuthorizationPending)
+	}
+	if _, err := pollDevice(s, testDeviceClientID, response.DeviceCode); !isOAuthError(err, OAuthSlowDown) {
+		t.Fatalf("poll within the interval: err = %v, want %s", err, OAuthSlowDown)
+	}
+	waitPollInterval(t, store, response.DeviceCode)
+	if _, err := pollDevice(s, "radio", response.DeviceCode); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("poll of another client: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+
+	// People type the user code in any case and without the dash.
+	typed := strings.ToLower(strings.ReplaceAll(response.UserCode, "-", ""))
+	info, err := s.GetDeviceAuthorization(typed)
+	if err != nil {
+		t.Fatalf("get device authorization: %v", err)
+	}
+	if info.ClientID != testDeviceClientID || info.UserCode != response.UserCode {
+		t.Errorf("info = %+v", info)
+	}
+
+	claims, err := s.ValidateAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if err := s.AnswerDeviceAuthorization(claims, typed, true); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := s.AnswerDeviceAuthorization(claims, response.UserCode, false); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Errorf("second answer: err = %v, want %v", err, ErrDeviceAuthorizationNotFound)
+	}
+
+	waitPollInterval(t, store, response.DeviceCode)
+	pair, err := pollDevice(s, testDeviceClientID, response.DeviceCode)
+	if err != nil {
+		t.Fatalf("poll after approval: %v", err)
+	}
+	tokenClaims, err := s.ValidateAccessToken(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("validate device token: %v", err)
+	}
+	if tokenClaims.Subject != claims.Subject || tokenClaims.ClientID != testDeviceClientID || pair.RefreshToken == "" {
+		t.Errorf("device token of %s for %s, refresh token %q", tokenClaims.Subject, tokenClaims.ClientID, pair.RefreshToken)
+	}
+
+	if _, err := pollDevice(s, testDeviceClientID, response.DeviceCode); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("poll after the exchange: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createDeviceClient(t, s)
+
+	response := startDeviceAuthorization(t, s)
+	claims, err := s.ValidateAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if err := s.AnswerDeviceAuthorization(claims, response.UserCode, false); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	if _, err := s.GetDeviceAuthorization(response.UserCode); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Errorf("answered user code: err = %v, want %v", err, ErrDeviceAuthorizationNotFound)
+	}
+
+	for i := 0; i < 2; i++ {
+		waitPollInterval(t, store, response.DeviceCode)
+		if _, err := pollDevice(s, testDeviceClientID, response.DeviceCode); !isOAuthError(err, OAuthAccessDenied) {
+			t.Errorf("poll %d: err = %v, want %s", i+1, err, OAuthAccessDenied)
+		}
+	}
+}
+
+func TestDeviceCodeExpired(t *testing.T) {
+	s, store, _ := newTestAuthService(t)
+	createDeviceClient(t, s)
+
+	created := time.Now().Add(-20 * time.Minute)
+	err := store.OAuth.CreateDeviceAuthorization(context.Background(), &models.DeviceAuthorization{
+		DeviceCodeHash: utils.HashToken("expired-device-code"),
+		UserCodeHash:   utils.HashToken("BCDFGHJK"),
+		ClientID:       testDeviceClientID,
+		Scopes:         []string{"openid"},
+		Status:         models.DeviceAuthorizationPending,
+		Interval:       5,
+		CreatedAt:      created,
+		ExpiresAt:      created.Add(10 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("create device authorization: %v", err)
+	}
+
+	if _, err := pollDevice(s, testDeviceClientID, "expired-device-code"); !isOAuthError(err, OAuthExpiredToken) {
+		t.Errorf("poll: err = %v, want %s", err, OAuthExpiredToken)
+	}
+	if _, err := s.GetDeviceAuthorization("BCDF-GHJK"); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Errorf("expired user code: err = %v, want %v", err, ErrDeviceAuthorizationNotFound)
+	}
+	if _, err := pollDevice(s, testDeviceClientID, "unknown-device-code"); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("unknown device code: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+}
+
+func TestDeviceAuthorizationRejects(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	createDeviceClient(t, s)
+	createPublicClient(t, s)
+
+	tests := []struct {
+		name string
+		req  models.TokenRequest
+		code string
+	}{
+		{"unknown client", models.TokenRequest{ClientID: "unknown"}, OAuthInvalidClient},
+		{"client without the device grant", models.TokenRequest{ClientID: testClientID}, OAuthUnauthorizedClient},
+		{"unregistered scope", models.TokenRequest{ClientID: testDeviceClientID, Scope: "openid users:delete"}, OAuthInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.DeviceAuthorization(tt.req, models.ClientAuthNone); !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+
+	s.cfg.OAuthDevicePageURL = ""
+	if _, err := s.DeviceAuthorization(models.TokenRequest{ClientID: testDeviceClientID}, models.ClientAuthNone); !errors.Is(err, ErrDevicePageNotConfigured) {
+		t.Errorf("without a verification page: err = %v, want %v", err, ErrDevicePageNotConfigured)
+	}
+}
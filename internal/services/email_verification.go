@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	"net/url"
+	"time"
+)
+
+const verificationTokenSize = 32
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired token")
+
+func (s *AuthService) VerifyEmail(token string) error {
+	s.logger.Info("Starting email verification")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeVerificationToken(ctx, token, models.PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	// The address must still match, otherwise the token was issued for an email
+	// the user has since changed and confirms nothing.
+	user, err := s.userForToken(ctx, stored)
+	if err != nil {
+		return err
+	}
+
+	verified, active := true, true
+	_, err = s.users.Update(ctx, user.ID, repository.UserUpdate{EmailVerified: &verified, IsActive: &active})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to activate user")
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditEmailVerified, nil)
+
+	s.logger.WithField("email", stored.Email).Info("Email verified successfully")
+	return nil
+}
+
+// ResendVerificationEmail sends a fresh verification link. It does not report
+// whether the address is registered, so callers must answer the same way in
+// every case.
+func (s *AuthService) ResendVerificationEmail(email string) error {
+	s.logger.WithField("email", email).Info("Starting verification email resend")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("email", email).Warn("Verification resend for unknown email")
+			return nil
+		}
+		s.logger.WithError(err).Error("Failed to look up user")
+		return err
+	}
+
+	if user.EmailVerified {
+		s.logger.WithField("email", email).Warn("Verification resend for already verified email")
+		return nil
+	}
+
+	return s.sendEmailVerification(ctx, user)
+}
+
+func (s *AuthService) sendEmailVerification(ctx context.Context, user *models.User) error {
+	token, err := s.issueVerificationToken(ctx, user, models.PurposeEmailVerification, user.Email, s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailEmailVerification(ctx, user, token)
+}
+
+func (s *AuthService) mailEmailVerification(ctx context.Context, user *models.User, token string) error {
+	link := fmt.Sprintf("%s/api/v1/verify-email?token=%s", s.cfg.AppBaseURL, url.QueryEscape(token))
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello, %s!\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %s. If you did not create an account, just ignore this email.\n",
+			user.Username, link, s.cfg.EmailVerificationTTL),
+	})
+}
+
+// issueVerificationToken stores the hash of a new single-use token. Unused
+// tokens issued earlier for the same purpose are dropped, so only the most
+// recent email works.
+func (s *AuthService) issueVerificationToken(ctx context.Context, user *models.User, purpose models.TokenPurpose, email string, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateRandomToken(verificationTokenSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate verification token")
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.verificationTokens.Replace(ctx, &models.VerificationToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(token),
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to store verification token")
+		return "", err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": user.ID.Hex(),
+		"purpose": purpose,
+	}).Debug("Verification token issued")
+	return token, nil
+}
+
+// consumeVerificationToken marks a token as used in a single conditional
+// update, so the same token can never be redeemed twice.
+func (s *AuthService) consumeVerificationToken(ctx context.Context, token string, purpose models.TokenPurpose) (*models.VerificationToken, error) {
+	stored, err := s.verificationTokens.Consume(ctx, utils.HashToken(token), purpose, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("purpose", purpose).Warn("Invalid, used or expired verification token")
+			return nil, ErrInvalidVerificationToken
+		}
+		s.logger.WithError(err).Error("Failed to consume verification token")
+		return nil, err
+	}
+	return stored, nil
+}
+
+// findVerificationToken looks a token up like consumeVerificationToken, but
+// leaves it unused.
+func (s *AuthService) findVerificationToken(ctx context.Context, token string, purpose models.TokenPurpose) (*models.VerificationToken, error) {
+	stored, err := s.verificationTokens.Find(ctx, utils.HashToken(token), purpose, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("purpose", purpose).Warn("Invalid, used or expired verification token")
+			return nil, ErrInvalidVerificationToken
+		}
+		s.logger.WithError(err).Error("Failed to look up verification token")
+		return nil, err
+	}
+	return stored, nil
+}
+
+// userForToken loads the owner of a consumed token and checks that the token
+// was issued for the email the account still has.
+func (s *AuthService) userForToken(ctx context.Context, stored *models.VerificationToken) (*models.User, error) {
+	user, err := s.users.FindByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("user_id", stored.UserID.Hex()).Warn("User for verification token not found")
+			return nil, ErrInvalidVerificationToken
+		}
+		s.logger.WithError(err).Error("Failed to look up user")
+		return nil, err
+	}
+
+	if user.Email != stored.Email {
+		s.logger.WithField("user_id", stored.UserID.Hex()).Warn("Verification token issued for a previous email")
+		return nil, ErrInvalidVerificationToken
+	}
+	return user, nil
+}
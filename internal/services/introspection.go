@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"time"
+)
+
+// Introspect reports whether an access token is currently usable. Only a
+// confidential OAuth client may ask (RFC 7662 section 2.1): it authenticates
+// with one of methods like at the token endpoint, and a failure is an
+// invalid_client OAuthError. Invalid or revoked tokens are not an error: they
+// are reported as inactive.
+func (s *AuthService) Introspect(credentials models.TokenRequest, token string, methods ...string) (*models.TokenIntrospection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if containsAll(methods, []string{models.ClientAuthNone}) {
+		return nil, oauthError(OAuthInvalidClient, "client authentication is required")
+	}
+	client, err := s.authenticateClient(ctx, credentials, methods...)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.WithField("client_id", client.ID).Debug("Introspecting token")
+
+	claims, err := s.jwtManager.VerifyToken(token)
+	if err != nil {
+		s.logger.WithError(err).Debug("Introspected token failed verification")
+		return &models.TokenIntrospection{Active: false}, nil
+	}
+
+	result := &models.TokenIntrospection{
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		Role:        claims.Role,
+		Scope:       claims.Scope,
+		Permissions: claims.Permissions,
+		ClientID:    claims.ClientID,
+		Issuer:      claims.Issuer,
+		JTI:         claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	user, err := s.checkAccessToken(ctx, claims)
+	switch {
+	case errors.Is(err, ErrTokenRevoked):
+		result.Revoked = true
+		return result, nil
+	case errors.Is(err, ErrInvalidToken):
+		return &models.TokenIntrospection{Active: false}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if user != nil {
+		if !user.IsActive {
+			return result, nil
+		}
+		if result.Role == "" {
+			result.Role = string(user.Role)
+		}
+	}
+	result.Active = true
+	result.TokenType = "access_token"
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"github/alexnoodl/raiko-auth/internal/models"
+	"testing"
+)
+
+func TestIntrospectRequiresClientAuthentication(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createPublicClient(t, s)
+
+	service, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                "reports",
+		GrantTypes:              []string{models.GrantClientCredentials},
+		TokenEndpointAuthMethod: models.ClientAuthClientSecretBasic,
+		Scope:                   "orders:read",
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		credentials models.TokenRequest
+		method      string
+		code        string
+	}{
+		{
+			name:   "no credentials",
+			method: models.ClientAuthNone,
+			code:   OAuthInvalidClient,
+		},
+		{
+			name:        "public client",
+			credentials: models.TokenRequest{ClientID: testClientID},
+			method:      models.ClientAuthNone,
+			code:        OAuthInvalidClient,
+		},
+		{
+			name:        "wrong secret",
+			credentials: models.TokenRequest{ClientID: "reports", ClientSecret: "wrong"},
+			method:      models.ClientAuthClientSecretBasic,
+			code:        OAuthInvalidClient,
+		},
+		{
+			name:        "unknown client",
+			credentials: models.TokenRequest{ClientID: "unknown", ClientSecret: service.ClientSecret},
+			method:      models.ClientAuthClientSecretBasic,
+			code:        OAuthInvalidClient,
+		},
+		{
+			name:        "registered secret",
+			credentials: models.TokenRequest{ClientID: "reports", ClientSecret: service.ClientSecret},
+			method:      models.ClientAuthClientSecretBasic,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Introspect(tt.credentials, accessToken, tt.method)
+			if tt.code != "" {
+				if !isOAuthError(err, tt.code) {
+					t.Errorf("err = %v, want %s", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("introspect: %v", err)
+			}
+			if !result.Active {
+				t.Errorf("token is inactive")
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts")
+)
+
+// LoginThrottledError wraps ErrTooManyLoginAttempts with the time the client
+// has to wait before trying again.
+type LoginThrottledError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfterSeconds rounds the wait up, as Retry-After takes whole seconds.
+func (e *LoginThrottledError) RetryAfterSeconds() int64 {
+	return int64((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// checkIPThrottle refuses a login from an IP that has to wait after its last
+// failure. The password is not checked in that case.
+func (s *AuthService) checkIPThrottle(ctx context.Context, ip string, now time.Time) error {
+	if ip == "" {
+		return nil
+	}
+
+	record, err := s.loginFailures.Find(ctx, ip, now)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		s.logger.WithError(err).Error("Failed to look up login failures")
+		return err
+	}
+
+	wait := record.LastFailedAt.Add(s.loginDelay(record.Count, s.cfg.LoginIPDelayAfter)).Sub(now)
+	if wait > 0 {
+		s.logger.WithFields(logrus.Fields{
+			"ip":       ip,
+			"failures": record.Count,
+		}).Warn("Login refused, client IP has to wait")
+		return &LoginThrottledError{Err: ErrTooManyLoginAttempts, RetryAfter: wait}
+	}
+	return nil
+}
+
+// checkUserThrottle refuses a login while the account is locked or has to
+// wait after its last failure. Both are answered like an unknown login, and
+// the password is not checked: only the IP throttle tells a client how long
+// to wait, since an unknown login can never trigger the account one. The
+// audit log records the refusal.
+func (s *AuthService) checkUserThrottle(ctx context.Context, user *models.User, ip string, now time.Time) error {
+	details := map[string]string{"ip": ip}
+	switch {
+	case user.IsLocked(now):
+		s.logger.WithField("email", user.Email).Warn("Login attempt on locked account")
+		details["reason"] = "account_locked"
+		details["locked_until"] = user.LockedUntil.UTC().Format(time.RFC3339)
+	case s.accountWait(user, now) > 0:
+		s.logger.WithFields(logrus.Fields{
+			"email":    user.Email,
+			"failures": user.FailedLogins,
+		}).Warn("Login refused, account has to wait")
+		details["reason"] = "account_throttled"
+	default:
+		return nil
+	}
+
+	s.recordEvent(ctx, user.ID, models.AuditLoginFailed, details)
+	s.recordIPFailure(ctx, ip, now)
+	return ErrInvalidCredentials
+}
+
+// accountWait is how long the account has to wait after its last failure.
+func (s *AuthService) accountWait(user *models.User, now time.Time) time.Duration {
+	if !user.LastFailedLoginAt.After(now.Add(-s.cfg.LoginFailureWindow)) {
+		return 0
+	}
+	return user.LastFailedLoginAt.Add(s.loginDelay(user.FailedLogins, s.cfg.LoginDelayAfter)).Sub(now)
+}
+
+// loginDelay is the wait after failures failed logins. The first free ones
+// cost nothing, every further failure doubles the delay up to LoginDelayMax.
+func (s *AuthService) loginDelay(failures, free int) time.Duration {
+	if failures <= free || s.cfg.LoginDelayBase <= 0 {
+		return 0
+	}
+
+	delay := s.cfg.LoginDelayBase
+	for i := free + 1; i < failures && delay < s.cfg.LoginDelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.LoginDelayMax)
+}
+
+// recordIPFailure counts a failed login against the client IP. The login has
+// failed already, so storage errors are only logged.
+func (s *AuthService) recordIPFailure(ctx context.Context, ip string, now time.Time) {
+	if ip == "" {
+		return
+	}
+
+	if _, err := s.loginFailures.Record(ctx, ip, now, now.Add(s.cfg.LoginFailureWindow)); err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"ip":    ip,
+			"error": err,
+		}).Error("Failed to record login failure")
+	}
+}
+
+// recordUserFailure counts a wrong password or second factor against the
+// account and locks it once LoginLockoutThreshold failures fall into the
+// failure window.
+func (s *AuthService) recordUserFailure(ctx context.Context, user *models.User, ip string, now time.Time) {
+	failures, err := s.users.RecordLoginFailure(ctx, user.ID, now, now.Add(-s.cfg.LoginFailureWindow))
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to record login failure")
+		return
+	}
+
+	if s.cfg.LoginLockoutThreshold <= 0 || failures < s.cfg.LoginLockoutThreshold {
+		return
+	}
+
+	until := now.Add(s.cfg.LoginLockoutDuration)
+	if err := s.users.Lock(ctx, user.ID, until); err != nil {
+		s.logger.WithError(err).Error("Failed to lock account")
+		return
+	}
+	s.recordEvent(ctx, user.ID, models.AuditAccountLocked, map[string]string{
+		"ip":    ip,
+		"until": until.UTC().Format(time.RFC3339),
+	})
+
+	s.logger.WithFields(logrus.Fields{
+		"email":    user.Email,
+		"failures": failures,
+		"until":    until,
+	}).Warn("Account locked after repeated login failures")
+}
+
+// resetLoginFailures clears the failures of an account once the user has
+// proven every factor. Failures from the client IP are kept: a single account
+// of its own must not let a client reset the count it guesses other passwords
+// with.
+func (s *AuthService) resetLoginFailures(ctx context.Context, user *models.User) {
+	if user.FailedLogins == 0 && user.LockedUntil.IsZero() {
+		return
+	}
+	if err := s.users.ResetLoginFailures(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to reset login failures")
+	}
+}
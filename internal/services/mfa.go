@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	passwordhash "github/alexnoodl/raiko-auth/pkg/password"
+	"github/alexnoodl/raiko-auth/pkg/totp"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeAlphabet leaves out characters that are easily confused.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+	// totpSkew accepts the previous and the next code to allow for clock drift.
+	totpSkew = 1
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFANotPending     = errors.New("no pending two-factor enrollment")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired MFA token")
+)
+
+// BeginTOTPEnrollment creates a new secret for the user. It only takes effect
+// once ConfirmTOTPEnrollment receives a code generated from it, so starting
+// over simply replaces an unconfirmed secret.
+func (s *AuthService) BeginTOTPEnrollment(userID string) (*models.TOTPSetupResponse, error) {
+	s.logger.WithField("user_id", userID).Info("Starting TOTP enrollment")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := s.mfa.FindTOTP(ctx, user.ID)
+	switch {
+	case err == nil && enrollment.Confirmed:
+		return nil, ErrMFAAlreadyEnabled
+	case err != nil && !errors.Is(err, repository.ErrNotFound):
+		s.logger.WithError(err).Error("Failed to look up TOTP enrollment")
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate TOTP secret")
+		return nil, err
+	}
+
+	// The user ID is bound to the ciphertext, so a secret copied to another
+	// account does not decrypt.
+	sealed, err := s.secrets.Seal([]byte(secret), []byte(user.ID.Hex()))
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to encrypt TOTP secret")
+		return nil, err
+	}
+
+	err = s.mfa.SaveTOTP(ctx, &models.TOTPEnrollment{
+		UserID:    user.ID,
+		Secret:    sealed,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to store TOTP enrollment")
+		return nil, err
+	}
+
+	return &models.TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables the pending enrollment after checking a code
+// from the authenticator app and returns the recovery codes. They are shown
+// only this once.
+func (s *AuthService) ConfirmTOTPEnrollment(userID, code string) ([]string, error) {
+	s.logger.WithField("user_id", userID).Info("Confirming TOTP enrollment")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := s.mfa.FindTOTP(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrMFANotPending
+		}
+		s.logger.WithError(err).Error("Failed to look up TOTP enrollment")
+		return nil, err
+	}
+	if enrollment.Confirmed {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := s.openTOTPSecret(enrollment)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, normalizeMFACode(code), time.Now(), totpSkew)
+	if !ok {
+		s.logger.WithField("user_id", userID).Warn("TOTP enrollment confirmed with invalid code")
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate recovery codes")
+		return nil, err
+	}
+
+	err = s.store.InTransaction(ctx, func(tx *repository.Store) error {
+		if err := tx.MFA.ConfirmTOTP(ctx, user.ID, step, time.Now()); err != nil {
+			return err
+		}
+		return tx.MFA.ReplaceRecoveryCodes(ctx, user.ID, hashes)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrMFANotPending
+		}
+		s.logger.WithError(err).Error("Failed to confirm TOTP enrollment")
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditMFAEnabled, map[string]string{"method": models.MFAMethodTOTP})
+
+	s.logger.WithField("user_id", userID).Info("TOTP enrollment confirmed")
+	return codes, nil
+}
+
+// DisableTOTP removes the authenticator app and the recovery codes after
+// checking the password.
+func (s *AuthService) DisableTOTP(userID, password string) error {
+	s.logger.WithField("user_id", userID).Info("Disabling TOTP")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userWithPassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.confirmedTOTP(ctx, user); err != nil {
+		return err
+	}
+
+	if err := s.mfa.DeleteTOTP(ctx, user.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to delete TOTP enrollment")
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditMFADisabled, map[string]string{"method": models.MFAMethodTOTP})
+
+	s.logger.WithField("user_id", userID).Info("TOTP disabled")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not, with new ones.
+func (s *AuthService) RegenerateRecoveryCodes(userID, password string) ([]string, error) {
+	s.logger.WithField("user_id", userID).Info("Regenerating recovery codes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userWithPassword(ctx, userID, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.confirmedTOTP(ctx, user); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate recovery codes")
+		return nil, err
+	}
+
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		s.logger.WithError(err).Error("Failed to store recovery codes")
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditRecoveryCodesReset, nil)
+
+	return codes, nil
+}
+
+// LoginMFA finishes a login that Login answered with an MFA challenge. The
+// challenge is single-use: after a wrong code the login starts over with the
+// password, which keeps codes from being guessed against one challenge. Wrong
+// codes count as failed logins of the account and of clientIP.
+func (s *AuthService) LoginMFA(mfaToken, code, clientIP string) (*models.TokenPair, error) {
+	s.logger.Info("Starting MFA login")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userForMFAToken(ctx, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := s.verifySecondFactor(ctx, user, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			now := time.Now()
+			s.recordEvent(ctx, user.ID, models.AuditMFAFailed, map[string]string{"ip": clientIP})
+			s.recordIPFailure(ctx, clientIP, now)
+			s.recordUserFailure(ctx, user, clientIP, now)
+		}
+		return nil, err
+	}
+	s.resetLoginFailures(ctx, user)
+
+	// Recovery codes are one-time passwords too.
+	tokens, err := s.issueTokenPair(ctx, user, models.AMRPassword, models.AMROneTimePassword, models.AMRMultiFactor)
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditLoginSucceeded, map[string]string{"mfa": method})
+
+	s.logger.WithFields(logrus.Fields{
+		"email":  user.Email,
+		"method": method,
+	}).Info("MFA login successful")
+	return tokens, nil
+}
+
+// userForMFAToken redeems the challenge issued by Login and returns the user
+// who answered the password.
+func (s *AuthService) userForMFAToken(ctx context.Context, mfaToken string) (*models.User, error) {
+	stored, err := s.consumeVerificationToken(ctx, mfaToken, models.PurposeMFAChallenge)
+	if err != nil {
+		if errors.Is(err, ErrInvalidVerificationToken) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+
+	user, err := s.userForToken(ctx, stored)
+	if err != nil {
+		if errors.Is(err, ErrInvalidVerificationToken) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("MFA login attempt on inactive account")
+		return nil, ErrAccountNotActive
+	}
+	return user, nil
+}
+
+// mfaMethods lists the second factors the user has enabled. An empty list
+// means a password alone completes the login.
+func (s *AuthService) mfaMethods(ctx context.Context, user *models.User) ([]string, error) {
+	var methods []string
+
+	enrollment, err := s.mfa.FindTOTP(ctx, user.ID)
+	switch {
+	case err == nil && enrollment.Confirmed:
+		methods = append(methods, models.MFAMethodTOTP, models.MFAMethodRecoveryCode)
+	case err != nil && !errors.Is(err, repository.ErrNotFound):
+		s.logger.WithError(err).Error("Failed to look up TOTP enrollment")
+		return nil, err
+	}
+
+	credentials, err := s.webAuthnCredentials.ListCredentials(ctx, user.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list WebAuthn credentials")
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, models.MFAMethodWebAuthn)
+	}
+	return methods, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code and returns which one it was.
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *models.User, code string) (string, error) {
+	enrollment, err := s.confirmedTOTP(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	code = normalizeMFACode(code)
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		secret, err := s.openTOTPSecret(enrollment)
+		if err != nil {
+			return "", err
+		}
+
+		step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+		if !ok {
+			s.logger.WithField("user_id", user.ID.Hex()).Warn("Invalid TOTP code")
+			return "", ErrInvalidMFACode
+		}
+		// A code that was already used, even by a concurrent request, is rejected.
+		if err := s.mfa.UseTOTPStep(ctx, user.ID, step); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				s.logger.WithField("user_id", user.ID.Hex()).Warn("TOTP code replayed")
+				return "", ErrInvalidMFACode
+			}
+			s.logger.WithError(err).Error("Failed to record TOTP step")
+			return "", err
+		}
+		return models.MFAMethodTOTP, nil
+	}
+
+	if err := s.mfa.UseRecoveryCode(ctx, user.ID, utils.HashToken(code), time.Now()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("user_id", user.ID.Hex()).Warn("Invalid recovery code")
+			return "", ErrInvalidMFACode
+		}
+		s.logger.WithError(err).Error("Failed to use recovery code")
+		return "", err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditRecoveryCodeUsed, nil)
+	return models.MFAMethodRecoveryCode, nil
+}
+
+func (s *AuthService) issueMFAChallenge(ctx context.Context, user *models.User, methods []string) (*models.LoginResult, error) {
+	token, err := s.issueVerificationToken(ctx, user, models.PurposeMFAChallenge, user.Email, s.cfg.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithField("email", user.Email).Info("Password accepted, second factor required")
+	return &models.LoginResult{MFAToken: token, MFAMethods: methods}, nil
+}
+
+func (s *AuthService) confirmedTOTP(ctx context.Context, user *models.User) (*models.TOTPEnrollment, error) {
+	enrollment, err := s.mfa.FindTOTP(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		s.logger.WithError(err).Error("Failed to look up TOTP enrollment")
+		return nil, err
+	}
+	if !enrollment.Confirmed {
+		return nil, ErrMFANotEnabled
+	}
+	return enrollment, nil
+}
+
+func (s *AuthService) openTOTPSecret(enrollment *models.TOTPEnrollment) (string, error) {
+	secret, err := s.secrets.Open(enrollment.Secret, []byte(enrollment.UserID.Hex()))
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"user_id": enrollment.UserID.Hex(),
+			"error":   err,
+		}).Error("Failed to decrypt TOTP secret")
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// userWithPassword loads the user and checks their current password, for
+// changes that need more than a valid access token. Wrong passwords count
+// against the account like failed logins, and while it is locked or has to
+// wait every password is refused.
+func (s *AuthService) userWithPassword(ctx context.Context, userID, password string) (*models.User, error) {
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if user.IsLocked(now) || s.accountWait(user, now) > 0 {
+		s.logger.WithField("user_id", userID).Warn("Password check refused, account has to wait")
+		s.passwords.VerifyDummy(password)
+		return nil, ErrIncorrectPassword
+	}
+
+	if _, err := s.passwords.Verify(password, user.Password); err != nil {
+		s.logger.WithField("user_id", userID).Warn("Incorrect password")
+		if errors.Is(err, passwordhash.ErrMismatch) {
+			s.recordUserFailure(ctx, user, "", now)
+		}
+		return nil, ErrIncorrectPassword
+	}
+	return user, nil
+}
+
+// generateRecoveryCodes returns codes formatted as xxxxx-xxxxx together with
+// the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := make([]byte, recoveryCodeLength)
+		for j := range code {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			code[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+
+		half := recoveryCodeLength / 2
+		codes = append(codes, string(code[:half])+"-"+string(code[half:]))
+		hashes = append(hashes, utils.HashToken(string(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeMFACode drops the spaces and dashes people type or copy along with
+// a code.
+func normalizeMFACode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
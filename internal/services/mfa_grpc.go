@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	pb "github/alexnoodl/raiko-auth/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *AuthGrpcServer) LoginMFA(ctx context.Context, req *pb.LoginMFARequest) (*pb.LoginResponse, error) {
+	s.logger.Info("gRPC LoginMFA request received")
+
+	tokens, err := s.AuthService.LoginMFA(req.MfaToken, req.Code, middleware.PeerIP(ctx))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC LoginMFA failed")
+		// During login a wrong code is a failed authentication, not a bad request.
+		if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFANotEnabled) {
+			return &pb.LoginResponse{Error: err.Error()}, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return &pb.LoginResponse{Error: err.Error()}, mfaErrorStatus(err)
+	}
+
+	return &pb.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func (s *AuthGrpcServer) BeginTOTPEnrollment(ctx context.Context, req *pb.BeginTOTPEnrollmentRequest) (*pb.TOTPSetupResponse, error) {
+	s.logger.Info("gRPC BeginTOTPEnrollment request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.TOTPSetupResponse{Error: err.Error()}, err
+	}
+
+	setup, err := s.AuthService.BeginTOTPEnrollment(claims.Subject)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC BeginTOTPEnrollment failed")
+		return &pb.TOTPSetupResponse{Error: err.Error()}, mfaErrorStatus(err)
+	}
+
+	return &pb.TOTPSetupResponse{Secret: setup.Secret, OtpauthUri: setup.OTPAuthURI}, nil
+}
+
+func (s *AuthGrpcServer) ConfirmTOTPEnrollment(ctx context.Context, req *pb.ConfirmTOTPEnrollmentRequest) (*pb.RecoveryCodesResponse, error) {
+	s.logger.Info("gRPC ConfirmTOTPEnrollment request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.RecoveryCodesResponse{Error: err.Error()}, err
+	}
+
+	codes, err := s.AuthService.ConfirmTOTPEnrollment(claims.Subject, req.Code)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ConfirmTOTPEnrollment failed")
+		return &pb.RecoveryCodesResponse{Error: err.Error()}, mfaErrorStatus(err)
+	}
+
+	return &pb.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *AuthGrpcServer) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	s.logger.Info("gRPC DisableTOTP request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.DisableTOTPResponse{Error: err.Error()}, err
+	}
+
+	if err := s.AuthService.DisableTOTP(claims.Subject, req.Password); err != nil {
+		s.logger.WithError(err).Error("gRPC DisableTOTP failed")
+		return &pb.DisableTOTPResponse{Error: err.Error()}, mfaErrorStatus(err)
+	}
+
+	return &pb.DisableTOTPResponse{Message: "Two-factor authentication disabled"}, nil
+}
+
+func (s *AuthGrpcServer) RegenerateRecoveryCodes(ctx context.Context, req *pb.RegenerateRecoveryCodesRequest) (*pb.RecoveryCodesResponse, error) {
+	s.logger.Info("gRPC RegenerateRecoveryCodes request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.RecoveryCodesResponse{Error: err.Error()}, err
+	}
+
+	codes, err := s.AuthService.RegenerateRecoveryCodes(claims.Subject, req.Password)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC RegenerateRecoveryCodes failed")
+		return &pb.RecoveryCodesResponse{Error: err.Error()}, mfaErrorStatus(err)
+	}
+
+	return &pb.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func mfaErrorStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidMFAToken),
+		errors.Is(err, ErrAccountNotActive):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrInvalidMFACode):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrIncorrectPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrMFANotEnabled), errors.Is(err, ErrMFANotPending):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
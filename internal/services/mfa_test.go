@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/pkg/totp"
+	"testing"
+	"time"
+)
+
+// enrollTOTP turns on the authenticator app for user and returns its secret.
+func enrollTOTP(t *testing.T, s *AuthService, user *models.User) string {
+	t.Helper()
+
+	setup, err := s.BeginTOTPEnrollment(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("begin TOTP enrollment: %v", err)
+	}
+	code, err := totp.Code(setup.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	if _, err := s.ConfirmTOTPEnrollment(user.ID.Hex(), code); err != nil {
+		t.Fatalf("confirm TOTP enrollment: %v", err)
+	}
+	return setup.Secret
+}
+
+// wrongTOTPCode is a well-formed code far outside the accepted window.
+func wrongTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+1000)
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	return code
+}
+
+func failedLogins(t *testing.T, store *repository.Store, user *models.User) int {
+	t.Helper()
+
+	stored, err := store.Users.FindByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	return stored.FailedLogins
+}
+
+func TestLoginMFACountsWrongCodes(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	secret := enrollTOTP(t, s, user)
+
+	// Every attempt answers the password right, which must not reset the
+	// failures of the second factor.
+	for i := 0; i < 4; i++ {
+		result, err := s.Login("alice", testPassword, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+		if _, err := s.LoginMFA(result.MFAToken, wrongTOTPCode(t, secret), "192.0.2.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("wrong code %d: err = %v, want %v", i+1, err, ErrInvalidMFACode)
+		}
+	}
+	if got := failedLogins(t, store, user); got != 4 {
+		t.Errorf("failed logins = %d, want 4", got)
+	}
+	failures, err := store.LoginFailures.Find(context.Background(), "192.0.2.1", time.Now())
+	if err != nil || failures.Count != 4 {
+		t.Errorf("IP failures = %+v (%v), want 4", failures, err)
+	}
+
+	if _, err := s.Login("alice", testPassword, "192.0.2.2"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("login while the account waits: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestLoginMFAResetsFailures(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	secret := enrollTOTP(t, s, user)
+
+	if _, err := s.Login("alice", "Wrong-Harbor-Lantern-92", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password: err = %v, want %v", err, ErrInvalidCredentials)
+	}
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if got := failedLogins(t, store, user); got != 1 {
+		t.Errorf("failed logins before the second factor = %d, want 1", got)
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+1)
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	if _, err := s.LoginMFA(result.MFAToken, code, "192.0.2.1"); err != nil {
+		t.Fatalf("login MFA: %v", err)
+	}
+	if got := failedLogins(t, store, user); got != 0 {
+		t.Errorf("failed logins after the second factor = %d, want 0", got)
+	}
+}
+
+func TestLoginHidesThrottledAccount(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	for i := 0; i < 4; i++ {
+		if _, err := s.Login("alice", "Wrong-Harbor-Lantern-92", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("wrong password %d: err = %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+
+	_, throttledErr := s.Login("alice", testPassword, "192.0.2.2")
+	_, unknownErr := s.Login("nobody", testPassword, "192.0.2.3")
+	var throttled *LoginThrottledError
+	if errors.As(throttledErr, &throttled) || throttledErr == nil || throttledErr.Error() != unknownErr.Error() {
+		t.Fatalf("waiting account: err = %v, want the answer to an unknown login (%v)", throttledErr, unknownErr)
+	}
+
+	events, err := store.Audit.ListByUser(context.Background(), user.ID, 0)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) == 0 || events[0].Details["reason"] != "account_throttled" {
+		t.Errorf("latest audit event = %+v, want the refused login on the waiting account", events)
+	}
+}
+
+func TestPasswordRecheckCountsFailures(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	enrollTOTP(t, s, user)
+
+	for i := 0; i < 4; i++ {
+		if err := s.DisableTOTP(user.ID.Hex(), "Wrong-Harbor-Lantern-92"); !errors.Is(err, ErrIncorrectPassword) {
+			t.Fatalf("wrong password %d: err = %v, want %v", i+1, err, ErrIncorrectPassword)
+		}
+	}
+	if got := failedLogins(t, store, user); got != 4 {
+		t.Errorf("failed logins = %d, want 4", got)
+	}
+
+	if err := s.DisableTOTP(user.ID.Hex(), testPassword); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("right password while the account waits: err = %v, want %v", err, ErrIncorrectPassword)
+	}
+	if err := s.DeleteWebAuthnCredential(user.ID.Hex(), "unknown", testPassword); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("delete passkey while the account waits: err = %v, want %v", err, ErrIncorrectPassword)
+	}
+}
+
+func TestLoginMFARejectsReusedCodes(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	secret := enrollTOTP(t, s, user)
+
+	current := totp.Step(time.Now())
+	codeAt := func(step int64) string {
+		t.Helper()
+
+		code, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatalf("generate code: %v", err)
+		}
+		return code
+	}
+
+	// Enrollment used the current step, so only the next one is left.
+	tests := []struct {
+		name string
+		code string
+		err  error
+	}{
+		{"code used for enrollment", codeAt(current), ErrInvalidMFACode},
+		{"code from before enrollment", codeAt(current - 1), ErrInvalidMFACode},
+		{"code outside the window", codeAt(current + 2), ErrInvalidMFACode},
+		{"next code", codeAt(current + 1), nil},
+		{"next code again", codeAt(current + 1), ErrInvalidMFACode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Login("alice", testPassword, "192.0.2.1")
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if _, err := s.LoginMFA(result.MFAToken, tt.code, "192.0.2.1"); !errors.Is(err, tt.err) {
+				t.Errorf("login MFA: err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,586 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Error codes of RFC 6749, of RFC 8628 for polling devices, and of RFC 7591
+// and RFC 6750 for client registration.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+	OAuthServerError             = "server_error"
+	OAuthAuthorizationPending    = "authorization_pending"
+	OAuthSlowDown                = "slow_down"
+	OAuthExpiredToken            = "expired_token"
+	OAuthInvalidRedirectURI      = "invalid_redirect_uri"
+	OAuthInvalidClientMetadata   = "invalid_client_metadata"
+	OAuthInvalidToken            = "invalid_token"
+)
+
+const (
+	authorizationRequestIDSize = 32
+	authorizationCodeSize      = 32
+	maxNonceLength             = 512
+)
+
+var (
+	ErrAuthorizationRequestNotFound = errors.New("authorization request not found or expired")
+	ErrInvalidScope                 = errors.New("scope exceeds the grant")
+)
+
+// OAuthError is an error to report to an OAuth client in the format of
+// RFC 6749.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// PKCE code challenges and verifiers use the unreserved characters of
+// RFC 7636. An S256 challenge is always 43 characters long.
+var (
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+)
+
+// Authorize validates a request to /oauth/authorize, stores it and returns
+// where to send the browser: the login page, or back to the client with an
+// error. As long as the client and redirect URI are not known to be valid,
+// there is nowhere safe to redirect to, and the OAuthError is returned
+// without a location.
+func (s *AuthService) Authorize(req models.AuthorizeRequest) (string, error) {
+	s.logger.WithField("client_id", req.ClientID).Info("Starting authorization request")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.findOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", oauthError(OAuthInvalidRequest, "unknown client_id")
+		}
+		return "", err
+	}
+	if req.RedirectURI == "" || !client.AllowsRedirectURI(req.RedirectURI) {
+		s.logger.WithFields(logrus.Fields{
+			"client_id":    client.ID,
+			"redirect_uri": req.RedirectURI,
+		}).Warn("Authorization request with unregistered redirect URI")
+		return "", oauthError(OAuthInvalidRequest, "redirect_uri is not registered for the client")
+	}
+
+	fail := func(err *OAuthError) (string, error) {
+		s.logger.WithFields(logrus.Fields{
+			"client_id": client.ID,
+			"error":     err,
+		}).Warn("Authorization request rejected")
+		return authorizationResponse(req.RedirectURI, url.Values{
+			"error":             {err.Code},
+			"error_description": {err.Description},
+			"state":             {req.State},
+		}), err
+	}
+
+	if req.ResponseType != "code" {
+		return fail(oauthError(OAuthUnsupportedResponseType, "only response_type=code is supported"))
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return fail(oauthError(OAuthUnauthorizedClient, "the client may not use the authorization code grant"))
+	}
+	if req.CodeChallengeMethod != models.PKCEMethodS256 || !codeChallengePattern.MatchString(req.CodeChallenge) {
+		return fail(oauthError(OAuthInvalidRequest, "PKCE with code_challenge_method=S256 is required"))
+	}
+
+	if len(req.Nonce) > maxNonceLength {
+		return fail(oauthError(OAuthInvalidRequest, "nonce is too long"))
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = uniqueScopes(strings.Fields(req.Scope))
+		if !containsAll(client.Scopes, scopes) {
+			return fail(oauthError(OAuthInvalidScope, "the client may not request these scopes"))
+		}
+	}
+
+	// Clients registered earlier may outlive the settings that required a
+	// login page at startup.
+	if s.cfg.OAuthLoginURL == "" {
+		s.logger.Error("OAUTH_LOGIN_URL is not set, authorization requests cannot be answered")
+		return fail(oauthError(OAuthServerError, ""))
+	}
+
+	requestID, err := utils.GenerateRandomToken(authorizationRequestIDSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate authorization request ID")
+		location, _ := fail(oauthError(OAuthServerError, ""))
+		return location, err
+	}
+
+	now := time.Now()
+	err = s.oauth.SaveAuthorizationRequest(ctx, &models.AuthorizationRequest{
+		TokenHash:           utils.HashToken(requestID),
+		ClientID:            client.ID,
+		RedirectURI:         req.RedirectURI,
+		Scopes:              scopes,
+		State:               req.State,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(s.cfg.OAuthRequestTTL),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to store authorization request")
+		location, _ := fail(oauthError(OAuthServerError, ""))
+		return location, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("Authorization request waiting for login")
+	return authorizationResponse(s.cfg.OAuthLoginURL, url.Values{"request_id": {requestID}}), nil
+}
+
+// GetAuthorizationRequest describes a pending request to the user who is
+// about to approve it.
+func (s *AuthService) GetAuthorizationRequest(requestID string) (*models.AuthorizationRequestInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := s.oauth.FindAuthorizationRequest(ctx, utils.HashToken(requestID), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrAuthorizationRequestNotFound
+		}
+		s.logger.WithError(err).Error("Failed to look up authorization request")
+		return nil, err
+	}
+
+	client, err := s.findOAuthClient(ctx, request.ClientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrAuthorizationRequestNotFound
+		}
+		return nil, err
+	}
+
+	return &models.AuthorizationRequestInfo{
+		RequestID:       requestID,
+		ClientID:        client.ID,
+		ClientName:      client.Name,
+		Scopes:          request.Scopes,
+		ConsentRequired: !client.SkipConsent,
+		ExpiresAt:       request.ExpiresAt,
+	}, nil
+}
+
+// AnswerAuthorizationRequest records the decision of the logged-in user on a
+// pending request and returns the redirect back to the client, carrying an
+// authorization code or access_denied. Every request is answered once. The
+// login behind claims becomes the auth_time and amr of the ID token.
+func (s *AuthService) AnswerAuthorizationRequest(claims *jwtmanager.Claims, requestID string, approve bool) (string, error) {
+	userID := claims.Subject
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"approve": approve,
+	}).Info("Starting authorization consent")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := s.oauth.ConsumeAuthorizationRequest(ctx, utils.HashToken(requestID), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", ErrAuthorizationRequestNotFound
+		}
+		s.logger.WithError(err).Error("Failed to consume authorization request")
+		return "", err
+	}
+
+	if !approve {
+		s.recordEvent(ctx, user.ID, models.AuditOAuthConsentDenied, map[string]string{"client_id": request.ClientID})
+		s.logger.WithFields(logrus.Fields{
+			"user_id":   userID,
+			"client_id": request.ClientID,
+		}).Info("Authorization request denied")
+		return authorizationResponse(request.RedirectURI, url.Values{
+			"error":             {OAuthAccessDenied},
+			"error_description": {"the user denied the request"},
+			"state":             {request.State},
+		}), nil
+	}
+
+	code, err := utils.GenerateRandomToken(authorizationCodeSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate authorization code")
+		return "", err
+	}
+
+	now := time.Now()
+	authCode := &models.AuthorizationCode{
+		CodeHash:            utils.HashToken(code),
+		ClientID:            request.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         request.RedirectURI,
+		Scopes:              request.Scopes,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		FamilyID:            primitive.NewObjectID().Hex(),
+		Nonce:               request.Nonce,
+		AuthTime:            loginTime(claims),
+		AMR:                 claims.AMR,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(s.cfg.OAuthCodeTTL),
+	}
+	if err := s.oauth.CreateAuthorizationCode(ctx, authCode); err != nil {
+		s.logger.WithError(err).Error("Failed to store authorization code")
+		return "", err
+	}
+
+	s.recordEvent(ctx, user.ID, models.AuditOAuthConsentGranted, map[string]string{
+		"client_id": request.ClientID,
+		"scope":     strings.Join(request.Scopes, " "),
+	})
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"client_id": request.ClientID,
+	}).Info("Authorization code issued")
+	return authorizationResponse(request.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+	}), nil
+}
+
+// Token handles a request to /oauth/token. authMethod is how the client
+// presented its credentials: client_secret_basic, client_secret_post,
+// private_key_jwt or none.
+// Failures the client should hear about are returned as *OAuthError.
+func (s *AuthService) Token(req models.TokenRequest, authMethod string) (*models.TokenPair, error) {
+	s.logger.WithFields(logrus.Fields{
+		"client_id":  req.ClientID,
+		"grant_type": req.GrantType,
+	}).Info("Starting token request")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := s.authenticateClient(ctx, req, authMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case models.GrantAuthorizationCode:
+		if !client.AllowsGrant(models.GrantAuthorizationCode) {
+			return nil, oauthError(OAuthUnauthorizedClient, "the client may not use this grant type")
+		}
+		return s.exchangeAuthorizationCode(ctx, client, req)
+	case models.GrantRefreshToken:
+		if !client.AllowsGrant(models.GrantRefreshToken) {
+			return nil, oauthError(OAuthUnauthorizedClient, "the client may not use this grant type")
+		}
+		return s.refreshClientToken(ctx, client, req)
+	case models.GrantDeviceCode:
+		if !client.AllowsGrant(models.GrantDeviceCode) {
+			return nil, oauthError(OAuthUnauthorizedClient, "the client may not use this grant type")
+		}
+		return s.exchangeDeviceCode(ctx, client, req)
+	case models.GrantClientCredentials:
+		return s.issueServiceToken(ctx, client, req.Scope)
+	case "":
+		return nil, oauthError(OAuthInvalidRequest, "grant_type is required")
+	default:
+		return nil, oauthError(OAuthUnsupportedGrantType, "")
+	}
+}
+
+func (s *AuthService) exchangeAuthorizationCode(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenPair, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError(OAuthInvalidRequest, "code and code_verifier are required")
+	}
+
+	code, err := s.oauth.FindAuthorizationCode(ctx, utils.HashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("client_id", client.ID).Warn("Authorization code not found")
+			return nil, oauthError(OAuthInvalidGrant, "invalid authorization code")
+		}
+		s.logger.WithError(err).Error("Failed to look up authorization code")
+		return nil, err
+	}
+
+	if code.ClientID != client.ID {
+		s.logger.WithField("client_id", client.ID).Warn("Authorization code presented by another client")
+		return nil, oauthError(OAuthInvalidGrant, "invalid authorization code")
+	}
+	if code.UsedAt != nil {
+		return nil, s.handleCodeReuse(ctx, code)
+	}
+	if !time.Now().Before(code.ExpiresAt) {
+		s.logger.WithField("client_id", client.ID).Warn("Expired authorization code")
+		return nil, oauthError(OAuthInvalidGrant, "invalid authorization code")
+	}
+	if req.RedirectURI != "" && req.RedirectURI != code.RedirectURI {
+		s.logger.WithField("client_id", client.ID).Warn("Authorization code redeemed with another redirect URI")
+		return nil, oauthError(OAuthInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+		s.logger.WithField("client_id", client.ID).Warn("PKCE verification failed")
+		return nil, oauthError(OAuthInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	// Of two concurrent exchanges only one marks the code as used, the other
+	// is treated as reuse.
+	if err := s.oauth.MarkAuthorizationCodeUsed(ctx, code.ID, time.Now()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, s.handleCodeReuse(ctx, code)
+		}
+		s.logger.WithError(err).Error("Failed to mark authorization code as used")
+		return nil, err
+	}
+
+	user, err := s.users.FindByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, oauthError(OAuthInvalidGrant, "invalid authorization code")
+		}
+		s.logger.WithError(err).Error("Failed to load user for authorization code")
+		return nil, err
+	}
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("Authorization code of inactive account")
+		return nil, oauthError(OAuthInvalidGrant, ErrAccountNotActive.Error())
+	}
+
+	pair, err := s.issueTokens(ctx, user, tokenGrant{
+		FamilyID:       code.FamilyID,
+		ClientID:       client.ID,
+		Scopes:         code.Scopes,
+		NoRefreshToken: !client.AllowsGrant(models.GrantRefreshToken),
+		AuthTime:       code.AuthTime,
+		AMR:            code.AMR,
+		Nonce:          code.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"email":     user.Email,
+		"client_id": client.ID,
+	}).Info("Authorization code exchanged")
+	return pair, nil
+}
+
+// handleCodeReuse revokes the refresh tokens obtained with a code that is
+// presented a second time, as either attempt may come from an attacker.
+func (s *AuthService) handleCodeReuse(ctx context.Context, code *models.AuthorizationCode) error {
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   code.UserID.Hex(),
+		"client_id": code.ClientID,
+	}).Warn("Authorization code reuse detected, revoking its tokens")
+
+	if err := s.revokeRefreshFamily(ctx, code.FamilyID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, code.UserID, models.AuditOAuthCodeReused, map[string]string{"client_id": code.ClientID})
+	return oauthError(OAuthInvalidGrant, "invalid authorization code")
+}
+
+func (s *AuthService) refreshClientToken(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenPair, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError(OAuthInvalidRequest, "refresh_token is required")
+	}
+
+	var scopes []string
+	if req.Scope != "" {
+		scopes = uniqueScopes(strings.Fields(req.Scope))
+	}
+
+	pair, err := s.refresh(ctx, req.RefreshToken, client.ID, scopes)
+	switch {
+	case errors.Is(err, ErrInvalidScope):
+		return nil, oauthError(OAuthInvalidScope, "the scope exceeds the original grant")
+	case errors.Is(err, ErrInvalidRefreshToken),
+		errors.Is(err, ErrRefreshTokenReused),
+		errors.Is(err, ErrAccountNotActive):
+		return nil, oauthError(OAuthInvalidGrant, err.Error())
+	case err != nil:
+		return nil, err
+	}
+
+	s.logger.WithField("client_id", client.ID).Info("Client token refresh successful")
+	return pair, nil
+}
+
+// authenticateClient checks the client credentials of a token request. A
+// client has to authenticate with the method it is registered for, which
+// must be one of methods. Clients using private_key_jwt may leave out
+// client_id, as the assertion names them.
+func (s *AuthService) authenticateClient(ctx context.Context, req models.TokenRequest, methods ...string) (*models.OAuthClient, error) {
+	clientID := req.ClientID
+	if clientID == "" && req.ClientAssertion != "" {
+		clientID = assertionSubject(req.ClientAssertion)
+	}
+	if clientID == "" {
+		return nil, oauthError(OAuthInvalidClient, "client authentication is required")
+	}
+
+	client, err := s.findOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("client_id", clientID).Warn("Token request from unknown client")
+			return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+		}
+		return nil, err
+	}
+
+	if !containsAll(methods, []string{client.TokenEndpointAuthMethod}) {
+		s.logger.WithFields(logrus.Fields{
+			"client_id": clientID,
+			"methods":   methods,
+		}).Warn("Client used another authentication method than registered")
+		return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case models.ClientAuthNone:
+	case models.ClientAuthPrivateKeyJWT:
+		if req.ClientAssertionType != models.ClientAssertionTypeJWTBearer || req.ClientAssertion == "" {
+			return nil, oauthError(OAuthInvalidClient, "a client assertion of type jwt-bearer is required")
+		}
+		if err := s.verifyClientAssertion(ctx, client, req.ClientAssertion); err != nil {
+			return nil, err
+		}
+	default:
+		if subtle.ConstantTimeCompare([]byte(utils.HashToken(req.ClientSecret)), []byte(client.SecretHash)) != 1 {
+			s.logger.WithField("client_id", clientID).Warn("Client authentication failed")
+			return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+		}
+	}
+	return client, nil
+}
+
+func (s *AuthService) findOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, repository.ErrNotFound
+	}
+	client, err := s.oauth.FindClient(ctx, clientID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		s.logger.WithError(err).Error("Failed to look up OAuth client")
+	}
+	return client, err
+}
+
+// loginTime is when the user behind claims logged in. Tokens from before
+// auth_time was recorded fall back to their issue time.
+func loginTime(claims *jwtmanager.Claims) time.Time {
+	switch {
+	case claims.AuthTime != nil:
+		return claims.AuthTime.Time
+	case claims.IssuedAt != nil:
+		return claims.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge
+// of the authorization request.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// authorizationResponse adds params to the query of target, leaving out
+// empty ones. The target was validated before, so parse errors cannot occur.
+func authorizationResponse(target string, params url.Values) string {
+	location, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := location.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	location.RawQuery = query.Encode()
+	return location.String()
+}
+
+func uniqueScopes(scopes []string) []string {
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !containsAll(unique, []string{scope}) {
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
+
+// containsAll reports whether every value of subset is in set.
+func containsAll(set, subset []string) bool {
+	for _, value := range subset {
+		found := false
+		for _, candidate := range set {
+			if candidate == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// intersect returns the values of a that are also in b, in the order of a.
+func intersect(a, b []string) []string {
+	var common []string
+	for _, value := range a {
+		if containsAll(b, []string{value}) {
+			common = append(common, value)
+		}
+	}
+	return common
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{1,128}$`)
+	// scopePattern is the scope-token of RFC 6749.
+	scopePattern = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+)
+
+// minClientSecretLength keeps guessable secrets out, as secrets are compared
+// by their SHA-256 like other high-entropy tokens.
+const minClientSecretLength = 32
+
+// EnsureOAuthClients saves the clients configured in OAUTH_CLIENTS_FILE,
+// replacing earlier versions of them.
+func (s *AuthService) EnsureOAuthClients(configs []models.OAuthClientConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, config := range configs {
+		client, err := newOAuthClient(config)
+		if err != nil {
+			return fmt.Errorf("OAuth client %q: %w", config.ClientID, err)
+		}
+		if err := s.oauth.SaveClient(ctx, client); err != nil {
+			s.logger.WithError(err).WithField("client_id", client.ID).Error("Failed to save OAuth client")
+			return err
+		}
+		s.logger.WithField("client_id", client.ID).Info("OAuth client configured")
+	}
+	return nil
+}
+
+func newOAuthClient(config models.OAuthClientConfig) (*models.OAuthClient, error) {
+	now := time.Now()
+	client := &models.OAuthClient{
+		ID:                      config.ClientID,
+		Name:                    config.Name,
+		RedirectURIs:            config.RedirectURIs,
+		PostLogoutRedirectURIs:  config.PostLogoutRedirectURIs,
+		Scopes:                  uniqueScopes(config.Scopes),
+		GrantTypes:              config.GrantTypes,
+		TokenEndpointAuthMethod: config.TokenEndpointAuthMethod,
+		SkipConsent:             config.SkipConsent,
+		CreatedAt:               now,
+		UpdatedAt:               now,
+	}
+	if client.Name == "" {
+		client.Name = client.ID
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	}
+	if client.TokenEndpointAuthMethod == "" {
+		client.TokenEndpointAuthMethod = models.ClientAuthClientSecretBasic
+	}
+
+	if err := validateOAuthClient(client); err != nil {
+		return nil, err
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case models.ClientAuthPrivateKeyJWT:
+		if len(config.JWKS) == 0 {
+			return nil, fmt.Errorf("private_key_jwt clients need a jwks")
+		}
+		if _, err := jwtmanager.ParseJWKS(config.JWKS); err != nil {
+			return nil, fmt.Errorf("invalid jwks: %w", err)
+		}
+		client.JWKS = string(config.JWKS)
+	default:
+		if len(config.JWKS) != 0 {
+			return nil, fmt.Errorf("a jwks is only used with private_key_jwt")
+		}
+	}
+
+	switch {
+	case (client.TokenEndpointAuthMethod == models.ClientAuthNone ||
+		client.TokenEndpointAuthMethod == models.ClientAuthPrivateKeyJWT) && config.ClientSecret != "":
+		return nil, fmt.Errorf("%s clients must not have a client_secret", client.TokenEndpointAuthMethod)
+	case client.TokenEndpointAuthMethod == models.ClientAuthPrivateKeyJWT:
+	case client.TokenEndpointAuthMethod != models.ClientAuthNone && len(config.ClientSecret) < minClientSecretLength:
+		return nil, fmt.Errorf("client_secret must be at least %d characters long", minClientSecretLength)
+	case config.ClientSecret != "":
+		client.SecretHash = utils.HashToken(config.ClientSecret)
+	}
+	return client, nil
+}
+
+// validateOAuthClient checks the registration of a client. Redirect URIs must
+// be absolute without a fragment and use https, except for http on the
+// loopback interface and private-use schemes of native apps (RFC 8252).
+func validateOAuthClient(client *models.OAuthClient) error {
+	if !clientIDPattern.MatchString(client.ID) {
+		return fmt.Errorf("invalid client_id")
+	}
+	// Service tokens have the client ID as subject, which must never be
+	// mistaken for a user ID.
+	if primitive.IsValidObjectID(client.ID) {
+		return fmt.Errorf("client_id must not look like a user ID")
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case models.ClientAuthNone, models.ClientAuthClientSecretBasic, models.ClientAuthClientSecretPost,
+		models.ClientAuthPrivateKeyJWT:
+	default:
+		return fmt.Errorf("unsupported token_endpoint_auth_method %q", client.TokenEndpointAuthMethod)
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials,
+			models.GrantDeviceCode:
+		default:
+			return fmt.Errorf("unsupported grant type %q", grantType)
+		}
+	}
+
+	if client.AllowsGrant(models.GrantClientCredentials) && client.TokenEndpointAuthMethod == models.ClientAuthNone {
+		return fmt.Errorf("public clients cannot use the client credentials grant")
+	}
+
+	if client.AllowsGrant(models.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return fmt.Errorf("the authorization code grant needs at least one redirect URI")
+	}
+	for _, uri := range client.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return fmt.Errorf("redirect URI %q: %w", uri, err)
+		}
+	}
+	for _, uri := range client.PostLogoutRedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return fmt.Errorf("post-logout redirect URI %q: %w", uri, err)
+		}
+	}
+
+	for _, scope := range client.Scopes {
+		if !scopePattern.MatchString(scope) {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func validateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() {
+		return fmt.Errorf("must be an absolute URI")
+	}
+	if parsed.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("must not contain a fragment")
+	}
+
+	switch scheme := strings.ToLower(parsed.Scheme); {
+	case scheme == "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("must name a host")
+		}
+	case scheme == "http":
+		if !isLoopback(parsed.Hostname()) {
+			return fmt.Errorf("http is only allowed for loopback addresses")
+		}
+	case strings.Contains(scheme, "."):
+		// A private-use scheme in reverse domain name notation, such as
+		// com.example.app:/callback.
+	default:
+		return fmt.Errorf("scheme %q is not allowed", parsed.Scheme)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
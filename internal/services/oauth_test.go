@@ -0,0 +1,311 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/servicetest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testClientID    = "app"
+	testRedirectURI = "https://client.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// testChallenge is the S256 code challenge of testVerifier.
+func testChallenge() string {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// createPublicClient creates a client that uses the authorization code
+// grant with PKCE and refresh tokens, without a secret.
+func createPublicClient(t *testing.T, s *AuthService) {
+	t.Helper()
+
+	_, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                testClientID,
+		ClientName:              "App",
+		RedirectURIs:            []string{testRedirectURI},
+		GrantTypes:              []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		TokenEndpointAuthMethod: models.ClientAuthNone,
+		Scope:                   "openid profile:read",
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+}
+
+// authorizationCode runs /oauth/authorize and the consent of the user behind
+// accessToken, and returns the code sent to the redirect URI.
+func authorizationCode(t *testing.T, s *AuthService, accessToken string, req models.AuthorizeRequest) string {
+	t.Helper()
+
+	location, err := s.Authorize(req)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	requestID := queryParam(t, location, "request_id")
+
+	claims, err := s.ValidateAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	location, err = s.AnswerAuthorizationRequest(claims, requestID, true)
+	if err != nil {
+		t.Fatalf("consent: %v", err)
+	}
+	return queryParam(t, location, "code")
+}
+
+func authorizeRequest() models.AuthorizeRequest {
+	return models.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            testClientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               "openid profile:read",
+		State:               "xyz",
+		CodeChallenge:       testChallenge(),
+		CodeChallengeMethod: models.PKCEMethodS256,
+	}
+}
+
+func queryParam(t *testing.T, location, name string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("parse %s: %v", location, err)
+	}
+	value := parsed.Query().Get(name)
+	if value == "" {
+		t.Fatalf("%s has no %s", location, name)
+	}
+	return value
+}
+
+// loginAccessToken logs in as a verified user and returns the access token.
+func loginAccessToken(t *testing.T, s *AuthService, mail *servicetest.Mailer) string {
+	t.Helper()
+
+	registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return result.Tokens.AccessToken
+}
+
+func TestDeleteClientDropsRefreshTokens(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createPublicClient(t, s)
+
+	code := authorizationCode(t, s, accessToken, authorizeRequest())
+	pair, err := s.Token(models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		ClientID:     testClientID,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+	}, models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("exchange code: %v", err)
+	}
+
+	if err := s.DeleteClient(testClientID); err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	createPublicClient(t, s)
+
+	_, err = s.Token(models.TokenRequest{
+		GrantType:    models.GrantRefreshToken,
+		ClientID:     testClientID,
+		RefreshToken: pair.RefreshToken,
+	}, models.ClientAuthNone)
+	if !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("refresh after the client was re-created: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+}
+
+// codeExchange is the token request redeeming code for the public client.
+func codeExchange(code string) models.TokenRequest {
+	return models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		ClientID:     testClientID,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+	}
+}
+
+func TestAuthorizeRejects(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	createPublicClient(t, s)
+
+	tests := []struct {
+		name string
+		edit func(*models.AuthorizeRequest)
+		code string
+		// redirect tells whether the error is sent back to the client.
+		redirect bool
+	}{
+		{"unknown client", func(r *models.AuthorizeRequest) { r.ClientID = "unknown" }, OAuthInvalidRequest, false},
+		{"unregistered redirect_uri", func(r *models.AuthorizeRequest) { r.RedirectURI = "https://evil.example.com/callback" }, OAuthInvalidRequest, false},
+		{"redirect_uri with another path", func(r *models.AuthorizeRequest) { r.RedirectURI = testRedirectURI + "/next" }, OAuthInvalidRequest, false},
+		{"no redirect_uri", func(r *models.AuthorizeRequest) { r.RedirectURI = "" }, OAuthInvalidRequest, false},
+		{"implicit flow", func(r *models.AuthorizeRequest) { r.ResponseType = "token" }, OAuthUnsupportedResponseType, true},
+		{"no code challenge", func(r *models.AuthorizeRequest) { r.CodeChallenge = "" }, OAuthInvalidRequest, true},
+		{"plain code challenge", func(r *models.AuthorizeRequest) { r.CodeChallengeMethod = "plain" }, OAuthInvalidRequest, true},
+		{"malformed code challenge", func(r *models.AuthorizeRequest) { r.CodeChallenge = "short" }, OAuthInvalidRequest, true},
+		{"scope of another client", func(r *models.AuthorizeRequest) { r.Scope = "openid users:delete" }, OAuthInvalidScope, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := authorizeRequest()
+			tt.edit(&req)
+
+			location, err := s.Authorize(req)
+			if !isOAuthError(err, tt.code) {
+				t.Fatalf("err = %v, want %s", err, tt.code)
+			}
+			if !tt.redirect {
+				if location != "" {
+					t.Errorf("redirected to %s", location)
+				}
+				return
+			}
+			if !strings.HasPrefix(location, testRedirectURI+"?") {
+				t.Fatalf("location = %s, want the redirect URI", location)
+			}
+			if got := queryParam(t, location, "error"); got != tt.code {
+				t.Errorf("error = %s, want %s", got, tt.code)
+			}
+			if got := queryParam(t, location, "state"); got != "xyz" {
+				t.Errorf("state = %s, want xyz", got)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createPublicClient(t, s)
+
+	other := authorizeRequest()
+	other.ClientID = "other"
+	if _, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                other.ClientID,
+		RedirectURIs:            []string{testRedirectURI},
+		GrantTypes:              []string{models.GrantAuthorizationCode},
+		TokenEndpointAuthMethod: models.ClientAuthNone,
+		Scope:                   "openid profile:read",
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*models.TokenRequest)
+		code string
+	}{
+		{"wrong code_verifier", func(r *models.TokenRequest) { r.CodeVerifier = strings.Repeat("a", 43) }, OAuthInvalidGrant},
+		{"no code_verifier", func(r *models.TokenRequest) { r.CodeVerifier = "" }, OAuthInvalidRequest},
+		{"other redirect_uri", func(r *models.TokenRequest) { r.RedirectURI = "https://client.example.com/other" }, OAuthInvalidGrant},
+		{"other client", func(r *models.TokenRequest) { r.ClientID = other.ClientID }, OAuthInvalidGrant},
+		{"unknown code", func(r *models.TokenRequest) { r.Code = "unknown" }, OAuthInvalidGrant},
+		{"no grant_type", func(r *models.TokenRequest) { r.GrantType = "" }, OAuthInvalidRequest},
+		{"password grant", func(r *models.TokenRequest) { r.GrantType = "password" }, OAuthUnsupportedGrantType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := authorizationCode(t, s, accessToken, authorizeRequest())
+			req := codeExchange(code)
+			tt.edit(&req)
+
+			if _, err := s.Token(req, models.ClientAuthNone); !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+
+	// A code presented with the wrong verifier is not spent, as the request
+	// may not come from the client it was issued to.
+	code := authorizationCode(t, s, accessToken, authorizeRequest())
+	wrongVerifier := codeExchange(code)
+	wrongVerifier.CodeVerifier = strings.Repeat("a", 43)
+	if _, err := s.Token(wrongVerifier, models.ClientAuthNone); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Fatalf("wrong code_verifier: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+	pair, err := s.Token(codeExchange(code), models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("exchange code: %v", err)
+	}
+	if pair.AccessToken == "" || pair.RefreshToken == "" || pair.IDToken == "" {
+		t.Errorf("token pair = %+v, want access, refresh and ID tokens", pair)
+	}
+	claims, err := s.ValidateAccessToken(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if claims.Scope != "openid profile:read" {
+		t.Errorf("scope = %q, want the requested scopes", claims.Scope)
+	}
+}
+
+func TestAuthorizationCodeReuseRevokesTokens(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createPublicClient(t, s)
+
+	code := authorizationCode(t, s, accessToken, authorizeRequest())
+	pair, err := s.Token(codeExchange(code), models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("exchange code: %v", err)
+	}
+
+	if _, err := s.Token(codeExchange(code), models.ClientAuthNone); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Fatalf("second exchange: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+	_, err = s.Token(models.TokenRequest{
+		GrantType:    models.GrantRefreshToken,
+		ClientID:     testClientID,
+		RefreshToken: pair.RefreshToken,
+	}, models.ClientAuthNone)
+	if !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("refresh token of the reused code: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+}
+
+func TestAnswerAuthorizationRequestDenied(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createPublicClient(t, s)
+
+	location, err := s.Authorize(authorizeRequest())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	requestID := queryParam(t, location, "request_id")
+	claims, err := s.ValidateAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+
+	location, err = s.AnswerAuthorizationRequest(claims, requestID, false)
+	if err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	if got := queryParam(t, location, "error"); got != OAuthAccessDenied {
+		t.Errorf("error = %s, want %s", got, OAuthAccessDenied)
+	}
+	if _, err := s.AnswerAuthorizationRequest(claims, requestID, true); !errors.Is(err, ErrAuthorizationRequestNotFound) {
+		t.Errorf("second answer: err = %v, want %v", err, ErrAuthorizationRequestNotFound)
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"time"
+)
+
+var ErrInsufficientScope = errors.New("the access token was not granted the openid scope")
+
+// OpenIDConfiguration returns the discovery document served at
+// /.well-known/openid-configuration. ID tokens can only be verified by
+// clients when they are signed with an asymmetric key.
+func (s *AuthService) OpenIDConfiguration() *models.OpenIDConfiguration {
+	issuer := s.cfg.OIDCIssuer
+	algorithms := s.jwtManager.SigningAlgorithms()
+	if algorithms == nil {
+		algorithms = []string{}
+	}
+
+	config := &models.OpenIDConfiguration{
+		Issuer:                      issuer,
+		AuthorizationEndpoint:       issuer + "/oauth/authorize",
+		TokenEndpoint:               issuer + "/oauth/token",
+		UserInfoEndpoint:            issuer + "/oauth/userinfo",
+		JWKSURI:                     issuer + "/.well-known/jwks.json",
+		EndSessionEndpoint:          issuer + "/oauth/logout",
+		DeviceAuthorizationEndpoint: issuer + "/oauth/device_authorization",
+		ScopesSupported:             []string{models.ScopeOpenID, models.ScopeEmail, models.ScopeProfile},
+		ResponseTypesSupported:      []string{"code"},
+		ResponseModesSupported:      []string{"query"},
+		GrantTypesSupported: []string{
+			models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials, models.GrantDeviceCode,
+		},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: algorithms,
+		TokenEndpointAuthMethodsSupported: []string{
+			models.ClientAuthClientSecretBasic, models.ClientAuthClientSecretPost, models.ClientAuthPrivateKeyJWT, models.ClientAuthNone,
+		},
+		TokenEndpointAuthSigningAlgValuesSupported: jwtmanager.AssertionAlgorithms(),
+		CodeChallengeMethodsSupported:              []string{models.PKCEMethodS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "sid",
+			"email", "email_verified", "preferred_username",
+		},
+		ACRValuesSupported: []string{models.ACRSingleFactor, models.ACRMultiFactor},
+	}
+	if s.cfg.OAuthRegistrationToken != "" {
+		config.RegistrationEndpoint = issuer + "/oauth/register"
+	}
+	return config
+}
+
+// UserInfo returns the claims about the owner of an access token that its
+// scopes allow: the subject for openid, the address for email and the
+// username for profile.
+func (s *AuthService) UserInfo(claims *jwtmanager.Claims) (*models.UserInfo, error) {
+	scopes := claims.Scopes()
+	if !containsAll(scopes, []string{models.ScopeOpenID}) {
+		s.logger.WithFields(logrus.Fields{
+			"user_id":   claims.Subject,
+			"client_id": claims.ClientID,
+		}).Warn("UserInfo request without the openid scope")
+		return nil, ErrInsufficientScope
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &models.UserInfo{Subject: user.ID.Hex()}
+	if containsAll(scopes, []string{models.ScopeEmail}) {
+		verified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	if containsAll(scopes, []string{models.ScopeProfile}) {
+		info.PreferredUsername = user.Username
+	}
+	return info, nil
+}
+
+// EndSession handles RP-initiated logout. The id_token_hint names the client
+// and the session, whose refresh tokens are revoked; access tokens already
+// issued stay valid until they expire. The returned location is the
+// post_logout_redirect_uri with state, or empty when the client gave none.
+func (s *AuthService) EndSession(req models.EndSessionRequest) (string, error) {
+	s.logger.WithField("client_id", req.ClientID).Info("Starting RP-initiated logout")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if req.IDTokenHint == "" {
+		return "", oauthError(OAuthInvalidRequest, "id_token_hint is required")
+	}
+	hint, err := s.jwtManager.ParseIDTokenHint(req.IDTokenHint, s.cfg.OIDCIssuer)
+	if err != nil {
+		s.logger.WithError(err).Warn("Logout with invalid id_token_hint")
+		return "", oauthError(OAuthInvalidRequest, "invalid id_token_hint")
+	}
+	userID, err := primitive.ObjectIDFromHex(hint.Subject)
+	if err != nil || len(hint.Audience) != 1 {
+		s.logger.WithField("subject", hint.Subject).Warn("Logout with malformed id_token_hint")
+		return "", oauthError(OAuthInvalidRequest, "invalid id_token_hint")
+	}
+
+	clientID := hint.Audience[0]
+	if req.ClientID != "" && req.ClientID != clientID {
+		s.logger.WithFields(logrus.Fields{
+			"client_id":      req.ClientID,
+			"hint_client_id": clientID,
+		}).Warn("Logout with id_token_hint of another client")
+		return "", oauthError(OAuthInvalidRequest, "client_id does not match the id_token_hint")
+	}
+
+	client, err := s.findOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", oauthError(OAuthInvalidRequest, "unknown client_id")
+		}
+		return "", err
+	}
+	if req.PostLogoutRedirectURI != "" && !client.AllowsPostLogoutRedirectURI(req.PostLogoutRedirectURI) {
+		s.logger.WithFields(logrus.Fields{
+			"client_id":                client.ID,
+			"post_logout_redirect_uri": req.PostLogoutRedirectURI,
+		}).Warn("Logout with unregistered post_logout_redirect_uri")
+		return "", oauthError(OAuthInvalidRequest, "post_logout_redirect_uri is not registered for the client")
+	}
+
+	if hint.SessionID != "" {
+		if err := s.revokeRefreshFamily(ctx, hint.SessionID); err != nil {
+			return "", err
+		}
+	}
+	s.recordEvent(ctx, userID, models.AuditOAuthLogout, map[string]string{"client_id": client.ID})
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID.Hex(),
+		"client_id": client.ID,
+	}).Info("RP-initiated logout completed")
+	if req.PostLogoutRedirectURI == "" {
+		return "", nil
+	}
+	return authorizationResponse(req.PostLogoutRedirectURI, url.Values{"state": {req.State}}), nil
+}
+
+// generateIDToken signs the ID token of a grant for the client. Its sid is
+// the refresh token family, so logging out with it ends that grant.
+func (s *AuthService) generateIDToken(user *models.User, grant tokenGrant, familyID string) (string, error) {
+	now := time.Now()
+	claims := &jwtmanager.IDTokenClaims{
+		Nonce:     grant.Nonce,
+		AMR:       grant.AMR,
+		SessionID: familyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.cfg.OIDCIssuer,
+			Subject:   user.ID.Hex(),
+			Audience:  jwt.ClaimStrings{grant.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.jwtManager.TokenExpires())),
+		},
+	}
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(grant.AuthTime)
+	}
+	if len(grant.AMR) > 0 {
+		claims.ACR = models.ACRSingleFactor
+		if containsAll(grant.AMR, []string{models.AMRMultiFactor}) {
+			claims.ACR = models.ACRMultiFactor
+		}
+	}
+
+	token, err := s.jwtManager.SignClaims(claims)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to sign ID token")
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	testOIDCClientID     = "portal"
+	testPostLogoutURI    = "https://client.example.com/logged-out"
+	testOIDCClientScopes = "openid email profile"
+	testNonce            = "n-0S6_WzA2Mj"
+)
+
+// createOIDCClient creates a public client allowed every OpenID Connect
+// scope, with a page to return to after logout.
+func createOIDCClient(t *testing.T, s *AuthService) {
+	t.Helper()
+
+	_, err := s.CreateClient(models.ClientMetadata{
+		ClientID:                testOIDCClientID,
+		RedirectURIs:            []string{testRedirectURI},
+		PostLogoutRedirectURIs:  []string{testPostLogoutURI},
+		GrantTypes:              []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		TokenEndpointAuthMethod: models.ClientAuthNone,
+		Scope:                   testOIDCClientScopes,
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+}
+
+// oidcTokens runs the authorization code flow of the OpenID Connect client
+// for scope.
+func oidcTokens(t *testing.T, s *AuthService, accessToken, scope string) *models.TokenPair {
+	t.Helper()
+
+	req := authorizeRequest()
+	req.ClientID = testOIDCClientID
+	req.Scope = scope
+	req.Nonce = testNonce
+	exchange := codeExchange(authorizationCode(t, s, accessToken, req))
+	exchange.ClientID = testOIDCClientID
+
+	pair, err := s.Token(exchange, models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("exchange code: %v", err)
+	}
+	return pair
+}
+
+func TestIDToken(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createOIDCClient(t, s)
+
+	pair := oidcTokens(t, s, accessToken, "openid")
+	claims, err := s.jwtManager.ParseIDTokenHint(pair.IDToken, testOrigin)
+	if err != nil {
+		t.Fatalf("parse ID token: %v", err)
+	}
+	user, err := store.Users.FindByLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+
+	if claims.Subject != user.ID.Hex() || !slices.Equal(claims.Audience, []string{testOIDCClientID}) {
+		t.Errorf("sub = %s, aud = %v, want %s and %s", claims.Subject, claims.Audience, user.ID.Hex(), testOIDCClientID)
+	}
+	if claims.Nonce != testNonce {
+		t.Errorf("nonce = %q, want %q", claims.Nonce, testNonce)
+	}
+	if !slices.Equal(claims.AMR, []string{models.AMRPassword}) || claims.ACR != models.ACRSingleFactor {
+		t.Errorf("amr = %v, acr = %s, want a password login", claims.AMR, claims.ACR)
+	}
+	if claims.AuthTime == nil || claims.SessionID == "" {
+		t.Errorf("auth_time = %v, sid = %q, want both set", claims.AuthTime, claims.SessionID)
+	}
+
+	noOpenID := oidcTokens(t, s, accessToken, "email")
+	if noOpenID.IDToken != "" {
+		t.Error("ID token issued without the openid scope")
+	}
+}
+
+func TestUserInfoScopes(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createOIDCClient(t, s)
+
+	tests := []struct {
+		scope    string
+		email    bool
+		username bool
+		err      error
+	}{
+		{scope: "openid"},
+		{scope: "openid email", email: true},
+		{scope: "openid profile", username: true},
+		{scope: testOIDCClientScopes, email: true, username: true},
+		{scope: "email profile", err: ErrInsufficientScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			pair := oidcTokens(t, s, accessToken, tt.scope)
+			claims, err := s.ValidateAccessToken(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("validate access token: %v", err)
+			}
+
+			info, err := s.UserInfo(claims)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("userinfo: err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if info.Subject != claims.Subject {
+				t.Errorf("sub = %s, want %s", info.Subject, claims.Subject)
+			}
+			if got := info.Email != "" && info.EmailVerified != nil && *info.EmailVerified; got != tt.email {
+				t.Errorf("email = %q (verified %v), want it shown: %v", info.Email, info.EmailVerified, tt.email)
+			}
+			if got := info.PreferredUsername == "alice"; got != tt.username {
+				t.Errorf("preferred_username = %q, want it shown: %v", info.PreferredUsername, tt.username)
+			}
+		})
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	accessToken := loginAccessToken(t, s, mail)
+	createOIDCClient(t, s)
+	createPublicClient(t, s)
+
+	pair := oidcTokens(t, s, accessToken, "openid")
+	parts := strings.Split(pair.IDToken, ".")
+
+	tests := []struct {
+		name string
+		req  models.EndSessionRequest
+		code string
+	}{
+		{
+			name: "no id_token_hint",
+			req:  models.EndSessionRequest{PostLogoutRedirectURI: testPostLogoutURI},
+			code: OAuthInvalidRequest,
+		},
+		{
+			name: "tampered id_token_hint",
+			req:  models.EndSessionRequest{IDTokenHint: parts[0] + "." + parts[1] + "x." + parts[2]},
+			code: OAuthInvalidRequest,
+		},
+		{
+			name: "access token as id_token_hint",
+			req:  models.EndSessionRequest{IDTokenHint: accessToken},
+			code: OAuthInvalidRequest,
+		},
+		{
+			name: "client_id of another client",
+			req:  models.EndSessionRequest{IDTokenHint: pair.IDToken, ClientID: testClientID},
+			code: OAuthInvalidRequest,
+		},
+		{
+			name: "unregistered post_logout_redirect_uri",
+			req:  models.EndSessionRequest{IDTokenHint: pair.IDToken, PostLogoutRedirectURI: "https://evil.example.com/"},
+			code: OAuthInvalidRequest,
+		},
+		{
+			name: "redirect_uri instead of post_logout_redirect_uri",
+			req:  models.EndSessionRequest{IDTokenHint: pair.IDToken, PostLogoutRedirectURI: testRedirectURI},
+			code: OAuthInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := s.EndSession(tt.req)
+			if !isOAuthError(err, tt.code) {
+				t.Errorf("err = %v, want %s", err, tt.code)
+			}
+			if location != "" {
+				t.Errorf("redirected to %s", location)
+			}
+		})
+	}
+
+	// None of the rejected requests ended the session.
+	refresh := models.TokenRequest{GrantType: models.GrantRefreshToken, ClientID: testOIDCClientID, RefreshToken: pair.RefreshToken}
+	refreshed, err := s.Token(refresh, models.ClientAuthNone)
+	if err != nil {
+		t.Fatalf("refresh before logout: %v", err)
+	}
+
+	location, err := s.EndSession(models.EndSessionRequest{
+		IDTokenHint:           pair.IDToken,
+		ClientID:              testOIDCClientID,
+		PostLogoutRedirectURI: testPostLogoutURI,
+		State:                 "af0ifjsldkj",
+	})
+	if err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+	if location != testPostLogoutURI+"?state=af0ifjsldkj" {
+		t.Errorf("location = %s, want the post logout redirect URI with state", location)
+	}
+	refresh.RefreshToken = refreshed.RefreshToken
+	if _, err := s.Token(refresh, models.ClientAuthNone); !isOAuthError(err, OAuthInvalidGrant) {
+		t.Errorf("refresh after logout: err = %v, want %s", err, OAuthInvalidGrant)
+	}
+
+	if location, err := s.EndSession(models.EndSessionRequest{IDTokenHint: pair.IDToken}); err != nil || location != "" {
+		t.Errorf("logout without a redirect: location = %q (%v), want none", location, err)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	passwordhash "github/alexnoodl/raiko-auth/pkg/password"
+	"strings"
+)
+
+// PasswordPolicyError lists the rules a new password breaks. It wraps
+// ErrInvalidPassword.
+type PasswordPolicyError struct {
+	Violations []passwordhash.Violation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	codes := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return ErrInvalidPassword.Error() + ": " + strings.Join(codes, ", ")
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrInvalidPassword
+}
+
+// checkNewPassword applies the password policy to a new password for user and
+// makes sure it is none of the latest PasswordHistory passwords. Comparing
+// with earlier passwords means hashing it once for each of them, so that is
+// skipped when the password is rejected anyway.
+func (s *AuthService) checkNewPassword(user *models.User, newPassword string) error {
+	violations := s.passwordPolicy.Check(newPassword, user.Username, user.Email)
+	if len(violations) == 0 && s.isRecentPassword(user, newPassword) {
+		violations = append(violations, passwordhash.Violation{
+			Code:    passwordhash.ViolationReused,
+			Message: "must differ from the recently used passwords",
+		})
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"email":      user.Email,
+		"violations": violations,
+	}).Warn("Password rejected by policy")
+	return &PasswordPolicyError{Violations: violations}
+}
+
+// isRecentPassword reports whether newPassword is the current password of
+// user or one of the earlier ones still covered by the history.
+func (s *AuthService) isRecentPassword(user *models.User, newPassword string) bool {
+	for _, hash := range s.recentPasswordHashes(user) {
+		_, err := s.passwords.Verify(newPassword, hash)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, passwordhash.ErrMismatch) {
+			s.logger.WithError(err).Warn("Failed to compare with an earlier password")
+		}
+	}
+	return false
+}
+
+// recentPasswordHashes returns the hashes of the current and earlier
+// passwords that may not be chosen again, newest first. Once the current
+// password is replaced, that is also the history to store. A password
+// cleared by a forced reset is not a hash and is left out.
+func (s *AuthService) recentPasswordHashes(user *models.User) []string {
+	hashes := make([]string, 0, len(user.PasswordHistory)+1)
+	for _, hash := range append([]string{user.Password}, user.PasswordHistory...) {
+		if hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes[:min(len(hashes), max(s.cfg.PasswordHistory, 0))]
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"errors"
+	passwordhash "github/alexnoodl/raiko-auth/pkg/password"
+	"slices"
+	"testing"
+)
+
+func TestChangePasswordRejectsRecentPasswords(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	passwords := []string{testPassword, "Quiet-Meadow-Compass-57", "Silent-River-Lantern-38", "Amber-Forest-Beacon-64"}
+	for i := 1; i < len(passwords); i++ {
+		if _, err := s.ChangePassword(user.ID.Hex(), passwords[i-1], passwords[i]); err != nil {
+			t.Fatalf("change password %d: %v", i, err)
+		}
+	}
+	current := passwords[len(passwords)-1]
+
+	// The history covers the current password and the two before it.
+	tests := []struct {
+		name     string
+		password string
+		code     string
+	}{
+		{"current password", current, passwordhash.ViolationReused},
+		{"previous password", passwords[2], passwordhash.ViolationReused},
+		{"oldest password in the history", passwords[1], passwordhash.ViolationReused},
+		{"common password", "Password123!", passwordhash.ViolationBanned},
+		{"too short", "Qm-7h", passwordhash.ViolationTooShort},
+		{"password older than the history", passwords[0], ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ChangePassword(user.ID.Hex(), current, tt.password)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("change password: %v", err)
+				}
+				return
+			}
+
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) || !errors.Is(err, ErrInvalidPassword) {
+				t.Fatalf("err = %v, want a password policy error", err)
+			}
+			codes := make([]string, 0, len(policyErr.Violations))
+			for _, violation := range policyErr.Violations {
+				codes = append(codes, violation.Code)
+			}
+			if !slices.Contains(codes, tt.code) {
+				t.Errorf("violations = %v, want %s", codes, tt.code)
+			}
+		})
+	}
+}
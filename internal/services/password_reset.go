@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	"net/url"
+	"time"
+)
+
+// ForgotPassword starts a password reset. Whether the account exists is never
+// reported back, and the email is sent in the background so the response time
+// does not give it away either.
+func (s *AuthService) ForgotPassword(email string) error {
+	s.logger.WithField("email", email).Info("Starting password reset request")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("email", email).Warn("Password reset requested for unknown email")
+			return nil
+		}
+		s.logger.WithError(err).Error("Failed to look up user")
+		return err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := s.sendPasswordReset(ctx, user); err != nil {
+			s.logger.WithFields(logrus.Fields{
+				"email": user.Email,
+				"error": err,
+			}).Error("Failed to send password reset email")
+		}
+	}()
+
+	return nil
+}
+
+func (s *AuthService) ResetPassword(token, newPassword string) error {
+	s.logger.Info("Starting password reset")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The token is only used up once the password has passed the policy, so
+	// the user can try another password with the same link.
+	stored, err := s.findVerificationToken(ctx, token, models.PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userForToken(ctx, stored)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkNewPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	if _, err := s.consumeVerificationToken(ctx, token, models.PurposePasswordReset); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to hash password")
+		return err
+	}
+
+	history := s.recentPasswordHashes(user)
+	if _, err := s.users.Update(ctx, user.ID, repository.UserUpdate{Password: &hashedPassword, PasswordHistory: &history}); err != nil {
+		s.logger.WithError(err).Error("Failed to update password")
+		return err
+	}
+
+	if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditPasswordReset, nil)
+
+	s.logger.WithField("email", stored.Email).Info("Password reset successfully")
+	return nil
+}
+
+func (s *AuthService) sendPasswordReset(ctx context.Context, user *models.User) error {
+	token, err := s.issueVerificationToken(ctx, user, models.PurposePasswordReset, user.Email, s.cfg.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", s.cfg.PasswordResetURL, url.QueryEscape(token))
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello, %s!\n\nSomeone requested a password reset for your account. "+
+			"To choose a new password, open the link below:\n\n%s\n\nReset token: %s\n\n"+
+			"The link expires in %s and can be used once. If you did not request a reset, just ignore this email.\n",
+			user.Username, link, token, s.cfg.PasswordResetTTL),
+	})
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrInvalidEmail      = errors.New("incorrect email")
+	ErrInvalidUsername   = errors.New("username must be between 3 and 20 characters")
+	ErrUsernameTaken     = errors.New("username already exists")
+	ErrEmailTaken        = errors.New("email already exists")
+)
+
+func (s *AuthService) GetProfile(userID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.findUserByID(ctx, userID)
+}
+
+func (s *AuthService) UpdateProfile(userID, username string) (*models.User, error) {
+	s.logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"username": username,
+	}).Info("Starting profile update")
+
+	if len(username) < 3 || len(username) > 20 {
+		return nil, ErrInvalidUsername
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Username == username {
+		return user, nil
+	}
+
+	taken, err := s.users.UsernameTaken(ctx, username, user.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check existing users")
+		return nil, err
+	}
+	if taken {
+		s.logger.WithField("username", username).Warn("Username already exists")
+		return nil, ErrUsernameTaken
+	}
+
+	user, err = s.users.Update(ctx, user.ID, repository.UserUpdate{Username: &username})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, ErrUsernameTaken
+		}
+		s.logger.WithError(err).Error("Failed to update profile")
+		return nil, err
+	}
+
+	s.logger.WithField("user_id", userID).Info("Profile updated successfully")
+	return user, nil
+}
+
+// ChangePassword replaces the password after checking the current one. Every
+// existing session is revoked and the caller gets a fresh token pair instead.
+func (s *AuthService) ChangePassword(userID, currentPassword, newPassword string) (*models.TokenPair, error) {
+	s.logger.WithField("user_id", userID).Info("Starting password change")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userWithPassword(ctx, userID, currentPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNewPassword(user, newPassword); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to hash password")
+		return nil, err
+	}
+
+	history := s.recentPasswordHashes(user)
+	if _, err := s.users.Update(ctx, user.ID, repository.UserUpdate{Password: &hashedPassword, PasswordHistory: &history}); err != nil {
+		s.logger.WithError(err).Error("Failed to update password")
+		return nil, err
+	}
+
+	if err := s.revokeAllSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	// The new pair must not fall under the cutoff just set.
+	user.TokensValidAfter = time.Now()
+	s.recordEvent(ctx, user.ID, models.AuditPasswordChanged, nil)
+
+	s.logger.WithField("user_id", userID).Info("Password changed successfully")
+	return s.issueTokenPair(ctx, user, models.AMRPassword)
+}
+
+// RequestEmailChange sends a confirmation link to the new address. The email
+// on the account only changes once that link is opened.
+func (s *AuthService) RequestEmailChange(userID, newEmail, password string) error {
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"new_email": newEmail,
+	}).Info("Starting email change")
+
+	if !utils.IsValidEmail(newEmail) {
+		s.logger.WithField("new_email", newEmail).Warn("Email validation failed")
+		return ErrInvalidEmail
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userWithPassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkEmailAvailable(ctx, newEmail, user.ID); err != nil {
+		return err
+	}
+
+	token, err := s.issueVerificationToken(ctx, user, models.PurposeEmailChange, newEmail, s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/me/email/confirm?token=%s", s.cfg.AppBaseURL, url.QueryEscape(token))
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello, %s!\n\nTo use this address for your account, open the link below:\n\n%s\n\n"+
+			"The link expires in %s. If you did not request this change, just ignore this email.\n",
+			user.Username, link, s.cfg.EmailVerificationTTL),
+	})
+}
+
+func (s *AuthService) ConfirmEmailChange(token string) error {
+	s.logger.Info("Starting email change confirmation")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.consumeVerificationToken(ctx, token, models.PurposeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	// The address may have been taken while the link was waiting in the inbox.
+	if err := s.checkEmailAvailable(ctx, stored.Email, stored.UserID); err != nil {
+		return err
+	}
+
+	verified := true
+	user, err := s.users.Update(ctx, stored.UserID, repository.UserUpdate{Email: &stored.Email, EmailVerified: &verified})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			s.logger.WithField("user_id", stored.UserID.Hex()).Warn("User for email change token not found")
+			return ErrInvalidVerificationToken
+		case errors.Is(err, repository.ErrDuplicate):
+			return ErrEmailTaken
+		}
+		s.logger.WithError(err).Error("Failed to update email")
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditEmailChanged, nil)
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": stored.UserID.Hex(),
+		"email":   stored.Email,
+	}).Info("Email changed successfully")
+	return nil
+}
+
+func (s *AuthService) findUserByID(ctx context.Context, userID string) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	user, err := s.users.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("user_id", userID).Warn("User not found")
+			return nil, ErrUserNotFound
+		}
+		s.logger.WithError(err).Error("Failed to look up user")
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *AuthService) checkEmailAvailable(ctx context.Context, email string, owner primitive.ObjectID) error {
+	taken, err := s.users.EmailTaken(ctx, email, owner)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check existing users")
+		return err
+	}
+	if taken {
+		s.logger.WithField("email", email).Warn("Email already exists")
+		return ErrEmailTaken
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/middleware"
+	"github/alexnoodl/raiko-auth/internal/models"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	pb "github/alexnoodl/raiko-auth/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *AuthGrpcServer) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.ProfileResponse, error) {
+	s.logger.Info("gRPC GetProfile request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.ProfileResponse{Error: err.Error()}, err
+	}
+
+	user, err := s.AuthService.GetProfile(claims.Subject)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC GetProfile failed")
+		return &pb.ProfileResponse{Error: err.Error()}, profileErrorStatus(err)
+	}
+
+	return &pb.ProfileResponse{Profile: profileToProto(user)}, nil
+}
+
+func (s *AuthGrpcServer) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.ProfileResponse, error) {
+	s.logger.WithField("username", req.Username).Info("gRPC UpdateProfile request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.ProfileResponse{Error: err.Error()}, err
+	}
+
+	user, err := s.AuthService.UpdateProfile(claims.Subject, req.Username)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC UpdateProfile failed")
+		return &pb.ProfileResponse{Error: err.Error()}, profileErrorStatus(err)
+	}
+
+	return &pb.ProfileResponse{Profile: profileToProto(user)}, nil
+}
+
+func (s *AuthGrpcServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	s.logger.Info("gRPC ChangePassword request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.ChangePasswordResponse{Error: err.Error()}, err
+	}
+
+	tokens, err := s.AuthService.ChangePassword(claims.Subject, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ChangePassword failed")
+		return &pb.ChangePasswordResponse{Error: err.Error()}, passwordErrorStatus(err, "new_password")
+	}
+
+	return &pb.ChangePasswordResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func (s *AuthGrpcServer) ChangeEmail(ctx context.Context, req *pb.ChangeEmailRequest) (*pb.ChangeEmailResponse, error) {
+	s.logger.WithField("new_email", req.NewEmail).Info("gRPC ChangeEmail request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.ChangeEmailResponse{Error: err.Error()}, err
+	}
+
+	if err := s.AuthService.RequestEmailChange(claims.Subject, req.NewEmail, req.Password); err != nil {
+		s.logger.WithError(err).Error("gRPC ChangeEmail failed")
+		return &pb.ChangeEmailResponse{Error: err.Error()}, profileErrorStatus(err)
+	}
+
+	return &pb.ChangeEmailResponse{Message: "Check the new address to confirm the change"}, nil
+}
+
+func (s *AuthGrpcServer) ConfirmEmailChange(ctx context.Context, req *pb.ConfirmEmailChangeRequest) (*pb.ConfirmEmailChangeResponse, error) {
+	s.logger.Info("gRPC ConfirmEmailChange request received")
+
+	if err := s.AuthService.ConfirmEmailChange(req.Token); err != nil {
+		s.logger.WithError(err).Error("gRPC ConfirmEmailChange failed")
+		return &pb.ConfirmEmailChangeResponse{Error: err.Error()}, profileErrorStatus(err)
+	}
+
+	return &pb.ConfirmEmailChangeResponse{Message: "Email changed successfully"}, nil
+}
+
+// authenticate returns the claims checked by the auth interceptor. When the
+// interceptor is not installed it validates the bearer token itself.
+func (s *AuthGrpcServer) authenticate(ctx context.Context) (*jwtmanager.Claims, error) {
+	if claims := middleware.ClaimsFromContext(ctx); claims != nil {
+		return claims, nil
+	}
+
+	claims, err := s.AuthService.ValidateAccessToken(middleware.BearerTokenFromContext(ctx))
+	if err != nil {
+		s.logger.WithError(err).Warn("gRPC request with invalid bearer token")
+		return nil, tokenErrorStatus(err)
+	}
+	return claims, nil
+}
+
+func profileErrorStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidPassword),
+		errors.Is(err, ErrInvalidEmail),
+		errors.Is(err, ErrInvalidUsername),
+		errors.Is(err, ErrInvalidVerificationToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrIncorrectPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrUsernameTaken),
+		errors.Is(err, ErrEmailTaken),
+		errors.Is(err, ErrUserExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func profileToProto(user *models.User) *pb.UserProfile {
+	return userProfileToProto(user.Profile())
+}
+
+func userProfileToProto(profile *models.UserProfile) *pb.UserProfile {
+	out := &pb.UserProfile{
+		Id:            profile.ID,
+		Email:         profile.Email,
+		Username:      profile.Username,
+		Role:          string(profile.Role),
+		IsActive:      profile.IsActive,
+		EmailVerified: profile.EmailVerified,
+		CreatedAt:     profile.CreatedAt.Unix(),
+	}
+	if profile.LockedUntil != nil {
+		out.LockedUntil = profile.LockedUntil.Unix()
+	}
+	return out
+}
+
+// passwordErrorStatus is profileErrorStatus for calls that set the password in
+// field. A password rejected by the policy fails with InvalidArgument and a
+// BadRequest detail with a field violation for every broken rule, the code of
+// the rule being its reason.
+func passwordErrorStatus(err error, field string) error {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return profileErrorStatus(err)
+	}
+
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(policyErr.Violations))
+	for _, violation := range policyErr.Violations {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Reason:      violation.Code,
+			Description: violation.Message,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, err.Error())
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"time"
+)
+
+// EnsureDefaultRoles creates the built-in roles that are missing and checks
+// that the configured default role exists.
+func (s *AuthService) EnsureDefaultRoles() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := range models.DefaultRoles {
+		role := &models.DefaultRoles[i]
+		if err := s.roles.CreateIfMissing(ctx, role); err != nil {
+			s.logger.WithError(err).WithField("role", role.Name).Error("Failed to create default role")
+			return err
+		}
+	}
+
+	_, err := s.roles.FindByName(ctx, models.Role(s.cfg.DefaultRole))
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("default role %q is not defined", s.cfg.DefaultRole)
+	}
+	return err
+}
+
+// permissionsForRole returns the permissions granted to role. An unknown role
+// grants nothing, users without a role are treated as having the default one.
+func (s *AuthService) permissionsForRole(ctx context.Context, role models.Role) (models.Role, []string, error) {
+	if role == "" {
+		role = models.Role(s.cfg.DefaultRole)
+	}
+
+	definition, err := s.roles.FindByName(ctx, role)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithField("role", role).Warn("Role is not defined, granting no permissions")
+			return role, nil, nil
+		}
+		s.logger.WithError(err).Error("Failed to load role")
+		return role, nil, err
+	}
+	return role, definition.Permissions, nil
+}
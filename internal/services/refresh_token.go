@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const refreshTokenSize = 32
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// tokenGrant is what a token pair is issued for. Tokens of the first-party
+// login have no ClientID and carry the default scopes, those of OAuth clients
+// the scopes the user approved.
+type tokenGrant struct {
+	// FamilyID is the refresh token family, empty to start a new one.
+	FamilyID string
+	ClientID string
+	Scopes   []string
+	// AccessScopes narrows the scopes of the access token, which defaults to
+	// Scopes. The refresh token keeps the whole grant.
+	AccessScopes []string
+	// NoRefreshToken issues the access token alone.
+	NoRefreshToken bool
+	// AuthTime and AMR describe the login the grant goes back to. Nonce is
+	// echoed in the ID token issued for an authorization code.
+	AuthTime time.Time
+	AMR      []string
+	Nonce    string
+}
+
+func (s *AuthService) Refresh(refreshToken string) (*models.TokenPair, error) {
+	s.logger.Info("Starting token refresh")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.refresh(ctx, refreshToken, "", nil)
+}
+
+// refresh rotates a refresh token of clientID, which is empty for the
+// first-party login. scopes narrows the access token, nil keeps the scopes of
+// the grant.
+func (s *AuthService) refresh(ctx context.Context, refreshToken, clientID string, scopes []string) (*models.TokenPair, error) {
+	stored, err := s.tokens.FindRefreshToken(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.Warn("Refresh token not found")
+			return nil, ErrInvalidRefreshToken
+		}
+		s.logger.WithError(err).Error("Failed to look up refresh token")
+		return nil, err
+	}
+
+	// A token is only accepted from the client it was issued to, and never
+	// counts as reuse when presented by another one.
+	if stored.ClientID != clientID {
+		s.logger.WithFields(logrus.Fields{
+			"family_id": stored.FamilyID,
+			"client_id": clientID,
+		}).Warn("Refresh token presented by another client")
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if scopes != nil && !containsAll(stored.Scopes, scopes) {
+		s.logger.WithField("family_id", stored.FamilyID).Warn("Refresh requested scopes beyond the grant")
+		return nil, ErrInvalidScope
+	}
+
+	if stored.Revoked {
+		s.logger.WithField("family_id", stored.FamilyID).Warn("Refresh attempt with revoked token")
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return nil, s.handleRefreshReuse(ctx, stored)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		s.logger.WithField("family_id", stored.FamilyID).Warn("Refresh attempt with expired token")
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// The token is marked as used with a conditional update, so of two concurrent
+	// refreshes with the same token only one wins and the other is treated as reuse.
+	err = s.tokens.MarkRefreshTokenUsed(ctx, stored.ID, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, s.handleRefreshReuse(ctx, stored)
+		}
+		s.logger.WithError(err).Error("Failed to mark refresh token as used")
+		return nil, err
+	}
+
+	user, err := s.users.FindByID(ctx, stored.UserID)
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"user_id": stored.UserID.Hex(),
+			"error":   err,
+		}).Warn("User for refresh token not found")
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("Refresh attempt on inactive account")
+		return nil, ErrAccountNotActive
+	}
+
+	pair, err := s.issueTokens(ctx, user, tokenGrant{
+		FamilyID:     stored.FamilyID,
+		ClientID:     stored.ClientID,
+		Scopes:       stored.Scopes,
+		AccessScopes: scopes,
+		AuthTime:     stored.AuthTime,
+		AMR:          stored.AMR,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"email":     user.Email,
+		"family_id": stored.FamilyID,
+	}).Info("Token refresh successful")
+	return pair, nil
+}
+
+// issueTokenPair signs an access token and stores a refresh token in a new
+// family for a login with the authentication methods amr.
+func (s *AuthService) issueTokenPair(ctx context.Context, user *models.User, amr ...string) (*models.TokenPair, error) {
+	return s.issueTokens(ctx, user, tokenGrant{AuthTime: time.Now(), AMR: amr})
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, grant tokenGrant) (*models.TokenPair, error) {
+	accessScopes := grant.AccessScopes
+	if accessScopes == nil {
+		accessScopes = grant.Scopes
+	}
+	accessToken, err := s.generateAccessToken(ctx, user, grant, accessScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	familyID := grant.FamilyID
+	if familyID == "" {
+		familyID = primitive.NewObjectID().Hex()
+	}
+
+	pair := &models.TokenPair{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(s.jwtManager.TokenExpires().Seconds()),
+		Scopes:      accessScopes,
+	}
+	if grant.ClientID != "" && containsAll(accessScopes, []string{models.ScopeOpenID}) {
+		if pair.IDToken, err = s.generateIDToken(user, grant, familyID); err != nil {
+			return nil, err
+		}
+	}
+	if grant.NoRefreshToken {
+		return pair, nil
+	}
+
+	refreshToken, err := utils.GenerateRandomToken(refreshTokenSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate refresh token")
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.tokens.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ClientID:  grant.ClientID,
+		Scopes:    grant.Scopes,
+		AuthTime:  grant.AuthTime,
+		AMR:       grant.AMR,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to store refresh token")
+		return nil, err
+	}
+
+	pair.RefreshToken = refreshToken
+	return pair, nil
+}
+
+func (s *AuthService) handleRefreshReuse(ctx context.Context, stored *models.RefreshToken) error {
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   stored.UserID.Hex(),
+		"family_id": stored.FamilyID,
+	}).Warn("Refresh token reuse detected, revoking token family")
+
+	if err := s.revokeRefreshFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, stored.UserID, models.AuditRefreshTokenReused, map[string]string{"family_id": stored.FamilyID})
+	return ErrRefreshTokenReused
+}
+
+func (s *AuthService) revokeRefreshFamily(ctx context.Context, familyID string) error {
+	err := s.tokens.RevokeRefreshFamily(ctx, familyID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to revoke refresh token family")
+	}
+	return err
+}
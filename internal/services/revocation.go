@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// ValidateAccessToken checks the signature and revocation of an access token.
+// Service tokens are accepted too; they carry no permissions, so routes that
+// require one refuse them.
+func (s *AuthService) ValidateAccessToken(tokenString string) (*jwtmanager.Claims, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.validateAccessToken(ctx, tokenString)
+}
+
+func (s *AuthService) Logout(accessToken, refreshToken string) error {
+	s.logger.Info("Starting logout")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims, err := s.validateAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := s.revokeJTI(ctx, claims, userID); err != nil {
+		return err
+	}
+
+	if refreshToken != "" {
+		stored, err := s.tokens.FindRefreshToken(ctx, utils.HashToken(refreshToken))
+		if err == nil && stored.UserID != userID {
+			err = repository.ErrNotFound
+		}
+		switch {
+		case err == nil:
+			if err := s.revokeRefreshFamily(ctx, stored.FamilyID); err != nil {
+				return err
+			}
+		case errors.Is(err, repository.ErrNotFound):
+			s.logger.WithField("user_id", claims.Subject).Warn("Refresh token passed to logout not found")
+		default:
+			s.logger.WithError(err).Error("Failed to look up refresh token")
+			return err
+		}
+	}
+
+	s.logger.WithField("user_id", claims.Subject).Info("Logout successful")
+	return nil
+}
+
+func (s *AuthService) LogoutAll(accessToken string) error {
+	s.logger.Info("Starting logout from all sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims, err := s.validateAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := s.revokeJTI(ctx, claims, userID); err != nil {
+		return err
+	}
+
+	if err := s.revokeAllSessions(ctx, userID); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, userID, models.AuditSessionsRevoked, nil)
+
+	s.logger.WithField("user_id", claims.Subject).Info("Logout from all sessions successful")
+	return nil
+}
+
+// RevokeAllSessions invalidates every access and refresh token issued to the
+// user so far. It is meant for password changes and administrative actions.
+func (s *AuthService) RevokeAllSessions(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.revokeAllSessions(ctx, userID)
+}
+
+func (s *AuthService) revokeAllSessions(ctx context.Context, userID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := s.users.Update(ctx, userID, repository.UserUpdate{TokensValidAfter: &now})
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		s.logger.WithError(err).Error("Failed to update tokens_valid_after")
+		return err
+	}
+
+	err = s.tokens.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to revoke refresh tokens")
+		return err
+	}
+
+	s.logger.WithField("user_id", userID.Hex()).Info("All sessions revoked")
+	return nil
+}
+
+func (s *AuthService) validateAccessToken(ctx context.Context, tokenString string) (*jwtmanager.Claims, error) {
+	claims, err := s.jwtManager.VerifyToken(tokenString)
+	if err != nil {
+		s.logger.WithError(err).Warn("Access token validation failed")
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := s.checkAccessToken(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkAccessToken is the revocation check shared by ValidateAccessToken and
+// Introspect. Service tokens are checked against their client and have no
+// user, so the returned user is nil for them.
+func (s *AuthService) checkAccessToken(ctx context.Context, claims *jwtmanager.Claims) (*models.User, error) {
+	if isServiceToken(claims) {
+		return nil, s.checkServiceToken(ctx, claims)
+	}
+	return s.checkTokenRevocation(ctx, claims.ID, claims.Subject, claims.IssuedAt)
+}
+
+// checkTokenRevocation looks the token up in the JTI denylist and compares its
+// iat with the subject's tokens_valid_after cutoff. It returns the subject so
+// callers can report its current state.
+func (s *AuthService) checkTokenRevocation(ctx context.Context, jti, subject string, issuedAt *jwt.NumericDate) (*models.User, error) {
+	if jti == "" || subject == "" || issuedAt == nil {
+		s.logger.Warn("Access token without jti, sub or iat claim")
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.tokens.IsAccessTokenRevoked(ctx, jti)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check revoked tokens")
+		return nil, err
+	}
+	if revoked {
+		s.logger.WithField("jti", jti).Warn("Revoked access token presented")
+		return nil, ErrTokenRevoked
+	}
+
+	userID, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		s.logger.WithError(err).Error("Failed to load token subject")
+		return nil, err
+	}
+
+	if !user.TokensValidAfter.IsZero() && issuedAt.Before(tokensValidFrom(user)) {
+		s.logger.WithField("user_id", subject).Warn("Access token issued before tokens_valid_after")
+		return nil, ErrTokenRevoked
+	}
+
+	return user, nil
+}
+
+// tokensValidFrom is the earliest iat an access token of user may have. iat
+// has whole seconds, so the tokens_valid_after cutoff is rounded up: a token
+// issued in the same second as the revocation is revoked too.
+func tokensValidFrom(user *models.User) time.Time {
+	cutoff := user.TokensValidAfter.Truncate(time.Second)
+	if cutoff.Before(user.TokensValidAfter) {
+		cutoff = cutoff.Add(time.Second)
+	}
+	return cutoff
+}
+
+func (s *AuthService) revokeJTI(ctx context.Context, claims *jwtmanager.Claims, userID primitive.ObjectID) error {
+	err := s.tokens.RevokeAccessToken(ctx, &models.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    userID,
+		RevokedAt: time.Now(),
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"jti":   claims.ID,
+			"error": err,
+		}).Error("Failed to store revoked token")
+		return err
+	}
+	return nil
+}
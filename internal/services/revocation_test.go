@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"testing"
+	"time"
+)
+
+func TestRevocationCoversTheSameSecond(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	claims, err := s.ValidateAccessToken(result.Tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+
+	// Sessions revoked later within the second the token was issued in.
+	cutoff := claims.IssuedAt.Add(500 * time.Millisecond)
+	if _, err := store.Users.Update(context.Background(), user.ID, repository.UserUpdate{TokensValidAfter: &cutoff}); err != nil {
+		t.Fatalf("set tokens_valid_after: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(result.Tokens.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token of the revocation second: err = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	after, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login after the revocation: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(after.Tokens.AccessToken); err != nil {
+		t.Errorf("token issued after the revocation: %v", err)
+	}
+}
+
+func TestChangePasswordKeepsNewTokens(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	tokens, err := s.ChangePassword(user.ID.Hex(), testPassword, "Quiet-Meadow-Harbor-57")
+	if err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+
+	if _, err := s.ValidateAccessToken(result.Tokens.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token from before the change: err = %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, err := s.ValidateAccessToken(tokens.AccessToken); err != nil {
+		t.Errorf("token from the change: %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCredentialName = "Passkey"
+	maxCredentialNameLen  = 64
+)
+
+var (
+	ErrInvalidWebAuthnSession     = errors.New("invalid or expired WebAuthn session")
+	ErrInvalidWebAuthnResponse    = errors.New("WebAuthn response could not be verified")
+	ErrInvalidCredentialName      = errors.New("credential name must be at most 64 characters")
+	ErrWebAuthnCredentialExists   = errors.New("credential is already registered")
+	ErrWebAuthnCredentialNotFound = errors.New("credential not found")
+)
+
+// BeginWebAuthnRegistration starts registering a new passkey for the user.
+// Credentials the user already has are excluded, so an authenticator is not
+// registered twice.
+func (s *AuthService) BeginWebAuthnRegistration(userID string) (*models.WebAuthnOptionsResponse, error) {
+	s.logger.WithField("user_id", userID).Info("Starting WebAuthn registration")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// A discoverable credential is preferred so the passkey can also be used
+	// without a password, but security keys that cannot store one still work
+	// as a second factor.
+	options, data, err := s.relyingParty.BeginRegistration(owner,
+		webauthn.WithExclusions(webauthn.Credentials(owner.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to begin WebAuthn registration")
+		return nil, err
+	}
+
+	return s.startWebAuthnCeremony(ctx, user.ID, models.WebAuthnRegistration, options, data)
+}
+
+// FinishWebAuthnRegistration verifies the attestation returned by
+// navigator.credentials.create() and stores the new credential.
+func (s *AuthService) FinishWebAuthnRegistration(userID, sessionID, name string, response []byte) (*models.WebAuthnCredential, error) {
+	s.logger.WithField("user_id", userID).Info("Finishing WebAuthn registration")
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultCredentialName
+	}
+	if len([]rune(name)) > maxCredentialNameLen {
+		return nil, ErrInvalidCredentialName
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, data, err := s.consumeWebAuthnSession(ctx, sessionID, models.WebAuthnRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != user.ID {
+		s.logger.WithField("user_id", userID).Warn("WebAuthn registration session of another user")
+		return nil, ErrInvalidWebAuthnSession
+	}
+
+	owner, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		s.logWebAuthnFailure(user.ID, "Failed to parse WebAuthn attestation", err)
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	created, err := s.relyingParty.CreateCredential(owner, *data, parsed)
+	if err != nil {
+		s.logWebAuthnFailure(user.ID, "WebAuthn attestation rejected", err)
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	credential := credentialFromLibrary(user.ID, name, created)
+	if err := s.webAuthnCredentials.CreateCredential(ctx, credential); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			s.logger.WithField("user_id", userID).Warn("WebAuthn credential already registered")
+			return nil, ErrWebAuthnCredentialExists
+		}
+		s.logger.WithError(err).Error("Failed to store WebAuthn credential")
+		return nil, err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditWebAuthnAdded, map[string]string{"credential_id": credential.ID})
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":       userID,
+		"credential_id": credential.ID,
+	}).Info("WebAuthn credential registered")
+	return credential, nil
+}
+
+func (s *AuthService) ListWebAuthnCredentials(userID string) ([]models.WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.findUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.webAuthnCredentials.ListCredentials(ctx, user.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list WebAuthn credentials")
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's passkeys after checking
+// the password, like turning off the authenticator app does.
+func (s *AuthService) DeleteWebAuthnCredential(userID, credentialID, password string) error {
+	s.logger.WithFields(logrus.Fields{
+		"user_id":       userID,
+		"credential_id": credentialID,
+	}).Info("Deleting WebAuthn credential")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := s.userWithPassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.webAuthnCredentials.DeleteCredential(ctx, user.ID, credentialID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrWebAuthnCredentialNotFound
+		}
+		s.logger.WithError(err).Error("Failed to delete WebAuthn credential")
+		return err
+	}
+	s.recordEvent(ctx, user.ID, models.AuditWebAuthnRemoved, map[string]string{"credential_id": credentialID})
+	return nil
+}
+
+// BeginWebAuthnLogin starts an authentication ceremony. With an mfa_token from
+// Login it answers that challenge as a second factor; the token is consumed
+// here, so a failed ceremony starts over with the password. Without one it
+// starts a passwordless login with a discoverable credential, which has to
+// verify the user (PIN or biometrics) since nothing else is checked.
+func (s *AuthService) BeginWebAuthnLogin(mfaToken string) (*models.WebAuthnOptionsResponse, error) {
+	s.logger.WithField("mfa", mfaToken != "").Info("Starting WebAuthn login")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if mfaToken == "" {
+		options, data, err := s.relyingParty.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to begin WebAuthn login")
+			return nil, err
+		}
+		return s.startWebAuthnCeremony(ctx, primitive.NilObjectID, models.WebAuthnLogin, options, data)
+	}
+
+	user, err := s.userForMFAToken(ctx, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(owner.credentials) == 0 {
+		return nil, ErrMFANotEnabled
+	}
+
+	options, data, err := s.relyingParty.BeginLogin(owner)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to begin WebAuthn login")
+		return nil, err
+	}
+	return s.startWebAuthnCeremony(ctx, user.ID, models.WebAuthnMFA, options, data)
+}
+
+// FinishWebAuthnLogin verifies the assertion returned by
+// navigator.credentials.get() and issues tokens.
+func (s *AuthService) FinishWebAuthnLogin(sessionID string, response []byte) (*models.TokenPair, error) {
+	s.logger.Info("Finishing WebAuthn login")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, data, err := s.consumeWebAuthnSession(ctx, sessionID, models.WebAuthnLogin, models.WebAuthnMFA)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		s.logWebAuthnFailure(session.UserID, "Failed to parse WebAuthn assertion", err)
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	var (
+		owner      *webAuthnUser
+		credential *webauthn.Credential
+	)
+	if session.Ceremony == models.WebAuthnMFA {
+		var user *models.User
+		if user, err = s.users.FindByID(ctx, session.UserID); err != nil {
+			s.logger.WithFields(logrus.Fields{
+				"user_id": session.UserID.Hex(),
+				"error":   err,
+			}).Warn("User for WebAuthn session not found")
+			return nil, ErrInvalidWebAuthnSession
+		}
+		if owner, err = s.webAuthnUser(ctx, user); err != nil {
+			return nil, err
+		}
+		credential, err = s.relyingParty.ValidateLogin(owner, *data, parsed)
+	} else {
+		// The user handle stored in a discoverable credential is the user ID.
+		_, credential, err = s.relyingParty.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			var id primitive.ObjectID
+			if len(userHandle) != len(id) {
+				return nil, fmt.Errorf("unexpected user handle length %d", len(userHandle))
+			}
+			copy(id[:], userHandle)
+
+			user, err := s.users.FindByID(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if owner, err = s.webAuthnUser(ctx, user); err != nil {
+				return nil, err
+			}
+			return owner, nil
+		}, *data, parsed)
+	}
+	if err != nil {
+		if owner != nil {
+			userID := owner.user.ID
+			s.logWebAuthnFailure(userID, "WebAuthn assertion rejected", err)
+			if session.Ceremony == models.WebAuthnMFA {
+				s.recordEvent(ctx, userID, models.AuditMFAFailed, map[string]string{"method": models.MFAMethodWebAuthn})
+			} else {
+				s.recordEvent(ctx, userID, models.AuditLoginFailed, map[string]string{"method": models.MFAMethodWebAuthn})
+			}
+		} else {
+			s.logWebAuthnFailure(primitive.NilObjectID, "WebAuthn assertion for unknown credential", err)
+		}
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	user := owner.user
+	if !user.IsActive {
+		s.logger.WithField("email", user.Email).Warn("WebAuthn login attempt on inactive account")
+		return nil, ErrAccountNotActive
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	// A counter that did not increase means the private key may have been
+	// copied to another authenticator.
+	if credential.Authenticator.CloneWarning {
+		s.logger.WithFields(logrus.Fields{
+			"user_id":       user.ID.Hex(),
+			"credential_id": credentialID,
+		}).Warn("WebAuthn signature counter went backwards, possible cloned authenticator")
+		s.recordEvent(ctx, user.ID, models.AuditLoginFailed, map[string]string{
+			"method":        models.MFAMethodWebAuthn,
+			"credential_id": credentialID,
+			"reason":        "clone_warning",
+		})
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	err = s.webAuthnCredentials.UpdateCredentialUsage(ctx, credentialID,
+		credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to update WebAuthn credential")
+		return nil, err
+	}
+
+	// A passkey login verified the user on the authenticator, which makes it
+	// multi-factor on its own.
+	amr := []string{models.AMRHardwareKey, models.AMRMultiFactor}
+	if session.Ceremony == models.WebAuthnMFA {
+		amr = []string{models.AMRPassword, models.AMRHardwareKey, models.AMRMultiFactor}
+	}
+	if session.Ceremony == models.WebAuthnMFA {
+		s.resetLoginFailures(ctx, user)
+	}
+	tokens, err := s.issueTokenPair(ctx, user, amr...)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{"method": models.MFAMethodWebAuthn}
+	if session.Ceremony == models.WebAuthnMFA {
+		details = map[string]string{"mfa": models.MFAMethodWebAuthn}
+	}
+	s.recordEvent(ctx, user.ID, models.AuditLoginSucceeded, details)
+
+	s.logger.WithFields(logrus.Fields{
+		"email":    user.Email,
+		"ceremony": session.Ceremony,
+	}).Info("WebAuthn login successful")
+	return tokens, nil
+}
+
+// webAuthnUser adapts a user and their credentials to the WebAuthn library.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		id, err := base64.RawURLEncoding.DecodeString(stored.ID)
+		if err != nil {
+			continue
+		}
+
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: stored.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+func (s *AuthService) webAuthnUser(ctx context.Context, user *models.User) (*webAuthnUser, error) {
+	credentials, err := s.webAuthnCredentials.ListCredentials(ctx, user.ID)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list WebAuthn credentials")
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+func credentialFromLibrary(userID primitive.ObjectID, name string, created *webauthn.Credential) *models.WebAuthnCredential {
+	transports := make([]string, 0, len(created.Transport))
+	for _, transport := range created.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return &models.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(created.ID),
+		UserID:          userID,
+		Name:            name,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+}
+
+// startWebAuthnCeremony stores the session state of the library under a new
+// random session ID and returns it together with the options for the browser.
+func (s *AuthService) startWebAuthnCeremony(ctx context.Context, userID primitive.ObjectID, ceremony models.WebAuthnCeremony, options any, data *webauthn.SessionData) (*models.WebAuthnOptionsResponse, error) {
+	sessionID, err := utils.GenerateRandomToken(verificationTokenSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate WebAuthn session ID")
+		return nil, err
+	}
+
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	encodedOptions, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.webAuthnCredentials.SaveSession(ctx, &models.WebAuthnSession{
+		TokenHash: utils.HashToken(sessionID),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      encodedData,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.cfg.WebAuthnTimeout),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to store WebAuthn session")
+		return nil, err
+	}
+
+	return &models.WebAuthnOptionsResponse{SessionID: sessionID, Options: encodedOptions}, nil
+}
+
+// consumeWebAuthnSession redeems a session of one of the given ceremonies.
+// Sessions are deleted on first use, whether the ceremony then succeeds or not.
+func (s *AuthService) consumeWebAuthnSession(ctx context.Context, sessionID string, ceremonies ...models.WebAuthnCeremony) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	session, err := s.webAuthnCredentials.ConsumeSession(ctx, utils.HashToken(sessionID), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.Warn("Invalid, used or expired WebAuthn session")
+			return nil, nil, ErrInvalidWebAuthnSession
+		}
+		s.logger.WithError(err).Error("Failed to consume WebAuthn session")
+		return nil, nil, err
+	}
+
+	expected := false
+	for _, ceremony := range ceremonies {
+		expected = expected || session.Ceremony == ceremony
+	}
+	if !expected {
+		s.logger.WithField("ceremony", session.Ceremony).Warn("WebAuthn session used for another ceremony")
+		return nil, nil, ErrInvalidWebAuthnSession
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		s.logger.WithError(err).Error("Failed to decode WebAuthn session")
+		return nil, nil, err
+	}
+	return session, &data, nil
+}
+
+// logWebAuthnFailure logs why the library rejected a response. The details
+// are only logged, the client just learns that verification failed.
+func (s *AuthService) logWebAuthnFailure(userID primitive.ObjectID, message string, err error) {
+	fields := logrus.Fields{"error": err}
+	if !userID.IsZero() {
+		fields["user_id"] = userID.Hex()
+	}
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		fields["details"] = protocolErr.Details
+		fields["dev_info"] = protocolErr.DevInfo
+	}
+	s.logger.WithFields(fields).Warn(message)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	pb "github/alexnoodl/raiko-auth/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *AuthGrpcServer) BeginWebAuthnRegistration(ctx context.Context, req *pb.BeginWebAuthnRegistrationRequest) (*pb.WebAuthnOptionsResponse, error) {
+	s.logger.Info("gRPC BeginWebAuthnRegistration request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.WebAuthnOptionsResponse{Error: err.Error()}, err
+	}
+
+	options, err := s.AuthService.BeginWebAuthnRegistration(claims.Subject)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC BeginWebAuthnRegistration failed")
+		return &pb.WebAuthnOptionsResponse{Error: err.Error()}, webAuthnErrorStatus(err)
+	}
+
+	return &pb.WebAuthnOptionsResponse{SessionId: options.SessionID, OptionsJson: string(options.Options)}, nil
+}
+
+func (s *AuthGrpcServer) FinishWebAuthnRegistration(ctx context.Context, req *pb.FinishWebAuthnRegistrationRequest) (*pb.WebAuthnCredentialResponse, error) {
+	s.logger.Info("gRPC FinishWebAuthnRegistration request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.WebAuthnCredentialResponse{Error: err.Error()}, err
+	}
+
+	credential, err := s.AuthService.FinishWebAuthnRegistration(claims.Subject, req.SessionId, req.Name, []byte(req.CredentialJson))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC FinishWebAuthnRegistration failed")
+		return &pb.WebAuthnCredentialResponse{Error: err.Error()}, webAuthnErrorStatus(err)
+	}
+
+	return &pb.WebAuthnCredentialResponse{Credential: webAuthnCredentialToProto(credential)}, nil
+}
+
+func (s *AuthGrpcServer) ListWebAuthnCredentials(ctx context.Context, req *pb.ListWebAuthnCredentialsRequest) (*pb.ListWebAuthnCredentialsResponse, error) {
+	s.logger.Info("gRPC ListWebAuthnCredentials request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.ListWebAuthnCredentialsResponse{Error: err.Error()}, err
+	}
+
+	credentials, err := s.AuthService.ListWebAuthnCredentials(claims.Subject)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC ListWebAuthnCredentials failed")
+		return &pb.ListWebAuthnCredentialsResponse{Error: err.Error()}, webAuthnErrorStatus(err)
+	}
+
+	response := &pb.ListWebAuthnCredentialsResponse{Credentials: make([]*pb.WebAuthnCredential, 0, len(credentials))}
+	for i := range credentials {
+		response.Credentials = append(response.Credentials, webAuthnCredentialToProto(&credentials[i]))
+	}
+	return response, nil
+}
+
+func (s *AuthGrpcServer) DeleteWebAuthnCredential(ctx context.Context, req *pb.DeleteWebAuthnCredentialRequest) (*pb.DeleteWebAuthnCredentialResponse, error) {
+	s.logger.Info("gRPC DeleteWebAuthnCredential request received")
+
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return &pb.DeleteWebAuthnCredentialResponse{Error: err.Error()}, err
+	}
+
+	if err := s.AuthService.DeleteWebAuthnCredential(claims.Subject, req.Id, req.Password); err != nil {
+		s.logger.WithError(err).Error("gRPC DeleteWebAuthnCredential failed")
+		return &pb.DeleteWebAuthnCredentialResponse{Error: err.Error()}, webAuthnErrorStatus(err)
+	}
+
+	return &pb.DeleteWebAuthnCredentialResponse{Message: "Credential deleted"}, nil
+}
+
+func (s *AuthGrpcServer) BeginWebAuthnLogin(ctx context.Context, req *pb.BeginWebAuthnLoginRequest) (*pb.WebAuthnOptionsResponse, error) {
+	s.logger.Info("gRPC BeginWebAuthnLogin request received")
+
+	options, err := s.AuthService.BeginWebAuthnLogin(req.MfaToken)
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC BeginWebAuthnLogin failed")
+		return &pb.WebAuthnOptionsResponse{Error: err.Error()}, webAuthnLoginErrorStatus(err)
+	}
+
+	return &pb.WebAuthnOptionsResponse{SessionId: options.SessionID, OptionsJson: string(options.Options)}, nil
+}
+
+func (s *AuthGrpcServer) FinishWebAuthnLogin(ctx context.Context, req *pb.FinishWebAuthnLoginRequest) (*pb.LoginResponse, error) {
+	s.logger.Info("gRPC FinishWebAuthnLogin request received")
+
+	tokens, err := s.AuthService.FinishWebAuthnLogin(req.SessionId, []byte(req.CredentialJson))
+	if err != nil {
+		s.logger.WithError(err).Error("gRPC FinishWebAuthnLogin failed")
+		return &pb.LoginResponse{Error: err.Error()}, webAuthnLoginErrorStatus(err)
+	}
+
+	return &pb.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func webAuthnCredentialToProto(credential *models.WebAuthnCredential) *pb.WebAuthnCredential {
+	info := credential.Info()
+	out := &pb.WebAuthnCredential{
+		Id:             info.ID,
+		Name:           info.Name,
+		Aaguid:         info.AAGUID,
+		Transports:     info.Transports,
+		BackupEligible: info.BackupEligible,
+		BackupState:    info.BackupState,
+		CreatedAt:      info.CreatedAt.Unix(),
+	}
+	if info.LastUsedAt != nil {
+		out.LastUsedAt = info.LastUsedAt.Unix()
+	}
+	return out
+}
+
+func webAuthnErrorStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidWebAuthnSession),
+		errors.Is(err, ErrInvalidWebAuthnResponse),
+		errors.Is(err, ErrInvalidCredentialName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrIncorrectPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrUserNotFound),
+		errors.Is(err, ErrWebAuthnCredentialNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrWebAuthnCredentialExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func webAuthnLoginErrorStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidWebAuthnSession),
+		errors.Is(err, ErrInvalidWebAuthnResponse),
+		errors.Is(err, ErrInvalidMFAToken),
+		errors.Is(err, ErrMFANotEnabled),
+		errors.Is(err, ErrAccountNotActive):
+		return status.Error(codes.Unauthenticated, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
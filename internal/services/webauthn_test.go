@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/servicetest"
+	"github/alexnoodl/raiko-auth/internal/webauthntest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"slices"
+	"testing"
+)
+
+func newTestAuthenticator() *webauthntest.Authenticator {
+	return &webauthntest.Authenticator{RPID: servicetest.RPID, Origin: servicetest.Origin}
+}
+
+// registerPasskey runs the registration ceremony for user.
+func registerPasskey(t *testing.T, s *AuthService, user *models.User, authenticator *webauthntest.Authenticator) *webauthntest.Credential {
+	t.Helper()
+
+	options, err := s.BeginWebAuthnRegistration(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	credential, response, err := authenticator.Register(options.Options)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+	if _, err := s.FinishWebAuthnRegistration(user.ID.Hex(), options.SessionID, "Laptop", response); err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return credential
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+	options, err := s.BeginWebAuthnRegistration(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	credential, response, err := newTestAuthenticator().Register(options.Options)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+	stored, err := s.FinishWebAuthnRegistration(user.ID.Hex(), options.SessionID, " Laptop ", response)
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	if stored.Name != "Laptop" {
+		t.Errorf("name = %q, want %q", stored.Name, "Laptop")
+	}
+	if stored.AttestationType != "packed" {
+		t.Errorf("attestation type = %q, want packed", stored.AttestationType)
+	}
+
+	credentials, err := s.ListWebAuthnCredentials(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("list credentials: %v", err)
+	}
+	if len(credentials) != 1 || credentials[0].ID != stored.ID {
+		t.Fatalf("credentials = %+v, want the registered one", credentials)
+	}
+	if credentials[0].UserID != user.ID {
+		t.Errorf("credential belongs to %s, want %s", credentials[0].UserID.Hex(), user.ID.Hex())
+	}
+	if len(credential.UserHandle) != len(user.ID) || primitive.ObjectID(credential.UserHandle) != user.ID {
+		t.Errorf("user handle = %x, want the user ID", credential.UserHandle)
+	}
+
+	if _, err := s.FinishWebAuthnRegistration(user.ID.Hex(), options.SessionID, "Laptop", response); !errors.Is(err, ErrInvalidWebAuthnSession) {
+		t.Errorf("reused session: err = %v, want %v", err, ErrInvalidWebAuthnSession)
+	}
+
+	// A response is bound to the challenge of its session.
+	again, err := s.BeginWebAuthnRegistration(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	if _, err := s.FinishWebAuthnRegistration(user.ID.Hex(), again.SessionID, "Laptop", response); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+		t.Errorf("replayed attestation: err = %v, want %v", err, ErrInvalidWebAuthnResponse)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []webauthntest.Option
+	}{
+		{name: "bad challenge", opts: []webauthntest.Option{webauthntest.WithChallenge([]byte("another challenge of 32 bytes..."))}},
+		{name: "wrong origin", opts: []webauthntest.Option{webauthntest.WithOrigin("https://evil.example.com")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, mail := newTestAuthService(t)
+			user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+
+			options, err := s.BeginWebAuthnRegistration(user.ID.Hex())
+			if err != nil {
+				t.Fatalf("begin registration: %v", err)
+			}
+			_, response, err := newTestAuthenticator().Register(options.Options, tt.opts...)
+			if err != nil {
+				t.Fatalf("authenticator: %v", err)
+			}
+			if _, err := s.FinishWebAuthnRegistration(user.ID.Hex(), options.SessionID, "", response); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidWebAuthnResponse)
+			}
+
+			credentials, err := s.ListWebAuthnCredentials(user.ID.Hex())
+			if err != nil {
+				t.Fatalf("list credentials: %v", err)
+			}
+			if len(credentials) != 0 {
+				t.Errorf("credentials = %d, want 0", len(credentials))
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationRejectsSessionOfAnotherUser(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	alice := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	bob := registerVerifiedUser(t, s, mail, "bob@example.com", "bob")
+
+	options, err := s.BeginWebAuthnRegistration(alice.ID.Hex())
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	_, response, err := newTestAuthenticator().Register(options.Options)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+	if _, err := s.FinishWebAuthnRegistration(bob.ID.Hex(), options.SessionID, "", response); !errors.Is(err, ErrInvalidWebAuthnSession) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidWebAuthnSession)
+	}
+}
+
+func TestWebAuthnPasskeyLogin(t *testing.T) {
+	s, store, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	authenticator := newTestAuthenticator()
+	credential := registerPasskey(t, s, user, authenticator)
+
+	for i := 0; i < 2; i++ {
+		options, err := s.BeginWebAuthnLogin("")
+		if err != nil {
+			t.Fatalf("begin login: %v", err)
+		}
+		response, err := authenticator.Login(options.Options, credential)
+		if err != nil {
+			t.Fatalf("authenticator: %v", err)
+		}
+		tokens, err := s.FinishWebAuthnLogin(options.SessionID, response)
+		if err != nil {
+			t.Fatalf("finish login: %v", err)
+		}
+
+		claims, err := s.ValidateAccessToken(tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("validate access token: %v", err)
+		}
+		if claims.Subject != user.ID.Hex() {
+			t.Errorf("subject = %s, want %s", claims.Subject, user.ID.Hex())
+		}
+		if !slices.Equal(claims.AMR, []string{models.AMRHardwareKey, models.AMRMultiFactor}) {
+			t.Errorf("amr = %v", claims.AMR)
+		}
+
+		if _, err := s.FinishWebAuthnLogin(options.SessionID, response); !errors.Is(err, ErrInvalidWebAuthnSession) {
+			t.Errorf("reused session: err = %v, want %v", err, ErrInvalidWebAuthnSession)
+		}
+	}
+
+	stored, err := store.WebAuthn.ListCredentials(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("list credentials: %v", err)
+	}
+	if stored[0].SignCount != credential.SignCount {
+		t.Errorf("stored sign count = %d, want %d", stored[0].SignCount, credential.SignCount)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	authenticator := newTestAuthenticator()
+	credential := registerPasskey(t, s, user, authenticator)
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if result.Tokens != nil || !slices.Contains(result.MFAMethods, models.MFAMethodWebAuthn) {
+		t.Fatalf("login result = %+v, want a WebAuthn challenge", result)
+	}
+
+	options, err := s.BeginWebAuthnLogin(result.MFAToken)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if _, err := s.BeginWebAuthnLogin(result.MFAToken); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("reused MFA token: err = %v, want %v", err, ErrInvalidMFAToken)
+	}
+
+	response, err := authenticator.Login(options.Options, credential)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+	tokens, err := s.FinishWebAuthnLogin(options.SessionID, response)
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	claims, err := s.ValidateAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if !slices.Equal(claims.AMR, []string{models.AMRPassword, models.AMRHardwareKey, models.AMRMultiFactor}) {
+		t.Errorf("amr = %v", claims.AMR)
+	}
+}
+
+func TestWebAuthnLoginRejectsResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		// respond answers the options of the ceremony; credential is the
+		// registered one, which has been used once.
+		respond func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte
+		audited bool
+	}{
+		{
+			name: "bad challenge",
+			respond: func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte {
+				return login(t, authenticator, credential, options, webauthntest.WithChallenge([]byte("another challenge of 32 bytes...")))
+			},
+			audited: true,
+		},
+		{
+			name: "wrong origin",
+			respond: func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte {
+				return login(t, authenticator, credential, options, webauthntest.WithOrigin("https://evil.example.com"))
+			},
+			audited: true,
+		},
+		{
+			name: "replayed sign count",
+			respond: func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte {
+				// The counter repeats the value of the previous assertion.
+				credential.SignCount--
+				return login(t, authenticator, credential, options)
+			},
+			audited: true,
+		},
+		{
+			name: "unknown credential",
+			respond: func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte {
+				unknown, err := webauthntest.NewCredential(credential.UserHandle)
+				if err != nil {
+					t.Fatalf("new credential: %v", err)
+				}
+				return login(t, authenticator, unknown, options)
+			},
+			audited: true,
+		},
+		{
+			name: "unknown user",
+			respond: func(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte) []byte {
+				id := primitive.NewObjectID()
+				unknown, err := webauthntest.NewCredential(id[:])
+				if err != nil {
+					t.Fatalf("new credential: %v", err)
+				}
+				return login(t, authenticator, unknown, options)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, store, mail := newTestAuthService(t)
+			user := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+			authenticator := newTestAuthenticator()
+			credential := registerPasskey(t, s, user, authenticator)
+
+			options, err := s.BeginWebAuthnLogin("")
+			if err != nil {
+				t.Fatalf("begin login: %v", err)
+			}
+			if _, err := s.FinishWebAuthnLogin(options.SessionID, login(t, authenticator, credential, options.Options)); err != nil {
+				t.Fatalf("first login: %v", err)
+			}
+
+			options, err = s.BeginWebAuthnLogin("")
+			if err != nil {
+				t.Fatalf("begin login: %v", err)
+			}
+			response := tt.respond(t, authenticator, credential, options.Options)
+			if _, err := s.FinishWebAuthnLogin(options.SessionID, response); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidWebAuthnResponse)
+			}
+
+			stored, err := store.WebAuthn.ListCredentials(context.Background(), user.ID)
+			if err != nil {
+				t.Fatalf("list credentials: %v", err)
+			}
+			if stored[0].SignCount != 1 {
+				t.Errorf("stored sign count = %d, want 1", stored[0].SignCount)
+			}
+
+			events, err := store.Audit.ListByUser(context.Background(), user.ID, 0)
+			if err != nil {
+				t.Fatalf("list audit events: %v", err)
+			}
+			failed := false
+			for _, event := range events {
+				failed = failed || event.Action == models.AuditLoginFailed
+			}
+			if failed != tt.audited {
+				t.Errorf("failed login audited = %v, want %v", failed, tt.audited)
+			}
+		})
+	}
+}
+
+func TestWebAuthnSecondFactorRejectsCredentialOfAnotherUser(t *testing.T) {
+	s, _, mail := newTestAuthService(t)
+	alice := registerVerifiedUser(t, s, mail, "alice@example.com", "alice")
+	bob := registerVerifiedUser(t, s, mail, "bob@example.com", "bob")
+	authenticator := newTestAuthenticator()
+	registerPasskey(t, s, alice, authenticator)
+	bobsCredential := registerPasskey(t, s, bob, authenticator)
+
+	result, err := s.Login("alice", testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	options, err := s.BeginWebAuthnLogin(result.MFAToken)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if _, err := s.FinishWebAuthnLogin(options.SessionID, login(t, authenticator, bobsCredential, options.Options)); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidWebAuthnResponse)
+	}
+}
+
+func login(t *testing.T, authenticator *webauthntest.Authenticator, credential *webauthntest.Credential, options []byte, opts ...webauthntest.Option) []byte {
+	t.Helper()
+
+	response, err := authenticator.Login(options, credential, opts...)
+	if err != nil {
+		t.Fatalf("authenticator: %v", err)
+	}
+	return response
+}
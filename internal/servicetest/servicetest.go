@@ -0,0 +1,182 @@
+// Package servicetest builds what an AuthService depends on for tests: a
+// configuration, a throwaway signing key and MFA key, a WebAuthn relying
+// party and fast password hashing, all on the memory store. It does not
+// import the services package, so the tests inside it can use it too.
+package servicetest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+	"github/alexnoodl/raiko-auth/internal/config"
+	"github/alexnoodl/raiko-auth/internal/models"
+	"github/alexnoodl/raiko-auth/internal/repository"
+	jwtmanager "github/alexnoodl/raiko-auth/pkg/jwt"
+	"github/alexnoodl/raiko-auth/pkg/mailer"
+	passwordhash "github/alexnoodl/raiko-auth/pkg/password"
+	"github/alexnoodl/raiko-auth/pkg/secretbox"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	RPID     = "auth.example.com"
+	Origin   = "https://" + RPID
+	Password = "Velvet-Harbor-Lantern-92"
+)
+
+// Deps holds the arguments of services.NewAuthService.
+type Deps struct {
+	Config       *config.Config
+	Logger       *logrus.Logger
+	JWT          *jwtmanager.JWTManager
+	Secrets      *secretbox.Box
+	RelyingParty *webauthn.WebAuthn
+	Passwords    *passwordhash.Manager
+	Policy       *passwordhash.Policy
+	Store        *repository.Store
+	Mailer       *Mailer
+}
+
+// New returns fresh dependencies. Every call has its own store and keys.
+func New(t testing.TB) *Deps {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{
+		Logger:                  logger,
+		JWTIssuer:               "raiko-auth",
+		JWTAudience:             "raiko-auth",
+		DefaultScopes:           []string{"openid", "profile", "email"},
+		DefaultRole:             string(models.UserRole),
+		AccessTokenTTL:          15 * time.Minute,
+		RefreshTokenTTL:         24 * time.Hour,
+		MFAIssuer:               "raiko-auth",
+		MFAChallengeTTL:         5 * time.Minute,
+		WebAuthnRPID:            RPID,
+		WebAuthnRPName:          "Raiko",
+		WebAuthnRPOrigins:       []string{Origin},
+		WebAuthnTimeout:         5 * time.Minute,
+		LoginFailureWindow:      15 * time.Minute,
+		LoginDelayAfter:         3,
+		LoginIPDelayAfter:       10,
+		LoginDelayBase:          time.Second,
+		LoginDelayMax:           time.Minute,
+		LoginLockoutThreshold:   5,
+		LoginLockoutDuration:    15 * time.Minute,
+		PasswordMinLength:       8,
+		PasswordHistory:         3,
+		AppBaseURL:              "https://app.example.com",
+		EmailVerificationTTL:    time.Hour,
+		PasswordResetTTL:        time.Hour,
+		OIDCIssuer:              Origin,
+		OAuthLoginURL:           "https://app.example.com/oauth/login",
+		OAuthRequestTTL:         10 * time.Minute,
+		OAuthCodeTTL:            time.Minute,
+		OAuthDevicePageURL:      "https://app.example.com/oauth/device",
+		OAuthDeviceCodeTTL:      10 * time.Minute,
+		OAuthDevicePollInterval: 5 * time.Second,
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	key, err := jwtmanager.NewKey(privateKey, "test")
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	jwtManager := jwtmanager.NewJWTManager(jwtmanager.NewStaticKeyRing(key), cfg.JWTIssuer, cfg.JWTAudience, cfg.AccessTokenTTL)
+
+	secretKey := make([]byte, secretbox.KeySize)
+	if _, err := rand.Read(secretKey); err != nil {
+		t.Fatalf("generate secret key: %v", err)
+	}
+	secrets, err := secretbox.New(secretKey)
+	if err != nil {
+		t.Fatalf("secret box: %v", err)
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.WebAuthnTimeout, TimeoutUVD: cfg.WebAuthnTimeout}
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnRPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		t.Fatalf("relying party: %v", err)
+	}
+
+	passwords, err := passwordhash.NewManager(&passwordhash.Bcrypt{Cost: bcrypt.MinCost}, passwordhash.Pepper{})
+	if err != nil {
+		t.Fatalf("password manager: %v", err)
+	}
+	policy := passwordhash.NewPolicy()
+	policy.MinLength = cfg.PasswordMinLength
+
+	return &Deps{
+		Config:       cfg,
+		Logger:       logger,
+		JWT:          jwtManager,
+		Secrets:      secrets,
+		RelyingParty: relyingParty,
+		Passwords:    passwords,
+		Policy:       policy,
+		Store:        repository.NewMemoryStore(),
+		Mailer:       &Mailer{},
+	}
+}
+
+// Mailer keeps the messages instead of sending them.
+type Mailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *Mailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var tokenParam = regexp.MustCompile(`token=([^\s&]+)`)
+
+// LastToken returns the token of the last link mailed to to.
+func (m *Mailer) LastToken(t testing.TB, to string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != to {
+			continue
+		}
+		match := tokenParam.FindStringSubmatch(m.messages[i].Body)
+		if match == nil {
+			break
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatalf("unescape token: %v", err)
+		}
+		return token
+	}
+	t.Fatalf("no token mailed to %s", to)
+	return ""
+}
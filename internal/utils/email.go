@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"net/mail"
+	"strings"
+)
+
+func IsValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// NormalizeIdentifier returns the form emails and usernames are compared in,
+// so that they are unique regardless of case.
+func NormalizeIdentifier(value string) string {
+	return strings.ToLower(value)
+}
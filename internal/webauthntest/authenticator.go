@@ -0,0 +1,230 @@
+// Package webauthntest provides a software authenticator for tests. It
+// answers the options of a relying party with responses a browser would
+// return from navigator.credentials.create() and .get(): packed
+// self-attestation and ES256 assertions.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// Credential is a key pair held by the authenticator. SignCount is the value
+// of the signature counter in the last response; every assertion increments
+// it first.
+type Credential struct {
+	ID         []byte
+	UserHandle []byte
+	SignCount  uint32
+
+	key *ecdsa.PrivateKey
+}
+
+// NewCredential creates a key pair for the user handle. Credentials that were
+// never registered make assertions the relying party does not know.
+func NewCredential(userHandle []byte) (*Credential, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: id, UserHandle: userHandle, key: key}, nil
+}
+
+// Authenticator creates credentials for RPID and signs client data with
+// Origin, like a browser on that origin would.
+type Authenticator struct {
+	RPID   string
+	Origin string
+}
+
+// Option changes what goes into the client data of one response.
+type Option func(*clientData)
+
+// WithChallenge signs challenge instead of the one in the options.
+func WithChallenge(challenge []byte) Option {
+	return func(c *clientData) { c.Challenge = base64.RawURLEncoding.EncodeToString(challenge) }
+}
+
+// WithOrigin claims origin instead of the authenticator's.
+func WithOrigin(origin string) Option {
+	return func(c *clientData) { c.Origin = origin }
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Register answers the JSON creation options with a new credential and the
+// JSON of the PublicKeyCredential.
+func (a *Authenticator) Register(options []byte, opts ...Option) (*Credential, []byte, error) {
+	var creation struct {
+		PublicKey struct {
+			Challenge protocol.URLEncodedBase64 `json:"challenge"`
+			User      struct {
+				ID protocol.URLEncodedBase64 `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &creation); err != nil {
+		return nil, nil, err
+	}
+
+	credential, err := NewCredential(creation.PublicKey.User.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err := credential.publicKeyCOSE()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The AAGUID is all zeros, as for authenticators that do not reveal their model.
+	attested := make([]byte, 16, 16+2+len(credential.ID)+len(publicKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credential.ID)))
+	attested = append(attested, credential.ID...)
+	attested = append(attested, publicKey...)
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified|flagAttestedCredData, 0, attested)
+
+	clientDataJSON, err := a.clientData("webauthn.create", creation.PublicKey.Challenge, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := credential.sign(authData, clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attestation, err := webauthncbor.Marshal(struct {
+		Format    string         `cbor:"fmt"`
+		Statement map[string]any `cbor:"attStmt"`
+		AuthData  []byte         `cbor:"authData"`
+	}{
+		Format:    "packed",
+		Statement: map[string]any{"alg": int64(webauthncose.AlgES256), "sig": signature},
+		AuthData:  authData,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(credential.ID),
+		"rawId": base64.RawURLEncoding.EncodeToString(credential.ID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return credential, response, nil
+}
+
+// Login answers the JSON request options with an assertion of credential.
+func (a *Authenticator) Login(options []byte, credential *Credential, opts ...Option) ([]byte, error) {
+	var assertion struct {
+		PublicKey struct {
+			Challenge protocol.URLEncodedBase64 `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		return nil, err
+	}
+
+	credential.SignCount++
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified, credential.SignCount, nil)
+
+	clientDataJSON, err := a.clientData("webauthn.get", assertion.PublicKey.Challenge, opts)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := credential.sign(authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(credential.ID),
+		"rawId": base64.RawURLEncoding.EncodeToString(credential.ID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(credential.UserHandle),
+		},
+	})
+}
+
+func (a *Authenticator) authenticatorData(flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte, opts []Option) ([]byte, error) {
+	if len(challenge) == 0 {
+		return nil, errors.New("options carry no challenge")
+	}
+	data := clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	}
+	for _, opt := range opts {
+		opt(&data)
+	}
+	return json.Marshal(data)
+}
+
+// sign signs the authenticator data and the hash of the client data, which
+// is what both attestation and assertion signatures cover.
+func (c *Credential) sign(authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+}
+
+func (c *Credential) publicKeyCOSE() ([]byte, error) {
+	point, err := c.key.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	// The uncompressed point is 0x04 followed by both coordinates.
+	coordinates := point.Bytes()[1:]
+	return webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: coordinates[:32],
+		YCoord: coordinates[32:],
+	})
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func EnsureIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Users stored before the normalized fields existed lack them until the
+	// 0001 migration backfills them. The partial filter keeps those documents
+	// from colliding on a missing value in the meantime.
+	normalized := func(field string) *options.IndexOptions {
+		return options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{field: bson.M{"$type": "string"}})
+	}
+
+	indexes := map[string][]mongo.IndexModel{
+		"users": {
+			{
+				Keys:    bson.D{{Key: "email_normalized", Value: 1}},
+				Options: normalized("email_normalized"),
+			},
+			{
+				Keys:    bson.D{{Key: "username_normalized", Value: 1}},
+				Options: normalized("username_normalized"),
+			},
+			{
+				Keys: bson.D{{Key: "role", Value: 1}},
+			},
+		},
+		"refresh_tokens": {
+			{
+				Keys:    bson.D{{Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "family_id", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}},
+			},
+			{
+				// Only tokens issued to OAuth clients have a client_id.
+				Keys:    bson.D{{Key: "client_id", Value: 1}},
+				Options: options.Index().SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"verification_tokens": {
+			{
+				Keys:    bson.D{{Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}},
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"revoked_tokens": {
+			{
+				Keys:    bson.D{{Key: "jti", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				// Revoked JTIs only need to be kept until the token would have expired anyway.
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"webauthn_credentials": {
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+			},
+		},
+		"webauthn_sessions": {
+			{
+				Keys:    bson.D{{Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}},
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"oauth_authorization_requests": {
+			{
+				Keys:    bson.D{{Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"oauth_authorization_codes": {
+			{
+				Keys:    bson.D{{Key: "code_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				// Used codes are kept until they expire, so that a second
+				// exchange is recognised and revokes the tokens of the first.
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"oauth_device_authorizations": {
+			{
+				Keys:    bson.D{{Key: "device_code_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "user_code_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"oauth_client_assertions": {
+			{
+				Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "jti", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"login_failures": {
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"audit_events": {
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+			},
+		},
+	}
+
+	for collection, collectionIndexes := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, collectionIndexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github/alexnoodl/raiko-auth/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MigrationStatus describes one known migration. AppliedAt is nil while the
+// migration is pending.
+type MigrationStatus struct {
+	Version   string
+	AppliedAt *time.Time
+}
+
+// mongoMigration changes existing documents. Index definitions live in
+// EnsureIndexes, which is idempotent and runs after every migration.
+type mongoMigration struct {
+	Version string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// mongoMigrations run in order and are never edited once released, new
+// changes get a new version. A migration may be run again after an instance
+// died halfway through it, so it has to be idempotent.
+var mongoMigrations = []mongoMigration{
+	{Version: "0001_normalize_user_identifiers", Up: normalizeUserIdentifiers},
+	{Version: "0002_admin_client_permissions", Up: addAdminClientPermissions},
+}
+
+// mongoMigrationLease is how long a started migration stays claimed. It is
+// longer than MigrateMongo may run, so a claim that is older was left behind
+// by an instance that stopped before finishing.
+const mongoMigrationLease = 10 * time.Minute
+
+type migrationRecord struct {
+	Version   string     `bson:"_id"`
+	StartedAt time.Time  `bson:"started_at"`
+	AppliedAt *time.Time `bson:"applied_at,omitempty"`
+}
+
+// MigrateMongo applies the document migrations not yet recorded in
+// schema_migrations and then makes sure all indexes exist. It returns the
+// versions it applied. It fails when another instance is applying a
+// migration, as the ones after it may depend on it.
+func MigrateMongo(db *mongo.Database) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	records := db.Collection("schema_migrations")
+	applied := []string{}
+	for _, migration := range mongoMigrations {
+		claimedAt, err := claimMongoMigration(ctx, records, migration.Version)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", migration.Version, err)
+		}
+		if claimedAt.IsZero() {
+			continue
+		}
+
+		claim := bson.M{"_id": migration.Version, "started_at": claimedAt}
+		if err := migration.Up(ctx, db); err != nil {
+			// Release the claim so that the next start retries the migration.
+			if _, releaseErr := records.DeleteOne(context.Background(), claim); releaseErr != nil {
+				return applied, fmt.Errorf("migration %s: %w (releasing the claim failed: %v)", migration.Version, err, releaseErr)
+			}
+			return applied, fmt.Errorf("migration %s: %w", migration.Version, err)
+		}
+
+		result, err := records.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"applied_at": time.Now()}})
+		if err != nil {
+			return applied, err
+		}
+		if result.MatchedCount == 0 {
+			return applied, fmt.Errorf("migration %s: the claim was taken over by another instance", migration.Version)
+		}
+		applied = append(applied, migration.Version)
+	}
+
+	if err := EnsureIndexes(db); err != nil {
+		return applied, fmt.Errorf("create indexes: %w", err)
+	}
+	return applied, nil
+}
+
+// claimMongoMigration records that this instance starts the migration and
+// returns the start time the claim holds. It returns the zero time for a
+// migration that is applied already. A claim older than mongoMigrationLease
+// is taken over; a newer one means another instance is running the
+// migration, which is an error.
+func claimMongoMigration(ctx context.Context, records *mongo.Collection, version string) (time.Time, error) {
+	// MongoDB keeps milliseconds, the claim is matched by its start time.
+	now := time.Now().Truncate(time.Millisecond)
+
+	// Inserting the record claims the migration, so of several instances
+	// starting at the same time only one runs it.
+	_, err := records.InsertOne(ctx, migrationRecord{Version: version, StartedAt: now})
+	if err == nil {
+		return now, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return time.Time{}, err
+	}
+
+	var record migrationRecord
+	if err := records.FindOne(ctx, bson.M{"_id": version}).Decode(&record); err != nil {
+		return time.Time{}, err
+	}
+	if record.AppliedAt != nil {
+		return time.Time{}, nil
+	}
+	if now.Sub(record.StartedAt) < mongoMigrationLease {
+		return time.Time{}, fmt.Errorf("being applied by another instance since %s", record.StartedAt.Format(time.RFC3339))
+	}
+
+	result, err := records.UpdateOne(ctx,
+		bson.M{"_id": version, "started_at": record.StartedAt, "applied_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"started_at": now}},
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if result.MatchedCount == 0 {
+		return time.Time{}, fmt.Errorf("claimed by another instance")
+	}
+	return now, nil
+}
+
+// MongoMigrationStatus lists the known migrations in order. A migration that
+// was claimed but never finished is reported as pending.
+func MongoMigrationStatus(ctx context.Context, db *mongo.Database) ([]MigrationStatus, error) {
+	cursor, err := db.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[string]*time.Time{}
+	for _, record := range records {
+		appliedAt[record.Version] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(mongoMigrations))
+	for _, migration := range mongoMigrations {
+		statuses = append(statuses, MigrationStatus{Version: migration.Version, AppliedAt: appliedAt[migration.Version]})
+	}
+	return statuses, nil
+}
+
+// normalizeUserIdentifiers backfills email_normalized and username_normalized
+// on users created before lookups switched to them. Users whose emails or
+// usernames differ only in case make the following index build fail and have
+// to be resolved by hand.
+func normalizeUserIdentifiers(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+	cursor, err := users.Find(ctx,
+		bson.M{"$or": []bson.M{
+			{"email_normalized": bson.M{"$exists": false}},
+			{"username_normalized": bson.M{"$exists": false}},
+		}},
+		options.Find().SetProjection(bson.M{"email": 1, "username": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	const batchSize = 500
+	batch := make([]mongo.WriteModel, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := users.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Email    string             `bson:"email"`
+			Username string             `bson:"username"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": user.ID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"email_normalized":    utils.NormalizeIdentifier(user.Email),
+				"username_normalized": utils.NormalizeIdentifier(user.Username),
+			}}))
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// addAdminClientPermissions grants the admin role the permissions to manage
+// OAuth clients. Roles are only created when missing, so deployments from
+// before those permissions existed do not get them otherwise. A database
+// without the role is left alone for EnsureDefaultRoles to fill in.
+func addAdminClientPermissions(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("roles").UpdateOne(ctx,
+		bson.M{"_id": "admin"},
+		bson.M{"$addToSet": bson.M{"permissions": bson.M{"$each": []string{"clients:read", "clients:write"}}}},
+	)
+	return err
+}
@@ -0,0 +1,74 @@
+-- IDs are MongoDB ObjectID hex strings, so they look the same whichever
+-- backend issued them and keep sorting in creation order.
+
+CREATE TABLE users (
+    id                 CHAR(24) PRIMARY KEY,
+    email              TEXT        NOT NULL,
+    username           TEXT        NOT NULL,
+    password           TEXT        NOT NULL,
+    role               TEXT        NOT NULL,
+    is_active          BOOLEAN     NOT NULL DEFAULT FALSE,
+    email_verified     BOOLEAN     NOT NULL DEFAULT FALSE,
+    tokens_valid_after TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL
+);
+
+-- Email and username are unique regardless of case.
+CREATE UNIQUE INDEX users_email_normalized_key ON users (lower(email));
+CREATE UNIQUE INDEX users_username_normalized_key ON users (lower(username));
+CREATE INDEX users_role_idx ON users (role);
+
+CREATE TABLE refresh_tokens (
+    id         CHAR(24) PRIMARY KEY,
+    user_id    CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family_id  TEXT        NOT NULL,
+    token_hash TEXT        NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    revoked    BOOLEAN     NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_id_expires_at_idx ON refresh_tokens (user_id, expires_at);
+
+-- Revoked JTIs only need to be kept until the token would have expired anyway.
+CREATE TABLE revoked_tokens (
+    jti        TEXT PRIMARY KEY,
+    user_id    CHAR(24)    NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX revoked_tokens_user_id_idx ON revoked_tokens (user_id);
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+CREATE TABLE verification_tokens (
+    id         CHAR(24) PRIMARY KEY,
+    user_id    CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    TEXT        NOT NULL,
+    token_hash TEXT        NOT NULL UNIQUE,
+    email      TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX verification_tokens_user_id_purpose_idx ON verification_tokens (user_id, purpose);
+
+CREATE TABLE roles (
+    name        TEXT PRIMARY KEY,
+    description TEXT   NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL DEFAULT '{}'
+);
+
+-- Audit events outlive the user they describe, so there is no foreign key.
+CREATE TABLE audit_events (
+    id         CHAR(24) PRIMARY KEY,
+    user_id    CHAR(24)    NOT NULL,
+    action     TEXT        NOT NULL,
+    details    JSONB,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX audit_events_user_id_created_at_idx ON audit_events (user_id, created_at DESC);
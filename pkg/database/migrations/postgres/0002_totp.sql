@@ -0,0 +1,15 @@
+CREATE TABLE totp_enrollments (
+    user_id        CHAR(24) PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret         TEXT        NOT NULL,
+    confirmed      BOOLEAN     NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL,
+    confirmed_at   TIMESTAMPTZ
+);
+
+CREATE TABLE recovery_codes (
+    user_id   CHAR(24) NOT NULL REFERENCES totp_enrollments (user_id) ON DELETE CASCADE,
+    code_hash TEXT     NOT NULL,
+    used_at   TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
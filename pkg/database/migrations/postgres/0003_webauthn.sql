@@ -0,0 +1,30 @@
+CREATE TABLE webauthn_credentials (
+    id               TEXT PRIMARY KEY,
+    user_id          CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             TEXT        NOT NULL,
+    public_key       BYTEA       NOT NULL,
+    attestation_type TEXT        NOT NULL,
+    aaguid           BYTEA,
+    sign_count       BIGINT      NOT NULL DEFAULT 0,
+    transports       TEXT[]      NOT NULL DEFAULT '{}',
+    backup_eligible  BOOLEAN     NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMPTZ NOT NULL,
+    last_used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- A passwordless login does not know its user until it finishes, so user_id
+-- is optional.
+CREATE TABLE webauthn_sessions (
+    id         CHAR(24) PRIMARY KEY,
+    token_hash TEXT        NOT NULL UNIQUE,
+    user_id    CHAR(24) REFERENCES users (id) ON DELETE CASCADE,
+    ceremony   TEXT        NOT NULL,
+    data       BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX webauthn_sessions_expires_at_idx ON webauthn_sessions (expires_at);
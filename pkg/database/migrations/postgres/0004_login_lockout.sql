@@ -0,0 +1,13 @@
+ALTER TABLE users
+    ADD COLUMN failed_logins        INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_failed_login_at TIMESTAMPTZ,
+    ADD COLUMN locked_until         TIMESTAMPTZ;
+
+CREATE TABLE login_failures (
+    ip             TEXT PRIMARY KEY,
+    count          INTEGER     NOT NULL,
+    last_failed_at TIMESTAMPTZ NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX login_failures_expires_at_idx ON login_failures (expires_at);
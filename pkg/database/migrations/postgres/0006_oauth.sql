@@ -0,0 +1,51 @@
+CREATE TABLE oauth_clients (
+    id                         TEXT PRIMARY KEY,
+    name                       TEXT        NOT NULL,
+    secret_hash                TEXT        NOT NULL DEFAULT '',
+    redirect_uris              TEXT[]      NOT NULL DEFAULT '{}',
+    scopes                     TEXT[]      NOT NULL DEFAULT '{}',
+    grant_types                TEXT[]      NOT NULL DEFAULT '{}',
+    token_endpoint_auth_method TEXT        NOT NULL,
+    skip_consent               BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at                 TIMESTAMPTZ NOT NULL,
+    updated_at                 TIMESTAMPTZ NOT NULL
+);
+
+-- The user is only known once the request is approved.
+CREATE TABLE oauth_authorization_requests (
+    id                    CHAR(24) PRIMARY KEY,
+    token_hash            TEXT        NOT NULL UNIQUE,
+    client_id             TEXT        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    redirect_uri          TEXT        NOT NULL,
+    scopes                TEXT[]      NOT NULL DEFAULT '{}',
+    state                 TEXT        NOT NULL DEFAULT '',
+    code_challenge        TEXT        NOT NULL,
+    code_challenge_method TEXT        NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL,
+    expires_at            TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX oauth_authorization_requests_expires_at_idx ON oauth_authorization_requests (expires_at);
+
+CREATE TABLE oauth_authorization_codes (
+    id                    CHAR(24) PRIMARY KEY,
+    code_hash             TEXT        NOT NULL UNIQUE,
+    client_id             TEXT        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id               CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri          TEXT        NOT NULL,
+    scopes                TEXT[]      NOT NULL DEFAULT '{}',
+    code_challenge        TEXT        NOT NULL,
+    code_challenge_method TEXT        NOT NULL,
+    family_id             TEXT        NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL,
+    expires_at            TIMESTAMPTZ NOT NULL,
+    used_at               TIMESTAMPTZ
+);
+
+CREATE INDEX oauth_authorization_codes_expires_at_idx ON oauth_authorization_codes (expires_at);
+
+-- Refresh tokens of OAuth clients are bound to the client and the scopes
+-- granted to it.
+ALTER TABLE refresh_tokens
+    ADD COLUMN client_id TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN scopes    TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,16 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN post_logout_redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE oauth_authorization_requests
+    ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+
+-- auth_time and amr describe the login behind a grant and end up in its ID
+-- tokens.
+ALTER TABLE oauth_authorization_codes
+    ADD COLUMN nonce     TEXT        NOT NULL DEFAULT '',
+    ADD COLUMN auth_time TIMESTAMPTZ,
+    ADD COLUMN amr       TEXT[]      NOT NULL DEFAULT '{}';
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN auth_time TIMESTAMPTZ,
+    ADD COLUMN amr       TEXT[]      NOT NULL DEFAULT '{}';
@@ -0,0 +1,14 @@
+-- jwks holds the public keys of clients using private_key_jwt.
+ALTER TABLE oauth_clients
+    ADD COLUMN jwks TEXT NOT NULL DEFAULT '';
+
+-- The jti of every client assertion is kept until the assertion expires, so
+-- that it cannot be replayed.
+CREATE TABLE oauth_client_assertions (
+    client_id  TEXT        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    jti        TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+CREATE INDEX oauth_client_assertions_expires_at_idx ON oauth_client_assertions (expires_at);
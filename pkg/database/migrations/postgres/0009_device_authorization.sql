@@ -0,0 +1,19 @@
+-- Device authorizations of RFC 8628. user_id, auth_time and amr are set once
+-- the user approved.
+CREATE TABLE oauth_device_authorizations (
+    id               CHAR(24) PRIMARY KEY,
+    device_code_hash TEXT        NOT NULL UNIQUE,
+    user_code_hash   TEXT        NOT NULL UNIQUE,
+    client_id        TEXT        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scopes           TEXT[]      NOT NULL DEFAULT '{}',
+    status           TEXT        NOT NULL,
+    user_id          CHAR(24) REFERENCES users (id) ON DELETE CASCADE,
+    auth_time        TIMESTAMPTZ,
+    amr              TEXT[]      NOT NULL DEFAULT '{}',
+    interval_seconds INTEGER     NOT NULL,
+    last_polled_at   TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL,
+    expires_at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX oauth_device_authorizations_expires_at_idx ON oauth_device_authorizations (expires_at);
@@ -0,0 +1,4 @@
+-- registration_token_hash is the hash of the registration access token of
+-- dynamically registered clients (RFC 7592).
+ALTER TABLE oauth_clients
+    ADD COLUMN registration_token_hash TEXT NOT NULL DEFAULT '';
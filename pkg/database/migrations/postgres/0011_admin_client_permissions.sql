@@ -0,0 +1,10 @@
+-- Grants the admin role the permissions to manage OAuth clients. Roles are
+-- only created when missing, so databases from before these permissions
+-- existed do not get them otherwise.
+UPDATE roles
+SET permissions = permissions || ARRAY(
+    SELECT permission
+    FROM unnest(ARRAY['clients:read', 'clients:write']) AS permission
+    WHERE permission <> ALL (roles.permissions)
+)
+WHERE name = 'admin';
@@ -0,0 +1,4 @@
+-- Refresh tokens of an OAuth client are deleted together with the client.
+-- client_id is empty for tokens of the first-party API, so there is no
+-- foreign key and only client tokens are indexed.
+CREATE INDEX refresh_tokens_client_id_idx ON refresh_tokens (client_id) WHERE client_id <> '';
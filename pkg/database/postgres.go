@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github/alexnoodl/raiko-auth/internal/config"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// migrationLockID is the advisory lock that keeps two instances starting at
+// the same time from applying the same migration twice.
+const migrationLockID = 0x7261696b6f
+
+func InitPostgres(cfg *config.Config) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, cfg.PostgresDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// MigratePostgres applies the embedded migrations that are not yet recorded in
+// schema_migrations. Files run in name order, each in its own transaction.
+func MigratePostgres(pool *pgxpool.Pool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return nil, err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	for _, file := range files {
+		version := path.Base(file)
+		ok, err := applyPostgresMigration(ctx, conn.Conn(), file, version)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", version, err)
+		}
+		if ok {
+			applied = append(applied, version)
+		}
+	}
+	return applied, nil
+}
+
+// PostgresMigrationStatus lists the embedded migrations in order.
+func PostgresMigrationStatus(ctx context.Context, pool *pgxpool.Pool) ([]MigrationStatus, error) {
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	appliedAt := map[string]*time.Time{}
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := pool.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				version string
+				at      time.Time
+			)
+			if err := rows.Scan(&version, &at); err != nil {
+				return nil, err
+			}
+			appliedAt[version] = &at
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(files))
+	for _, file := range files {
+		version := path.Base(file)
+		statuses = append(statuses, MigrationStatus{Version: version, AppliedAt: appliedAt[version]})
+	}
+	return statuses, nil
+}
+
+func applyPostgresMigration(ctx context.Context, conn *pgx.Conn, file, version string) (bool, error) {
+	script, err := postgresMigrations.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	applied := false
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		var done bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&done)
+		if err != nil || done {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, string(script)); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)", version, time.Now())
+		applied = err == nil
+		return err
+	})
+	return applied, err
+}
@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+)
+
+// ParseJWKS reads a JWK Set of public keys, such as the keys an OAuth client
+// signs its client assertions with.
+func ParseJWKS(data []byte) ([]*Key, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	if len(set.Keys) == 0 {
+		return nil, errors.New("the JWK Set has no keys")
+	}
+
+	keys := make([]*Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.Key()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Key turns a public JWK back into a verification key. The algorithm follows
+// from the key type unless alg names another one of the same family.
+func (j JWK) Key() (*Key, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return nil, fmt.Errorf("unsupported key use %q", j.Use)
+	}
+
+	key := &Key{ID: j.Kid}
+	switch j.Kty {
+	case "RSA":
+		n, errN := decodeSegment(j.N)
+		e, errE := decodeSegment(j.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed RSA key")
+		}
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if publicKey.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		key.PublicKey = publicKey
+		key.Method = jwt.SigningMethodRS256
+		switch j.Alg {
+		case "", "RS256":
+		case "RS384":
+			key.Method = jwt.SigningMethodRS384
+		case "RS512":
+			key.Method = jwt.SigningMethodRS512
+		case "PS256":
+			key.Method = jwt.SigningMethodPS256
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q for an RSA key", j.Alg)
+		}
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve, key.Method = elliptic.P256(), jwt.SigningMethodES256
+		case "P-384":
+			curve, key.Method = elliptic.P384(), jwt.SigningMethodES384
+		case "P-521":
+			curve, key.Method = elliptic.P521(), jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %q", j.Crv)
+		}
+		x, errX := decodeSegment(j.X)
+		y, errY := decodeSegment(j.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("malformed EC key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("the EC point is not on the curve")
+		}
+		key.PublicKey = publicKey
+	case "OKP":
+		x, err := decodeSegment(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("only Ed25519 keys are supported for OKP")
+		}
+		key.PublicKey = ed25519.PublicKey(x)
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+
+	if j.Alg != "" && j.Alg != key.Method.Alg() {
+		return nil, fmt.Errorf("algorithm %q does not match the key", j.Alg)
+	}
+	return key, nil
+}
+
+// AssertionAlgorithms lists the algorithms client assertions can be signed
+// with, following the key types Key accepts.
+func AssertionAlgorithms() []string {
+	return []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}
+}
+
+// VerifyAssertion verifies a JWT signed with one of keys, as clients sign the
+// assertions of private_key_jwt authentication (RFC 7523). A token naming a
+// kid is only checked against that key. exp is required; the audience and
+// the other claims are left to the caller.
+func VerifyAssertion(tokenString string, keys []*Key) (*jwt.RegisteredClaims, error) {
+	err := ErrUnknownKey
+	for _, key := range keys {
+		claims := &jwt.RegisteredClaims{}
+		var token *jwt.Token
+		token, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if kid, _ := token.Header["kid"].(string); kid != "" && kid != key.ID {
+				return nil, ErrUnknownKey
+			}
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+			}
+			return key.PublicKey, nil
+		}, jwt.WithExpirationRequired())
+		if err == nil && token.Valid {
+			return claims, nil
+		}
+	}
+	return nil, err
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
@@ -0,0 +1,178 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T, alg, kid string) *Key {
+	t.Helper()
+
+	privateKey, err := GeneratePrivateKey(alg)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key, err := NewKey(privateKey, kid)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	return key
+}
+
+func publicJWK(t *testing.T, key *Key) JWK {
+	t.Helper()
+
+	jwk, ok := key.PublicJWK()
+	if !ok {
+		t.Fatalf("key %s has no public JWK", key.ID)
+	}
+	return jwk
+}
+
+func jwksJSON(t *testing.T, keys ...JWK) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(JWKS{Keys: keys})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+	return data
+}
+
+func signAssertion(t *testing.T, key *Key, claims jwt.RegisteredClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+	return signed
+}
+
+func TestParseJWKS(t *testing.T) {
+	ec := publicJWK(t, newKey(t, "ES256", "ec"))
+	ed := publicJWK(t, newKey(t, "EdDSA", "ed"))
+
+	keys, err := ParseJWKS(jwksJSON(t, ec, ed))
+	if err != nil {
+		t.Fatalf("parse JWKS: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "ec" || keys[0].Method.Alg() != "ES256" || keys[1].Method.Alg() != "EdDSA" {
+		t.Errorf("keys = %+v, want the EC and the Ed25519 key", keys)
+	}
+
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	weak, err := NewKey(weakRSA, "weak")
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate P-224 key: %v", err)
+	}
+
+	edit := func(jwk JWK, change func(*JWK)) JWK {
+		change(&jwk)
+		return jwk
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not JSON", []byte("keys")},
+		{"no keys", jwksJSON(t)},
+		{"encryption key", jwksJSON(t, edit(ec, func(j *JWK) { j.Use = "enc" }))},
+		{"unknown key type", jwksJSON(t, edit(ec, func(j *JWK) { j.Kty = "oct" }))},
+		{"algorithm of another key type", jwksJSON(t, edit(ec, func(j *JWK) { j.Alg = "RS256" }))},
+		{"algorithm of another curve", jwksJSON(t, edit(ec, func(j *JWK) { j.Alg = "ES384" }))},
+		{"point off the curve", jwksJSON(t, edit(ec, func(j *JWK) { j.Y = j.X }))},
+		{"unsupported curve", jwksJSON(t, edit(ec, func(j *JWK) { j.Crv = p224.Curve.Params().Name }))},
+		{"RSA key under 2048 bits", jwksJSON(t, publicJWK(t, weak))},
+		{"one bad key among good ones", jwksJSON(t, ed, edit(ec, func(j *JWK) { j.X = "!" }))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if keys, err := ParseJWKS(tt.data); err == nil {
+				t.Errorf("keys %+v were accepted", keys)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	key := newKey(t, "ES256", "client-1")
+	other := newKey(t, "ES256", "client-2")
+	keys, err := ParseJWKS(jwksJSON(t, publicJWK(t, key), publicJWK(t, other)))
+	if err != nil {
+		t.Fatalf("parse JWKS: %v", err)
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    "reports",
+		Subject:   "reports",
+		Audience:  jwt.ClaimStrings{testIssuer},
+		ID:        "assertion-1",
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}
+	valid := signAssertion(t, key, claims)
+	parts := strings.Split(valid, ".")
+
+	withKID := func(kid string) *Key {
+		k := *key
+		k.ID = kid
+		return &k
+	}
+	expired := claims
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	noExp := claims
+	noExp.ExpiresAt = nil
+	notYet := claims
+	notYet.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+
+	tests := []struct {
+		name      string
+		assertion string
+	}{
+		{"expired", signAssertion(t, key, expired)},
+		{"without exp", signAssertion(t, key, noExp)},
+		{"not valid yet", signAssertion(t, key, notYet)},
+		{"unregistered key", signAssertion(t, newKey(t, "ES256", "client-3"), claims)},
+		{"kid of another registered key", signAssertion(t, withKID(other.ID), claims)},
+		{"HMAC with a public key as secret", signAssertion(t, NewHMACKey([]byte("secret"), key.ID), claims)},
+		{"tampered payload", parts[0] + "." + parts[1] + "x." + parts[2]},
+		{"not a JWT", "assertion"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyAssertion(tt.assertion, keys); err == nil {
+				t.Error("assertion was accepted")
+			}
+		})
+	}
+
+	verified, err := VerifyAssertion(valid, keys)
+	if err != nil {
+		t.Fatalf("valid assertion: %v", err)
+	}
+	if verified.ID != claims.ID || verified.Subject != claims.Subject {
+		t.Errorf("claims = %+v, want %+v", verified, claims)
+	}
+	// Without a kid every registered key is tried.
+	unnamed := signAssertion(t, withKID(""), claims)
+	if _, err := VerifyAssertion(unnamed, keys); err != nil {
+		t.Errorf("assertion without kid: %v", err)
+	}
+}
@@ -1,52 +1,208 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"strings"
 	"time"
 )
 
 type JWTManager struct {
-	secretKey    string
+	keys         *KeyRing
+	issuer       string
+	audience     string
 	tokenExpires time.Duration
 }
 
-func NewJWTManager(secretKey string, tokenExpires time.Duration) *JWTManager {
+func NewJWTManager(keys *KeyRing, issuer, audience string, tokenExpires time.Duration) *JWTManager {
 	return &JWTManager{
-		secretKey:    secretKey,
+		keys:         keys,
+		issuer:       issuer,
+		audience:     audience,
 		tokenExpires: tokenExpires,
 	}
 }
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	Email       string   `json:"email,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// ClientID is the OAuth client the token was issued to. It is empty for
+	// tokens from the first-party login. Service tokens of the client
+	// credentials grant have it as their subject and carry no email.
+	ClientID string `json:"client_id,omitempty"`
+	// AuthTime and AMR tell when and how the user logged in.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (j *JWTManager) GenerateToken(userID string, role string) (string, error) {
-	claims := &Claims{
-		UserID: userID,
-		Role:   role,
+// IDTokenClaims are the claims of an OpenID Connect ID token. Its audience
+// is the client, so an ID token is never accepted as an access token.
+type IDTokenClaims struct {
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	// SessionID is the refresh token family of the grant, which RP-initiated
+	// logout ends.
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JWTManager) TokenExpires() time.Duration {
+	return j.tokenExpires
+}
+
+func (j *JWTManager) Issuer() string {
+	return j.issuer
+}
+
+// NewClaims returns claims for subject with iss, aud, iat, nbf, exp and a
+// fresh jti already set. Callers fill in the private claims and sign them
+// with SignClaims.
+func (j *JWTManager) NewClaims(subject string) (*Claims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.tokenExpires)),
+			ID:        jti,
+			Issuer:    j.issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{j.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.tokenExpires)),
 		},
+	}, nil
+}
+
+func (j *JWTManager) GenerateToken(userID, email, role string, scopes, permissions []string) (string, error) {
+	claims, err := j.NewClaims(userID)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secretKey))
+	claims.Email = email
+	claims.Role = role
+	claims.Scope = strings.Join(scopes, " ")
+	claims.Permissions = permissions
+
+	return j.SignClaims(claims)
 }
 
 func (j *JWTManager) VerifyToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(j.secretKey), nil
-	})
+	claims := &Claims{}
+	if err := j.ParseClaims(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// SignClaims signs arbitrary claims with the current key and sets the kid header.
+func (j *JWTManager) SignClaims(claims jwt.Claims) (string, error) {
+	key, err := j.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+func (j *JWTManager) ParseClaims(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.keyFunc,
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(j.issuer),
+		jwt.WithAudience(j.audience),
+	)
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// ParseIDTokenHint verifies an ID token issued by issuer. Expired tokens are
+// accepted, as RP-initiated logout uses them only to tell whose session ends.
+func (j *JWTManager) ParseIDTokenHint(tokenString, issuer string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.keyFunc, jwt.WithoutClaimsValidation())
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
+	if !token.Valid {
 		return nil, jwt.ErrSignatureInvalid
 	}
+	if claims.Issuer != issuer {
+		return nil, jwt.ErrTokenInvalidIssuer
+	}
 	return claims, nil
 }
+
+// SigningAlgorithms lists the algorithms of the published keys, which are
+// the ones clients can verify tokens with.
+func (j *JWTManager) SigningAlgorithms() []string {
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, key := range j.keys.JWKS().Keys {
+		if key.Alg != "" && !seen[key.Alg] {
+			seen[key.Alg] = true
+			algorithms = append(algorithms, key.Alg)
+		}
+	}
+	return algorithms
+}
+
+func (j *JWTManager) JWKS() JWKS {
+	return j.keys.JWKS()
+}
+
+// keyFunc picks the verification key by the kid header and rejects tokens
+// whose alg does not match that key, so an RSA public key can never be
+// misused as an HMAC secret.
+func (j *JWTManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := j.keys.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return key.PublicKey, nil
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
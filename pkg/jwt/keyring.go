@@ -0,0 +1,176 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type KeyStatus string
+
+const (
+	KeyStatusActive KeyStatus = "active"
+	KeyStatusVerify KeyStatus = "verify"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown or retired key id")
+)
+
+// KeyRecord is the persisted form of a ring key. NotBefore and RetireAfter
+// bound the period in which the key is used: an active key signs only after
+// NotBefore, and any key stops verifying once RetireAfter has passed.
+type KeyRecord struct {
+	ID          string    `json:"kid" bson:"_id"`
+	Status      KeyStatus `json:"status" bson:"status"`
+	NotBefore   time.Time `json:"not_before" bson:"not_before"`
+	RetireAfter time.Time `json:"retire_after" bson:"retire_after,omitempty"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+	PEM         []byte    `json:"-" bson:"pem"`
+}
+
+func (r *KeyRecord) Retired(now time.Time) bool {
+	return !r.RetireAfter.IsZero() && !now.Before(r.RetireAfter)
+}
+
+type KeyStore interface {
+	List(ctx context.Context) ([]KeyRecord, error)
+	Save(ctx context.Context, record KeyRecord) error
+}
+
+type ringKey struct {
+	key    *Key
+	record KeyRecord
+}
+
+type KeyRing struct {
+	mu    sync.RWMutex
+	keys  map[string]*ringKey
+	store KeyStore
+}
+
+func NewKeyRing(store KeyStore) *KeyRing {
+	return &KeyRing{
+		keys:  map[string]*ringKey{},
+		store: store,
+	}
+}
+
+// NewStaticKeyRing wraps a single key that is always active. It is used when
+// keys are configured directly instead of through a key store.
+func NewStaticKeyRing(key *Key) *KeyRing {
+	return &KeyRing{
+		keys: map[string]*ringKey{
+			key.ID: {key: key, record: KeyRecord{ID: key.ID, Status: KeyStatusActive}},
+		},
+	}
+}
+
+func (r *KeyRing) Load(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+
+	records, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*ringKey, len(records))
+	for _, record := range records {
+		key, err := ParsePEMKey(record.PEM, record.ID)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", record.ID, err)
+		}
+		keys[record.ID] = &ringKey{key: key, record: record}
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the ring from its store every interval until stop is called,
+// so that keys promoted or retired by the admin command are picked up.
+func (r *KeyRing) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if r.store == nil || interval <= 0 {
+		return cancel
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Load(ctx); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// SigningKey returns the active key whose window has started. When several
+// keys qualify the one with the latest NotBefore wins.
+func (r *KeyRing) SigningKey() (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var current *ringKey
+	for _, k := range r.keys {
+		if k.record.Status != KeyStatusActive || now.Before(k.record.NotBefore) || k.record.Retired(now) {
+			continue
+		}
+		if current == nil || k.record.NotBefore.After(current.record.NotBefore) {
+			current = k
+		}
+	}
+
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current.key, nil
+}
+
+func (r *KeyRing) VerificationKey(kid string) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k, ok := r.keys[kid]
+	if !ok || k.record.Retired(time.Now()) {
+		return nil, ErrUnknownKey
+	}
+	return k.key, nil
+}
+
+// JWKS publishes every key that has not been retired yet, including keys whose
+// signing window has not started, so verifiers can cache them ahead of time.
+func (r *KeyRing) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	jwks := JWKS{Keys: []JWK{}}
+	for _, k := range r.keys {
+		if k.record.Retired(now) {
+			continue
+		}
+		if jwk, ok := k.key.PublicJWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
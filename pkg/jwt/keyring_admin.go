@@ -0,0 +1,135 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+func GeneratePrivateKey(alg string) (crypto.PrivateKey, error) {
+	switch alg {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+func EncodePrivateKeyPEM(privateKey crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// GenerateKey creates a new verification-only key and saves it to the store.
+// It becomes eligible for signing only after it is promoted.
+func GenerateKey(ctx context.Context, store KeyStore, alg string, notBefore time.Time) (*KeyRecord, error) {
+	privateKey, err := GeneratePrivateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewKey(privateKey, "")
+	if err != nil {
+		return nil, err
+	}
+
+	pemData, err := EncodePrivateKeyPEM(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	record := KeyRecord{
+		ID:        key.ID,
+		Status:    KeyStatusVerify,
+		NotBefore: notBefore,
+		CreatedAt: time.Now(),
+		PEM:       pemData,
+	}
+	if err := store.Save(ctx, record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// PromoteKey makes kid the active signing key. If its NotBefore lies in the
+// future the currently active keys keep signing until then. Either way they are
+// retired grace after the switch, which should be at least the lifetime of the
+// tokens they have signed.
+func PromoteKey(ctx context.Context, store KeyStore, kid string, grace time.Duration) error {
+	records, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var target *KeyRecord
+	for i := range records {
+		if records[i].ID == kid {
+			target = &records[i]
+		}
+	}
+	if target == nil || target.Retired(now) {
+		return ErrUnknownKey
+	}
+
+	if target.NotBefore.IsZero() || target.NotBefore.Before(now) {
+		target.NotBefore = now
+	}
+	switchAt := target.NotBefore
+
+	for _, record := range records {
+		if record.ID == kid || record.Status != KeyStatusActive {
+			continue
+		}
+		if !switchAt.After(now) {
+			record.Status = KeyStatusVerify
+		}
+		if record.RetireAfter.IsZero() || record.RetireAfter.After(switchAt.Add(grace)) {
+			record.RetireAfter = switchAt.Add(grace)
+		}
+		record.PEM = nil
+		if err := store.Save(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	target.Status = KeyStatusActive
+	target.PEM = nil
+	return store.Save(ctx, *target)
+}
+
+func RetireKey(ctx context.Context, store KeyStore, kid string, at time.Time) error {
+	records, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.ID != kid {
+			continue
+		}
+		record.Status = KeyStatusVerify
+		record.RetireAfter = at
+		record.PEM = nil
+		return store.Save(ctx, record)
+	}
+	return ErrUnknownKey
+}
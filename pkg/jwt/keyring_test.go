@@ -0,0 +1,203 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "raiko-auth"
+	testAudience = "raiko-api"
+)
+
+// newRotatingRing returns a ring over a key directory and a manager signing
+// with it.
+func newRotatingRing(t *testing.T) (*KeyRing, *DirKeyStore, *JWTManager) {
+	t.Helper()
+
+	store := NewDirKeyStore(t.TempDir())
+	ring := NewKeyRing(store)
+	return ring, store, NewJWTManager(ring, testIssuer, testAudience, time.Minute)
+}
+
+func generateKey(t *testing.T, store KeyStore, notBefore time.Time) string {
+	t.Helper()
+
+	record, err := GenerateKey(context.Background(), store, "ES256", notBefore)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return record.ID
+}
+
+func reload(t *testing.T, ring *KeyRing) {
+	t.Helper()
+
+	if err := ring.Load(context.Background()); err != nil {
+		t.Fatalf("load ring: %v", err)
+	}
+}
+
+func signedToken(t *testing.T, manager *JWTManager) string {
+	t.Helper()
+
+	token, err := manager.GenerateToken("user", "user@example.com", "user", nil, nil)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func tokenKID(t *testing.T, tokenString string) string {
+	t.Helper()
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestKeyRotationByKID(t *testing.T) {
+	ctx := context.Background()
+	ring, store, manager := newRotatingRing(t)
+
+	oldKID := generateKey(t, store, time.Time{})
+	reload(t, ring)
+	if _, err := manager.GenerateToken("user", "", "", nil, nil); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("sign before promotion: err = %v, want %v", err, ErrNoSigningKey)
+	}
+
+	if err := PromoteKey(ctx, store, oldKID, time.Hour); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	reload(t, ring)
+	oldToken := signedToken(t, manager)
+	if kid := tokenKID(t, oldToken); kid != oldKID {
+		t.Fatalf("kid = %s, want %s", kid, oldKID)
+	}
+
+	newKID := generateKey(t, store, time.Time{})
+	if err := PromoteKey(ctx, store, newKID, time.Hour); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	reload(t, ring)
+	newToken := signedToken(t, manager)
+	if kid := tokenKID(t, newToken); kid != newKID {
+		t.Errorf("kid after rotation = %s, want %s", kid, newKID)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := manager.VerifyToken(token); err != nil {
+			t.Errorf("%s token during the grace period: %v", name, err)
+		}
+	}
+	if jwks := manager.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("published %d keys during the grace period, want 2", len(jwks.Keys))
+	}
+
+	if err := RetireKey(ctx, store, oldKID, time.Now()); err != nil {
+		t.Fatalf("retire: %v", err)
+	}
+	reload(t, ring)
+	if _, err := manager.VerifyToken(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the retired key: err = %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := manager.VerifyToken(newToken); err != nil {
+		t.Errorf("token of the active key: %v", err)
+	}
+	if jwks := manager.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != newKID {
+		t.Errorf("published keys = %+v, want only %s", jwks.Keys, newKID)
+	}
+}
+
+func TestPromoteKeyWaitsForNotBefore(t *testing.T) {
+	ctx := context.Background()
+	ring, store, manager := newRotatingRing(t)
+
+	currentKID := generateKey(t, store, time.Time{})
+	if err := PromoteKey(ctx, store, currentKID, time.Hour); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	nextKID := generateKey(t, store, time.Now().Add(time.Hour))
+	if err := PromoteKey(ctx, store, nextKID, time.Hour); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	reload(t, ring)
+
+	if kid := tokenKID(t, signedToken(t, manager)); kid != currentKID {
+		t.Errorf("kid before the switch = %s, want %s", kid, currentKID)
+	}
+	if _, err := ring.VerificationKey(nextKID); err != nil {
+		t.Errorf("the next key is not published ahead of the switch: %v", err)
+	}
+}
+
+func TestVerifyTokenRejects(t *testing.T) {
+	key, err := GeneratePrivateKey("ES256")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signingKey, err := NewKey(key, "")
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	ring := NewStaticKeyRing(signingKey)
+	manager := NewJWTManager(ring, testIssuer, testAudience, time.Minute)
+
+	otherKey, err := GeneratePrivateKey("ES256")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	// Same kid, different key: the signature must not verify.
+	impostor, err := NewKey(otherKey, signingKey.ID)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+
+	sign := func(manager *JWTManager, edit func(*Claims)) string {
+		claims, err := manager.NewClaims("user")
+		if err != nil {
+			t.Fatalf("claims: %v", err)
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		token, err := manager.SignClaims(claims)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return token
+	}
+	valid := sign(manager, nil)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other issuer", sign(NewJWTManager(ring, "someone-else", testAudience, time.Minute), nil)},
+		{"other audience", sign(NewJWTManager(ring, testIssuer, "other-api", time.Minute), nil)},
+		{"expired", sign(manager, func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })},
+		{"without exp", sign(manager, func(c *Claims) { c.ExpiresAt = nil })},
+		{"other key with the same kid", sign(NewJWTManager(NewStaticKeyRing(impostor), testIssuer, testAudience, time.Minute), nil)},
+		{"unknown kid", sign(NewJWTManager(NewStaticKeyRing(NewHMACKey([]byte("secret"), "other")), testIssuer, testAudience, time.Minute), nil)},
+		{"HMAC with the kid of an EC key", sign(NewJWTManager(NewStaticKeyRing(NewHMACKey([]byte("secret"), signingKey.ID)), testIssuer, testAudience, time.Minute), nil)},
+		{"tampered payload", parts[0] + "." + parts[1] + "x." + parts[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := manager.VerifyToken(tt.token); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+
+	if _, err := manager.VerifyToken(valid); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+}
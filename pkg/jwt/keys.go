@@ -0,0 +1,181 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+)
+
+// Key is a single signing key together with the JWS algorithm it is used with.
+// Asymmetric keys publish their public half through JWKS, HMAC keys never do.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewHMACKey(secret []byte, kid string) *Key {
+	if kid == "" {
+		kid = "default"
+	}
+	return &Key{
+		ID:         kid,
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: secret,
+		PublicKey:  secret,
+	}
+}
+
+// LoadPEMKey reads an RSA, ECDSA or Ed25519 private key from a PEM file. When
+// kid is empty the RFC 7638 thumbprint of the public key is used instead.
+func LoadPEMKey(path, kid string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePEMKey(data, kid)
+}
+
+func ParsePEMKey(data []byte, kid string) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var privateKey crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(privateKey, kid)
+}
+
+func NewKey(privateKey crypto.PrivateKey, kid string) (*Key, error) {
+	key := &Key{ID: kid, PrivateKey: privateKey}
+
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.PublicKey = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, errors.New("unsupported elliptic curve")
+		}
+		key.PublicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.PublicKey = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	if key.ID == "" {
+		jwk, _ := key.PublicJWK()
+		thumbprint, err := jwk.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+// PublicJWK returns the public part of the key as a JWK. It reports false for
+// symmetric keys, which must never be published.
+func (k *Key) PublicJWK() (JWK, bool) {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(pub.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeSegment(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint.
+func (j JWK) Thumbprint() (string, error) {
+	var members map[string]string
+	switch j.Kty {
+	case "RSA":
+		members = map[string]string{"e": j.E, "kty": j.Kty, "n": j.N}
+	case "EC":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X, "y": j.Y}
+	case "OKP":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+
+	// encoding/json sorts map keys, which gives the lexicographic member order RFC 7638 requires.
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encodeSegment(sum[:]), nil
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,116 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirKeyStore keeps every key as <kid>.pem next to a <kid>.json file with its
+// status and validity window. A PEM file without metadata is verification-only.
+type DirKeyStore struct {
+	dir string
+}
+
+func NewDirKeyStore(dir string) *DirKeyStore {
+	return &DirKeyStore{dir: dir}
+}
+
+func (s *DirKeyStore) List(ctx context.Context) ([]KeyRecord, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]KeyRecord, 0, len(files))
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+		pemData, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		record := KeyRecord{ID: kid, Status: KeyStatusVerify}
+		meta, err := os.ReadFile(filepath.Join(s.dir, kid+".json"))
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(meta, &record); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+
+		record.ID = kid
+		record.PEM = pemData
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *DirKeyStore) Save(ctx context.Context, record KeyRecord) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+
+	if len(record.PEM) > 0 {
+		if err := os.WriteFile(filepath.Join(s.dir, record.ID+".pem"), record.PEM, 0o600); err != nil {
+			return err
+		}
+	}
+
+	meta, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, record.ID+".json"), meta, 0o600)
+}
+
+type MongoKeyStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoKeyStore(db *mongo.Database) *MongoKeyStore {
+	return &MongoKeyStore{collection: db.Collection("signing_keys")}
+}
+
+func (s *MongoKeyStore) List(ctx context.Context) ([]KeyRecord, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []KeyRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *MongoKeyStore) Save(ctx context.Context, record KeyRecord) error {
+	update := bson.M{
+		"status":     record.Status,
+		"not_before": record.NotBefore,
+		"created_at": record.CreatedAt,
+	}
+	if !record.RetireAfter.IsZero() {
+		update["retire_after"] = record.RetireAfter
+	}
+	if len(record.PEM) > 0 {
+		update["pem"] = record.PEM
+	}
+
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$set": update},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idID = "argon2id"
+
+// Argon2id hashes with Argon2id (RFC 9106). Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the RFC 9106 recommendation for memory constrained
+// environments, with 64 MiB of memory.
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Validate rejects parameters too weak or too large to be meant seriously.
+func (a *Argon2id) Validate() error {
+	switch {
+	case a.Memory < 8*uint32(a.Parallelism):
+		return fmt.Errorf("argon2id memory must be at least 8 KiB per lane")
+	case a.Iterations < 1:
+		return fmt.Errorf("argon2id iterations must be at least 1")
+	case a.Parallelism < 1:
+		return fmt.Errorf("argon2id parallelism must be at least 1")
+	case a.SaltLength < 8:
+		return fmt.Errorf("argon2id salt must be at least 8 bytes")
+	case a.KeyLength < 16 || a.KeyLength > 1024:
+		return fmt.Errorf("argon2id key length must be between 16 and 1024 bytes")
+	}
+	return nil
+}
+
+func (a *Argon2id) ID() string {
+	return argon2idID
+}
+
+func (a *Argon2id) Hash(secret []byte, keyID string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(secret, salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d%s$%s$%s", argon2idID, argon2.Version,
+		a.Memory, a.Iterations, a.Parallelism, keyIDParam(keyID),
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) Verify(secret []byte, hash PHC) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	computed := argon2.IDKey(secret, salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a *Argon2id) NeedsRehash(hash PHC) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory ||
+		params.Iterations < a.Iterations ||
+		len(salt) < int(a.SaltLength) ||
+		len(key) < int(a.KeyLength)
+}
+
+func decodeArgon2id(hash PHC) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	if hash.Version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: argon2 version %d", ErrUnsupported, hash.Version)
+	}
+
+	memory, err := hash.intParam("m", 32)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	iterations, err := hash.intParam("t", 32)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	parallelism, err := hash.intParam("p", 8)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	params = Argon2id{Memory: uint32(memory), Iterations: uint32(iterations), Parallelism: uint8(parallelism)}
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, ErrMalformed
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(hash.Salt)
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(hash.Hash)
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformed
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,92 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const bcryptID = "bcrypt-sha256"
+
+// Bcrypt hashes with bcrypt. As bcrypt ignores everything past 72 bytes, the
+// secret is reduced to the base64 of its SHA-256 first. The salt and hash in
+// the PHC string are in bcrypt's own base64 alphabet.
+type Bcrypt struct {
+	Cost int
+}
+
+var DefaultBcrypt = Bcrypt{Cost: 12}
+
+func (b *Bcrypt) Validate() error {
+	if b.Cost < bcrypt.MinCost || b.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+func (b *Bcrypt) ID() string {
+	return bcryptID
+}
+
+func (b *Bcrypt) Hash(secret []byte, keyID string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword(bcryptPrehash(secret), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	// hashed is $2a$<cost>$<22 characters of salt><31 of hash>.
+	fields := strings.Split(string(hashed), "$")
+	if len(fields) != 4 || len(fields[3]) != 53 {
+		return "", fmt.Errorf("unexpected bcrypt hash %q", hashed)
+	}
+	return fmt.Sprintf("$%s$r=%d%s$%s$%s", bcryptID, b.Cost, keyIDParam(keyID), fields[3][:22], fields[3][22:]), nil
+}
+
+func (b *Bcrypt) Verify(secret []byte, hash PHC) error {
+	cost, err := hash.intParam("r", 8)
+	if err != nil {
+		return err
+	}
+	if len(hash.Salt) != 22 || len(hash.Hash) != 31 {
+		return ErrMalformed
+	}
+
+	legacy := fmt.Sprintf("$2a$%02d$%s%s", cost, hash.Salt, hash.Hash)
+	return compareBcrypt(bcryptPrehash(secret), legacy)
+}
+
+func (b *Bcrypt) NeedsRehash(hash PHC) bool {
+	cost, err := hash.intParam("r", 8)
+	return err != nil || int(cost) < b.Cost
+}
+
+func bcryptPrehash(secret []byte) []byte {
+	sum := sha256.Sum256(secret)
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// isLegacyBcrypt reports whether encoded is a plain bcrypt hash, as stored
+// before PHC strings were used.
+func isLegacyBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// verifyLegacyBcrypt checks the password itself against a plain bcrypt hash,
+// without pepper or prehash.
+func verifyLegacyBcrypt(password []byte, encoded string) error {
+	return compareBcrypt(password, encoded)
+}
+
+func compareBcrypt(secret []byte, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), secret)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
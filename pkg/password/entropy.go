@@ -0,0 +1,107 @@
+package password
+
+import (
+	"math"
+	"unicode"
+)
+
+// minWordLength is the shortest banned password Entropy looks for inside
+// longer passwords.
+const minWordLength = 4
+
+// Entropy estimates the entropy of password in bits, as seen by an attacker
+// who tries common passwords, repeated characters and sequences before brute
+// force. It is a rough lower bound for comparing passwords, not a measure of
+// their actual strength:
+//
+//   - a banned password inside the password costs as much as picking it from
+//     the list, plus a bit each for capitals and substitutions;
+//   - a repeated character, or one continuing a sequence such as "abc" or
+//     "321", costs a single bit;
+//   - any other character costs log2 of the size of the character classes
+//     the password draws from.
+func (p *Policy) Entropy(password string) float64 {
+	runes := []rune(password)
+	plain := []rune(normalize(password))
+
+	charBits := math.Log2(float64(alphabetSize(runes)))
+	wordBits := math.Log2(float64(max(len(p.banned), 2)))
+
+	bits := 0.0
+	for i := 0; i < len(runes); {
+		if n := p.bannedWordAt(plain, i); n > 0 {
+			bits += wordBits
+			for _, r := range runes[i : i+n] {
+				if unicode.IsUpper(r) {
+					bits++
+					break
+				}
+			}
+			for j := i; j < i+n; j++ {
+				if unicode.ToLower(runes[j]) != plain[j] {
+					bits++
+					break
+				}
+			}
+			i += n
+			continue
+		}
+
+		switch {
+		case i > 0 && runes[i] == runes[i-1]:
+			bits++
+		case i > 1 && isStep(runes[i-1], runes[i]) && runes[i]-runes[i-1] == runes[i-1]-runes[i-2]:
+			bits++
+		default:
+			bits += charBits
+		}
+		i++
+	}
+	return bits
+}
+
+// bannedWordAt returns the length of the longest banned password starting
+// at position i of plain, or 0.
+func (p *Policy) bannedWordAt(plain []rune, i int) int {
+	for n := min(p.longest, len(plain)-i); n >= minWordLength; n-- {
+		if _, ok := p.banned[string(plain[i:i+n])]; ok {
+			return n
+		}
+	}
+	return 0
+}
+
+func isStep(a, b rune) bool {
+	return b-a == 1 || a-b == 1
+}
+
+// alphabetSize is the number of characters in the classes password uses.
+// Letters outside ASCII are counted as one alphabet of 64.
+func alphabetSize(password []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r > unicode.MaxASCII && unicode.IsLetter(r):
+			other = true
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 64}} {
+		if class.used {
+			size += class.size
+		}
+	}
+	return max(size, 2)
+}
@@ -0,0 +1,242 @@
+// Package password hashes passwords for storage. Hashes are PHC strings,
+//
+//	$<algorithm>$v=<version>$<param>=<value>,...$<salt>$<hash>
+//
+// so every hash names the algorithm and parameters it was made with, and the
+// algorithm or its cost can be changed without breaking stored hashes. An
+// optional pepper, a server-side secret kept out of the database, is mixed
+// into the password before hashing.
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrMalformed     = errors.New("malformed password hash")
+	ErrUnsupported   = errors.New("unsupported password hash algorithm")
+	ErrUnknownPepper = errors.New("password hash uses an unknown pepper")
+)
+
+// Hasher is one hashing algorithm. It works on the secret derived from the
+// password, which has the pepper applied already.
+type Hasher interface {
+	// ID is the PHC identifier of the hashes, such as "argon2id".
+	ID() string
+	// Hash returns the PHC string of secret. keyID names the pepper applied to
+	// secret and is empty when there is none.
+	Hash(secret []byte, keyID string) (string, error)
+	// Verify returns ErrMismatch when secret does not match hash.
+	Verify(secret []byte, hash PHC) error
+	// NeedsRehash reports whether hash is weaker than the hashes Hash makes.
+	NeedsRehash(hash PHC) bool
+}
+
+// Pepper is a secret HMAC key applied to every password before hashing. ID is
+// stored with the hash, so the pepper can be rotated while hashes made with
+// the previous one still verify.
+type Pepper struct {
+	ID  string
+	Key []byte
+}
+
+var pepperIDPattern = regexp.MustCompile(`^[A-Za-z0-9.-]{1,32}$`)
+
+// Manager hashes new passwords with one hasher and verifies hashes of every
+// supported algorithm, including plain bcrypt hashes made before PHC strings
+// were used.
+type Manager struct {
+	hasher  Hasher
+	hashers map[string]Hasher
+	pepper  Pepper
+	peppers map[string][]byte
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// NewManager returns a Manager that hashes with hasher and, when its key is
+// set, pepper. Hashes made with one of the old peppers still verify and are
+// reported as needing a rehash.
+func NewManager(hasher Hasher, pepper Pepper, oldPeppers ...Pepper) (*Manager, error) {
+	m := &Manager{
+		hasher: hasher,
+		// Verifying only needs the parameters stored in the hash.
+		hashers: map[string]Hasher{
+			argon2idID: &Argon2id{},
+			bcryptID:   &Bcrypt{},
+		},
+		pepper:  pepper,
+		peppers: map[string][]byte{},
+	}
+	m.hashers[hasher.ID()] = hasher
+
+	for _, p := range append([]Pepper{pepper}, oldPeppers...) {
+		if len(p.Key) == 0 {
+			continue
+		}
+		if !pepperIDPattern.MatchString(p.ID) {
+			return nil, fmt.Errorf("invalid pepper ID %q", p.ID)
+		}
+		if _, ok := m.peppers[p.ID]; ok {
+			return nil, fmt.Errorf("duplicate pepper ID %q", p.ID)
+		}
+		m.peppers[p.ID] = p.Key
+	}
+	return m, nil
+}
+
+// Hash returns the hash of password to store.
+func (m *Manager) Hash(password string) (string, error) {
+	keyID := ""
+	if len(m.pepper.Key) > 0 {
+		keyID = m.pepper.ID
+	}
+	return m.hasher.Hash(m.secret(password, keyID), keyID)
+}
+
+// Verify checks password against a stored hash and returns ErrMismatch when
+// it does not match. Once it matched, rehash tells whether the hash uses
+// another algorithm, weaker parameters or another pepper than new hashes, and
+// should be replaced by Hash(password).
+func (m *Manager) Verify(password, encoded string) (rehash bool, err error) {
+	if isLegacyBcrypt(encoded) {
+		if err := verifyLegacyBcrypt([]byte(password), encoded); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	hash, err := ParsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	hasher, ok := m.hashers[hash.ID]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrUnsupported, hash.ID)
+	}
+
+	keyID := hash.Params["keyid"]
+	if keyID != "" {
+		if _, ok := m.peppers[keyID]; !ok {
+			return false, fmt.Errorf("%w: %s", ErrUnknownPepper, keyID)
+		}
+	}
+	if err := hasher.Verify(m.secret(password, keyID), hash); err != nil {
+		return false, err
+	}
+
+	currentKeyID := ""
+	if len(m.pepper.Key) > 0 {
+		currentKeyID = m.pepper.ID
+	}
+	return hash.ID != m.hasher.ID() || keyID != currentKeyID || m.hasher.NeedsRehash(hash), nil
+}
+
+// VerifyDummy checks password against a hash no password matches, which takes
+// as long as Verify with a current hash. Callers without a stored hash, such
+// as a login for an unknown user, use it so the response time does not tell
+// that the hash is missing.
+func (m *Manager) VerifyDummy(password string) {
+	m.dummyOnce.Do(func() {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return
+		}
+		m.dummy, _ = m.Hash(hex.EncodeToString(salt))
+	})
+	_, _ = m.Verify(password, m.dummy)
+}
+
+// secret applies the pepper keyID to password.
+func (m *Manager) secret(password, keyID string) []byte {
+	if keyID == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, m.peppers[keyID])
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// PHC is a hash in PHC string format. Salt and Hash are kept encoded, as
+// bcrypt uses its own base64 alphabet.
+type PHC struct {
+	ID string
+	// Version is -1 when the hash has none.
+	Version int
+	Params  map[string]string
+	Salt    string
+	Hash    string
+}
+
+// ParsePHC splits a PHC string into its fields.
+func ParsePHC(encoded string) (PHC, error) {
+	hash := PHC{Version: -1, Params: map[string]string{}}
+
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 2 || fields[0] != "" || fields[1] == "" {
+		return hash, ErrMalformed
+	}
+	hash.ID, fields = fields[1], fields[2:]
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		version, err := strconv.Atoi(strings.TrimPrefix(fields[0], "v="))
+		if err != nil || version < 0 {
+			return hash, ErrMalformed
+		}
+		hash.Version, fields = version, fields[1:]
+	}
+
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, param := range strings.Split(fields[0], ",") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || name == "" {
+				return hash, ErrMalformed
+			}
+			hash.Params[name] = value
+		}
+		fields = fields[1:]
+	}
+
+	switch len(fields) {
+	case 2:
+		hash.Salt, hash.Hash = fields[0], fields[1]
+	case 1:
+		hash.Salt = fields[0]
+	case 0:
+	default:
+		return hash, ErrMalformed
+	}
+	return hash, nil
+}
+
+// intParam reads a numeric parameter of hash.
+func (h PHC) intParam(name string, bits int) (uint64, error) {
+	value, ok := h.Params[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: missing %s", ErrMalformed, name)
+	}
+	n, err := strconv.ParseUint(value, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s", ErrMalformed, name)
+	}
+	return n, nil
+}
+
+// keyIDParam is the PHC parameter naming the pepper, with its separator.
+func keyIDParam(keyID string) string {
+	if keyID == "" {
+		return ""
+	}
+	return ",keyid=" + keyID
+}
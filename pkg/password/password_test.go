@@ -0,0 +1,230 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// Cheap parameters, so the tests do not spend their time hashing.
+var (
+	testArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = Bcrypt{Cost: bcrypt.MinCost}
+)
+
+const testPassword = "Quiet-Meadow-Harbor-57"
+
+func newManager(t *testing.T, hasher Hasher, pepper Pepper, oldPeppers ...Pepper) *Manager {
+	t.Helper()
+
+	m, err := NewManager(hasher, pepper, oldPeppers...)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	return m
+}
+
+func hash(t *testing.T, m *Manager, password string) string {
+	t.Helper()
+
+	encoded, err := m.Hash(password)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	return encoded
+}
+
+func TestManagerHashVerify(t *testing.T) {
+	pepper := Pepper{ID: "2024", Key: []byte("pepper")}
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		pepper Pepper
+		prefix string
+	}{
+		{"argon2id", &testArgon2id, Pepper{}, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"argon2id with pepper", &testArgon2id, pepper, "$argon2id$v=19$m=64,t=1,p=1,keyid=2024$"},
+		{"bcrypt", &testBcrypt, Pepper{}, "$bcrypt-sha256$r=4$"},
+		{"bcrypt with pepper", &testBcrypt, pepper, "$bcrypt-sha256$r=4,keyid=2024$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newManager(t, tt.hasher, tt.pepper)
+			encoded := hash(t, m, testPassword)
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Errorf("hash %s does not start with %s", encoded, tt.prefix)
+			}
+			if again := hash(t, m, testPassword); again == encoded {
+				t.Error("hashing twice gave the same hash")
+			}
+
+			rehash, err := m.Verify(testPassword, encoded)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if rehash {
+				t.Error("a current hash needs a rehash")
+			}
+			for _, wrong := range []string{"", "quiet-meadow-harbor-57", testPassword + " "} {
+				if _, err := m.Verify(wrong, encoded); !errors.Is(err, ErrMismatch) {
+					t.Errorf("verify %q: err = %v, want %v", wrong, err, ErrMismatch)
+				}
+			}
+		})
+	}
+}
+
+func TestBcryptUsesTheWholePassword(t *testing.T) {
+	m := newManager(t, &testBcrypt, Pepper{})
+	long := strings.Repeat("a", 72)
+	encoded := hash(t, m, long+"1")
+
+	if _, err := m.Verify(long+"2", encoded); !errors.Is(err, ErrMismatch) {
+		t.Errorf("password differing after 72 bytes: err = %v, want %v", err, ErrMismatch)
+	}
+}
+
+func TestManagerVerifyNeedsRehash(t *testing.T) {
+	oldPepper := Pepper{ID: "2023", Key: []byte("old pepper")}
+	newPepper := Pepper{ID: "2024", Key: []byte("new pepper")}
+	weakArgon2id := testArgon2id
+	weakArgon2id.Memory = 32
+	strongBcrypt := Bcrypt{Cost: bcrypt.MinCost + 1}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("legacy hash: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		current *Manager
+		rehash  bool
+	}{
+		{
+			name:    "same parameters",
+			encoded: hash(t, newManager(t, &testArgon2id, newPepper), testPassword),
+			current: newManager(t, &testArgon2id, newPepper),
+		},
+		{
+			name:    "less memory",
+			encoded: hash(t, newManager(t, &weakArgon2id, Pepper{}), testPassword),
+			current: newManager(t, &testArgon2id, Pepper{}),
+			rehash:  true,
+		},
+		{
+			name:    "lower bcrypt cost",
+			encoded: hash(t, newManager(t, &testBcrypt, Pepper{}), testPassword),
+			current: newManager(t, &strongBcrypt, Pepper{}),
+			rehash:  true,
+		},
+		{
+			name:    "other algorithm",
+			encoded: hash(t, newManager(t, &testBcrypt, Pepper{}), testPassword),
+			current: newManager(t, &testArgon2id, Pepper{}),
+			rehash:  true,
+		},
+		{
+			name:    "old pepper",
+			encoded: hash(t, newManager(t, &testArgon2id, oldPepper), testPassword),
+			current: newManager(t, &testArgon2id, newPepper, oldPepper),
+			rehash:  true,
+		},
+		{
+			name:    "no pepper yet",
+			encoded: hash(t, newManager(t, &testArgon2id, Pepper{}), testPassword),
+			current: newManager(t, &testArgon2id, newPepper),
+			rehash:  true,
+		},
+		{
+			name:    "plain bcrypt",
+			encoded: string(legacy),
+			current: newManager(t, &testArgon2id, newPepper),
+			rehash:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := tt.current.Verify(testPassword, tt.encoded)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if rehash != tt.rehash {
+				t.Errorf("rehash = %v, want %v", rehash, tt.rehash)
+			}
+			if _, err := tt.current.Verify("Wrong-Harbor-Lantern-92", tt.encoded); !errors.Is(err, ErrMismatch) {
+				t.Errorf("wrong password: err = %v, want %v", err, ErrMismatch)
+			}
+		})
+	}
+}
+
+func TestManagerVerifyRejects(t *testing.T) {
+	pepper := Pepper{ID: "2024", Key: []byte("pepper")}
+	peppered := hash(t, newManager(t, &testArgon2id, pepper), testPassword)
+	plain := hash(t, newManager(t, &testArgon2id, Pepper{}), testPassword)
+	m := newManager(t, &testArgon2id, Pepper{ID: "2025", Key: []byte("other pepper")})
+
+	tests := []struct {
+		name    string
+		manager *Manager
+		encoded string
+		err     error
+	}{
+		{"retired pepper", m, peppered, ErrUnknownPepper},
+		{"pepper with another key", newManager(t, &testArgon2id, Pepper{ID: "2024", Key: []byte("other")}), peppered, ErrMismatch},
+		{"pepper stripped from the hash", m, strings.Replace(peppered, ",keyid=2024", "", 1), ErrMismatch},
+		{"unknown algorithm", m, strings.Replace(plain, "argon2id", "scrypt", 1), ErrUnsupported},
+		{"other argon2 version", m, strings.Replace(plain, "v=19", "v=16", 1), ErrUnsupported},
+		{"missing parameter", m, strings.Replace(plain, ",t=1", "", 1), ErrMalformed},
+		{"missing hash", m, plain[:strings.LastIndex(plain, "$")], ErrMalformed},
+		{"not a PHC string", m, "secret", ErrMalformed},
+		{"empty", m, "", ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.manager.Verify(testPassword, tt.encoded); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewManagerRejectsPeppers(t *testing.T) {
+	tests := []struct {
+		name   string
+		pepper Pepper
+		old    []Pepper
+	}{
+		{"no ID", Pepper{Key: []byte("pepper")}, nil},
+		{"ID with a separator", Pepper{ID: "a$b", Key: []byte("pepper")}, nil},
+		{"ID with a comma", Pepper{ID: "a,b", Key: []byte("pepper")}, nil},
+		{"duplicate ID", Pepper{ID: "2024", Key: []byte("pepper")}, []Pepper{{ID: "2024", Key: []byte("old")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewManager(&testArgon2id, tt.pepper, tt.old...); err == nil {
+				t.Error("manager was created")
+			}
+		})
+	}
+
+	if _, err := NewManager(&testArgon2id, Pepper{}, Pepper{ID: "retired"}); err != nil {
+		t.Errorf("pepper without a key: %v", err)
+	}
+}
+
+func TestVerifyDummyMatchesNoPassword(t *testing.T) {
+	m := newManager(t, &testArgon2id, Pepper{})
+	m.VerifyDummy(testPassword)
+
+	if !strings.HasPrefix(m.dummy, "$argon2id$") {
+		t.Fatalf("dummy hash = %q, want a hash of the current hasher", m.dummy)
+	}
+	if _, err := m.Verify(testPassword, m.dummy); !errors.Is(err, ErrMismatch) {
+		t.Errorf("password against the dummy hash: err = %v, want %v", err, ErrMismatch)
+	}
+}
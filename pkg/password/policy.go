@@ -0,0 +1,213 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Violation codes, stable for clients to translate.
+const (
+	ViolationTooShort         = "too_short"
+	ViolationTooLong          = "too_long"
+	ViolationMissingUppercase = "missing_uppercase"
+	ViolationMissingLowercase = "missing_lowercase"
+	ViolationMissingDigit     = "missing_digit"
+	ViolationMissingSymbol    = "missing_symbol"
+	ViolationTooWeak          = "too_weak"
+	ViolationBanned           = "banned"
+	ViolationContainsUsername = "contains_username"
+	ViolationContainsEmail    = "contains_email"
+	ViolationReused           = "reused"
+)
+
+// Character classes a Policy can require.
+const (
+	ClassUpper  = "upper"
+	ClassLower  = "lower"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// Violation is one rule a password breaks. Message is an English description
+// for clients without their own text for Code.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// Policy decides which new passwords are accepted. Lengths are counted in
+// characters, and the character classes follow Unicode, so passphrases and
+// passwords in any script are judged like ASCII ones.
+type Policy struct {
+	MinLength int
+	// MaxLength bounds the work of hashing, 0 leaves the length open.
+	MaxLength       int
+	RequiredClasses []string
+	// MinEntropy is the least estimated entropy in bits, see Entropy.
+	MinEntropy float64
+	// CheckUserInfo rejects passwords that contain the username or email.
+	CheckUserInfo bool
+
+	banned  map[string]struct{}
+	longest int
+}
+
+// NewPolicy returns a policy that bans the built-in list of common passwords.
+func NewPolicy() *Policy {
+	p := &Policy{banned: map[string]struct{}{}}
+	_ = p.AddBanned(strings.NewReader(commonPasswords))
+	return p
+}
+
+// AddBanned bans the passwords listed in r, one per line. Empty lines and
+// lines starting with # are skipped.
+func (p *Policy) AddBanned(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word := normalize(line)
+		p.banned[word] = struct{}{}
+		p.longest = max(p.longest, len([]rune(word)))
+	}
+	return scanner.Err()
+}
+
+// ValidateClass reports whether class can be required.
+func ValidateClass(class string) error {
+	switch class {
+	case ClassUpper, ClassLower, ClassDigit, ClassSymbol:
+		return nil
+	}
+	return fmt.Errorf("unknown character class %q", class)
+}
+
+// Check returns the rules password breaks, none when it is acceptable. The
+// username and email are those of the account the password is for. Reuse of
+// earlier passwords is up to the caller, which has the hashes.
+func (p *Policy) Check(password, username, email string) []Violation {
+	var violations []Violation
+	add := func(code, format string, args ...any) {
+		violations = append(violations, Violation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add(ViolationTooShort, "must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(ViolationTooLong, "must be at most %d characters long", p.MaxLength)
+	}
+
+	classes := characterClasses(password)
+	for _, class := range p.RequiredClasses {
+		if classes[class] {
+			continue
+		}
+		switch class {
+		case ClassUpper:
+			add(ViolationMissingUppercase, "must contain an uppercase letter")
+		case ClassLower:
+			add(ViolationMissingLowercase, "must contain a lowercase letter")
+		case ClassDigit:
+			add(ViolationMissingDigit, "must contain a digit")
+		case ClassSymbol:
+			add(ViolationMissingSymbol, "must contain a symbol")
+		}
+	}
+
+	if p.isBanned(password) {
+		add(ViolationBanned, "is too common")
+	}
+
+	if p.CheckUserInfo {
+		if containsUserInfo(password, username) {
+			add(ViolationContainsUsername, "must not contain the username")
+		}
+		local, _, _ := strings.Cut(email, "@")
+		if containsUserInfo(password, email) || containsUserInfo(password, local) {
+			add(ViolationContainsEmail, "must not contain the email address")
+		}
+	}
+
+	// A banned password is weak by definition, saying so twice adds nothing.
+	if p.MinEntropy > 0 && !p.isBanned(password) && p.Entropy(password) < p.MinEntropy {
+		add(ViolationTooWeak, "is too easy to guess, use a longer password or passphrase")
+	}
+	return violations
+}
+
+// isBanned matches password against the list, also with leading and trailing
+// digits and symbols removed, so that "Password123!" counts as "password".
+func (p *Policy) isBanned(password string) bool {
+	lower := strings.ToLower(password)
+	if _, ok := p.banned[normalize(lower)]; ok {
+		return true
+	}
+	core := strings.TrimFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	if core == "" {
+		return false
+	}
+	_, ok := p.banned[normalize(core)]
+	return ok
+}
+
+func characterClasses(password string) map[string]bool {
+	classes := map[string]bool{}
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			classes[ClassUpper] = true
+		case unicode.IsLower(r):
+			classes[ClassLower] = true
+		case unicode.IsDigit(r):
+			classes[ClassDigit] = true
+		case !unicode.IsLetter(r):
+			classes[ClassSymbol] = true
+		}
+	}
+	return classes
+}
+
+// containsUserInfo reports whether password contains value, ignoring case and
+// character substitutions. Values shorter than three characters are ignored.
+func containsUserInfo(password, value string) bool {
+	if len([]rune(value)) < 3 {
+		return false
+	}
+	return strings.Contains(normalize(password), normalize(value))
+}
+
+// substitutions undoes the usual replacements of letters by look-alikes.
+var substitutions = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+}
+
+// normalize lowercases s and undoes substitutions. It maps rune to rune, so
+// the result has as many runes as s.
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if plain, ok := substitutions[r]; ok {
+			return plain
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,117 @@
+package password
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func violationCodes(violations []Violation) []string {
+	codes := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := NewPolicy()
+	policy.MinLength = 10
+	policy.MaxLength = 64
+	policy.RequiredClasses = []string{ClassUpper, ClassLower, ClassDigit}
+	policy.MinEntropy = 40
+	policy.CheckUserInfo = true
+
+	tests := []struct {
+		name     string
+		password string
+		codes    []string
+	}{
+		{"strong", "Quiet-Meadow-Harbor-57", nil},
+		{"passphrase in Cyrillic", "Тихий-Луг-Гавань-57", nil},
+		{"too short", "Qm-7h", []string{ViolationTooShort, ViolationTooWeak}},
+		{"too long", "Quiet-Meadow-Harbor-57" + strings.Repeat("x", 64), []string{ViolationTooLong}},
+		{"no uppercase", "quiet-meadow-harbor-57", []string{ViolationMissingUppercase}},
+		{"no digit", "Quiet-Meadow-Harbor", []string{ViolationMissingDigit}},
+		{"common password", "Password123!", []string{ViolationBanned}},
+		{"common password with substitutions", "P4ssw0rd1234", []string{ViolationBanned}},
+		{"username", "Alice-Harbor-57", []string{ViolationContainsUsername, ViolationContainsEmail}},
+		{"username with substitutions", "4l1ce-Harbor-Meadow-57", []string{ViolationContainsUsername, ViolationContainsEmail}},
+		{"email domain alone", "Example-Harbor-57", nil},
+		{"repetition", "Aaaaaaaaaaaa1", []string{ViolationTooWeak}},
+		{"sequence", "Abcdefghijk1", []string{ViolationTooWeak}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := violationCodes(policy.Check(tt.password, "alice", "alice@example.com"))
+			if !slices.Equal(codes, tt.codes) && !(len(codes) == 0 && len(tt.codes) == 0) {
+				t.Errorf("violations = %v, want %v", codes, tt.codes)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckSkipsDisabledRules(t *testing.T) {
+	policy := NewPolicy()
+	policy.MinLength = 8
+
+	// Without CheckUserInfo, required classes and an entropy floor only the
+	// length and the list of common passwords apply.
+	tests := []struct {
+		password string
+		codes    []string
+	}{
+		{"alice-harbor", nil},
+		{"aaaaaaaa", nil},
+		{"harbor", []string{ViolationTooShort}},
+		{"qwerty", []string{ViolationTooShort, ViolationBanned}},
+	}
+	for _, tt := range tests {
+		codes := violationCodes(policy.Check(tt.password, "alice", "alice@example.com"))
+		if !slices.Equal(codes, tt.codes) && !(len(codes) == 0 && len(tt.codes) == 0) {
+			t.Errorf("%s: violations = %v, want %v", tt.password, codes, tt.codes)
+		}
+	}
+}
+
+func TestPolicyAddBanned(t *testing.T) {
+	policy := NewPolicy()
+	if err := policy.AddBanned(strings.NewReader("# company words\n\nRaikoAuth\n")); err != nil {
+		t.Fatalf("add banned: %v", err)
+	}
+
+	for _, password := range []string{"raikoauth", "R41k0Auth!", "2024raikoauth"} {
+		if codes := violationCodes(policy.Check(password, "", "")); !slices.Contains(codes, ViolationBanned) {
+			t.Errorf("%s: violations = %v, want %s", password, codes, ViolationBanned)
+		}
+	}
+	if codes := violationCodes(policy.Check("# company words", "", "")); slices.Contains(codes, ViolationBanned) {
+		t.Errorf("comment line was banned")
+	}
+}
+
+func TestEntropyRanksPasswords(t *testing.T) {
+	policy := NewPolicy()
+
+	// Each password is easier to guess than the next.
+	passwords := []string{"abcdefghijkl", "aaaaaaaaaaaa", "Password2024", "kq8Lm2Zt", "Quiet-Meadow-Harbor-57"}
+	for i := 1; i < len(passwords); i++ {
+		weaker, stronger := policy.Entropy(passwords[i-1]), policy.Entropy(passwords[i])
+		if weaker >= stronger {
+			t.Errorf("entropy of %s = %.1f, not below %s = %.1f", passwords[i-1], weaker, passwords[i], stronger)
+		}
+	}
+}
+
+func TestValidateClass(t *testing.T) {
+	for _, class := range []string{ClassUpper, ClassLower, ClassDigit, ClassSymbol} {
+		if err := ValidateClass(class); err != nil {
+			t.Errorf("%s: %v", class, err)
+		}
+	}
+	for _, class := range []string{"", "letter", "UPPER"} {
+		if err := ValidateClass(class); err == nil {
+			t.Errorf("%q was accepted", class)
+		}
+	}
+}
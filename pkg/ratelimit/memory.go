@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops state that no longer limits
+// anything.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the counters in process memory. Every instance counts on
+// its own, so it only fits deployments with a single instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket has refilled, after which it can be dropped.
+	full time.Time
+}
+
+type window struct {
+	index    int64
+	current  int
+	previous int
+	period   time.Duration
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		windows: map[string]*window{},
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	if policy.Algorithm == SlidingWindow {
+		return s.takeWindow(key, policy, now), nil
+	}
+	return s.takeToken(key, policy, now), nil
+}
+
+func (s *MemoryStore) takeToken(key string, policy Policy, now time.Time) Result {
+	rate := tokenRate(policy)
+	burst := float64(policy.burst())
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(elapsed)*rate)
+		b.updated = now
+	}
+
+	result := Result{Limit: policy.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+	result.Remaining = int(b.tokens)
+	b.full = now.Add(time.Duration((burst - b.tokens) / rate))
+	return result
+}
+
+func (s *MemoryStore) takeWindow(key string, policy Policy, now time.Time) Result {
+	index, elapsed := windowPosition(policy.Period, now)
+
+	w, ok := s.windows[key]
+	if !ok {
+		w = &window{index: index, period: policy.Period}
+		s.windows[key] = w
+	}
+	switch {
+	case w.index == index-1:
+		w.previous, w.current = w.current, 0
+	case w.index != index:
+		w.previous, w.current = 0, 0
+	}
+	w.index = index
+
+	allowed := float64(w.previous)*(1-elapsed)+float64(w.current)+1 <= float64(policy.Limit)
+	if allowed {
+		w.current++
+	}
+	return slidingWindowResult(policy, allowed, w.current, w.previous, elapsed)
+}
+
+// sweep drops full buckets and windows that the sliding window has left
+// behind, as neither limits anything any more.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if index, _ := windowPosition(w.period, now); w.index < index-1 {
+			delete(s.windows, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// step is one request in a sequence taken from a MemoryStore.
+type step struct {
+	at         time.Duration
+	key        string
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+}
+
+func runSteps(t *testing.T, policy Policy, steps []step) {
+	t.Helper()
+
+	store := NewMemoryStore()
+	// A whole number of minutes, so that fixed windows start at start.
+	start := time.Unix(6000, 0)
+	for i, s := range steps {
+		key := s.key
+		if key == "" {
+			key = "192.0.2.1"
+		}
+		result, err := store.Take(context.Background(), key, policy, start.Add(s.at))
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		if result.Allowed != s.allowed || result.Remaining != s.remaining || result.RetryAfter != s.retryAfter {
+			t.Errorf("request %d at %v: result = %+v, want allowed %v, remaining %d, retry after %v",
+				i+1, s.at, result, s.allowed, s.remaining, s.retryAfter)
+		}
+	}
+}
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	policy := Policy{Algorithm: TokenBucket, Limit: 10, Period: time.Minute, Burst: 2}
+
+	runSteps(t, policy, []step{
+		{at: 0, allowed: true, remaining: 1},
+		{at: 0, allowed: true, remaining: 0},
+		// Tokens come back every six seconds.
+		{at: 0, allowed: false, remaining: 0, retryAfter: 6 * time.Second},
+		{at: 0, key: "192.0.2.2", allowed: true, remaining: 1},
+		{at: 3 * time.Second, allowed: false, remaining: 0, retryAfter: 3 * time.Second},
+		{at: 6 * time.Second, allowed: true, remaining: 0},
+		// The bucket never holds more than the burst.
+		{at: time.Hour, allowed: true, remaining: 1},
+		{at: time.Hour, allowed: true, remaining: 0},
+		{at: time.Hour, allowed: false, remaining: 0, retryAfter: 6 * time.Second},
+	})
+}
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	policy := Policy{Algorithm: SlidingWindow, Limit: 4, Period: time.Minute}
+
+	runSteps(t, policy, []step{
+		{at: 0, allowed: true, remaining: 3},
+		{at: 10 * time.Second, allowed: true, remaining: 2},
+		{at: 20 * time.Second, allowed: true, remaining: 1},
+		{at: 30 * time.Second, allowed: true, remaining: 0},
+		// The four requests of this window still weigh three quarters
+		// fifteen seconds into the next one, which leaves room for one.
+		{at: 40 * time.Second, allowed: false, remaining: 0, retryAfter: 35 * time.Second},
+		{at: 40 * time.Second, key: "192.0.2.2", allowed: true, remaining: 3},
+		{at: 74 * time.Second, allowed: false, remaining: 0, retryAfter: time.Second},
+		{at: 75 * time.Second, allowed: true, remaining: 0},
+		// A window after an idle one starts from scratch.
+		{at: 3 * time.Minute, allowed: true, remaining: 3},
+	})
+}
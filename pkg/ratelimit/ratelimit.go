@@ -0,0 +1,196 @@
+// Package ratelimit limits how often a key, such as a client IP, may perform
+// an action. Policies use a token bucket, which allows bursts and refills at
+// a steady rate, or a sliding window, which caps the requests in any window
+// of the given length. State lives in a Store: in process memory for a
+// single instance, or in Redis when several instances share the limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// KeyKind names what requests are counted by. Limiter does not interpret it;
+// the HTTP and gRPC middleware derive the key from the request.
+type KeyKind string
+
+const (
+	KeyIP      KeyKind = "ip"
+	KeyLogin   KeyKind = "login"
+	KeySubject KeyKind = "subject"
+)
+
+// Policy allows Limit requests per Period. A token bucket holds up to Burst
+// tokens, Limit when Burst is zero. A policy with a zero Limit is off.
+type Policy struct {
+	Name      string
+	Algorithm Algorithm
+	Limit     int
+	Period    time.Duration
+	Burst     int
+	Key       KeyKind
+}
+
+func (p Policy) Enabled() bool {
+	return p.Limit > 0
+}
+
+func (p Policy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// ParsePolicy reads a policy from its configuration form,
+//
+//	<algorithm> <limit>/<period> [burst=<n>] [key=ip|login|subject]
+//
+// for example "token_bucket 10/1m burst=20 key=ip". The key defaults to ip.
+// "off" disables the policy.
+func ParsePolicy(name, spec string) (Policy, error) {
+	policy := Policy{Name: name, Key: KeyIP}
+
+	fields := strings.Fields(spec)
+	if len(fields) == 1 && fields[0] == "off" {
+		return policy, nil
+	}
+	if len(fields) < 2 {
+		return policy, fmt.Errorf("rate limit %s: expected \"<algorithm> <limit>/<period>\", got %q", name, spec)
+	}
+
+	policy.Algorithm = Algorithm(fields[0])
+	if policy.Algorithm != TokenBucket && policy.Algorithm != SlidingWindow {
+		return policy, fmt.Errorf("rate limit %s: unknown algorithm %q", name, fields[0])
+	}
+
+	limit, period, ok := strings.Cut(fields[1], "/")
+	if !ok {
+		return policy, fmt.Errorf("rate limit %s: expected <limit>/<period>, got %q", name, fields[1])
+	}
+	var err error
+	if policy.Limit, err = strconv.Atoi(limit); err != nil || policy.Limit <= 0 {
+		return policy, fmt.Errorf("rate limit %s: invalid limit %q", name, limit)
+	}
+	if policy.Period, err = time.ParseDuration(period); err != nil || policy.Period <= 0 {
+		return policy, fmt.Errorf("rate limit %s: invalid period %q", name, period)
+	}
+
+	for _, option := range fields[2:] {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "burst":
+			if policy.Burst, err = strconv.Atoi(value); err != nil || policy.Burst <= 0 {
+				return policy, fmt.Errorf("rate limit %s: invalid burst %q", name, value)
+			}
+		case "key":
+			policy.Key = KeyKind(value)
+			if policy.Key != KeyIP && policy.Key != KeyLogin && policy.Key != KeySubject {
+				return policy, fmt.Errorf("rate limit %s: unknown key %q", name, value)
+			}
+		default:
+			return policy, fmt.Errorf("rate limit %s: unknown option %q", name, option)
+		}
+	}
+	if policy.Burst > 0 && policy.Algorithm != TokenBucket {
+		return policy, fmt.Errorf("rate limit %s: burst only applies to token_bucket", name)
+	}
+	return policy, nil
+}
+
+// Result is the outcome of one request. RetryAfter is set when the request
+// was refused and tells when the next one can succeed.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store keeps the counters. Take counts one request for key under policy
+// unless that would exceed the limit. Refused requests are not counted, so
+// a client that keeps retrying is not locked out for longer.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func NewLimiter(store Store, policies []Policy) *Limiter {
+	l := &Limiter{store: store, policies: map[string]Policy{}}
+	for _, policy := range policies {
+		l.policies[policy.Name] = policy
+	}
+	return l
+}
+
+// Policy returns the named policy. Unknown names are reported as off.
+func (l *Limiter) Policy(name string) Policy {
+	return l.policies[name]
+}
+
+// Allow counts a request for key under the named policy. Requests under
+// disabled or unknown policies are always allowed.
+func (l *Limiter) Allow(ctx context.Context, name, key string) (Result, error) {
+	policy, ok := l.policies[name]
+	if !ok || !policy.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	return l.store.Take(ctx, "ratelimit:"+name+":"+key, policy, time.Now())
+}
+
+// tokenRate is the refill rate of a token bucket in tokens per nanosecond.
+func tokenRate(policy Policy) float64 {
+	return float64(policy.Limit) / float64(policy.Period)
+}
+
+// slidingWindowResult completes the result of a sliding window from the
+// counts of the current and previous fixed windows. The estimate weighs the
+// previous window by the part of it that still overlaps the sliding window;
+// elapsed is how far into the current window now is, from 0 to 1.
+func slidingWindowResult(policy Policy, allowed bool, current, previous int, elapsed float64) Result {
+	result := Result{Allowed: allowed, Limit: policy.Limit}
+
+	estimate := float64(previous)*(1-elapsed) + float64(current)
+	result.Remaining = max(0, policy.Limit-int(math.Ceil(estimate)))
+	if allowed {
+		return result
+	}
+
+	// Find the point at which one more request fits again. While the current
+	// window is full that is only after it has become the previous one.
+	free := float64(policy.Limit - 1)
+	wait := 0.0
+	if float64(current) > free {
+		wait = 1 - elapsed
+		previous, current, elapsed = current, 0, 0
+	}
+	if previous > 0 {
+		if needed := 1 - (free-float64(current))/float64(previous); needed > elapsed {
+			wait += needed - elapsed
+		}
+	}
+	result.RetryAfter = time.Duration(math.Ceil(wait * float64(policy.Period)))
+	return result
+}
+
+// windowPosition returns the index of the fixed window now falls into and
+// how far into it now is.
+func windowPosition(period time.Duration, now time.Time) (int64, float64) {
+	nanos := now.UnixNano()
+	return nanos / int64(period), float64(nanos%int64(period)) / float64(period)
+}
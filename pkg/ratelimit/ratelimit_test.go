@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		spec   string
+		policy Policy
+		err    bool
+	}{
+		{spec: "token_bucket 10/1m", policy: Policy{Algorithm: TokenBucket, Limit: 10, Period: time.Minute, Key: KeyIP}},
+		{spec: "token_bucket 10/1m burst=20 key=login", policy: Policy{Algorithm: TokenBucket, Limit: 10, Period: time.Minute, Burst: 20, Key: KeyLogin}},
+		{spec: "sliding_window 5/15m key=subject", policy: Policy{Algorithm: SlidingWindow, Limit: 5, Period: 15 * time.Minute, Key: KeySubject}},
+		{spec: "off", policy: Policy{Key: KeyIP}},
+		{spec: "", err: true},
+		{spec: "token_bucket", err: true},
+		{spec: "leaky_bucket 10/1m", err: true},
+		{spec: "token_bucket 10", err: true},
+		{spec: "token_bucket 0/1m", err: true},
+		{spec: "token_bucket ten/1m", err: true},
+		{spec: "token_bucket 10/0s", err: true},
+		{spec: "token_bucket 10/minute", err: true},
+		{spec: "token_bucket 10/1m burst=0", err: true},
+		{spec: "token_bucket 10/1m key=header", err: true},
+		{spec: "token_bucket 10/1m scope=all", err: true},
+		{spec: "sliding_window 10/1m burst=20", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			policy, err := ParsePolicy("login", tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Errorf("policy %+v was accepted", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			tt.policy.Name = "login"
+			if policy != tt.policy {
+				t.Errorf("policy = %+v, want %+v", policy, tt.policy)
+			}
+		})
+	}
+}
+
+func TestLimiterAllowsDisabledPolicies(t *testing.T) {
+	ctx := context.Background()
+	off, err := ParsePolicy("off", "off")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	strict, err := ParsePolicy("strict", "sliding_window 1/1h")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	limiter := NewLimiter(NewMemoryStore(), []Policy{off, strict})
+
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"off", "unknown"} {
+			if result, err := limiter.Allow(ctx, name, "192.0.2.1"); err != nil || !result.Allowed {
+				t.Errorf("request %d under %s: result = %+v (%v), want allowed", i+1, name, result, err)
+			}
+		}
+	}
+
+	if result, err := limiter.Allow(ctx, "strict", "192.0.2.1"); err != nil || !result.Allowed {
+		t.Fatalf("first request: result = %+v (%v), want allowed", result, err)
+	}
+	if result, err := limiter.Allow(ctx, "strict", "192.0.2.1"); err != nil || result.Allowed {
+		t.Errorf("second request: result = %+v (%v), want refused", result, err)
+	}
+	if result, err := limiter.Allow(ctx, "strict", "192.0.2.2"); err != nil || !result.Allowed {
+		t.Errorf("request of another key: result = %+v (%v), want allowed", result, err)
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// tokenBucketScript refills and takes from the bucket in KEYS[1] in one step.
+// ARGV holds the rate in tokens per millisecond, the burst and the current
+// time in milliseconds. It returns whether a token was taken, the tokens left
+// and, when refused, the milliseconds until the next token.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+if now > updated then
+  tokens = math.min(burst, tokens + (now - updated) * rate)
+  updated = now
+end
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', updated)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {allowed, math.floor(tokens), retry}
+`)
+
+// slidingWindowScript counts a request in the current window KEYS[1] unless
+// the estimate with the previous window KEYS[2] reaches the limit. ARGV holds
+// the limit, the weight of the previous window and the window length in
+// milliseconds. It returns whether the request was counted and both counts.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+
+local current = tonumber(redis.call('GET', KEYS[1]) or 0)
+local previous = tonumber(redis.call('GET', KEYS[2]) or 0)
+if previous * weight + current + 1 > limit then
+  return {0, current, previous}
+end
+
+current = redis.call('INCR', KEYS[1])
+if current == 1 then
+  redis.call('PEXPIRE', KEYS[1], period * 2)
+end
+return {1, current, previous}
+`)
+
+// RedisStore keeps the counters in Redis or a compatible server, so that all
+// instances share them. The keys of one counter carry the same hash tag and
+// so stay in one slot of a Redis Cluster. Times come from the caller's clock,
+// which should be kept in sync across instances.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	if policy.Algorithm == SlidingWindow {
+		return s.takeWindow(ctx, key, policy, now)
+	}
+	return s.takeToken(ctx, key, policy, now)
+}
+
+func (s *RedisStore) takeToken(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	rate := tokenRate(policy) * float64(time.Millisecond)
+	values, err := tokenBucketScript.Run(ctx, s.client, []string{"{" + key + "}"},
+		strconv.FormatFloat(rate, 'g', -1, 64), policy.burst(), now.UnixMilli()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected token bucket reply %v", values)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      policy.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func (s *RedisStore) takeWindow(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	index, elapsed := windowPosition(policy.Period, now)
+	keys := []string{
+		fmt.Sprintf("{%s}:%d", key, index),
+		fmt.Sprintf("{%s}:%d", key, index-1),
+	}
+
+	values, err := slidingWindowScript.Run(ctx, s.client, keys,
+		policy.Limit, strconv.FormatFloat(1-elapsed, 'g', -1, 64), policy.Period.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected sliding window reply %v", values)
+	}
+
+	return slidingWindowResult(policy, values[0] == 1, int(values[1]), int(values[2]), elapsed), nil
+}
@@ -0,0 +1,71 @@
+// Package secretbox encrypts small secrets, such as TOTP seeds, before they
+// are written to the database.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// version prefixes every sealed value so the format or key can be changed
+// later without guessing what old values are.
+const version = "v1"
+
+// KeySize is the length of the key New expects.
+const KeySize = 32
+
+var ErrMalformed = errors.New("malformed sealed value")
+
+// Box seals values with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a Box for a KeySize byte key.
+func New(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. The same associated data must be passed to Open,
+// binding the value to, for example, the ID of the user that owns it.
+func (b *Box) Seal(plaintext, associatedData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, plaintext, associatedData)
+	return version + "." + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (b *Box) Open(sealed string, associatedData []byte) ([]byte, error) {
+	prefix, encoded, ok := strings.Cut(sealed, ".")
+	if !ok || prefix != version {
+		return nil, ErrMalformed
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	return b.aead.Open(nil, nonce, ciphertext, associatedData)
+}
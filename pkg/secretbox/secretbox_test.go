@@ -0,0 +1,91 @@
+package secretbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newBox(t *testing.T, fill byte) *Box {
+	t.Helper()
+
+	box, err := New(bytes.Repeat([]byte{fill}, KeySize))
+	if err != nil {
+		t.Fatalf("new box: %v", err)
+	}
+	return box
+}
+
+func TestNewRequiresKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, KeySize - 1, KeySize + 1, 64} {
+		if _, err := New(make([]byte, size)); err == nil {
+			t.Errorf("%d byte key was accepted", size)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	box := newBox(t, 1)
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+	owner := []byte("user-1")
+
+	sealed, err := box.Seal(plaintext, owner)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if strings.Contains(sealed, string(plaintext)) {
+		t.Fatalf("sealed value %s contains the plaintext", sealed)
+	}
+	again, err := box.Seal(plaintext, owner)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if again == sealed {
+		t.Error("sealing twice gave the same value")
+	}
+
+	opened, err := box.Open(sealed, owner)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened %q, want %q", opened, plaintext)
+	}
+
+	prefix, encoded, _ := strings.Cut(sealed, ".")
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	raw[len(raw)-1] ^= 1
+	tampered := base64.RawStdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name           string
+		box            *Box
+		sealed         string
+		associatedData []byte
+		malformed      bool
+	}{
+		{"other associated data", box, sealed, []byte("user-2"), false},
+		{"other key", newBox(t, 2), sealed, owner, false},
+		{"tampered ciphertext", box, prefix + "." + tampered, owner, false},
+		{"unknown version", box, "v2." + encoded, owner, true},
+		{"no version", box, encoded, owner, true},
+		{"not base64", box, "v1.!!!", owner, true},
+		{"shorter than a nonce", box, "v1.AAAA", owner, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.box.Open(tt.sealed, tt.associatedData)
+			if err == nil {
+				t.Fatal("sealed value was opened")
+			}
+			if errors.Is(err, ErrMalformed) != tt.malformed {
+				t.Errorf("err = %v, malformed = %v", err, tt.malformed)
+			}
+		})
+	}
+}
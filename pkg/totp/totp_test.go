@@ -0,0 +1,98 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight digit codes; the last six are the six digit code.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("code at %d: %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("malformed secret was accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	now := time.Now()
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		t.Helper()
+
+		code, err := Code(secret, step)
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{"current step", secret, codeAt(current), current, true},
+		{"previous step", secret, codeAt(current - 1), current - 1, true},
+		{"next step", secret, codeAt(current + 1), current + 1, true},
+		{"lowercase secret", strings.ToLower(secret), codeAt(current), current, true},
+		{"two steps behind", secret, codeAt(current - 2), 0, false},
+		{"two steps ahead", secret, codeAt(current + 2), 0, false},
+		{"too short", secret, codeAt(current)[1:], 0, false},
+		{"too long", secret, codeAt(current) + "0", 0, false},
+		{"malformed secret", "not base32!", codeAt(current), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, 1)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+
+	if _, ok := Validate(secret, codeAt(current-1), now, 0); ok {
+		t.Error("previous step was accepted without skew")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Raiko Auth", "alice@example.com", rfcSecret)
+
+	for _, part := range []string{"otpauth://totp/Raiko%20Auth:alice@example.com?", "secret=" + rfcSecret, "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %s does not contain %s", uri, part)
+		}
+	}
+}